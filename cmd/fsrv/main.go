@@ -1,44 +1,102 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
+	"fsrv/internal/auth"
 	"fsrv/internal/config"
 	"fsrv/internal/handler"
 	"fsrv/internal/service"
+	"fsrv/internal/storage"
 	"fsrv/internal/util"
 	"fsrv/web"
 )
 
 func main() {
+	// Helper commands for writing the users file
+	if len(os.Args) > 1 && runCommand(os.Args[1]) {
+		return
+	}
+
 	// Parse configuration
 	cfg, err := config.Parse(os.Args[1:])
 	if err != nil {
 		log.Fatalf("Failed to parse configuration: %v", err)
 	}
 
-	// Create store directory
-	if err := util.CheckAndCreateDir(cfg.Store); err != nil {
-		log.Fatalf("Failed to create store directory: %v", err)
-	}
-
 	// Prepare temporary directory
 	tmpDir, err := util.PrepareTmpDir()
 	if err != nil {
 		log.Fatalf("Failed to prepare temporary directory: %v", err)
 	}
 
-	// Set temporary directory environment variable
-	// This is necessary because the default temporary directory (/tmp) may have limited space
-	// and may not be sufficient for large file uploads (>2GB)
-	os.Setenv("TMPDIR", tmpDir)
+	// Uploads are staged in the temporary directory before being moved into the store.
+	// The default temporary directory (/tmp) may have limited space and may not be
+	// sufficient for large file uploads (>2GB), so a dedicated one is used.
+	cfg.Tmp = tmpDir
+
+	// Create storage backend
+	var store storage.Storage
+	switch cfg.Storage {
+	case "memory":
+		store = storage.NewMemory()
+	case "s3":
+		store, err = storage.NewS3(storage.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Bucket:    cfg.S3Bucket,
+			Region:    cfg.S3Region,
+			Prefix:    cfg.S3Prefix,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create S3 storage: %v", err)
+		}
+	default:
+		// Create store directory
+		if err := util.CheckAndCreateDir(cfg.Store); err != nil {
+			log.Fatalf("Failed to create store directory: %v", err)
+		}
+
+		local := storage.NewLocal(cfg.Store, cfg.Tmp)
+
+		// Remove staging files left behind by uploads that were interrupted by a crash or restart
+		if n, err := local.CleanStaging(); err != nil {
+			log.Printf("Failed to clean staging files: %v", err)
+		} else if n > 0 {
+			log.Printf("Removed %d orphaned staging file(s)", n)
+		}
+		store = local
+	}
+
+	// Keep every distinct content once, with the files pointing to it
+	if cfg.Dedup {
+		dedup, err := storage.NewDedup(store, service.IsMetadata)
+		if err != nil {
+			log.Fatalf("Failed to set up deduplication: %v", err)
+		}
+		stats := dedup.Stats()
+		log.Printf("Deduplication: %d file(s) in %d blob(s), %s stored for %s",
+			stats.Files, stats.Blobs, util.HumanReadableSize(stats.Stored), util.HumanReadableSize(stats.Size))
+		store = dedup
+	} else if dedup, err := storage.IsDeduplicated(store); err != nil {
+		log.Fatalf("Failed to check for deduplicated files: %v", err)
+	} else if dedup {
+		// Its files are pointers, which would be served instead of their content
+		log.Fatalf("The store holds deduplicated files in %s, it has to be served with -dedup", storage.DedupDir)
+	}
 
 	// Create service layer
-	svc := service.New(cfg)
+	svc := service.New(cfg, store)
+
+	// Periodically delete expired files, uploads and links and old trash
+	svc.StartJanitor(cfg.JanitorInterval)
 
 	// Create template filesystem
 	templates, err := fs.Sub(web.TemplatesFS, "templates")
@@ -52,19 +110,67 @@ func main() {
 		log.Fatalf("Failed to create handler: %v", err)
 	}
 
+	// Require login if a users file is given
+	var authenticator *auth.Authenticator
+	if cfg.UsersFile != "" {
+		authenticator, err = auth.Load(cfg.UsersFile, cfg.SessionTTL)
+		if err != nil {
+			log.Fatalf("Failed to load users: %v", err)
+		}
+		h.EnableAuth(authenticator)
+	}
+
 	// Register routes
 	mux := http.NewServeMux()
 	h.RegisterRoutes(mux)
 
+	var root http.Handler = mux
+	if authenticator != nil {
+		root = authenticator.Middleware(mux, h.PublicPaths()...)
+	}
+
 	// Start server
 	addr := fmt.Sprintf(":%s", cfg.Port)
 	log.Printf("Server starting on %s", addr)
-	log.Printf("Store directory: %s", cfg.Store)
+	log.Printf("Storage backend: %s", cfg.Storage)
+	if cfg.Storage == "local" {
+		log.Printf("Store directory: %s", cfg.Store)
+	}
 	log.Printf("Temporary directory: %s", tmpDir)
 	log.Printf("Max upload size: %s", svc.GetMaxUploadSizeHuman())
 	log.Printf("Delete enabled: %t", svc.IsDeleteEnabled())
+	log.Printf("Authentication enabled: %t", authenticator != nil)
+	if svc.IsS3APIEnabled() {
+		log.Printf("S3 API: http://%s:%s/s3 (bucket %s)", cfg.Hostname, cfg.Port, svc.S3APIBucket())
+	}
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	if err := http.ListenAndServe(addr, root); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// runCommand runs one of the helper commands and reports whether name was one
+func runCommand(name string) bool {
+	switch name {
+	case "hash-password":
+		// Read the password from stdin, so it does not end up in the shell history
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			log.Fatalf("Failed to read password: %v", err)
+		}
+		hash, err := auth.HashPassword(strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			log.Fatalf("Failed to hash password: %v", err)
+		}
+		fmt.Println(hash)
+	case "new-token":
+		token, hash, err := auth.NewToken()
+		if err != nil {
+			log.Fatalf("Failed to create token: %v", err)
+		}
+		fmt.Printf("Token: %s\nHash:  %s\n", token, hash)
+	default:
+		return false
+	}
+	return true
+}
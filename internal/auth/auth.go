@@ -0,0 +1,369 @@
+// Package auth identifies the users of fsrv.
+//
+// Users and API tokens are defined in a JSON users file:
+//
+//	{
+//	  "users":  [{"name": "alice", "password": "pbkdf2-sha256$310000$...", "role": "editor"}],
+//	  "tokens": [{"name": "backup", "user": "alice", "hash": "sha256:...", "role": "read-only"}]
+//	}
+//
+// The role of a user decides what they may do, see Permission; it can be
+// limited to a folder with "dir". Tokens act as their user unless they are
+// given a narrower role or folder of their own.
+//
+// Browsers log in with a form and get a session cookie, while scripts and
+// WebDAV clients send HTTP Basic credentials or an API token as a bearer token.
+// Passwords and tokens are only ever stored hashed.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paths of the login form and the logout action, and the name of the session cookie
+const (
+	LoginPath     = "/login"
+	LogoutPath    = "/logout"
+	SessionCookie = "fsrv_session"
+)
+
+// Errors returned by Authenticate
+var (
+	ErrUnauthenticated    = errors.New("authentication required")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// verifiedTTL is how long a successfully checked password is remembered, so
+// clients sending Basic credentials with every request, like WebDAV clients,
+// do not pay for the password hash each time
+const verifiedTTL = 5 * time.Minute
+
+// Principal is the authenticated user of a request
+type Principal struct {
+	Name  string // user name
+	Token string // name of the API token the request was made with, if any
+	Role  string // role of the user or token
+	Dir   string // folder the role is limited to, "" for the whole store
+
+	perms Permission
+}
+
+// usersFile is the format of the users file
+type usersFile struct {
+	Users []struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+		Dir      string `json:"dir"`
+	} `json:"users"`
+	Tokens []struct {
+		Name string `json:"name"`
+		User string `json:"user"`
+		Hash string `json:"hash"`
+		Role string `json:"role"`
+		Dir  string `json:"dir"`
+	} `json:"tokens"`
+}
+
+// account is a user from the users file
+type account struct {
+	password string
+	grant    grant
+}
+
+// apiToken is a token from the users file
+type apiToken struct {
+	name  string
+	user  string
+	grant grant
+}
+
+// Authenticator checks credentials against the users file and keeps the sessions
+// of logged in browsers
+type Authenticator struct {
+	users      map[string]account  // keyed by user name
+	tokens     map[string]apiToken // token hash -> token
+	sessions   *sessionTable
+	sessionTTL time.Duration
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]time.Time // recently checked credentials -> valid until
+}
+
+// Load reads the users file at path. Sessions of the login form last sessionTTL.
+func Load(path string, sessionTTL time.Duration) (*Authenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %w", err)
+	}
+	a, err := Parse(data, sessionTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid users file '%s': %w", path, err)
+	}
+	return a, nil
+}
+
+// Parse is like Load for the content of a users file
+func Parse(data []byte, sessionTTL time.Duration) (*Authenticator, error) {
+	var file usersFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	a := &Authenticator{
+		users:      make(map[string]account),
+		tokens:     make(map[string]apiToken),
+		sessions:   newSessionTable(),
+		sessionTTL: sessionTTL,
+		verified:   make(map[[sha256.Size]byte]time.Time),
+	}
+	for _, user := range file.Users {
+		if user.Name == "" || strings.ContainsAny(user.Name, ":\r\n") {
+			return nil, fmt.Errorf("invalid user name '%s'", user.Name)
+		}
+		if _, ok := a.users[user.Name]; ok {
+			return nil, fmt.Errorf("user '%s' is defined twice", user.Name)
+		}
+		if _, _, _, err := parseHash(user.Password); err != nil {
+			return nil, fmt.Errorf("user '%s': %w", user.Name, err)
+		}
+		g, err := newGrant(user.Role, user.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("user '%s': %w", user.Name, err)
+		}
+		a.users[user.Name] = account{password: user.Password, grant: g}
+	}
+	for _, token := range file.Tokens {
+		owner, ok := a.users[token.User]
+		if !ok {
+			return nil, fmt.Errorf("token '%s' belongs to unknown user '%s'", token.Name, token.User)
+		}
+		hash := strings.ToLower(token.Hash)
+		if !strings.HasPrefix(hash, "sha256:") || len(hash) != len("sha256:")+2*sha256.Size {
+			return nil, fmt.Errorf("token '%s': invalid hash, expected 'sha256:<hex>'", token.Name)
+		}
+		g, err := owner.grant.narrow(token.Role, token.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("token '%s': %w", token.Name, err)
+		}
+		a.tokens[hash] = apiToken{name: token.Name, user: token.User, grant: g}
+	}
+	if len(a.users) == 0 {
+		return nil, errors.New("no users defined")
+	}
+	return a, nil
+}
+
+// CheckPassword returns the principal for a user name and password
+func (a *Authenticator) CheckPassword(name, password string) (*Principal, error) {
+	user, ok := a.users[name]
+	hash := user.password
+	key := sha256.Sum256([]byte(name + "\x00" + password + "\x00" + hash))
+	if ok && a.recentlyVerified(key) {
+		return newPrincipal(name, "", user.grant), nil
+	}
+
+	if !ok {
+		// Take as long as for a known user, so user names cannot be probed
+		CheckPassword(dummyHash(), password)
+		return nil, ErrInvalidCredentials
+	}
+	if !CheckPassword(hash, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	a.remember(key)
+	return newPrincipal(name, "", user.grant), nil
+}
+
+// Authenticate identifies the user of a request by its bearer token, Basic
+// credentials or session cookie, in that order. It fails with ErrUnauthenticated
+// if the request carries no credentials at all.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, credentials, _ := strings.Cut(header, " ")
+		switch {
+		case strings.EqualFold(scheme, "Bearer"):
+			token, ok := a.tokens[HashToken(strings.TrimSpace(credentials))]
+			if !ok {
+				return nil, ErrInvalidCredentials
+			}
+			return newPrincipal(token.user, token.name, token.grant), nil
+		case strings.EqualFold(scheme, "Basic"):
+			name, password, ok := r.BasicAuth()
+			if !ok {
+				return nil, ErrInvalidCredentials
+			}
+			return a.CheckPassword(name, password)
+		default:
+			return nil, ErrInvalidCredentials
+		}
+	}
+
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		if name, ok := a.sessions.get(cookie.Value); ok {
+			// The user may have been removed from the users file since
+			if user, ok := a.users[name]; ok {
+				return newPrincipal(name, "", user.grant), nil
+			}
+		}
+	}
+	return nil, ErrUnauthenticated
+}
+
+// StartSession logs the browser of a request in as p by setting the session cookie
+func (a *Authenticator) StartSession(w http.ResponseWriter, r *http.Request, p *Principal) error {
+	id, err := a.sessions.create(p.Name, a.sessionTTL)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(a.sessionTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// EndSession logs the browser of a request out
+func (a *Authenticator) EndSession(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		a.sessions.remove(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Middleware only lets authenticated requests through to next, making their
+// principal available with FromContext.
+//
+// Requests for the public paths pass without credentials; a path ending in a
+// slash stands for everything below it. Browsers are sent to the login form,
+// everybody else gets a 401 with a Basic challenge.
+func (a *Authenticator) Middleware(next http.Handler, public ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.Authenticate(r)
+		if err == nil {
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+			return
+		}
+		if isPublic(r.URL.Path, public) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if errors.Is(err, ErrInvalidCredentials) {
+			log.Printf("Rejected credentials for %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		}
+		if errors.Is(err, ErrUnauthenticated) && isBrowser(r) {
+			http.Redirect(w, r, LoginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="fsrv", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// NewPrincipal returns the principal of a client authenticated by other means
+// than the users file, such as an S3 API access key, acting with role limited to
+// dir. An empty role means DefaultRole and an empty dir the whole store.
+func NewPrincipal(name, role, dir string) (*Principal, error) {
+	g, err := newGrant(role, dir)
+	if err != nil {
+		return nil, err
+	}
+	return newPrincipal(name, "", g), nil
+}
+
+// newPrincipal returns the principal of a user, or of one of their tokens
+func newPrincipal(name, token string, g grant) *Principal {
+	return &Principal{Name: name, Token: token, Role: g.role, Dir: g.dir, perms: g.perms}
+}
+
+// recentlyVerified reports whether credentials with the given key were checked
+// successfully within verifiedTTL
+func (a *Authenticator) recentlyVerified(key [sha256.Size]byte) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	until, ok := a.verified[key]
+	return ok && time.Now().Before(until)
+}
+
+// remember records credentials that were checked successfully
+func (a *Authenticator) remember(key [sha256.Size]byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	for k, until := range a.verified {
+		if now.After(until) {
+			delete(a.verified, k)
+		}
+	}
+	a.verified[key] = now.Add(verifiedTTL)
+}
+
+var (
+	dummyOnce sync.Once
+	dummy     string
+)
+
+// dummyHash returns a password hash that no password is checked against successfully
+func dummyHash() string {
+	dummyOnce.Do(func() {
+		dummy, _ = HashPassword("")
+	})
+	return dummy
+}
+
+// isPublic reports whether path is one of the public paths
+func isPublic(path string, public []string) bool {
+	for _, p := range public {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBrowser reports whether a request comes from a browser navigating to a page
+func isBrowser(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// contextKey is the type of the context key holding the principal
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying p
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal of a request, or nil if authentication is disabled
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testHashOnce sync.Once
+	testHash     string
+)
+
+// testUsers returns a users file with alice (password "secret") and a token for her
+func testUsers(t *testing.T) (*Authenticator, string) {
+	t.Helper()
+	testHashOnce.Do(func() {
+		testHash, _ = HashPassword("secret")
+	})
+	token, tokenHash, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken() error = %v", err)
+	}
+	data := fmt.Sprintf(`{"users": [{"name": "alice", "password": %q}],
+		"tokens": [{"name": "backup", "user": "alice", "hash": %q}]}`, testHash, tokenHash)
+	a, err := Parse([]byte(data), time.Hour)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return a, token
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := map[string]string{
+		"no users":       `{"users": []}`,
+		"bad hash":       `{"users": [{"name": "alice", "password": "secret"}]}`,
+		"colon in name":  `{"users": [{"name": "a:b", "password": "pbkdf2-sha256$1$c2FsdA$a2V5"}]}`,
+		"duplicate user": `{"users": [{"name": "a", "password": "pbkdf2-sha256$1$c2FsdA$a2V5"}, {"name": "a", "password": "pbkdf2-sha256$1$c2FsdA$a2V5"}]}`,
+		"unknown owner":  `{"users": [{"name": "a", "password": "pbkdf2-sha256$1$c2FsdA$a2V5"}], "tokens": [{"name": "t", "user": "b", "hash": "sha256:00"}]}`,
+		"bad token hash": `{"users": [{"name": "a", "password": "pbkdf2-sha256$1$c2FsdA$a2V5"}], "tokens": [{"name": "t", "user": "a", "hash": "plain"}]}`,
+		"not json":       `alice:secret`,
+	}
+	for name, data := range tests {
+		if _, err := Parse([]byte(data), time.Hour); err == nil {
+			t.Errorf("Parse() with %s succeeded", name)
+		}
+	}
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	a, token := testUsers(t)
+
+	request := func(header string) *http.Request {
+		req := httptest.NewRequest("GET", "/files", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		return req
+	}
+	basic := func(user, password string) string {
+		req := request("")
+		req.SetBasicAuth(user, password)
+		return req.Header.Get("Authorization")
+	}
+
+	tests := []struct {
+		name      string
+		header    string
+		wantUser  string
+		wantToken string
+		wantErr   error
+	}{
+		{"basic", basic("alice", "secret"), "alice", "", nil},
+		{"basic again from the cache", basic("alice", "secret"), "alice", "", nil},
+		{"wrong password", basic("alice", "wrong"), "", "", ErrInvalidCredentials},
+		{"unknown user", basic("bob", "secret"), "", "", ErrInvalidCredentials},
+		{"bearer", "Bearer " + token, "alice", "backup", nil},
+		{"unknown token", "Bearer fsrv_nope", "", "", ErrInvalidCredentials},
+		{"other scheme", "Digest username=alice", "", "", ErrInvalidCredentials},
+		{"no credentials", "", "", "", ErrUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.Authenticate(request(tt.header))
+			if err != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (p.Name != tt.wantUser || p.Token != tt.wantToken) {
+				t.Errorf("Authenticate() = %+v", p)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_Session(t *testing.T) {
+	a, _ := testUsers(t)
+
+	w := httptest.NewRecorder()
+	if err := a.StartSession(w, httptest.NewRequest("POST", LoginPath, nil), &Principal{Name: "alice"}); err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != SessionCookie || !cookies[0].HttpOnly {
+		t.Fatalf("StartSession() set cookies %v", cookies)
+	}
+
+	req := httptest.NewRequest("GET", "/files", nil)
+	req.AddCookie(cookies[0])
+	if p, err := a.Authenticate(req); err != nil || p.Name != "alice" {
+		t.Errorf("Authenticate() with session cookie = %v, %v", p, err)
+	}
+
+	a.EndSession(httptest.NewRecorder(), req)
+	if _, err := a.Authenticate(req); err != ErrUnauthenticated {
+		t.Errorf("Authenticate() after EndSession() error = %v, want %v", err, ErrUnauthenticated)
+	}
+
+	forged := httptest.NewRequest("GET", "/files", nil)
+	forged.AddCookie(&http.Cookie{Name: SessionCookie, Value: "forged"})
+	if _, err := a.Authenticate(forged); err != ErrUnauthenticated {
+		t.Errorf("Authenticate() with a forged cookie error = %v, want %v", err, ErrUnauthenticated)
+	}
+}
+
+func TestAuthenticator_Middleware(t *testing.T) {
+	a, token := testUsers(t)
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := FromContext(r.Context()); p != nil {
+			fmt.Fprint(w, p.Name)
+		}
+	}), LoginPath, "/s3/")
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Scripts get a Basic challenge
+	w := serve(httptest.NewRequest("PUT", "/files/a.txt", strings.NewReader("data")))
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic") {
+		t.Errorf("unauthenticated request = %d, WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	// Browsers are sent to the login form, which remembers where they were going
+	req := httptest.NewRequest("GET", "/files?dir=docs", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w = serve(req)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login?next=%2Ffiles%3Fdir%3Ddocs" {
+		t.Errorf("unauthenticated browser = %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// Public paths need no credentials
+	for _, path := range []string{"/login", "/s3/bucket/key"} {
+		if w := serve(httptest.NewRequest("GET", path, nil)); w.Code != http.StatusOK {
+			t.Errorf("public path %s = %d, want %d", path, w.Code, http.StatusOK)
+		}
+	}
+	if w := serve(httptest.NewRequest("GET", "/login/other", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("path below an exact public path = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest("GET", "/files", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if w := serve(req); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Errorf("authenticated request = %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Permission is a set of actions a principal may perform on files and folders
+type Permission uint
+
+// Permissions checked by the handlers
+const (
+	PermList      Permission = 1 << iota // list folders and look up files
+	PermDownload                         // read file contents
+	PermUpload                           // add new files and create folders
+	PermOverwrite                        // replace existing files
+	PermDelete                           // delete files and folders
+	PermAdmin                            // server wide actions that are not about a single file
+)
+
+// Roles that can be given to users and tokens in the users file
+const (
+	RoleReadOnly = "read-only"
+	RoleUploader = "uploader"
+	RoleEditor   = "editor"
+	RoleAdmin    = "admin"
+)
+
+// DefaultRole is the role of users without one, so users files written before
+// roles existed keep working as they did
+const DefaultRole = RoleAdmin
+
+// roles maps role names to their permissions
+var roles = map[string]Permission{
+	RoleReadOnly: PermList | PermDownload,
+	RoleUploader: PermList | PermDownload | PermUpload,
+	RoleEditor:   PermList | PermDownload | PermUpload | PermOverwrite | PermDelete,
+	RoleAdmin:    PermList | PermDownload | PermUpload | PermOverwrite | PermDelete | PermAdmin,
+}
+
+// grant is a role, optionally scoped to a folder
+type grant struct {
+	role  string
+	perms Permission
+	dir   string // folder the role is limited to, "" for the whole store
+}
+
+// newGrant checks a role and scope from the users file; an empty role means DefaultRole
+func newGrant(role, dir string) (grant, error) {
+	if role == "" {
+		role = DefaultRole
+	}
+	perms, ok := roles[role]
+	if !ok {
+		return grant{}, fmt.Errorf("unknown role '%s'", role)
+	}
+	return grant{role: role, perms: perms, dir: cleanName(dir)}, nil
+}
+
+// narrow returns the grant of a token given role and dir, which must not exceed g.
+// Empty values inherit those of g.
+func (g grant) narrow(role, dir string) (grant, error) {
+	narrowed := g
+	if role != "" {
+		perms, ok := roles[role]
+		if !ok {
+			return grant{}, fmt.Errorf("unknown role '%s'", role)
+		}
+		if perms&^g.perms != 0 {
+			return grant{}, fmt.Errorf("role '%s' exceeds the role '%s' of its user", role, g.role)
+		}
+		narrowed.role, narrowed.perms = role, perms
+	}
+	if dir != "" {
+		narrowed.dir = cleanName(dir)
+		if !within(narrowed.dir, g.dir) {
+			return grant{}, fmt.Errorf("folder '%s' is outside the folder '%s' of its user", narrowed.dir, g.dir)
+		}
+	}
+	return narrowed, nil
+}
+
+// Can reports whether p may perform perm on the file or folder name, a slash
+// separated path relative to the store root.
+//
+// Only names inside the folder the role is scoped to are allowed, except that
+// the folders leading to it may be listed so it can be navigated to; listings
+// of those folders should be filtered with Can as well.
+// A nil principal, used without authentication, may do everything.
+func (p *Principal) Can(perm Permission, name string) bool {
+	if p == nil {
+		return true
+	}
+	if p.perms&perm != perm {
+		return false
+	}
+	name = cleanName(name)
+	if perm == PermList && within(p.Dir, name) {
+		return true
+	}
+	return within(name, p.Dir)
+}
+
+// within reports whether name is dir or inside it
+func within(name, dir string) bool {
+	return dir == "" || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// cleanName normalizes a path relative to the store root
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+}
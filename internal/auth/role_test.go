@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPrincipal_Can(t *testing.T) {
+	reader := newPrincipal("r", "", grant{role: RoleReadOnly, perms: roles[RoleReadOnly]})
+	scoped := newPrincipal("e", "", grant{role: RoleEditor, perms: roles[RoleEditor], dir: "team/docs"})
+	admin := newPrincipal("a", "", grant{role: RoleAdmin, perms: roles[RoleAdmin]})
+
+	tests := []struct {
+		name string
+		p    *Principal
+		perm Permission
+		file string
+		want bool
+	}{
+		{"no authentication", nil, PermDelete, "a.txt", true},
+		{"reader lists", reader, PermList, "", true},
+		{"reader downloads", reader, PermDownload, "a/b.txt", true},
+		{"reader uploads", reader, PermUpload, "a.txt", false},
+		{"reader deletes", reader, PermDelete, "a.txt", false},
+		{"scoped in its folder", scoped, PermDelete, "team/docs/a.txt", true},
+		{"scoped on its folder", scoped, PermUpload, "team/docs", true},
+		{"scoped outside", scoped, PermDownload, "team/other.txt", false},
+		{"scoped on a prefix sibling", scoped, PermDownload, "team/docs2/a.txt", false},
+		{"scoped lists the way there", scoped, PermList, "team", true},
+		{"scoped lists the root", scoped, PermList, "", true},
+		{"scoped lists a sibling", scoped, PermList, "other", false},
+		{"scoped uploads on the way there", scoped, PermUpload, "team/a.txt", false},
+		{"scoped escapes with dots", scoped, PermDownload, "team/docs/../other.txt", false},
+		{"scoped with backslashes", scoped, PermDownload, "team\\docs\\a.txt", true},
+		{"editor has no admin", scoped, PermAdmin, "team/docs", false},
+		{"admin has admin", admin, PermAdmin, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Can(tt.perm, tt.file); got != tt.want {
+				t.Errorf("Can(%v, %q) = %v, want %v", tt.perm, tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_Roles(t *testing.T) {
+	testHashOnce.Do(func() {
+		testHash, _ = HashPassword("secret")
+	})
+	token, tokenHash, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken() error = %v", err)
+	}
+	data := fmt.Sprintf(`{"users": [{"name": "alice", "password": %q, "role": "editor", "dir": "/team/"},
+			{"name": "bob", "password": %q}],
+		"tokens": [{"name": "ci", "user": "alice", "hash": %q, "role": "read-only", "dir": "team/builds"}]}`,
+		testHash, testHash, tokenHash)
+	a, err := Parse([]byte(data), time.Hour)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	p, err := a.CheckPassword("alice", "secret")
+	if err != nil || p.Role != RoleEditor || p.Dir != "team" {
+		t.Errorf("alice = %+v, %v, want editor of team", p, err)
+	}
+	if p, err := a.CheckPassword("bob", "secret"); err != nil || p.Role != DefaultRole || p.Dir != "" {
+		t.Errorf("bob = %+v, %v, want the default role", p, err)
+	}
+
+	req := httptest.NewRequest("GET", "/files", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	p, err = a.Authenticate(req)
+	if err != nil || p.Role != RoleReadOnly || p.Dir != "team/builds" || p.Can(PermUpload, "team/builds/a") {
+		t.Errorf("token = %+v, %v, want read-only on team/builds", p, err)
+	}
+
+	invalid := map[string]string{
+		"unknown role":       `{"users": [{"name": "a", "password": "pbkdf2-sha256$1$c2FsdA$a2V5", "role": "owner"}]}`,
+		"token above user":   `{"users": [{"name": "a", "password": "pbkdf2-sha256$1$c2FsdA$a2V5", "role": "uploader"}], "tokens": [{"name": "t", "user": "a", "hash": "` + tokenHash + `", "role": "editor"}]}`,
+		"token outside user": `{"users": [{"name": "a", "password": "pbkdf2-sha256$1$c2FsdA$a2V5", "dir": "team"}], "tokens": [{"name": "t", "user": "a", "hash": "` + tokenHash + `", "dir": "other"}]}`,
+	}
+	for name, data := range invalid {
+		if _, err := Parse([]byte(data), time.Hour); err == nil {
+			t.Errorf("Parse() with %s succeeded", name)
+		}
+	}
+}
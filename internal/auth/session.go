@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// session is a logged in browser
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// sessionTable keeps the sessions of the login form in memory, so logging in
+// again is necessary after a restart
+type sessionTable struct {
+	mu       sync.Mutex
+	sessions map[string]session // keyed by session id
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{sessions: make(map[string]session)}
+}
+
+// create starts a session for user that lasts ttl and returns its id
+func (t *sessionTable) create(user string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	t.sessions[id] = session{user: user, expires: time.Now().Add(ttl)}
+	return id, nil
+}
+
+// get returns the user of a session that has not expired
+func (t *sessionTable) get(id string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.sessions[id]
+	if !ok || time.Now().After(s.expires) {
+		return "", false
+	}
+	return s.user, true
+}
+
+// remove ends a session
+func (t *sessionTable) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sessions, id)
+}
+
+// expire drops sessions that have expired. The caller must hold t.mu.
+func (t *sessionTable) expire() {
+	now := time.Now()
+	for id, s := range t.sessions {
+		if now.After(s.expires) {
+			delete(t.sessions, id)
+		}
+	}
+}
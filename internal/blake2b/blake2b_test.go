@@ -0,0 +1,59 @@
+package blake2b
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestSum512(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"", "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		// RFC 7693, appendix A
+		{"abc", "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{"The quick brown fox jumps over the lazy dog", "a8add4bdddfd93e4877d2746e62817b116364a1fa7bc148d95090bc7333b3673f82401cf7aa2e4cb1ecd90296e3f14cb5413f8ed77be73045b13914cdcd6a918"},
+	}
+	for _, tt := range tests {
+		sum := Sum512([]byte(tt.data))
+		if got := hex.EncodeToString(sum[:]); got != tt.want {
+			t.Errorf("Sum512(%q) = %s, want %s", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestNew512_Writes(t *testing.T) {
+	// Block sized and odd sized inputs, written whole and in pieces, give the same checksum
+	for _, size := range []int{127, 128, 129, 256, 1000} {
+		data := bytes.Repeat([]byte("fsrv"), size)[:size]
+		want := Sum512(data)
+
+		h := New512()
+		for _, piece := range []int{1, 63, 128, 200} {
+			if piece > len(data) {
+				piece = len(data)
+			}
+			h.Write(data[:piece])
+			data = data[piece:]
+		}
+		h.Write(data)
+		if got := h.Sum(nil); !bytes.Equal(got, want[:]) {
+			t.Errorf("size %d: written in pieces = %x, want %x", size, got, want)
+		}
+		// Sum does not change the state
+		if got := h.Sum(nil); !bytes.Equal(got, want[:]) {
+			t.Errorf("size %d: second Sum() = %x, want %x", size, got, want)
+		}
+	}
+
+	h := New512()
+	h.Write([]byte(strings.Repeat("x", 300)))
+	h.Reset()
+	h.Write([]byte("abc"))
+	if got, want := h.Sum(nil), Sum512([]byte("abc")); !bytes.Equal(got, want[:]) {
+		t.Errorf("after Reset() = %x, want %x", got, want)
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
+	"fsrv/internal/auth"
 	"fsrv/internal/util"
 )
 
@@ -15,6 +19,89 @@ type Config struct {
 	Hostname string
 	Store    string
 	Max      int64
+	SubDirs  bool
+
+	// Storage selects the storage backend: "local" keeps files in Store, "memory" in RAM
+	// and "s3" in the bucket of an S3 compatible object store
+	Storage string
+
+	// Dedup stores every distinct content once, as a blob named by its SHA-256
+	// that the files with that content point to (see storage.Dedup). It works
+	// with the local and memory storage backends.
+	Dedup bool
+
+	// S3 backend settings. The keys default to AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+	S3Endpoint  string
+	S3Bucket    string
+	S3Region    string
+	S3Prefix    string
+	S3AccessKey string
+	S3SecretKey string
+
+	// S3API serves the store as a bucket over the S3 API, for clients that sign
+	// requests with one of the access keys in S3APIKeys (access key -> key).
+	// The keys default to FSRV_S3_API_KEYS.
+	S3APIBucket string
+	S3APIKeys   map[string]S3APIKey
+
+	// UsersFile enables authentication with the users and API tokens defined in
+	// it (see package auth). Login sessions last SessionTTL.
+	UsersFile  string
+	SessionTTL time.Duration
+
+	// ShareMaxTTL is the longest time a share link or file request may last
+	ShareMaxTTL time.Duration
+
+	// FileTTL is how long uploaded files are kept when the uploader does not ask
+	// for another lifetime, 0 to keep them until they are deleted. FileMaxTTL is
+	// the longest lifetime that can be asked for, 0 for no limit; it is the
+	// default when FileTTL is 0.
+	FileTTL    time.Duration
+	FileMaxTTL time.Duration
+
+	// TrashRetention is how long deleted files are kept in the trash; 0 deletes them right away
+	TrashRetention time.Duration
+
+	// Versions is how many previous versions of a file are kept when it is
+	// uploaded again; 0 disables versioning, so uploads to taken names fail
+	Versions int
+
+	// OnConflict is what uploads do by default when their name is taken: "fail",
+	// "overwrite" or "rename". Empty means fail, or overwrite with versioning.
+	OnConflict string
+
+	// Checksums lists the checksums computed besides SHA-256: "md5" and "blake2b"
+	Checksums []string
+
+	// QuotaBytes and QuotaFiles cap the size and number of the files in the
+	// store, and UserQuota the size of the files each user uploaded; 0 is no
+	// limit. Files in the trash and previous versions do not count.
+	QuotaBytes int64
+	QuotaFiles int
+	UserQuota  int64
+
+	// DiskReserve is the disk space uploads must leave free on the filesystems
+	// of the store and the tmp directory; uploads that would use it are refused
+	DiskReserve int64
+
+	// TusExpiry is how long an incomplete resumable upload is kept without activity
+	TusExpiry time.Duration
+
+	// JanitorInterval is how often expired files, uploads and links and files
+	// that have been in the trash too long are deleted
+	JanitorInterval time.Duration
+
+	// Tmp is the directory uploads are staged in before being moved into the store.
+	// It is not a flag: main fills it in with the directory prepared by util.PrepareTmpDir.
+	Tmp string
+}
+
+// S3APIKey is an access key of the S3 API. It acts with Role, the default role
+// of users if empty, limited to the folder Dir if set (see package auth).
+type S3APIKey struct {
+	Secret string
+	Role   string
+	Dir    string
 }
 
 // Parse parses command line arguments and returns the configuration
@@ -37,6 +124,10 @@ func Parse(args []string) (*Config, error) {
 		fmt.Fprintf(fs.Output(), "\nExamples:\n")
 		fmt.Fprintf(fs.Output(), "  %s -p 8081\n", fs.Name())
 		fmt.Fprintf(fs.Output(), "  %s -s /tmp/files -d\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "  %s -users users.json -dirs\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "\nCommands:\n")
+		fmt.Fprintf(fs.Output(), "  %s hash-password   read a password from stdin and print its hash for the users file\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "  %s new-token       print a new API token and its hash for the users file\n", fs.Name())
 	}
 
 	// Parse command line flags
@@ -45,6 +136,32 @@ func Parse(args []string) (*Config, error) {
 	fs.StringVar(&cfg.Store, "s", "./store", "Specify the directory to store files")
 	fs.StringVar(&cfg.Hostname, "n", hostname, "Specify the server name, default hostname")
 	fs.Int64Var(&cfg.Max, "m", 32, "Max file size to upload, power of 2 (e.g., 32 means 1<<32=4GB)")
+	fs.BoolVar(&cfg.SubDirs, "dirs", false, "Enable subdirectories: browse, create and upload into folders")
+	fs.StringVar(&cfg.Storage, "storage", "local", "Storage backend: local, memory (files are lost on exit) or s3")
+	fs.BoolVar(&cfg.Dedup, "dedup", false, "Store files with the same content only once (local and memory storage)")
+	fs.StringVar(&cfg.S3Endpoint, "s3-endpoint", "", "S3 endpoint URL, e.g. http://localhost:9000")
+	fs.StringVar(&cfg.S3Bucket, "s3-bucket", "", "S3 bucket to keep files in")
+	fs.StringVar(&cfg.S3Region, "s3-region", "us-east-1", "S3 region")
+	fs.StringVar(&cfg.S3Prefix, "s3-prefix", "", "Key prefix to keep files under in the S3 bucket")
+	fs.StringVar(&cfg.S3AccessKey, "s3-access-key", "", "S3 access key (default $AWS_ACCESS_KEY_ID)")
+	fs.StringVar(&cfg.S3SecretKey, "s3-secret-key", "", "S3 secret key (default $AWS_SECRET_ACCESS_KEY)")
+	fs.StringVar(&cfg.S3APIBucket, "s3-api-bucket", "fsrv", "Bucket name the S3 API serves the store as")
+	s3APIKeys := fs.String("s3-api-keys", "", "Enable the S3 API at /s3/ for these comma separated access keys, each with an optional role and folder, e.g. 'key1:secret1,key2:secret2:read-only:public' (default $FSRV_S3_API_KEYS)")
+	fs.StringVar(&cfg.UsersFile, "users", "", "Require login for the users and API tokens in this JSON file (default: no authentication)")
+	fs.DurationVar(&cfg.SessionTTL, "session-ttl", 24*time.Hour, "How long a login lasts")
+	fs.DurationVar(&cfg.ShareMaxTTL, "share-max-ttl", 30*24*time.Hour, "Longest time a share link or file request may last")
+	fs.DurationVar(&cfg.FileTTL, "ttl", 0, "Delete uploaded files after this long unless the uploader asks otherwise, 0 to keep them (default: the -max-ttl)")
+	fs.DurationVar(&cfg.FileMaxTTL, "max-ttl", 0, "Longest time uploaded files may be kept, 0 for no limit")
+	fs.DurationVar(&cfg.TrashRetention, "trash-retention", 7*24*time.Hour, "How long deleted files are kept in the trash, 0 to delete them right away")
+	fs.IntVar(&cfg.Versions, "versions", 0, "Keep up to this many previous versions of a file when it is uploaded again, 0 to refuse uploads to taken names")
+	fs.StringVar(&cfg.OnConflict, "on-conflict", "", "What uploads do when their name is taken: fail, overwrite or rename (default fail, or overwrite with -versions)")
+	checksums := fs.String("checksums", "", "Also compute these comma separated checksums besides SHA-256: md5, blake2b")
+	quota := fs.String("quota", "", "Limit the total size of the stored files, e.g. 100GB (default: no limit)")
+	fs.IntVar(&cfg.QuotaFiles, "quota-files", 0, "Limit the number of stored files, 0 for no limit")
+	userQuota := fs.String("user-quota", "", "Limit the total size of the files each user uploads, e.g. 10GB; needs -users (default: no limit)")
+	diskReserve := fs.String("disk-reserve", "0", "Refuse uploads that would leave less disk space free than this, e.g. 1GB, 0 to only refuse those that do not fit")
+	fs.DurationVar(&cfg.TusExpiry, "tus-expiry", 24*time.Hour, "How long incomplete resumable uploads are kept without activity")
+	fs.DurationVar(&cfg.JanitorInterval, "janitor-interval", time.Hour, "How often expired files, uploads and links and old trash are deleted")
 
 	// Parse arguments
 	if err := fs.Parse(args); err != nil {
@@ -54,13 +171,180 @@ func Parse(args []string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse flags: %w", err)
 	}
 
+	switch cfg.Storage {
+	case "local", "memory":
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, fmt.Errorf("the s3 storage backend needs -s3-endpoint and -s3-bucket")
+		}
+		if cfg.Dedup {
+			return nil, fmt.Errorf("-dedup is not supported by the s3 storage backend")
+		}
+		if cfg.S3AccessKey == "" {
+			cfg.S3AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if cfg.S3SecretKey == "" {
+			cfg.S3SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+	default:
+		return nil, fmt.Errorf("unknown storage backend: '%s'", cfg.Storage)
+	}
+
+	if cfg.FileTTL < 0 || cfg.FileMaxTTL < 0 {
+		return nil, fmt.Errorf("invalid -ttl %s or -max-ttl %s, they cannot be negative", cfg.FileTTL, cfg.FileMaxTTL)
+	}
+	if cfg.FileMaxTTL > 0 && cfg.FileTTL > cfg.FileMaxTTL {
+		return nil, fmt.Errorf("-ttl %s is longer than -max-ttl %s", cfg.FileTTL, cfg.FileMaxTTL)
+	}
+
+	if cfg.JanitorInterval <= 0 {
+		return nil, fmt.Errorf("invalid -janitor-interval %s, it must be positive", cfg.JanitorInterval)
+	}
+
+	if cfg.Versions < 0 {
+		return nil, fmt.Errorf("invalid -versions %d, it cannot be negative", cfg.Versions)
+	}
+
+	switch cfg.OnConflict {
+	case "", "fail", "overwrite", "rename":
+	default:
+		return nil, fmt.Errorf("unknown conflict policy '%s', expected fail, overwrite or rename", cfg.OnConflict)
+	}
+
+	if *quota != "" {
+		if cfg.QuotaBytes, err = util.ParseSize(*quota); err != nil {
+			return nil, fmt.Errorf("invalid -quota: %w", err)
+		}
+	}
+	if cfg.QuotaFiles < 0 {
+		return nil, fmt.Errorf("invalid -quota-files %d, it cannot be negative", cfg.QuotaFiles)
+	}
+	if *userQuota != "" {
+		if cfg.UserQuota, err = util.ParseSize(*userQuota); err != nil {
+			return nil, fmt.Errorf("invalid -user-quota: %w", err)
+		}
+		if cfg.UserQuota > 0 && cfg.UsersFile == "" {
+			return nil, fmt.Errorf("-user-quota needs -users, uploads are anonymous without it")
+		}
+	}
+
+	if cfg.DiskReserve, err = util.ParseSize(*diskReserve); err != nil {
+		return nil, fmt.Errorf("invalid -disk-reserve: %w", err)
+	}
+
+	if cfg.Checksums, err = parseChecksums(*checksums); err != nil {
+		return nil, err
+	}
+
+	if *s3APIKeys == "" {
+		*s3APIKeys = os.Getenv("FSRV_S3_API_KEYS")
+	}
+	if cfg.S3APIKeys, err = parseKeys(*s3APIKeys); err != nil {
+		return nil, err
+	}
+
 	// Print configuration
 	fmt.Printf("Configuration:\n")
 	fmt.Printf("  Port: %s\n", cfg.Port)
-	fmt.Printf("  Store: %s\n", cfg.Store)
+	fmt.Printf("  Storage: %s\n", cfg.Storage)
+	if cfg.Storage == "s3" {
+		fmt.Printf("  S3 bucket: %s/%s (region %s, prefix '%s')\n", cfg.S3Endpoint, cfg.S3Bucket, cfg.S3Region, cfg.S3Prefix)
+	} else {
+		fmt.Printf("  Store: %s\n", cfg.Store)
+	}
+	if cfg.Dedup {
+		fmt.Printf("  Deduplication: enabled\n")
+	}
 	fmt.Printf("  Hostname: %s\n", cfg.Hostname)
 	fmt.Printf("  Delete enabled: %t\n", cfg.DelAble)
 	fmt.Printf("  Max file size: %d -> %s\n", cfg.Max, util.HumanReadableSize(1<<cfg.Max))
+	fmt.Printf("  Subdirectories enabled: %t\n", cfg.SubDirs)
+	fmt.Printf("  Resumable upload expiry: %s\n", cfg.TusExpiry)
+	fmt.Printf("  Share links and file requests last at most: %s\n", cfg.ShareMaxTTL)
+	if cfg.FileTTL > 0 {
+		fmt.Printf("  Default file lifetime: %s\n", cfg.FileTTL)
+	}
+	if cfg.FileMaxTTL > 0 {
+		fmt.Printf("  Maximum file lifetime: %s\n", cfg.FileMaxTTL)
+	}
+	fmt.Printf("  Trash retention: %s\n", cfg.TrashRetention)
+	fmt.Printf("  Cleanup interval: %s\n", cfg.JanitorInterval)
+	if cfg.Versions > 0 {
+		fmt.Printf("  Versions kept per file: %d\n", cfg.Versions)
+	} else {
+		fmt.Printf("  Versioning: disabled\n")
+	}
+	if cfg.OnConflict != "" {
+		fmt.Printf("  Upload conflicts: %s\n", cfg.OnConflict)
+	}
+	if cfg.QuotaBytes > 0 {
+		fmt.Printf("  Store quota: %s\n", util.HumanReadableSize(cfg.QuotaBytes))
+	}
+	if cfg.QuotaFiles > 0 {
+		fmt.Printf("  File quota: %d files\n", cfg.QuotaFiles)
+	}
+	if cfg.UserQuota > 0 {
+		fmt.Printf("  Quota per user: %s\n", util.HumanReadableSize(cfg.UserQuota))
+	}
+	fmt.Printf("  Disk reserve: %s\n", util.HumanReadableSize(cfg.DiskReserve))
+	fmt.Printf("  Checksums: %s\n", strings.Join(append([]string{"sha256"}, cfg.Checksums...), ", "))
+	if cfg.UsersFile != "" {
+		fmt.Printf("  Users file: %s (sessions last %s)\n", cfg.UsersFile, cfg.SessionTTL)
+	} else {
+		fmt.Printf("  Authentication: disabled\n")
+	}
+	if len(cfg.S3APIKeys) > 0 {
+		fmt.Printf("  S3 API: bucket '%s' with %d access key(s)\n", cfg.S3APIBucket, len(cfg.S3APIKeys))
+	}
 
 	return cfg, nil
 }
+
+// parseChecksums parses a comma separated list of checksum names, leaving out
+// sha256, which is always computed, and duplicates
+func parseChecksums(list string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "", "sha256":
+			continue
+		case "md5", "blake2b":
+		default:
+			return nil, fmt.Errorf("unknown checksum '%s', expected md5 or blake2b", name)
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// parseKeys parses a comma separated list of "access key:secret" pairs, each
+// optionally followed by ":role" and ":folder"
+func parseKeys(list string) (map[string]S3APIKey, error) {
+	keys := make(map[string]S3APIKey)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.SplitN(entry, ":", 4)
+		accessKey := fields[0]
+		if len(fields) < 2 || accessKey == "" || fields[1] == "" {
+			return nil, fmt.Errorf("invalid S3 API key '%s', expected 'access key:secret[:role[:folder]]'", accessKey)
+		}
+		key := S3APIKey{Secret: fields[1]}
+		if len(fields) > 2 {
+			key.Role = fields[2]
+		}
+		if len(fields) > 3 {
+			key.Dir = fields[3]
+		}
+		if _, err := auth.NewPrincipal(accessKey, key.Role, key.Dir); err != nil {
+			return nil, fmt.Errorf("invalid S3 API key '%s': %w", accessKey, err)
+		}
+		keys[accessKey] = key
+	}
+	return keys, nil
+}
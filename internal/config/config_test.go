@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 )
 
 func TestParse(t *testing.T) {
@@ -28,6 +29,33 @@ func TestParse(t *testing.T) {
 				if cfg.Max != 32 {
 					t.Errorf("expected max 32, got %d", cfg.Max)
 				}
+				if cfg.Storage != "local" {
+					t.Errorf("expected storage local, got %s", cfg.Storage)
+				}
+				if cfg.TusExpiry != 24*time.Hour {
+					t.Errorf("expected tus expiry 24h, got %s", cfg.TusExpiry)
+				}
+				if cfg.UsersFile != "" || cfg.SessionTTL != 24*time.Hour {
+					t.Errorf("expected no users file and 24h sessions, got '%s' and %s", cfg.UsersFile, cfg.SessionTTL)
+				}
+				if cfg.ShareMaxTTL != 30*24*time.Hour {
+					t.Errorf("expected share links to last at most 720h, got %s", cfg.ShareMaxTTL)
+				}
+				if cfg.TrashRetention != 7*24*time.Hour {
+					t.Errorf("expected a trash retention of 168h, got %s", cfg.TrashRetention)
+				}
+				if cfg.Versions != 0 {
+					t.Errorf("expected versioning to be disabled, got %d versions", cfg.Versions)
+				}
+				if cfg.FileTTL != 0 || cfg.FileMaxTTL != 0 {
+					t.Errorf("expected files to be kept, got a ttl of %s and at most %s", cfg.FileTTL, cfg.FileMaxTTL)
+				}
+				if cfg.DiskReserve != 0 {
+					t.Errorf("expected no disk reserve, got %d", cfg.DiskReserve)
+				}
+				if cfg.JanitorInterval != time.Hour {
+					t.Errorf("expected a cleanup every hour, got %s", cfg.JanitorInterval)
+				}
 			},
 		},
 		{
@@ -80,6 +108,239 @@ func TestParse(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:    "enable subdirectories",
+			args:    []string{"-dirs"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.SubDirs != true {
+					t.Errorf("expected SubDirs true, got %v", cfg.SubDirs)
+				}
+			},
+		},
+		{
+			name:    "memory storage",
+			args:    []string{"-storage", "memory"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Storage != "memory" {
+					t.Errorf("expected storage memory, got %s", cfg.Storage)
+				}
+			},
+		},
+		{
+			name:    "s3 storage",
+			args:    []string{"-storage", "s3", "-s3-endpoint", "http://localhost:9000", "-s3-bucket", "files", "-s3-access-key", "key"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.S3Endpoint != "http://localhost:9000" || cfg.S3Bucket != "files" || cfg.S3AccessKey != "key" {
+					t.Errorf("unexpected S3 settings: %+v", cfg)
+				}
+				if cfg.S3Region != "us-east-1" {
+					t.Errorf("expected region us-east-1, got %s", cfg.S3Region)
+				}
+			},
+		},
+		{
+			name:    "deduplication",
+			args:    []string{"-dedup"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if !cfg.Dedup {
+					t.Error("expected Dedup true")
+				}
+			},
+		},
+		{
+			name:    "deduplication on s3",
+			args:    []string{"-storage", "s3", "-s3-endpoint", "http://localhost:9000", "-s3-bucket", "files", "-dedup"},
+			wantErr: true,
+		},
+		{
+			name:    "versioning",
+			args:    []string{"-versions", "5"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Versions != 5 {
+					t.Errorf("expected 5 versions, got %d", cfg.Versions)
+				}
+			},
+		},
+		{
+			name:    "negative versions",
+			args:    []string{"-versions", "-1"},
+			wantErr: true,
+		},
+		{
+			name:    "conflict policy",
+			args:    []string{"-on-conflict", "rename"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.OnConflict != "rename" {
+					t.Errorf("expected conflict policy rename, got %s", cfg.OnConflict)
+				}
+			},
+		},
+		{
+			name:    "unknown conflict policy",
+			args:    []string{"-on-conflict", "merge"},
+			wantErr: true,
+		},
+		{
+			name:    "checksums",
+			args:    []string{"-checksums", "BLAKE2b, sha256,md5,md5"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if len(cfg.Checksums) != 2 || cfg.Checksums[0] != "blake2b" || cfg.Checksums[1] != "md5" {
+					t.Errorf("unexpected checksums: %v", cfg.Checksums)
+				}
+			},
+		},
+		{
+			name:    "unknown checksum",
+			args:    []string{"-checksums", "crc32"},
+			wantErr: true,
+		},
+		{
+			name:    "quotas",
+			args:    []string{"-quota", "10GB", "-quota-files", "1000", "-users", "users.json", "-user-quota", "512M"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.QuotaBytes != 10<<30 || cfg.QuotaFiles != 1000 || cfg.UserQuota != 512<<20 {
+					t.Errorf("unexpected quotas: %d bytes, %d files, %d per user", cfg.QuotaBytes, cfg.QuotaFiles, cfg.UserQuota)
+				}
+			},
+		},
+		{
+			name:    "invalid quota",
+			args:    []string{"-quota", "lots"},
+			wantErr: true,
+		},
+		{
+			name:    "negative file quota",
+			args:    []string{"-quota-files", "-1"},
+			wantErr: true,
+		},
+		{
+			name:    "user quota without users",
+			args:    []string{"-user-quota", "1G"},
+			wantErr: true,
+		},
+		{
+			name:    "disk reserve",
+			args:    []string{"-disk-reserve", "256M"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.DiskReserve != 256<<20 {
+					t.Errorf("expected a disk reserve of 256M, got %d", cfg.DiskReserve)
+				}
+			},
+		},
+		{
+			name:    "invalid disk reserve",
+			args:    []string{"-disk-reserve", "-1G"},
+			wantErr: true,
+		},
+		{
+			name:    "file ttl",
+			args:    []string{"-ttl", "24h", "-max-ttl", "168h"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.FileTTL != 24*time.Hour || cfg.FileMaxTTL != 168*time.Hour {
+					t.Errorf("expected a ttl of 24h and at most 168h, got %s and %s", cfg.FileTTL, cfg.FileMaxTTL)
+				}
+			},
+		},
+		{
+			name:    "file ttl beyond the maximum",
+			args:    []string{"-ttl", "48h", "-max-ttl", "24h"},
+			wantErr: true,
+		},
+		{
+			name:    "janitor interval",
+			args:    []string{"-janitor-interval", "5m"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.JanitorInterval != 5*time.Minute {
+					t.Errorf("expected a cleanup every 5m, got %s", cfg.JanitorInterval)
+				}
+			},
+		},
+		{
+			name:    "zero janitor interval",
+			args:    []string{"-janitor-interval", "0"},
+			wantErr: true,
+		},
+		{
+			name:    "negative file ttl",
+			args:    []string{"-ttl", "-1h"},
+			wantErr: true,
+		},
+		{
+			name:    "s3 storage without bucket",
+			args:    []string{"-storage", "s3", "-s3-endpoint", "http://localhost:9000"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown storage",
+			args:    []string{"-storage", "tape"},
+			wantErr: true,
+		},
+		{
+			name:    "s3 api keys",
+			args:    []string{"-s3-api-keys", "alice:secret1, bob:secret2"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if len(cfg.S3APIKeys) != 2 || cfg.S3APIKeys["alice"] != (S3APIKey{Secret: "secret1"}) ||
+					cfg.S3APIKeys["bob"] != (S3APIKey{Secret: "secret2"}) {
+					t.Errorf("unexpected S3 API keys: %v", cfg.S3APIKeys)
+				}
+				if cfg.S3APIBucket != "fsrv" {
+					t.Errorf("expected S3 API bucket fsrv, got %s", cfg.S3APIBucket)
+				}
+			},
+		},
+		{
+			name:    "s3 api key without secret",
+			args:    []string{"-s3-api-keys", "alice"},
+			wantErr: true,
+		},
+		{
+			name:    "s3 api keys with roles",
+			args:    []string{"-s3-api-keys", "ci:secret1:uploader:builds/nightly,web:secret2:read-only"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.S3APIKeys["ci"] != (S3APIKey{Secret: "secret1", Role: "uploader", Dir: "builds/nightly"}) ||
+					cfg.S3APIKeys["web"] != (S3APIKey{Secret: "secret2", Role: "read-only"}) {
+					t.Errorf("unexpected S3 API keys: %v", cfg.S3APIKeys)
+				}
+			},
+		},
+		{
+			name:    "s3 api key with unknown role",
+			args:    []string{"-s3-api-keys", "alice:secret:owner"},
+			wantErr: true,
+		},
+		{
+			name:    "users file",
+			args:    []string{"-users", "/etc/fsrv/users.json", "-session-ttl", "1h"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.UsersFile != "/etc/fsrv/users.json" || cfg.SessionTTL != time.Hour {
+					t.Errorf("unexpected auth settings: '%s', %s", cfg.UsersFile, cfg.SessionTTL)
+				}
+			},
+		},
+		{
+			name:    "custom resumable upload expiry",
+			args:    []string{"-tus-expiry", "2h"},
+			wantErr: false,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.TusExpiry != 2*time.Hour {
+					t.Errorf("expected tus expiry 2h, got %s", cfg.TusExpiry)
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
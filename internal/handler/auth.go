@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/service"
+	"fsrv/internal/util"
+)
+
+// errForbidden is returned when the role of the user does not allow an action
+var errForbidden = errors.New("permission denied")
+
+// EnableAuth makes the handler serve the login form and show who is logged in.
+// It must be called before RegisterRoutes; the requests themselves are checked
+// by the authenticator's middleware around the mux.
+func (h *Handler) EnableAuth(authenticator *auth.Authenticator) {
+	h.auth = authenticator
+}
+
+// PublicPaths returns the paths that must stay reachable without logging in,
+// for auth.Authenticator.Middleware. Share links and the S3 API check their own signatures,
+// drop links are only reachable with their random ID.
+func (h *Handler) PublicPaths() []string {
+	paths := []string{auth.LoginPath, sharePath, dropPrefix}
+	if h.svc.IsS3APIEnabled() {
+		paths = append(paths, s3Prefix)
+	}
+	return paths
+}
+
+// Login shows the login form and, when it is submitted, starts a session and
+// sends the browser on to the page it originally asked for
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	next := safeRedirect(r.FormValue("next"))
+	param := &PageParam{Title: "FSrv Login", Param1: next}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.renderTemplate(w, "login.html", param)
+	case http.MethodPost:
+		username := r.FormValue("username")
+		principal, err := h.auth.CheckPassword(username, r.FormValue("password"))
+		if err != nil {
+			log.Printf("Failed login for '%s' from %s", username, r.RemoteAddr)
+			param.Param2 = username
+			param.Msgs = []string{"Invalid username or password"}
+			w.WriteHeader(http.StatusUnauthorized)
+			h.renderTemplate(w, "login.html", param)
+			return
+		}
+		if err := h.auth.StartSession(w, r, principal); err != nil {
+			h.renderInfo(w, err.Error())
+			return
+		}
+
+		log.Printf("Logged in: %s", principal.Name)
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	default:
+		h.renderInfo(w, "HTTP Method should be 'GET' or 'POST'")
+	}
+}
+
+// Logout ends the session of the browser
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod(w, r, "POST") {
+		return
+	}
+	if err := checkCSRF(r); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
+	h.auth.EndSession(w, r)
+	http.Redirect(w, r, auth.LoginPath, http.StatusSeeOther)
+}
+
+// authorize checks that the user of r may perform perm on the file or folder name.
+// The name is cleaned like the service cleans it, so the check applies to what
+// is actually stored; names the service rejects anyway are let through.
+func (h *Handler) authorize(r *http.Request, perm auth.Permission, name string) error {
+	p := auth.FromContext(r.Context())
+	if p == nil {
+		return nil
+	}
+
+	clean, err := h.svc.ResolvePath(name)
+	if err != nil {
+		// Folders, including the store root, are not subject to the file name checks
+		if clean, err = util.SafePath(name); err != nil {
+			return nil
+		}
+	}
+	if !p.Can(perm, clean) {
+		return fmt.Errorf("%w: '%s'", errForbidden, clean)
+	}
+	return nil
+}
+
+// hasPermission reports whether the role of the user of r includes perm in the
+// folder it is limited to, for pages offering an action on several files
+func hasPermission(r *http.Request, perm auth.Permission) bool {
+	p := auth.FromContext(r.Context())
+	return p == nil || p.Can(perm, p.Dir)
+}
+
+// visibleFiles drops the files of a listing the user of r may not see, which
+// only happens in the folders leading to the folder their role is limited to
+func visibleFiles(r *http.Request, files []service.File) []service.File {
+	p := auth.FromContext(r.Context())
+	if p == nil || p.Dir == "" {
+		return files
+	}
+	visible := files[:0]
+	for _, file := range files {
+		if p.Can(auth.PermList, file.Path) {
+			visible = append(visible, file)
+		}
+	}
+	return visible
+}
+
+// renderForbidden renders an info page for an action the user may not perform
+func (h *Handler) renderForbidden(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusForbidden)
+	h.renderInfo(w, fmt.Sprintf("You are not allowed to do this: %v", err))
+}
+
+// currentUser returns the name of the user making a request, "" without authentication
+func currentUser(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil {
+		return p.Name
+	}
+	return ""
+}
+
+// safeRedirect returns target if it is a path on this server, and the file list otherwise,
+// so the login form cannot be used to send users to another site
+func safeRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/files"
+	}
+	return target
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fsrv/internal/auth"
+)
+
+// testUser is a user of the users file in handler tests, always with password "secret"
+type testUser struct {
+	name, role, dir string
+}
+
+var (
+	testHashOnce sync.Once
+	testHash     string
+)
+
+// setupAuthHandler returns the routes of a handler that requires login, for the
+// user alice with password "secret"
+func setupAuthHandler(t *testing.T) http.Handler {
+	h, _ := setupTestHandler(t)
+	return requireLogin(t, h, testUser{name: "alice"})
+}
+
+// requireLogin returns the routes of h behind an authenticator for users
+func requireLogin(t *testing.T, h *Handler, users ...testUser) http.Handler {
+	testHashOnce.Do(func() {
+		testHash, _ = auth.HashPassword("secret")
+	})
+	var entries []string
+	for _, user := range users {
+		entries = append(entries, fmt.Sprintf(`{"name": %q, "password": %q, "role": %q, "dir": %q}`,
+			user.name, testHash, user.role, user.dir))
+	}
+	authenticator, err := auth.Parse([]byte(`{"users": [`+strings.Join(entries, ",")+`]}`), time.Hour)
+	if err != nil {
+		t.Fatalf("auth.Parse() error = %v", err)
+	}
+
+	h.EnableAuth(authenticator)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	return authenticator.Middleware(mux, h.PublicPaths()...)
+}
+
+// postLogin submits the login form
+func postLogin(handler http.Handler, username, password, next string) *httptest.ResponseRecorder {
+	form := url.Values{"username": {username}, "password": {password}, "next": {next}}
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandler_Login(t *testing.T) {
+	handler := setupAuthHandler(t)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "FSrv Login") {
+		t.Fatalf("GET /login = %d %q", w.Code, w.Body.String())
+	}
+
+	w = postLogin(handler, "alice", "wrong", "/files")
+	if w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Errorf("login with a wrong password = %d, cookies %v", w.Code, w.Result().Cookies())
+	}
+
+	w = postLogin(handler, "alice", "secret", "/files?dir=docs")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/files?dir=docs" {
+		t.Fatalf("login = %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+
+	req := httptest.NewRequest("GET", "/files", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "alice") {
+		t.Errorf("file list after login = %d %q, want the user shown", w.Code, w.Body.String())
+	}
+
+	// Other sites cannot log the user out
+	logout := httptest.NewRequest("POST", "/logout", nil)
+	logout.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, logout)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("logout without the form token = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("file list after a forged logout = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	logout = httptest.NewRequest("POST", "/logout", strings.NewReader(csrfField+"="+testCSRF))
+	logout.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	logout.AddCookie(cookies[0])
+	logout.AddCookie(&http.Cookie{Name: csrfCookie, Value: testCSRF})
+	handler.ServeHTTP(httptest.NewRecorder(), logout)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("file list after logout = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestHandler_Login_OpenRedirect(t *testing.T) {
+	handler := setupAuthHandler(t)
+
+	for _, next := range []string{"https://evil.example/", "//evil.example/", "/\\evil.example"} {
+		w := postLogin(handler, "alice", "secret", next)
+		if w.Header().Get("Location") != "/files" {
+			t.Errorf("login with next=%q redirected to %q, want /files", next, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestHandler_Auth_Required(t *testing.T) {
+	handler := setupAuthHandler(t)
+
+	for _, target := range []string{"/files", "/download?file=a.txt", "/dav/", "/tus/"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without credentials = %d, want %d", target, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	req := httptest.NewRequest("PUT", "/files/a.txt", strings.NewReader("data"))
+	req.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Errorf("PUT with Basic credentials = %d, want %d", w.Code, http.StatusCreated)
+	}
+}
+
+func TestHandler_Roles(t *testing.T) {
+	h, store := setupSubDirHandler(t, true)
+	putStored(t, store, "root.txt", []byte("root"))
+	putStored(t, store, "team/a.txt", []byte("a"))
+	putStored(t, store, "other/b.txt", []byte("b"))
+	putStored(t, store, "empty.txt", nil)
+	handler := requireLogin(t, h,
+		testUser{name: "reader", role: auth.RoleReadOnly},
+		testUser{name: "uploader", role: auth.RoleUploader},
+		testUser{name: "editor", role: auth.RoleEditor, dir: "team"})
+
+	serve := func(user, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.SetBasicAuth(user, "secret")
+		if method == "PROPFIND" {
+			req.Header.Set("Depth", "1")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		user, method, target string
+		want                 int
+	}{
+		{"reader", "GET", "/download?file=root.txt", http.StatusOK},
+		{"reader", "PUT", "/files/new.txt", http.StatusForbidden},
+		{"reader", "GET", "/toUpload", http.StatusForbidden},
+		{"reader", "GET", "/del?file=root.txt", http.StatusForbidden},
+		{"reader", "POST", "/mkdir?name=docs", http.StatusForbidden},
+		{"uploader", "PUT", "/files/new.txt", http.StatusCreated},
+		{"uploader", "PUT", "/dav/root.txt", http.StatusForbidden},
+		{"uploader", "PUT", "/dav/empty.txt", http.StatusForbidden},
+		{"uploader", "DELETE", "/dav/root.txt", http.StatusForbidden},
+		{"editor", "GET", "/download?file=other/b.txt", http.StatusForbidden},
+		{"editor", "PUT", "/files/other/c.txt", http.StatusForbidden},
+		{"editor", "PUT", "/files/team\\..\\other\\c.txt", http.StatusBadRequest},
+		{"editor", "GET", "/files?dir=other", http.StatusForbidden},
+		{"editor", "GET", "/download?file=team/a.txt", http.StatusOK},
+		{"editor", "PUT", "/dav/team/a.txt", http.StatusNoContent},
+		{"editor", "GET", "/toUpload", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := serve(tt.user, tt.method, tt.target, "data"); w.Code != tt.want {
+			t.Errorf("%s: %s %s = %d, want %d", tt.user, tt.method, tt.target, w.Code, tt.want)
+		}
+	}
+	move := httptest.NewRequest("MOVE", "/dav/team/a.txt", nil)
+	move.SetBasicAuth("editor", "secret")
+	move.Header.Set("Destination", "http://example.com/dav/other/a.txt")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, move)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("editor: MOVE out of their folder = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if isStored(store, "other/c.txt") || isStored(store, "other/a.txt") {
+		t.Error("editor wrote outside their folder")
+	}
+
+	// Multi-file uploads report the files that were refused
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, name := range []string{"team/ok.txt", "other/no.txt"} {
+		part, _ := mw.CreateFormFile("file", name)
+		part.Write([]byte("data"))
+	}
+	mw.Close()
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth("editor", "secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusMultiStatus || !isStored(store, "team/ok.txt") || isStored(store, "other/no.txt") {
+		t.Errorf("mixed upload = %d %s", w.Code, w.Body.String())
+	}
+
+	// Listings hide what is out of reach, and the actions that are not allowed
+	listings := []struct {
+		user, dir string
+		want      string
+	}{
+		{"reader", "", "FSrv Filesreader empty.txt new.txt other root.txt team"},
+		{"editor", "", "FSrv Fileseditor team"},
+		{"editor", "team", "FSrv Fileseditor team/a.txt team/ok.txt can-upload can-delete"},
+	}
+	for _, tt := range listings {
+		w := serve(tt.user, "GET", listURL(tt.dir), "")
+		if got := sortedListing(w.Body.String()); got != tt.want {
+			t.Errorf("%s: listing of %q = %q, want %q", tt.user, tt.dir, got, tt.want)
+		}
+	}
+
+	w = serve("editor", "PROPFIND", "/dav/", "")
+	if w.Code != http.StatusMultiStatus || !strings.Contains(w.Body.String(), "/dav/team/") ||
+		strings.Contains(w.Body.String(), "other") || strings.Contains(w.Body.String(), "root.txt") {
+		t.Errorf("PROPFIND of the root by a scoped user = %d %s", w.Code, w.Body.String())
+	}
+}
+
+// sortedListing sorts the files of a rendered stub file list, whose order depends
+// on modification times
+func sortedListing(body string) string {
+	fields := strings.Fields(body)
+	var files, flags []string
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "can-") {
+			flags = append(flags, field)
+		} else {
+			files = append(files, field)
+		}
+	}
+	sort.Strings(files)
+	return strings.Join(append(append(fields[:1], files...), flags...), " ")
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/service"
+	"fsrv/internal/storage"
+	"fsrv/internal/util"
+)
+
+// errInvalidChecksum is returned for declared checksums that cannot be parsed
+var errInvalidChecksum = errors.New("invalid checksum")
+
+// checksumHeader declares the SHA-256 of an upload, hex or base64 encoded, and
+// so does the form field checksumField for the file that follows it
+const (
+	checksumHeader = "X-Checksum-Sha256"
+	checksumField  = "sha256"
+)
+
+// declaredChecksums returns the checksums a client declared for an upload in
+// the headers h: Content-MD5, Digest (RFC 3230), Repr-Digest (RFC 9530) and
+// X-Checksum-Sha256. Algorithms other than SHA-256 and MD5 are ignored, like
+// the RFCs allow; malformed values and headers that contradict each other are
+// an error.
+func declaredChecksums(h http.Header) (service.Checksums, error) {
+	var sums service.Checksums
+	declare := func(name, value string) error {
+		sum, size := &sums.SHA256, sha256.Size
+		if strings.EqualFold(name, "md5") || strings.EqualFold(name, "Content-MD5") {
+			sum, size = &sums.MD5, md5.Size
+		}
+		decoded, err := decodeChecksum(value, size)
+		if err != nil {
+			return fmt.Errorf("%w: %s '%s'", errInvalidChecksum, name, value)
+		}
+		if *sum != "" && *sum != decoded {
+			return fmt.Errorf("%w: the %s contradicts another header", errInvalidChecksum, name)
+		}
+		*sum = decoded
+		return nil
+	}
+
+	for _, name := range []string{checksumHeader, "Content-MD5"} {
+		if value := h.Get(name); value != "" {
+			if err := declare(name, value); err != nil {
+				return service.Checksums{}, err
+			}
+		}
+	}
+	for _, name := range []string{"Digest", "Repr-Digest"} {
+		for _, member := range strings.Split(strings.Join(h.Values(name), ","), ",") {
+			algorithm, value, _ := strings.Cut(strings.TrimSpace(member), "=")
+			switch strings.ToLower(algorithm) {
+			case "sha-256", "md5":
+				// Repr-Digest values are byte sequences, ":base64:", possibly with parameters
+				value, _, _ = strings.Cut(value, ";")
+				if err := declare(algorithm, strings.Trim(value, ":")); err != nil {
+					return service.Checksums{}, err
+				}
+			}
+		}
+	}
+	return sums, nil
+}
+
+// partChecksums returns the checksums declared for a file of a multipart upload:
+// in the headers of its part, or else the "sha256" field sent before it or the
+// X-Checksum-Sha256 header of the request. The other checksum headers of the
+// request describe the multipart body as a whole, not the file.
+func partChecksums(r *http.Request, part *multipart.Part, field string) (service.Checksums, error) {
+	header := http.Header(part.Header).Clone()
+	if header.Get(checksumHeader) == "" {
+		if field == "" {
+			field = r.Header.Get(checksumHeader)
+		}
+		if field != "" {
+			header.Set(checksumHeader, field)
+		}
+	}
+	return declaredChecksums(header)
+}
+
+// decodeChecksum turns a hex or base64 encoded checksum of size bytes into lowercase hex
+func decodeChecksum(value string, size int) (string, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 2*size {
+		if data, err := hex.DecodeString(value); err == nil {
+			return hex.EncodeToString(data), nil
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(data) != size {
+		return "", errInvalidChecksum
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// entityTag returns the entity tag of the stored file name with the given size
+// and modification time, which is the same whichever way the file is
+// downloaded, see checksumTag
+func (h *Handler) entityTag(name string, size int64, modTime time.Time) string {
+	info := storage.FileInfo{Size: size, ModTime: modTime}
+	sums, exact, ok := h.svc.RecordedChecksums(name, info)
+	return checksumTag(info, sums, exact, ok)
+}
+
+// checksumTag returns the entity tag of a file described by info, given the
+// result of service.RecordedChecksums. Once the checksums of the file have been
+// computed it is made of the SHA-256, a strong validator for If-None-Match and
+// If-Range unless the storage keeps modification times too coarsely to be sure
+// it still applies. Until then it is a weak one derived from the size and the
+// modification time to the second, which is all some storages report. Unlike in
+// S3 it is never an MD5 of the content.
+func checksumTag(info storage.FileInfo, sums service.Checksums, exact, ok bool) string {
+	switch {
+	case !ok:
+		return fmt.Sprintf(`W/"%x-%x"`, info.ModTime.Unix(), info.Size)
+	case !exact:
+		return `W/"` + sums.SHA256 + `"`
+	default:
+		return `"` + sums.SHA256 + `"`
+	}
+}
+
+// setChecksumHeaders describes a download of the stored file name, described
+// by info, by its entity tag and, if they have been computed, the checksums of
+// its content as Digest (RFC 3230) and Repr-Digest (RFC 9530). BLAKE2b has no
+// registered digest name, so it is left out.
+func (h *Handler) setChecksumHeaders(w http.ResponseWriter, name string, info storage.FileInfo) {
+	sums, exact, ok := h.svc.RecordedChecksums(name, info)
+	w.Header().Set("ETag", checksumTag(info, sums, exact, ok))
+	if !ok {
+		return
+	}
+
+	digests := []string{"sha-256=" + hexToBase64(sums.SHA256)}
+	reprDigests := []string{"sha-256=:" + hexToBase64(sums.SHA256) + ":"}
+	if sums.MD5 != "" {
+		digests = append(digests, "md5="+hexToBase64(sums.MD5))
+		reprDigests = append(reprDigests, "md5=:"+hexToBase64(sums.MD5)+":")
+	}
+	w.Header().Set("Digest", strings.Join(digests, ","))
+	w.Header().Set("Repr-Digest", strings.Join(reprDigests, ", "))
+}
+
+// hexToBase64 re-encodes a hex encoded checksum in base64, as digest headers carry them
+func hexToBase64(s string) string {
+	data, _ := hex.DecodeString(s)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// SHA256Sums serves the SHA-256 checksums of the files in a folder and all
+// folders below it, in the format of sha256sum, so that downloaded copies can be
+// checked with "sha256sum -c SHA256SUMS". The "dir" query parameter selects the
+// folder, and the paths are relative to it.
+//
+// Checksums that have not been computed yet are computed now, which reads the
+// files in question once.
+func (h *Handler) SHA256Sums(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod(w, r, "GET") {
+		return
+	}
+
+	dir := r.URL.Query().Get("dir")
+	if err := h.authorize(r, auth.PermList, dir); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
+	entries, err := h.svc.ListTree(dir, true)
+	if err != nil {
+		w.WriteHeader(statusFor(err))
+		h.renderInfo(w, fmt.Sprintf("Failed to list files: %v", err))
+		return
+	}
+	base, _ := util.SafePath(dir)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	var sums bytes.Buffer
+	for _, entry := range entries {
+		if h.authorize(r, auth.PermList, entry.Path) != nil {
+			continue
+		}
+		checksums, err := h.svc.FileChecksums(entry.Path)
+		if err != nil {
+			// Files that are being uploaded or went away in the meantime are left out
+			continue
+		}
+		name := entry.Path
+		if base != "" {
+			name = strings.TrimPrefix(name, base+"/")
+		}
+		sums.WriteString(sumLine(checksums.SHA256, name))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(sums.Bytes())
+	log.Printf("Served the checksums of /%s", base)
+}
+
+// sumLine formats a line of a checksum file like sha256sum does, which escapes
+// backslashes and newlines in names and then marks the line with a backslash
+func sumLine(sum, name string) string {
+	if !strings.ContainsAny(name, "\\\n") {
+		return fmt.Sprintf("%s  %s\n", sum, name)
+	}
+	name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+	return fmt.Sprintf("\\%s  %s\n", sum, name)
+}
@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/config"
+	"fsrv/internal/service"
+)
+
+const (
+	helloSHA256       = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	helloSHA256Base64 = "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	helloMD5          = "5d41402abc4b2a76b9719d911017c592"
+	helloMD5Base64    = "XUFAKrxLKna5cZ2REBfFkg=="
+
+	helloWorldSHA256 = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+)
+
+func TestHandler_DownloadFile_Checksums(t *testing.T) {
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, func(cfg *config.Config) { cfg.Checksums = []string{"md5"} })
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	if _, err := h.svc.UploadFile("hello.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/download?file=hello.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Fatalf("GET /download = %d %q", w.Code, w.Body.String())
+	}
+	headers := map[string]string{
+		"ETag":        `"` + helloSHA256 + `"`,
+		"Digest":      "sha-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=,md5=XUFAKrxLKna5cZ2REBfFkg==",
+		"Repr-Digest": "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:, md5=:XUFAKrxLKna5cZ2REBfFkg==:",
+	}
+	for name, want := range headers {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// The checksum makes a strong entity tag for conditional requests
+	req := httptest.NewRequest("GET", "/download?file=hello.txt", nil)
+	req.Header.Set("If-None-Match", `"`+helloSHA256+`"`)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("GET with a matching If-None-Match = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	// Files stored without going through an upload are served without checksums
+	// until they have been computed, rather than read twice
+	putStored(t, store, "other.txt", []byte("hello"))
+	req = httptest.NewRequest("GET", "/download?file=other.txt", nil)
+	req.Header.Set("Range", "bytes=1-2")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "el" || w.Header().Get("Digest") != "" {
+		t.Errorf("range GET of a file without recorded checksums = %d %q, Digest %q", w.Code, w.Body.String(), w.Header().Get("Digest"))
+	}
+	if _, err := h.svc.FileChecksums("other.txt"); err != nil {
+		t.Fatalf("FileChecksums() error = %v", err)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/download?file=other.txt", nil))
+	if w.Body.String() != "hello" || w.Header().Get("ETag") != `"`+helloSHA256+`"` {
+		t.Errorf("GET once the checksums are computed = %q, ETag %q", w.Body.String(), w.Header().Get("ETag"))
+	}
+}
+
+func TestHandler_ETags(t *testing.T) {
+	h, store := setupS3Handler(t, nil)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	if _, err := h.svc.UploadFile("docs/hello.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+	putStored(t, store, "docs/other.txt", []byte("hello"))
+
+	// Every way of getting at a file describes it by the same ETag
+	etags := func(name string) map[string]string {
+		got := make(map[string]string)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/download?file="+name, nil))
+		got["download"] = w.Header().Get("ETag")
+		got["dav GET"] = serveDAV(h, "GET", "/dav/"+name, "").Header().Get("ETag")
+		got["S3 HEAD"] = serveS3(h, s3Request("HEAD", "/s3/files/"+name, nil)).Header().Get("ETag")
+
+		var listing struct{ Contents []struct{ Key, ETag string } }
+		xml.Unmarshal(serveS3(h, s3Request("GET", "/s3/files?list-type=2&prefix=docs/", nil)).Body.Bytes(), &listing)
+		for _, object := range listing.Contents {
+			if object.Key == name {
+				got["S3 list"] = object.ETag
+			}
+		}
+		var multistatus struct {
+			Response []struct {
+				Href string `xml:"href"`
+				ETag string `xml:"propstat>prop>getetag"`
+			} `xml:"response"`
+		}
+		xml.Unmarshal(serveDAV(h, "PROPFIND", "/dav/docs/", "", "Depth", "1").Body.Bytes(), &multistatus)
+		for _, response := range multistatus.Response {
+			if response.Href == "/dav/"+name {
+				got["PROPFIND"] = response.ETag
+			}
+		}
+		return got
+	}
+	for name, want := range map[string]string{
+		"docs/hello.txt": `"` + helloSHA256 + `"`,
+		"docs/other.txt": "",
+	} {
+		got := etags(name)
+		if want == "" {
+			want = got["download"]
+			if !strings.HasPrefix(want, `W/"`) {
+				t.Errorf("ETag of %s without recorded checksums = %q, want a weak one", name, want)
+			}
+		}
+		for endpoint, etag := range got {
+			if etag != want {
+				t.Errorf("%s ETag of %s = %q, want %q", endpoint, name, etag, want)
+			}
+		}
+		if len(got) != 5 {
+			t.Errorf("ETags of %s = %v, want 5", name, got)
+		}
+	}
+}
+
+func TestHandler_SHA256Sums(t *testing.T) {
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, func(cfg *config.Config) { cfg.SubDirs = true })
+	putStored(t, store, "top.txt", []byte("hello"))
+	putStored(t, store, "team/a.txt", []byte("hello"))
+	putStored(t, store, "team/docs/b\nc.txt", []byte("hello"))
+	putStored(t, store, "other/c.txt", []byte("hello"))
+
+	sums := func(handler http.Handler, target, user string) (int, string) {
+		req := httptest.NewRequest("GET", target, nil)
+		if user != "" {
+			req.SetBasicAuth(user, "secret")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	want := helloSHA256 + "  a.txt\n\\" + helloSHA256 + "  docs/b\\nc.txt\n"
+	if code, body := sums(mux, "/SHA256SUMS?dir=team", ""); code != http.StatusOK || body != want {
+		t.Errorf("GET /SHA256SUMS?dir=team = %d %q, want %q", code, body, want)
+	}
+	if code, body := sums(mux, "/SHA256SUMS", ""); code != http.StatusOK || strings.Count(body, "\n") != 4 ||
+		!strings.HasPrefix(body, helloSHA256+"  other/c.txt\n") {
+		t.Errorf("GET /SHA256SUMS = %d %q", code, body)
+	}
+	if code, _ := sums(mux, "/SHA256SUMS?dir=missing", ""); code != http.StatusNotFound {
+		t.Errorf("GET /SHA256SUMS of a missing folder = %d, want %d", code, http.StatusNotFound)
+	}
+
+	// Users limited to a folder only get the checksums of what they may list
+	handler := requireLogin(t, h, testUser{name: "alice", role: auth.RoleReadOnly, dir: "team"})
+	if code, body := sums(handler, "/SHA256SUMS", "alice"); code != http.StatusOK || strings.Contains(body, "other/") || !strings.Contains(body, "team/a.txt") {
+		t.Errorf("GET /SHA256SUMS by a user limited to team = %d %q", code, body)
+	}
+}
+
+func TestDeclaredChecksums(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    service.Checksums
+		wantErr bool
+	}{
+		{"none", nil, service.Checksums{}, false},
+		{"hex", map[string]string{"X-Checksum-Sha256": strings.ToUpper(helloSHA256)}, service.Checksums{SHA256: helloSHA256}, false},
+		{"base64", map[string]string{"X-Checksum-Sha256": helloSHA256Base64}, service.Checksums{SHA256: helloSHA256}, false},
+		{"content md5", map[string]string{"Content-MD5": helloMD5Base64}, service.Checksums{MD5: helloMD5}, false},
+		{"digest", map[string]string{"Digest": "SHA-256=" + helloSHA256Base64 + ",unixsum=30637"}, service.Checksums{SHA256: helloSHA256}, false},
+		{"repr digest", map[string]string{"Repr-Digest": "sha-512=:AAAA:, sha-256=:" + helloSHA256Base64 + ":, md5=:" + helloMD5Base64 + ":"}, service.Checksums{SHA256: helloSHA256, MD5: helloMD5}, false},
+		{"agreeing", map[string]string{"X-Checksum-Sha256": helloSHA256, "Digest": "sha-256=" + helloSHA256Base64}, service.Checksums{SHA256: helloSHA256}, false},
+		{"contradicting", map[string]string{"X-Checksum-Sha256": helloSHA256, "Repr-Digest": "sha-256=:" + helloMD5Base64 + ":"}, service.Checksums{}, true},
+		{"malformed", map[string]string{"X-Checksum-Sha256": "not a checksum"}, service.Checksums{}, true},
+		{"wrong size", map[string]string{"Content-MD5": helloSHA256Base64}, service.Checksums{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for name, value := range tt.headers {
+				h.Set(name, value)
+			}
+			got, err := declaredChecksums(h)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("declaredChecksums() = %+v, %v, want %+v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandler_PutFile_Checksums(t *testing.T) {
+	h, store := setupTestHandler(t)
+	put := func(name string, headers map[string]string) int {
+		req := httptest.NewRequest("PUT", "/files/"+name, strings.NewReader("hello"))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		h.FileResource(w, req)
+		return w.Code
+	}
+
+	if code := put("good.txt", map[string]string{"X-Checksum-Sha256": helloSHA256, "Content-MD5": helloMD5Base64}); code != http.StatusCreated {
+		t.Errorf("PUT with matching checksums = %d, want %d", code, http.StatusCreated)
+	}
+	for name, headers := range map[string]map[string]string{
+		"sha256.txt":    {"X-Checksum-Sha256": helloWorldSHA256},
+		"md5.txt":       {"Content-MD5": "AAAAAAAAAAAAAAAAAAAAAA=="},
+		"digest.txt":    {"Repr-Digest": "sha-256=:" + hexToBase64(helloWorldSHA256) + ":"},
+		"malformed.txt": {"Digest": "sha-256=hello"},
+	} {
+		if code := put(name, headers); code != http.StatusBadRequest {
+			t.Errorf("PUT %s = %d, want %d", name, code, http.StatusBadRequest)
+		}
+		if isStored(store, name) {
+			t.Errorf("PUT %s with a wrong checksum was stored", name)
+		}
+	}
+}
+
+func TestHandler_UploadFile_Checksums(t *testing.T) {
+	h, store := setupTestHandler(t)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("sha256", helloSHA256)
+	part, _ := writer.CreateFormFile("file", "field.txt")
+	part.Write([]byte("hello"))
+	// The field only applies to the file right after it
+	part, _ = writer.CreateFormFile("file", "unchecked.txt")
+	part.Write([]byte("hello world"))
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="header.txt"`)
+	header.Set("Content-MD5", "AAAAAAAAAAAAAAAAAAAAAA==")
+	part, _ = writer.CreatePart(header)
+	part.Write([]byte("hello"))
+	writer.WriteField("sha256", helloWorldSHA256)
+	part, _ = writer.CreateFormFile("file", "mismatch.txt")
+	part.Write([]byte("hello"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.UploadFile(w, req)
+
+	for name, want := range map[string]bool{"field.txt": true, "unchecked.txt": true, "header.txt": false, "mismatch.txt": false} {
+		if got := isStored(store, name); got != want {
+			t.Errorf("%s stored = %v, want %v: %s", name, got, want, w.Body.String())
+		}
+	}
+	if !strings.Contains(w.Body.String(), "checksum mismatch") {
+		t.Errorf("report does not mention the checksum mismatch: %s", w.Body.String())
+	}
+}
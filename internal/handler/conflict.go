@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/service"
+)
+
+// conflictHeader lets scripts choose the conflict policy of an upload, like the
+// "conflict" form field and query parameter
+const conflictHeader = "X-Conflict-Policy"
+
+// askedConflictPolicy returns the conflict policy a request asks for in its
+// query string or header, "" for the server default
+func askedConflictPolicy(r *http.Request) string {
+	if policy := r.URL.Query().Get("conflict"); policy != "" {
+		return policy
+	}
+	return r.Header.Get(conflictHeader)
+}
+
+// conflictPolicy returns the conflict policy of an upload to filename: the one
+// asked for, or the server default.
+//
+// Overwriting needs the overwrite permission. Users without it are refused if
+// they ask for it, and fail instead if it is the server default.
+func (h *Handler) conflictPolicy(r *http.Request, asked, filename string) (service.ConflictPolicy, error) {
+	if asked == "" {
+		policy := h.svc.ConflictPolicy()
+		if policy == service.ConflictOverwrite && h.authorize(r, auth.PermOverwrite, filename) != nil {
+			return service.ConflictFail, nil
+		}
+		return policy, nil
+	}
+
+	policy, err := service.ParseConflictPolicy(asked)
+	if err != nil {
+		return "", err
+	}
+	if policy == service.ConflictOverwrite {
+		if err := h.authorize(r, auth.PermOverwrite, filename); err != nil {
+			return "", err
+		}
+	}
+	return policy, nil
+}
+
+// storeUpload saves an uploaded file to be kept for ttl, applying the conflict
+// policy asked for or the server default if its name is taken. With versioning,
+// overwriting keeps the previous content as a version.
+func (h *Handler) storeUpload(r *http.Request, filename, asked string, ttl time.Duration, src io.Reader) (service.StoredFile, service.ConflictPolicy, error) {
+	policy, err := h.conflictPolicy(r, asked, filename)
+	if err != nil {
+		return service.StoredFile{}, "", err
+	}
+	stored, err := h.svc.StoreFile(filename, src, policy, currentUser(r), ttl)
+	return stored, policy, err
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/config"
+)
+
+// conflictUpload builds a JSON multipart upload of one file with the conflict
+// field set to policy, if not empty
+func conflictUpload(t *testing.T, policy, filename, content string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if policy != "" {
+		mw.WriteField("conflict", policy)
+	}
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("CreateFormFile() error = %v", err)
+	}
+	part.Write([]byte(content))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	return req
+}
+
+func TestHandler_UploadFile_Conflict(t *testing.T) {
+	h, store := setupTestHandler(t)
+	putStored(t, store, "report.pdf", []byte("original"))
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	upload := func(req *http.Request) (int, UploadResult) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var report uploadReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || len(report.Files) != 1 {
+			t.Fatalf("upload = %d %s", w.Code, w.Body.String())
+		}
+		return w.Code, report.Files[0]
+	}
+
+	// The server default is to fail
+	if code, result := upload(conflictUpload(t, "", "report.pdf", "new")); code != http.StatusConflict || result.Policy != "fail" {
+		t.Errorf("upload with the default policy = %d %+v", code, result)
+	}
+
+	code, result := upload(conflictUpload(t, "rename", "report.pdf", "renamed"))
+	if code != http.StatusOK || result.Stored != "report (1).pdf" || !result.Renamed || result.Policy != "rename" {
+		t.Errorf("upload with rename = %d %+v", code, result)
+	}
+
+	req := conflictUpload(t, "", "report.pdf", "replaced")
+	req.Header.Set(conflictHeader, "overwrite")
+	code, result = upload(req)
+	if code != http.StatusOK || result.Stored != "report.pdf" || !result.Replaced || result.Policy != "overwrite" {
+		t.Errorf("upload with overwrite = %d %+v", code, result)
+	}
+	if got, _ := readStored(store, "report.pdf"); string(got) != "replaced" {
+		t.Errorf("content after overwrite = %q", got)
+	}
+
+	if code, _ := upload(conflictUpload(t, "merge", "report.pdf", "x")); code != http.StatusBadRequest {
+		t.Errorf("upload with an unknown policy = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// The result page names the file that was stored
+	req = conflictUpload(t, "rename", "report.pdf", "again")
+	req.Header.Del("Accept")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "report (2).pdf") || !strings.Contains(w.Body.String(), "Renamed from report.pdf") {
+		t.Errorf("result page = %q", w.Body.String())
+	}
+}
+
+func TestHandler_PutFile_Conflict(t *testing.T) {
+	h, store := setupTestHandler(t)
+	putStored(t, store, "app.zip", []byte("build 1"))
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("PUT", "/files/app.zip?conflict=rename", strings.NewReader("build 2")))
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/download?file=app+%281%29.zip" ||
+		!strings.Contains(w.Body.String(), "renamed") {
+		t.Errorf("PUT with rename = %d %q %q", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+
+	req := httptest.NewRequest("PUT", "/files/app.zip", strings.NewReader("build 3"))
+	req.Header.Set(conflictHeader, "overwrite")
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var result UploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusOK ||
+		result.Stored != "app.zip" || result.Policy != "overwrite" || !result.Replaced {
+		t.Errorf("PUT with overwrite = %d %s", w.Code, w.Body.String())
+	}
+
+	// If-None-Match: * wins over any policy
+	req = httptest.NewRequest("PUT", "/files/app.zip?conflict=overwrite", strings.NewReader("build 4"))
+	req.Header.Set("If-None-Match", "*")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if got, _ := readStored(store, "app.zip"); w.Code != http.StatusPreconditionFailed || string(got) != "build 3" {
+		t.Errorf("PUT with If-None-Match = %d, content %q", w.Code, got)
+	}
+}
+
+func TestHandler_UploadFile_ConflictRoles(t *testing.T) {
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, func(cfg *config.Config) { cfg.OnConflict = "overwrite" })
+	putStored(t, store, "report.pdf", []byte("original"))
+	handler := requireLogin(t, h, testUser{name: "uploader", role: auth.RoleUploader})
+
+	upload := func(req *http.Request) int {
+		req.SetBasicAuth("uploader", "secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Uploaders cannot overwrite: the default falls back to fail, asking is refused
+	if code := upload(conflictUpload(t, "", "report.pdf", "x")); code != http.StatusConflict {
+		t.Errorf("upload with the default policy = %d, want %d", code, http.StatusConflict)
+	}
+	if code := upload(conflictUpload(t, "overwrite", "report.pdf", "x")); code != http.StatusForbidden {
+		t.Errorf("upload asking to overwrite = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := upload(conflictUpload(t, "rename", "report.pdf", "x")); code != http.StatusOK || !isStored(store, "report (1).pdf") {
+		t.Errorf("upload with rename = %d", code)
+	}
+	if got, _ := readStored(store, "report.pdf"); string(got) != "original" {
+		t.Errorf("content = %q, want the original", got)
+	}
+}
@@ -0,0 +1,796 @@
+package handler
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/service"
+	"fsrv/internal/storage"
+)
+
+// WebDAV constants
+const (
+	davPrefix = "/dav/"
+	davMaxXML = 1 << 20 // limit for XML request bodies
+	davAllow  = "OPTIONS, GET, HEAD, PUT, DELETE, MKCOL, MOVE, COPY, PROPFIND, PROPPATCH, LOCK, UNLOCK"
+
+	// davSupportedLock is the supportedlock property, the same for every resource
+	davSupportedLock = `<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>` +
+		`<D:lockentry><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>`
+)
+
+// davLiveProps are the properties PROPFIND reports, in the order they are listed
+var davLiveProps = []string{
+	"resourcetype", "displayname", "getcontentlength", "getlastmodified",
+	"getcontenttype", "getetag", "supportedlock", "lockdiscovery",
+}
+
+// davPermissions are the permissions the methods need on the resource they address.
+// Writing over an existing file and the destination of MOVE and COPY are checked
+// by the methods themselves.
+var davPermissions = map[string]auth.Permission{
+	"PROPFIND":        auth.PermList,
+	"PROPPATCH":       auth.PermList,
+	http.MethodGet:    auth.PermDownload,
+	http.MethodHead:   auth.PermDownload,
+	http.MethodPut:    auth.PermUpload,
+	http.MethodDelete: auth.PermDelete,
+	"MKCOL":           auth.PermUpload,
+	"MOVE":            auth.PermDelete,
+	"COPY":            auth.PermDownload,
+	"LOCK":            auth.PermUpload,
+	"UNLOCK":          auth.PermUpload,
+}
+
+// WebDAV serves the store as a WebDAV share (RFC 4918, class 1 and 2), so it can be
+// mounted as a network drive with davfs2, macOS Finder or Windows Explorer.
+//
+//	OPTIONS    /dav/<path>   discover capabilities
+//	PROPFIND   /dav/<path>   describe a file or folder (Depth: 0 or 1)
+//	GET, HEAD  /dav/<path>   download a file; folders redirect to the file list
+//	PUT        /dav/<path>   upload a file
+//	DELETE     /dav/<path>   delete a file or folder, if delete is enabled
+//	MKCOL      /dav/<path>   create a folder
+//	MOVE, COPY /dav/<path>   move or copy to the Destination header
+//	LOCK       /dav/<path>   lock a file or folder, creating an empty file if missing
+//	UNLOCK     /dav/<path>   release a lock
+//
+// Every write goes through the service, so uploads are size limited and replacing
+// or removing anything requires delete to be enabled, like in the web UI. The one
+// exception is replacing an empty file, which clients do right after LOCK.
+// Folders need subdirectory support; without it only the root can be used.
+// The role of the user applies as well: folders outside the one it is limited
+// to are left out of listings, and forbidden actions get a 403.
+func (h *Handler) WebDAV(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, davPrefix), "/")
+	if name != "" && !h.svc.IsSubDirsEnabled() && strings.Contains(name, "/") {
+		http.Error(w, service.ErrSubDirsDisabled.Error(), http.StatusForbidden)
+		return
+	}
+	if perm, ok := davPermissions[r.Method]; ok {
+		if err := h.authorize(r, perm, name); err != nil {
+			http.Error(w, err.Error(), statusFor(err))
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("DAV", "1, 2")
+		w.Header().Set("MS-Author-Via", "DAV")
+		w.Header().Set("Allow", davAllow)
+		w.WriteHeader(http.StatusOK)
+	case "PROPFIND":
+		h.davPropfind(w, r, name)
+	case "PROPPATCH":
+		h.davProppatch(w, r, name)
+	case http.MethodGet, http.MethodHead:
+		h.davGet(w, r, name)
+	case http.MethodPut:
+		h.davPut(w, r, name)
+	case http.MethodDelete:
+		h.davDelete(w, r, name)
+	case "MKCOL":
+		h.davMkcol(w, r, name)
+	case "MOVE", "COPY":
+		h.davMoveCopy(w, r, name)
+	case "LOCK":
+		h.davLock(w, r, name)
+	case "UNLOCK":
+		h.davUnlock(w, r, name)
+	default:
+		w.Header().Set("Allow", davAllow)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// davPropfindRequest is the body of a PROPFIND request. An empty body asks for all properties.
+type davPropfindRequest struct {
+	AllProp  *struct{}    `xml:"allprop"`
+	PropName *struct{}    `xml:"propname"`
+	Prop     davPropNames `xml:"prop"`
+}
+
+// davPropNames lists the properties a client asks for or wants to change
+type davPropNames struct {
+	Names []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// davPropfind describes a resource and, with Depth: 1, the members of a folder
+func (h *Handler) davPropfind(w http.ResponseWriter, r *http.Request, name string) {
+	depth := r.Header.Get("Depth")
+	if depth == "" || depth == "infinity" {
+		writeDAVError(w, http.StatusForbidden, "propfind-finite-depth")
+		return
+	}
+	if depth != "0" && depth != "1" {
+		http.Error(w, "Invalid Depth header", http.StatusBadRequest)
+		return
+	}
+
+	var req davPropfindRequest
+	if err := readDAVXML(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry, err := h.davStat(name)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	entries := []service.Entry{entry}
+	if entry.IsDir && depth == "1" {
+		members, err := h.svc.ListTree(name, false)
+		if err != nil {
+			http.Error(w, err.Error(), statusFor(err))
+			return
+		}
+		for _, member := range members {
+			if h.authorize(r, auth.PermList, member.Path) == nil {
+				entries = append(entries, member)
+			}
+		}
+	}
+
+	var body strings.Builder
+	for _, entry := range entries {
+		body.WriteString(`<D:response><D:href>` + davHref(entry.Path, entry.IsDir) + `</D:href>`)
+		switch {
+		case req.PropName != nil:
+			var names strings.Builder
+			for _, prop := range h.davProps(entry) {
+				names.WriteString(`<D:` + prop.name + `/>`)
+			}
+			writePropstat(&body, names.String(), http.StatusOK)
+		case len(req.Prop.Names) > 0:
+			var found, missing strings.Builder
+			props := h.davProps(entry)
+			for _, want := range req.Prop.Names {
+				if prop, ok := findDAVProp(props, want.XMLName); ok {
+					found.WriteString(prop.xml())
+				} else {
+					missing.WriteString(emptyElement(want.XMLName))
+				}
+			}
+			writePropstat(&body, found.String(), http.StatusOK)
+			writePropstat(&body, missing.String(), http.StatusNotFound)
+		default:
+			var all strings.Builder
+			for _, prop := range h.davProps(entry) {
+				all.WriteString(prop.xml())
+			}
+			writePropstat(&body, all.String(), http.StatusOK)
+		}
+		body.WriteString(`</D:response>`)
+	}
+	writeMultistatus(w, body.String())
+}
+
+// davPropertyUpdate is the body of a PROPPATCH request
+type davPropertyUpdate struct {
+	Set    []davPropNames `xml:"set>prop"`
+	Remove []davPropNames `xml:"remove>prop"`
+}
+
+// davProppatch refuses to change properties: fsrv keeps no properties besides the
+// live ones, which are all read-only. Clients still get a per-property answer.
+func (h *Handler) davProppatch(w http.ResponseWriter, r *http.Request, name string) {
+	var req davPropertyUpdate
+	if err := readDAVXML(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entry, err := h.davStat(name)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	var props strings.Builder
+	for _, list := range append(req.Set, req.Remove...) {
+		for _, prop := range list.Names {
+			props.WriteString(emptyElement(prop.XMLName))
+		}
+	}
+
+	var body strings.Builder
+	body.WriteString(`<D:response><D:href>` + davHref(entry.Path, entry.IsDir) + `</D:href>`)
+	writePropstat(&body, props.String(), http.StatusForbidden)
+	body.WriteString(`</D:response>`)
+	writeMultistatus(w, body.String())
+}
+
+// davGet downloads a file. Folders have no content of their own, so browsers are
+// sent to the file list instead.
+func (h *Handler) davGet(w http.ResponseWriter, r *http.Request, name string) {
+	entry, err := h.davStat(name)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	if entry.IsDir {
+		http.Redirect(w, r, listURL(entry.Path), http.StatusFound)
+		return
+	}
+
+	file, err := h.svc.OpenFile(name)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	defer file.Close()
+
+	h.setChecksumHeaders(w, entry.Path, storage.FileInfo{Size: entry.Size, ModTime: entry.ModTime})
+	w.Header().Set("Content-Type", davContentType(name))
+	http.ServeContent(w, r, name, entry.ModTime, file)
+}
+
+// davPut uploads the request body as a file.
+//
+// Responds 201 on create and 204 on replace, 409 if the parent folder is missing,
+// 405 if a folder is in the way, 413 if the body exceeds the maximum upload size
+// and 400 if it does not match the checksums declared in its headers.
+func (h *Handler) davPut(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "Cannot upload to a folder", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.davConfirmLocks(w, r, name, false) || !h.davParentExists(w, name) {
+		return
+	}
+
+	existing, err := h.svc.StatFile(name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, service.ErrFileNotExist) {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	if exists && existing.IsDir {
+		http.Error(w, "Cannot replace a folder with a file", http.StatusMethodNotAllowed)
+		return
+	}
+	// Filling in the empty file created by the client's own LOCK is no overwrite
+	placeholder := exists && existing.Size == 0 &&
+		h.davLocks.holdsPlaceholder(name, currentUser(r), ifTokens(r.Header.Get("If")))
+	if exists && !placeholder {
+		err := h.authorize(r, auth.PermOverwrite, name)
+		if err == nil && !h.svc.IsDeleteEnabled() {
+			err = fmt.Errorf("%w: cannot replace '%s'", service.ErrDeleteDisabled, name)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), statusFor(err))
+			return
+		}
+	}
+
+	maxSize := h.svc.GetMaxUploadSize()
+	if r.ContentLength > maxSize {
+		http.Error(w, fmt.Sprintf("File is too large, max upload file size is %s", h.svc.GetMaxUploadSizeHuman()),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := h.svc.CheckQuota(name, currentUser(r), r.ContentLength); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	if err := h.svc.CheckDiskSpace(r.ContentLength); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	want, err := declaredChecksums(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	body := service.VerifyChecksums(r.Body, want)
+	ttl, err := askedTTL(r)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	policy := service.ConflictFail
+	if exists {
+		policy = service.ConflictOverwrite
+	}
+	if _, err := h.svc.StoreFile(name, body, policy, currentUser(r), ttl); err != nil {
+		log.Printf("Failed to upload file over WebDAV: %v", err)
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	log.Printf("Uploaded file over WebDAV successfully: %s", name)
+
+	if info, err := h.svc.StatFile(name); err == nil {
+		w.Header().Set("ETag", h.entityTag(name, info.Size, info.ModTime))
+	}
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// davDelete removes a file or a folder with everything in it
+func (h *Handler) davDelete(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "" {
+		http.Error(w, "Cannot delete the store root", http.StatusForbidden)
+		return
+	}
+	if !h.davConfirmLocks(w, r, name, true) {
+		return
+	}
+
+	if err := h.svc.RemoveAll(name); err != nil {
+		log.Printf("Failed to delete over WebDAV: %v", err)
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	h.davLocks.remove(name)
+
+	log.Printf("Deleted over WebDAV successfully: %s", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// davMkcol creates a folder. Unlike the web UI, the parent folder must exist.
+func (h *Handler) davMkcol(w http.ResponseWriter, r *http.Request, name string) {
+	if r.ContentLength > 0 || len(r.TransferEncoding) > 0 {
+		http.Error(w, "MKCOL with a body is not supported", http.StatusUnsupportedMediaType)
+		return
+	}
+	if name == "" {
+		http.Error(w, "The store root already exists", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.svc.IsSubDirsEnabled() {
+		http.Error(w, service.ErrSubDirsDisabled.Error(), http.StatusForbidden)
+		return
+	}
+	if !h.davConfirmLocks(w, r, name, false) || !h.davParentExists(w, name) {
+		return
+	}
+
+	if _, err := h.svc.MakeDir(name); err != nil {
+		status := statusFor(err)
+		if errors.Is(err, service.ErrFileExists) {
+			status = http.StatusMethodNotAllowed
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	log.Printf("Created folder over WebDAV successfully: %s", name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// davMoveCopy moves or copies a resource to the URL in the Destination header.
+//
+// Responds 201 if the destination was created and 204 if it was replaced, and
+// 412 if it exists and the Overwrite header is F. Replacing counts as a delete.
+func (h *Handler) davMoveCopy(w http.ResponseWriter, r *http.Request, name string) {
+	dst, err := h.davDestination(r)
+	if errors.Is(err, service.ErrSubDirsDisabled) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if name == "" || dst == "" {
+		http.Error(w, "Cannot move or copy the store root", http.StatusForbidden)
+		return
+	}
+	if dst == name {
+		http.Error(w, "Source and destination are the same", http.StatusForbidden)
+		return
+	}
+
+	overwrite := r.Header.Get("Overwrite") != "F"
+	recursive := true
+	switch r.Header.Get("Depth") {
+	case "", "infinity":
+	case "0":
+		recursive = r.Method == "MOVE" // a move always takes everything along
+	default:
+		http.Error(w, "Invalid Depth header", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authorize(r, auth.PermUpload, dst); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	if _, err := h.davStat(dst); err == nil && overwrite {
+		if err := h.authorize(r, auth.PermOverwrite, dst); err != nil {
+			http.Error(w, err.Error(), statusFor(err))
+			return
+		}
+	}
+
+	if r.Method == "MOVE" && !h.davConfirmLocks(w, r, name, true) {
+		return
+	}
+	if !h.davConfirmLocks(w, r, dst, true) || !h.davParentExists(w, dst) {
+		return
+	}
+
+	var replaced bool
+	if r.Method == "MOVE" {
+		replaced, err = h.svc.MoveFile(name, dst, overwrite)
+	} else {
+		replaced, err = h.svc.CopyFile(name, dst, overwrite, recursive)
+	}
+	if err != nil {
+		log.Printf("Failed to %s over WebDAV: %v", strings.ToLower(r.Method), err)
+		status := statusFor(err)
+		if errors.Is(err, service.ErrFileExists) && !overwrite {
+			status = http.StatusPreconditionFailed
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if r.Method == "MOVE" {
+		h.davLocks.remove(name)
+	}
+
+	log.Printf("%s over WebDAV successfully: %s -> %s", r.Method, name, dst)
+	if replaced {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// davLockInfo is the body of a LOCK request creating a lock
+type davLockInfo struct {
+	Exclusive *struct{} `xml:"lockscope>exclusive"`
+	Shared    *struct{} `xml:"lockscope>shared"`
+	Write     *struct{} `xml:"locktype>write"`
+	Owner     struct {
+		Href string `xml:"href"`
+		Text string `xml:",chardata"`
+	} `xml:"owner"`
+}
+
+// owner returns the owner of the lock as XML to echo back in lock discovery
+func (info *davLockInfo) owner() string {
+	if info.Owner.Href != "" {
+		return `<D:href>` + xmlEscape(strings.TrimSpace(info.Owner.Href)) + `</D:href>`
+	}
+	return xmlEscape(strings.TrimSpace(info.Owner.Text))
+}
+
+// davLock creates or, without a body, refreshes a write lock. Locking a missing
+// file creates it empty, so clients can reserve a name before uploading to it.
+func (h *Handler) davLock(w http.ResponseWriter, r *http.Request, name string) {
+	timeout := parseTimeout(r.Header.Get("Timeout"))
+
+	var info davLockInfo
+	if err := readDAVXML(r, &info); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if info == (davLockInfo{}) {
+		lock, err := h.davLocks.refresh(name, ifTokens(r.Header.Get("If")), timeout)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		writeLockDiscovery(w, http.StatusOK, *lock)
+		return
+	}
+	if info.Write == nil || (info.Exclusive == nil) == (info.Shared == nil) {
+		http.Error(w, "Only exclusive or shared write locks are supported", http.StatusBadRequest)
+		return
+	}
+	infinite := true
+	switch r.Header.Get("Depth") {
+	case "", "infinity":
+	case "0":
+		infinite = false
+	default:
+		http.Error(w, "Invalid Depth header", http.StatusBadRequest)
+		return
+	}
+
+	lock, err := h.davLocks.create(name, infinite, info.Shared != nil, info.owner(), timeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusLocked)
+		return
+	}
+
+	status := http.StatusOK
+	if _, err := h.davStat(name); errors.Is(err, service.ErrFileNotExist) {
+		if !h.davParentExists(w, name) {
+			h.davLocks.unlock(name, lock.token)
+			return
+		}
+		if _, err := h.svc.UploadFile(name, strings.NewReader("")); err != nil {
+			h.davLocks.unlock(name, lock.token)
+			http.Error(w, err.Error(), statusFor(err))
+			return
+		}
+		h.davLocks.created(lock.token, currentUser(r))
+		status = http.StatusCreated
+	}
+
+	w.Header().Set("Lock-Token", "<"+lock.token+">")
+	writeLockDiscovery(w, status, *lock)
+}
+
+// davUnlock releases the lock named in the Lock-Token header
+func (h *Handler) davUnlock(w http.ResponseWriter, r *http.Request, name string) {
+	token := strings.Trim(r.Header.Get("Lock-Token"), "<> ")
+	if token == "" {
+		http.Error(w, "Missing Lock-Token header", http.StatusBadRequest)
+		return
+	}
+	if err := h.davLocks.unlock(name, token); err != nil {
+		writeDAVError(w, http.StatusConflict, "lock-token-matches-request-uri")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// davConfirmLocks answers 423 if a write to name is guarded by a lock whose
+// token the client did not submit in the If header
+func (h *Handler) davConfirmLocks(w http.ResponseWriter, r *http.Request, name string, recursive bool) bool {
+	if err := h.davLocks.confirm(name, recursive, ifTokens(r.Header.Get("If"))); err != nil {
+		writeDAVError(w, http.StatusLocked, "lock-token-submitted")
+		return false
+	}
+	return true
+}
+
+// davParentExists answers 409 unless the folder name is to be created in exists,
+// as WebDAV never creates intermediate folders
+func (h *Handler) davParentExists(w http.ResponseWriter, name string) bool {
+	parent := path.Dir(name)
+	if parent == "." {
+		return true
+	}
+	if entry, err := h.davStat(parent); err != nil || !entry.IsDir {
+		http.Error(w, fmt.Sprintf("Parent folder '%s' does not exist", parent), http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// davStat describes the file or folder at name, "" being the store root
+func (h *Handler) davStat(name string) (service.Entry, error) {
+	if name == "" {
+		return service.Entry{IsDir: true}, nil
+	}
+	info, err := h.svc.StatFile(name)
+	if err != nil {
+		return service.Entry{}, err
+	}
+	if info.IsDir && !h.svc.IsSubDirsEnabled() {
+		return service.Entry{}, fmt.Errorf("%w: '%s'", service.ErrFileNotExist, name)
+	}
+	return service.Entry{Path: name, Size: info.Size, ModTime: info.ModTime, IsDir: info.IsDir}, nil
+}
+
+// davDestination returns the path in the store the Destination header points to.
+// It must be a WebDAV URL on this server.
+func (h *Handler) davDestination(r *http.Request) (string, error) {
+	dst, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || dst.Path == "" {
+		return "", errors.New("invalid Destination header")
+	}
+	if dst.Host != "" && dst.Host != r.Host {
+		return "", fmt.Errorf("destination '%s' is on another server", dst)
+	}
+	name, ok := strings.CutPrefix(dst.Path, davPrefix)
+	if !ok && dst.Path+"/" != davPrefix {
+		return "", fmt.Errorf("destination '%s' is outside of %s", dst.Path, davPrefix)
+	}
+	name = strings.Trim(name, "/")
+	if name != "" && !h.svc.IsSubDirsEnabled() && strings.Contains(name, "/") {
+		return "", service.ErrSubDirsDisabled
+	}
+	return name, nil
+}
+
+// davProp is a live property in the DAV: namespace
+type davProp struct {
+	name  string
+	value string // inner XML
+}
+
+// xml returns the property as an XML element
+func (p davProp) xml() string {
+	if p.value == "" {
+		return `<D:` + p.name + `/>`
+	}
+	return `<D:` + p.name + `>` + p.value + `</D:` + p.name + `>`
+}
+
+// davProps returns the live properties of a resource
+func (h *Handler) davProps(entry service.Entry) []davProp {
+	props := make([]davProp, 0, len(davLiveProps))
+	for _, name := range davLiveProps {
+		var value string
+		switch name {
+		case "resourcetype":
+			if entry.IsDir {
+				value = `<D:collection/>`
+			}
+		case "displayname":
+			value = xmlEscape(path.Base("/" + entry.Path))
+		case "getcontentlength":
+			if entry.IsDir {
+				continue
+			}
+			value = strconv.FormatInt(entry.Size, 10)
+		case "getlastmodified":
+			if entry.ModTime.IsZero() {
+				continue
+			}
+			value = entry.ModTime.UTC().Format(http.TimeFormat)
+		case "getcontenttype":
+			if entry.IsDir {
+				continue
+			}
+			value = xmlEscape(davContentType(entry.Path))
+		case "getetag":
+			if entry.IsDir {
+				continue
+			}
+			value = xmlEscape(h.entityTag(entry.Path, entry.Size, entry.ModTime))
+		case "supportedlock":
+			value = davSupportedLock
+		case "lockdiscovery":
+			for _, lock := range h.davLocks.find(entry.Path) {
+				value += activeLock(lock)
+			}
+		}
+		props = append(props, davProp{name: name, value: value})
+	}
+	return props
+}
+
+// findDAVProp returns the live property called name
+func findDAVProp(props []davProp, name xml.Name) (davProp, bool) {
+	if name.Space != "DAV:" {
+		return davProp{}, false
+	}
+	for _, prop := range props {
+		if prop.name == name.Local {
+			return prop, true
+		}
+	}
+	return davProp{}, false
+}
+
+// activeLock describes a lock for the lockdiscovery property
+func activeLock(lock davLock) string {
+	scope, depth := "exclusive", "0"
+	if lock.shared {
+		scope = "shared"
+	}
+	if lock.infinite {
+		depth = "infinity"
+	}
+	return `<D:activelock><D:locktype><D:write/></D:locktype>` +
+		`<D:lockscope><D:` + scope + `/></D:lockscope>` +
+		`<D:depth>` + depth + `</D:depth>` +
+		`<D:owner>` + lock.owner + `</D:owner>` +
+		`<D:timeout>Second-` + strconv.Itoa(int(lock.timeout/time.Second)) + `</D:timeout>` +
+		`<D:locktoken><D:href>` + xmlEscape(lock.token) + `</D:href></D:locktoken>` +
+		`<D:lockroot><D:href>` + davHref(lock.root, false) + `</D:href></D:lockroot>` +
+		`</D:activelock>`
+}
+
+// davHref returns the escaped URL path of a resource. Folders end in a slash.
+func davHref(name string, isDir bool) string {
+	href := (&url.URL{Path: davPrefix + name}).EscapedPath()
+	if isDir && name != "" {
+		href += "/"
+	}
+	return xmlEscape(href)
+}
+
+// davContentType guesses the media type of a file from its extension
+func davContentType(name string) string {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// emptyElement returns an empty XML element for a property name in any namespace
+func emptyElement(name xml.Name) string {
+	if name.Space == "DAV:" {
+		return `<D:` + name.Local + `/>`
+	}
+	return `<` + name.Local + ` xmlns="` + xmlEscape(name.Space) + `"/>`
+}
+
+// writePropstat appends a propstat element for props, unless there are none
+func writePropstat(body *strings.Builder, props string, status int) {
+	if props == "" {
+		return
+	}
+	body.WriteString(`<D:propstat><D:prop>` + props + `</D:prop>`)
+	body.WriteString(fmt.Sprintf(`<D:status>HTTP/1.1 %d %s</D:status></D:propstat>`, status, http.StatusText(status)))
+}
+
+// writeMultistatus writes a 207 Multi-Status response around the given responses
+func writeMultistatus(w http.ResponseWriter, responses string) {
+	writeDAVXML(w, http.StatusMultiStatus, `<D:multistatus xmlns:D="DAV:">`+responses+`</D:multistatus>`)
+}
+
+// writeLockDiscovery writes the response to a LOCK request
+func writeLockDiscovery(w http.ResponseWriter, status int, lock davLock) {
+	writeDAVXML(w, status, `<D:prop xmlns:D="DAV:"><D:lockdiscovery>`+activeLock(lock)+`</D:lockdiscovery></D:prop>`)
+}
+
+// writeDAVError writes an error response naming the precondition that failed
+func writeDAVError(w http.ResponseWriter, status int, condition string) {
+	writeDAVXML(w, status, `<D:error xmlns:D="DAV:"><D:`+condition+`/></D:error>`)
+}
+
+// writeDAVXML writes an XML document with the given status code
+func writeDAVXML(w http.ResponseWriter, status int, doc string) {
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header+doc)
+}
+
+// readDAVXML decodes an XML request body into v, leaving v untouched if there is none
+func readDAVXML(r *http.Request, v any) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, davMaxXML))
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil
+	}
+	if err := xml.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid XML request body: %w", err)
+	}
+	return nil
+}
+
+// xmlEscape escapes s for use as XML character data or attribute value
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// davMaxLockTimeout is the longest a WebDAV lock lasts. Clients may ask for less.
+const davMaxLockTimeout = time.Hour
+
+// Errors returned by the WebDAV lock table
+var (
+	errDAVLocked = errors.New("resource is locked")
+	errDAVNoLock = errors.New("lock token does not apply to the resource")
+)
+
+// davLock is a WebDAV write lock on a path relative to the store root
+type davLock struct {
+	token    string
+	root     string // locked path, "" for the store root
+	infinite bool   // Depth: infinity, covering everything below root
+	shared   bool
+	owner    string // raw XML of the owner element, echoed back to clients
+	timeout  time.Duration
+	expires  time.Time
+
+	// placeholder is set if taking the lock created root as an empty file,
+	// which user, who took it, may then upload to without overwriting anything
+	placeholder bool
+	user        string
+}
+
+// covers reports whether the lock applies to name
+func (l *davLock) covers(name string) bool {
+	return l.root == name || l.infinite && davWithin(name, l.root)
+}
+
+// davLockTable keeps the WebDAV locks in memory. Locks only guard against other
+// WebDAV clients; they expire after their timeout and do not survive a restart.
+type davLockTable struct {
+	mu    sync.Mutex
+	locks map[string]*davLock // keyed by token
+}
+
+func newDAVLockTable() *davLockTable {
+	return &davLockTable{locks: make(map[string]*davLock)}
+}
+
+// create takes a new lock on name. It fails with errDAVLocked if the lock
+// conflicts with an existing one; only shared locks can overlap.
+func (t *davLockTable) create(name string, infinite, shared bool, owner string, timeout time.Duration) (*davLock, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+
+	lock := &davLock{root: name, infinite: infinite, shared: shared, owner: owner}
+	for _, other := range t.locks {
+		overlaps := other.covers(name) || lock.covers(other.root)
+		if overlaps && !(shared && other.shared) {
+			return nil, fmt.Errorf("%w: '%s'", errDAVLocked, other.root)
+		}
+	}
+
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	lock.token = token
+	lock.refresh(timeout)
+	t.locks[token] = lock
+	return lock, nil
+}
+
+// refresh extends the first of tokens that locks name, for a LOCK request without body
+func (t *davLockTable) refresh(name string, tokens []string, timeout time.Duration) (*davLock, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+
+	for _, token := range tokens {
+		if lock, ok := t.locks[token]; ok && lock.covers(name) {
+			lock.refresh(timeout)
+			copied := *lock
+			return &copied, nil
+		}
+	}
+	return nil, errDAVNoLock
+}
+
+// created records that taking the lock with token created its root as an empty
+// file on behalf of user
+func (t *davLockTable) created(token, user string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if lock, ok := t.locks[token]; ok {
+		lock.placeholder, lock.user = true, user
+	}
+}
+
+// holdsPlaceholder reports whether one of tokens is a lock of user whose LOCK
+// request created the file name
+func (t *davLockTable) holdsPlaceholder(name, user string, tokens []string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+
+	for _, token := range tokens {
+		if lock, ok := t.locks[token]; ok && lock.placeholder && lock.root == name && lock.user == user {
+			return true
+		}
+	}
+	return false
+}
+
+// unlock removes the lock with token, which must apply to name
+func (t *davLockTable) unlock(name, token string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+
+	lock, ok := t.locks[token]
+	if !ok || !lock.covers(name) {
+		return errDAVNoLock
+	}
+	delete(t.locks, token)
+	return nil
+}
+
+// confirm checks that the client holds the locks guarding a write to name, i.e.
+// that tokens lists every lock covering name and, for recursive writes such as
+// deleting a folder, every lock below it. It fails with errDAVLocked otherwise.
+func (t *davLockTable) confirm(name string, recursive bool, tokens []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+
+	for _, lock := range t.locks {
+		if !lock.covers(name) && !(recursive && davWithin(lock.root, name)) {
+			continue
+		}
+		if !slices.Contains(tokens, lock.token) {
+			return fmt.Errorf("%w: '%s'", errDAVLocked, lock.root)
+		}
+	}
+	return nil
+}
+
+// find returns copies of the locks applying to name, for lock discovery
+func (t *davLockTable) find(name string) []davLock {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+
+	var found []davLock
+	for _, lock := range t.locks {
+		if lock.covers(name) {
+			found = append(found, *lock)
+		}
+	}
+	return found
+}
+
+// remove drops the locks on name and below, once it has been deleted or moved away
+func (t *davLockTable) remove(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for token, lock := range t.locks {
+		if davWithin(lock.root, name) {
+			delete(t.locks, token)
+		}
+	}
+}
+
+// expire drops locks whose timeout has passed. The caller must hold t.mu.
+func (t *davLockTable) expire() {
+	now := time.Now()
+	for token, lock := range t.locks {
+		if now.After(lock.expires) {
+			delete(t.locks, token)
+		}
+	}
+}
+
+// refresh restarts the timeout of the lock
+func (l *davLock) refresh(timeout time.Duration) {
+	l.timeout = timeout
+	l.expires = time.Now().Add(timeout)
+}
+
+// newLockToken returns a random lock token in the urn:uuid form
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // variant 10
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// parseTimeout parses a Timeout header such as "Second-600, Infinite", capping
+// the result at davMaxLockTimeout
+func parseTimeout(header string) time.Duration {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+		if seconds, ok := strings.CutPrefix(value, "Second-"); ok {
+			n, err := strconv.ParseInt(seconds, 10, 64)
+			if err != nil || n <= 0 {
+				continue
+			}
+			if n >= int64(davMaxLockTimeout/time.Second) {
+				break
+			}
+			return time.Duration(n) * time.Second
+		}
+	}
+	return davMaxLockTimeout
+}
+
+// ifTokens returns the lock tokens submitted in an If header, such as
+// `</dav/a.txt> (<urn:uuid:...>) (Not <DAV:no-lock>)`. Resource tags and
+// entity tags are ignored: a write succeeds if the client names the lock.
+func ifTokens(header string) []string {
+	var tokens []string
+	depth := 0
+	for len(header) > 0 {
+		switch header[0] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '<':
+			end := strings.IndexByte(header, '>')
+			if end < 0 {
+				return tokens
+			}
+			if depth > 0 {
+				tokens = append(tokens, header[1:end])
+			}
+			header = header[end:]
+		case '[':
+			end := strings.IndexByte(header, ']')
+			if end < 0 {
+				return tokens
+			}
+			header = header[end:]
+		}
+		header = header[1:]
+	}
+	return tokens
+}
+
+// davWithin reports whether name is dir or lies below it, "" being the store root
+func davWithin(name, dir string) bool {
+	return dir == "" || name == dir || strings.HasPrefix(name, dir+"/")
+}
@@ -0,0 +1,344 @@
+package handler
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fsrv/internal/config"
+	"fsrv/internal/storage"
+)
+
+// davLockBody is the body of a LOCK request for an exclusive write lock
+const davLockBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype>
+<D:owner><D:href>mailto:tester@example.com</D:href></D:owner></D:lockinfo>`
+
+// setupDAVHandler creates a handler with subdirectories over in-memory storage
+func setupDAVHandler(t *testing.T, configure func(*config.Config)) (*Handler, *storage.Memory) {
+	store := storage.NewMemory()
+	svc := newTestService(t, store, func(cfg *config.Config) {
+		cfg.SubDirs = true
+		if configure != nil {
+			configure(cfg)
+		}
+	})
+	h, err := New(svc, testTemplates)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	return h, store
+}
+
+// serveDAV sends a WebDAV request to h; headers are given as name, value pairs
+func serveDAV(h *Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.WebDAV(w, req)
+	return w
+}
+
+// davMultistatus is the part of a PROPFIND response the tests look at
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				ETag          string `xml:"getetag"`
+				LockToken     string `xml:"lockdiscovery>activelock>locktoken>href"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func TestHandler_WebDAV_Options(t *testing.T) {
+	h, _ := setupDAVHandler(t, nil)
+
+	w := serveDAV(h, "OPTIONS", "/dav/", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("OPTIONS status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w.Header().Get("DAV") != "1, 2" || !strings.Contains(w.Header().Get("Allow"), "PROPFIND") {
+		t.Errorf("OPTIONS headers = %v", w.Header())
+	}
+}
+
+func TestHandler_WebDAV_Propfind(t *testing.T) {
+	h, store := setupDAVHandler(t, nil)
+	putStored(t, store, "docs/my report.txt", []byte("hello"))
+	putStored(t, store, "root.txt", []byte("root"))
+
+	w := serveDAV(h, "PROPFIND", "/dav/", "", "Depth", "1")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND status = %d, want %d: %s", w.Code, http.StatusMultiStatus, w.Body.String())
+	}
+	var result davMultistatus
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse PROPFIND response: %v", err)
+	}
+	hrefs := make(map[string]bool)
+	for _, response := range result.Responses {
+		hrefs[response.Href] = response.Propstat[0].Prop.ResourceType.Collection != nil
+	}
+	if len(hrefs) != 3 || !hrefs["/dav/"] || !hrefs["/dav/docs/"] || hrefs["/dav/root.txt"] {
+		t.Errorf("PROPFIND listed %v", hrefs)
+	}
+
+	w = serveDAV(h, "PROPFIND", "/dav/docs/my%20report.txt",
+		`<D:propfind xmlns:D="DAV:"><D:prop><D:getcontentlength/><D:getetag/><x:color xmlns:x="urn:test"/></D:prop></D:propfind>`,
+		"Depth", "0")
+	result = davMultistatus{}
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse PROPFIND response: %v", err)
+	}
+	if len(result.Responses) != 1 || len(result.Responses[0].Propstat) != 2 {
+		t.Fatalf("PROPFIND of a file = %s", w.Body.String())
+	}
+	response := result.Responses[0]
+	if response.Href != "/dav/docs/my%20report.txt" || response.Propstat[0].Prop.ContentLength != "5" ||
+		response.Propstat[0].Prop.ETag == "" {
+		t.Errorf("PROPFIND of a file = %s", w.Body.String())
+	}
+	if !strings.Contains(response.Propstat[1].Status, "404") {
+		t.Errorf("unknown property status = %q, want 404", response.Propstat[1].Status)
+	}
+
+	if w := serveDAV(h, "PROPFIND", "/dav/", "", "Depth", "infinity"); w.Code != http.StatusForbidden {
+		t.Errorf("PROPFIND with Depth: infinity status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := serveDAV(h, "PROPFIND", "/dav/missing", "", "Depth", "0"); w.Code != http.StatusNotFound {
+		t.Errorf("PROPFIND of a missing file status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandler_WebDAV_PutGet(t *testing.T) {
+	h, store := setupDAVHandler(t, nil)
+
+	w := serveDAV(h, "PUT", "/dav/notes.txt", "first")
+	if w.Code != http.StatusCreated || w.Header().Get("ETag") == "" {
+		t.Fatalf("PUT status = %d, ETag = %q", w.Code, w.Header().Get("ETag"))
+	}
+	if w := serveDAV(h, "PUT", "/dav/notes.txt", "second"); w.Code != http.StatusNoContent {
+		t.Errorf("PUT replacing a file status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if content, _ := readStored(store, "notes.txt"); string(content) != "second" {
+		t.Errorf("stored content = %q, want second", content)
+	}
+
+	w = serveDAV(h, "GET", "/dav/notes.txt", "")
+	if w.Code != http.StatusOK || w.Body.String() != "second" || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("GET = %d %q (%s)", w.Code, w.Body.String(), w.Header().Get("Content-Type"))
+	}
+
+	if w := serveDAV(h, "PUT", "/dav/missing/notes.txt", "data"); w.Code != http.StatusConflict {
+		t.Errorf("PUT into a missing folder status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := serveDAV(h, "GET", "/dav/", ""); w.Code != http.StatusFound || w.Header().Get("Location") != "/files" {
+		t.Errorf("GET of a folder = %d to %q, want a redirect to the file list", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestHandler_WebDAV_PutTooLarge(t *testing.T) {
+	h, store := setupDAVHandler(t, withMax(4)) // 16 bytes
+
+	if w := serveDAV(h, "PUT", "/dav/big.bin", strings.Repeat("x", 17)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if isStored(store, "big.bin") {
+		t.Error("oversized PUT left a file behind")
+	}
+}
+
+func TestHandler_WebDAV_Mkcol(t *testing.T) {
+	h, store := setupDAVHandler(t, nil)
+
+	if w := serveDAV(h, "MKCOL", "/dav/docs/", ""); w.Code != http.StatusCreated {
+		t.Fatalf("MKCOL status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if info, err := store.Stat("docs"); err != nil || !info.IsDir {
+		t.Error("MKCOL did not create the folder")
+	}
+	if w := serveDAV(h, "MKCOL", "/dav/docs/", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("MKCOL of an existing folder status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+	if w := serveDAV(h, "MKCOL", "/dav/a/b/", ""); w.Code != http.StatusConflict {
+		t.Errorf("MKCOL without parent status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestHandler_WebDAV_MoveCopy(t *testing.T) {
+	h, store := setupDAVHandler(t, nil)
+	putStored(t, store, "docs/a.txt", []byte("a"))
+	putStored(t, store, "b.txt", []byte("b"))
+
+	if w := serveDAV(h, "COPY", "/dav/docs/", "", "Destination", "http://example.com/dav/copy/"); w.Code != http.StatusCreated {
+		t.Fatalf("COPY status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if content, _ := readStored(store, "copy/a.txt"); string(content) != "a" {
+		t.Error("COPY did not copy the folder")
+	}
+
+	w := serveDAV(h, "MOVE", "/dav/docs/a.txt", "", "Destination", "/dav/b.txt", "Overwrite", "F")
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("MOVE onto an existing file with Overwrite: F status = %d, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+	if w := serveDAV(h, "MOVE", "/dav/docs/a.txt", "", "Destination", "/dav/b.txt"); w.Code != http.StatusNoContent {
+		t.Errorf("MOVE replacing a file status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if content, _ := readStored(store, "b.txt"); string(content) != "a" || isStored(store, "docs/a.txt") {
+		t.Error("MOVE did not move the file")
+	}
+
+	if w := serveDAV(h, "MOVE", "/dav/b.txt", "", "Destination", "http://elsewhere.com/dav/c.txt"); w.Code != http.StatusBadGateway {
+		t.Errorf("MOVE to another server status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
+
+func TestHandler_WebDAV_DeleteDisabled(t *testing.T) {
+	h, store := setupDAVHandler(t, func(cfg *config.Config) { cfg.DelAble = false })
+	putStored(t, store, "docs/a.txt", []byte("a"))
+	putStored(t, store, "b.txt", []byte("b"))
+
+	if w := serveDAV(h, "DELETE", "/dav/docs/", ""); w.Code != http.StatusForbidden {
+		t.Errorf("DELETE status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := serveDAV(h, "PUT", "/dav/b.txt", "new"); w.Code != http.StatusForbidden {
+		t.Errorf("PUT replacing a file status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := serveDAV(h, "MOVE", "/dav/docs/a.txt", "", "Destination", "/dav/b.txt"); w.Code != http.StatusForbidden {
+		t.Errorf("MOVE replacing a file status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if !isStored(store, "docs/a.txt") {
+		t.Error("a disabled delete removed a file")
+	}
+	if content, _ := readStored(store, "b.txt"); string(content) != "b" {
+		t.Error("a disabled delete replaced a file")
+	}
+
+	// Creating files is still allowed, including filling in the empty file
+	// created by the client's own LOCK, but not replacing other empty files
+	if w := serveDAV(h, "PUT", "/dav/c.txt", "c"); w.Code != http.StatusCreated {
+		t.Errorf("PUT status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	w := serveDAV(h, "LOCK", "/dav/d.txt", davLockBody)
+	token := strings.Trim(w.Header().Get("Lock-Token"), "<>")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("LOCK status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if w := serveDAV(h, "PUT", "/dav/d.txt", "d", "If", "(<"+token+">)"); w.Code != http.StatusNoContent {
+		t.Errorf("PUT to a locked new file status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	putStored(t, store, "e.txt", nil)
+	if w := serveDAV(h, "PUT", "/dav/e.txt", "e"); w.Code != http.StatusForbidden {
+		t.Errorf("PUT replacing an empty file status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w = serveDAV(h, "LOCK", "/dav/e.txt", davLockBody)
+	token = strings.Trim(w.Header().Get("Lock-Token"), "<>")
+	if w := serveDAV(h, "PUT", "/dav/e.txt", "e", "If", "(<"+token+">)"); w.Code != http.StatusForbidden {
+		t.Errorf("PUT replacing an empty file locked afterwards status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestHandler_WebDAV_Delete(t *testing.T) {
+	h, store := setupDAVHandler(t, nil)
+	putStored(t, store, "docs/2024/a.txt", []byte("a"))
+
+	if w := serveDAV(h, "DELETE", "/dav/docs/", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if isStored(store, "docs") {
+		t.Error("DELETE did not remove the folder")
+	}
+	if w := serveDAV(h, "DELETE", "/dav/docs/", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a missing folder status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandler_WebDAV_Lock(t *testing.T) {
+	h, store := setupDAVHandler(t, nil)
+	putStored(t, store, "docs/a.txt", []byte("a"))
+
+	// Locking a missing file creates it empty
+	w := serveDAV(h, "LOCK", "/dav/new.txt", davLockBody, "Timeout", "Second-600")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("LOCK status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	token := strings.Trim(w.Header().Get("Lock-Token"), "<>")
+	if !strings.HasPrefix(token, "urn:uuid:") || !strings.Contains(w.Body.String(), "mailto:tester@example.com") {
+		t.Fatalf("LOCK token = %q, body = %s", token, w.Body.String())
+	}
+	if !isStored(store, "new.txt") {
+		t.Error("LOCK did not create the file")
+	}
+
+	if w := serveDAV(h, "PUT", "/dav/new.txt", "data"); w.Code != http.StatusLocked {
+		t.Errorf("PUT without the lock token status = %d, want %d", w.Code, http.StatusLocked)
+	}
+	if w := serveDAV(h, "LOCK", "/dav/new.txt", davLockBody); w.Code != http.StatusLocked {
+		t.Errorf("second LOCK status = %d, want %d", w.Code, http.StatusLocked)
+	}
+	if w := serveDAV(h, "PUT", "/dav/new.txt", "data", "If", "(<"+token+">)"); w.Code != http.StatusNoContent {
+		t.Errorf("PUT with the lock token status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serveDAV(h, "LOCK", "/dav/new.txt", "", "If", "(<"+token+">)"); w.Code != http.StatusOK {
+		t.Errorf("LOCK refresh status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if w := serveDAV(h, "UNLOCK", "/dav/new.txt", "", "Lock-Token", "<urn:uuid:other>"); w.Code != http.StatusConflict {
+		t.Errorf("UNLOCK with a wrong token status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := serveDAV(h, "UNLOCK", "/dav/new.txt", "", "Lock-Token", "<"+token+">"); w.Code != http.StatusNoContent {
+		t.Errorf("UNLOCK status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serveDAV(h, "DELETE", "/dav/new.txt", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE after UNLOCK status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	// A folder lock guards everything below it
+	w = serveDAV(h, "LOCK", "/dav/docs/", davLockBody, "Depth", "infinity")
+	if w.Code != http.StatusOK {
+		t.Fatalf("LOCK of a folder status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := serveDAV(h, "DELETE", "/dav/docs/a.txt", ""); w.Code != http.StatusLocked {
+		t.Errorf("DELETE in a locked folder status = %d, want %d", w.Code, http.StatusLocked)
+	}
+	w = serveDAV(h, "PROPFIND", "/dav/docs/a.txt", "", "Depth", "0")
+	var result davMultistatus
+	xml.Unmarshal(w.Body.Bytes(), &result)
+	if len(result.Responses) != 1 || result.Responses[0].Propstat[0].Prop.LockToken == "" {
+		t.Errorf("lockdiscovery of a file in a locked folder = %s", w.Body.String())
+	}
+}
+
+func TestIfTokens(t *testing.T) {
+	header := `</dav/a.txt> (<urn:uuid:1> ["etag"]) (Not <DAV:no-lock>) <http://x/dav/b> (<urn:uuid:2>)`
+	got := ifTokens(header)
+	want := []string{"urn:uuid:1", "DAV:no-lock", "urn:uuid:2"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ifTokens() = %v, want %v", got, want)
+	}
+}
+
+func TestHandler_WebDAV_PutChecksumMismatch(t *testing.T) {
+	h, store := setupDAVHandler(t, nil)
+
+	if w := serveDAV(h, "PUT", "/dav/a.txt", "hello", "X-Checksum-Sha256", helloWorldSHA256); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT with a wrong checksum = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if isStored(store, "a.txt") {
+		t.Error("PUT with a wrong checksum was stored")
+	}
+	if w := serveDAV(h, "PUT", "/dav/a.txt", "hello", "X-Checksum-Sha256", helloSHA256); w.Code != http.StatusCreated {
+		t.Errorf("PUT with the right checksum = %d, want %d", w.Code, http.StatusCreated)
+	}
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/util"
+)
+
+// Breadcrumb is one step of the path to the folder being shown
+type Breadcrumb struct {
+	Name string
+	Path string
+}
+
+// breadcrumbs splits a folder path into navigation steps, starting with the store root.
+// Invalid paths collapse to the root.
+func breadcrumbs(dir string) []Breadcrumb {
+	crumbs := []Breadcrumb{{Name: "Home", Path: ""}}
+
+	clean, err := util.SafePath(dir)
+	if err != nil || clean == "" {
+		return crumbs
+	}
+
+	current := ""
+	for _, name := range strings.Split(clean, "/") {
+		current = path.Join(current, name)
+		crumbs = append(crumbs, Breadcrumb{Name: name, Path: current})
+	}
+	return crumbs
+}
+
+// listURL returns the URL of the file list showing dir
+func listURL(dir string) string {
+	if dir == "" {
+		return "/files"
+	}
+	return "/files?dir=" + url.QueryEscape(dir)
+}
+
+// MakeDir creates a folder named "name" inside the folder "dir"
+func (h *Handler) MakeDir(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod(w, r, "POST") {
+		return
+	}
+
+	if err := checkCSRF(r); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
+	parent := r.FormValue("dir")
+	name := r.FormValue("name")
+	if strings.TrimSpace(name) == "" {
+		h.renderInfo(w, "Folder name must not be empty")
+		return
+	}
+
+	if err := h.authorize(r, auth.PermUpload, path.Join(parent, name)); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
+	created, err := h.svc.MakeDir(path.Join(parent, name))
+	if err != nil {
+		h.renderInfo(w, fmt.Sprintf("Failed to create folder: %v", err))
+		log.Printf("Failed to create folder: %v", err)
+		return
+	}
+
+	log.Printf("Created folder successfully: %s", created)
+	http.Redirect(w, r, listURL(created), http.StatusSeeOther)
+}
+
+// RemoveDir removes an empty folder, if delete is enabled
+func (h *Handler) RemoveDir(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod(w, r, "POST") {
+		return
+	}
+
+	if !h.svc.IsDeleteEnabled() {
+		h.renderInfo(w, "Delete is disabled on this server")
+		return
+	}
+	if err := checkCSRF(r); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
+
+	dir := r.FormValue("dir")
+	if err := h.authorize(r, auth.PermDelete, dir); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
+	if err := h.svc.RemoveDir(dir); err != nil {
+		h.renderInfo(w, fmt.Sprintf("Failed to remove folder: %v", err))
+		log.Printf("Failed to remove folder: %v", err)
+		return
+	}
+
+	log.Printf("Removed folder successfully: %s", dir)
+	parent := path.Dir(strings.Trim(dir, "/"))
+	if parent == "." {
+		parent = ""
+	}
+	http.Redirect(w, r, listURL(parent), http.StatusSeeOther)
+}
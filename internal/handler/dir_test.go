@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"fsrv/internal/config"
+	"fsrv/internal/storage"
+)
+
+// setupSubDirHandler creates a test handler with subdirectory support enabled
+func setupSubDirHandler(t *testing.T, delAble bool) (*Handler, *storage.Memory) {
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, func(cfg *config.Config) {
+		cfg.SubDirs = true
+		cfg.DelAble = delAble
+	})
+	return h, store
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want []Breadcrumb
+	}{
+		{"", []Breadcrumb{{"Home", ""}}},
+		{"../etc", []Breadcrumb{{"Home", ""}}},
+		{"/a//b/", []Breadcrumb{{"Home", ""}, {"a", "a"}, {"b", "a/b"}}},
+	}
+
+	for _, tt := range tests {
+		if got := breadcrumbs(tt.dir); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("breadcrumbs(%q) = %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestHandler_MakeDir(t *testing.T) {
+	h, store := setupSubDirHandler(t, true)
+
+	req := postForm("/mkdir", url.Values{"dir": {"docs"}, "name": {"2024 reports"}})
+	w := httptest.NewRecorder()
+
+	h.MakeDir(w, req)
+
+	if w.Code != 303 {
+		t.Fatalf("Expected status 303, got %d", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "/files?dir=docs%2F2024+reports" {
+		t.Errorf("Location = %q", loc)
+	}
+	if info, err := store.Stat("docs/2024 reports"); err != nil || !info.IsDir {
+		t.Error("Folder was not created")
+	}
+}
+
+func TestHandler_MakeDir_Disabled(t *testing.T) {
+	h, store := setupTestHandler(t)
+
+	req := postForm("/mkdir", url.Values{"name": {"docs"}})
+	w := httptest.NewRecorder()
+
+	h.MakeDir(w, req)
+
+	if !strings.Contains(w.Body.String(), "subdirectories are disabled") {
+		t.Errorf("Expected disabled message, got %q", w.Body.String())
+	}
+	if isStored(store, "docs") {
+		t.Error("Folder should not have been created")
+	}
+}
+
+func TestHandler_MakeDir_WrongMethod(t *testing.T) {
+	h, store := setupSubDirHandler(t, true)
+
+	req := httptest.NewRequest("GET", "/mkdir?name=docs", nil)
+	w := httptest.NewRecorder()
+
+	h.MakeDir(w, req)
+
+	if !strings.Contains(w.Body.String(), "HTTP Method should be") {
+		t.Errorf("Expected method error, got %q", w.Body.String())
+	}
+	if isStored(store, "docs") {
+		t.Error("GET must not create a folder")
+	}
+}
+
+func TestHandler_RemoveDir(t *testing.T) {
+	h, store := setupSubDirHandler(t, true)
+
+	if err := store.Mkdir("docs/old"); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+
+	req := postForm("/rmdir", url.Values{"dir": {"docs/old"}})
+	w := httptest.NewRecorder()
+
+	h.RemoveDir(w, req)
+
+	if w.Code != 303 {
+		t.Fatalf("Expected status 303, got %d", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "/files?dir=docs" {
+		t.Errorf("Location = %q", loc)
+	}
+	if isStored(store, "docs/old") {
+		t.Error("Folder was not removed")
+	}
+}
+
+func TestHandler_RemoveDir_DeleteDisabled(t *testing.T) {
+	h, store := setupSubDirHandler(t, false)
+
+	if err := store.Mkdir("docs"); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+
+	form := url.Values{"dir": {"docs"}}
+	req := httptest.NewRequest("POST", "/rmdir", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	h.RemoveDir(w, req)
+
+	if !strings.Contains(w.Body.String(), "Delete is disabled") {
+		t.Errorf("Expected disabled message, got %q", w.Body.String())
+	}
+	if !isStored(store, "docs") {
+		t.Error("Folder should not have been removed")
+	}
+}
+
+func TestHandler_ListFiles_SubDir(t *testing.T) {
+	h, store := setupSubDirHandler(t, true)
+
+	if err := store.Mkdir("docs"); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/files?dir=docs", nil)
+	w := httptest.NewRecorder()
+	h.ListFiles(w, req)
+
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "FSrv Files") {
+		t.Errorf("Expected file list, got %d %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/files?dir=missing", nil)
+	w = httptest.NewRecorder()
+	h.ListFiles(w, req)
+
+	if !strings.Contains(w.Body.String(), "directory does not exist") {
+		t.Errorf("Expected missing folder message, got %q", w.Body.String())
+	}
+}
+
+func TestHandler_UploadFile_IntoSubDir(t *testing.T) {
+	h, store := setupSubDirHandler(t, true)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("dir", "projects")
+	part, _ := writer.CreateFormFile("file", "src/main.go")
+	part.Write([]byte("package main"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	h.UploadFile(w, req)
+
+	data, err := readStored(store, "projects/src/main.go")
+	if err != nil {
+		t.Fatalf("Folder upload did not keep its relative path: %v", err)
+	}
+	if string(data) != "package main" {
+		t.Error("Uploaded file content does not match")
+	}
+}
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/service"
+	"fsrv/internal/util"
+)
+
+// dropPrefix is where drop links point to. It is public: the random ID of a
+// link is all the authorization it needs, and it only allows uploads.
+const dropPrefix = "/drop/"
+
+// dropResponse is the JSON response describing a new drop link
+type dropResponse struct {
+	URL      string    `json:"url"`
+	Dir      string    `json:"dir"`
+	Expires  time.Time `json:"expires"`
+	MaxSize  int64     `json:"maxSize"`
+	MaxFiles int       `json:"maxFiles,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// CreateDrop creates a drop link, which lets people without an account upload
+// files into a folder.
+//
+//	POST /mkdrop   dir=<folder>&expires=<duration, e.g. 168h>&max=<files, 0 for unlimited>&size=<per file, e.g. 50MB>
+//
+// The link is shown on the info page, or returned as JSON when the client asks
+// for it with "Accept: application/json".
+func (h *Handler) CreateDrop(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod(w, r, "POST") {
+		return
+	}
+	if err := checkCSRF(r); err != nil {
+		h.dropFailed(w, r, statusFor(err), err.Error())
+		return
+	}
+
+	dir := r.FormValue("dir")
+	var ttl time.Duration
+	var maxFiles int
+	var maxSize int64
+	var err error
+	if expires := r.FormValue("expires"); expires != "" {
+		if ttl, err = time.ParseDuration(expires); err != nil || ttl <= 0 {
+			h.dropFailed(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid expiry '%s', expected a duration like 168h", expires))
+			return
+		}
+	}
+	if max := r.FormValue("max"); max != "" {
+		if maxFiles, err = strconv.Atoi(max); err != nil || maxFiles < 0 {
+			h.dropFailed(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid file limit '%s'", max))
+			return
+		}
+	}
+	if size := r.FormValue("size"); size != "" {
+		if maxSize, err = util.ParseSize(size); err != nil {
+			h.dropFailed(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid size limit '%s', expected a size like 50MB", size))
+			return
+		}
+	}
+
+	// A drop link uploads on behalf of its creator
+	if err := h.authorize(r, auth.PermUpload, dir); err != nil {
+		h.dropFailed(w, r, statusFor(err), err.Error())
+		return
+	}
+	link, err := h.svc.CreateDropLink(dir, ttl, maxSize, maxFiles)
+	if err != nil {
+		log.Printf("Failed to create drop link: %v", err)
+		h.dropFailed(w, r, statusFor(err), err.Error())
+		return
+	}
+	log.Printf("Created drop link %s for '%s', valid until %s (%s)", link.ID, link.Dir, link.Expires.Format(time.RFC3339), currentUser(r))
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusCreated, dropResponse{
+			URL:      link.URL,
+			Dir:      link.Dir,
+			Expires:  link.Expires,
+			MaxSize:  link.MaxSize,
+			MaxFiles: link.MaxFiles,
+		})
+		return
+	}
+
+	files := "unlimited"
+	if link.MaxFiles > 0 {
+		files = strconv.Itoa(link.MaxFiles)
+	}
+	h.renderInfo(w, fmt.Sprintf("File request for '/%s':", link.Dir), link.URL,
+		fmt.Sprintf("Valid until: %s", link.Expires.Format("2006-01-02 15:04:05")),
+		fmt.Sprintf("Files: %s, up to %s each", files, util.HumanReadableSize(link.MaxSize)))
+}
+
+// dropFailed reports a drop link that could not be created
+func (h *Handler) dropFailed(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if wantsJSON(r) {
+		writeJSON(w, status, dropResponse{Error: msg})
+		return
+	}
+	w.WriteHeader(status)
+	h.renderInfo(w, "Failed to create file request", msg)
+}
+
+// Drop serves the upload page of a drop link and receives its uploads.
+//
+//	GET  /drop/<id>   upload form
+//	POST /drop/<id>   multipart upload, like /upload
+//
+// Nothing about the store is revealed: results only name the uploaded files,
+// never the folder they went to, and there are no links to other pages.
+func (h *Handler) Drop(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, dropPrefix)
+	param := &PageParam{Title: "FSrv File Request"}
+
+	link, err := h.svc.GetDropLink(id)
+	if err != nil {
+		log.Printf("Refused drop link from %s: %v", r.RemoteAddr, err)
+		if wantsJSON(r) {
+			writeJSON(w, statusFor(err), uploadReport{Time: service.GetCurrentTime(), Error: dropError(err)})
+			return
+		}
+		w.WriteHeader(statusFor(err))
+		param.Msgs = []string{dropError(err)}
+		h.renderTemplate(w, "drop.html", param)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		results, problem := h.receiveDrop(r, link)
+		report, status, msgs := summarizeUploads(results, problem)
+		if wantsJSON(r) {
+			writeJSON(w, status, report)
+			return
+		}
+		param.Msgs = msgs
+		param.Results = results
+		// Show what is left of the link below the results
+		if link, err = h.svc.GetDropLink(id); err != nil {
+			h.renderTemplate(w, "drop.html", param)
+			return
+		}
+	default:
+		h.renderInfo(w, "HTTP Method should be 'GET' or 'POST'")
+		return
+	}
+
+	remaining := link.Remaining()
+	if remaining == 0 {
+		param.Msgs = append(param.Msgs, dropError(service.ErrDropFull))
+	} else {
+		param.Param1 = dropPrefix + link.ID
+	}
+	param.Param2 = util.HumanReadableSize(link.MaxSize)
+	param.Param3 = link.Expires.Format("2006-01-02 15:04")
+	if remaining >= 0 {
+		param.Param4 = strconv.Itoa(remaining)
+	}
+	h.renderTemplate(w, "drop.html", param)
+}
+
+// receiveDrop stores the files of a multipart upload through a drop link.
+// Plain form fields other than the checksum of the next file, including "dir",
+// are ignored.
+func (h *Handler) receiveDrop(r *http.Request, link *service.DropLink) ([]UploadResult, string) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "No file selected for upload or file is too large"
+	}
+
+	var results []UploadResult
+	var checksum string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to read drop upload: %v", err)
+			return results, fmt.Sprintf("Failed to read upload: %v", err)
+		}
+		if part.FileName() == "" {
+			if part.FormName() == checksumField {
+				checksum = readFormValue(part)
+			}
+			part.Close()
+			continue
+		}
+
+		filename := path.Base(strings.ReplaceAll(partFileName(part), "\\", "/"))
+		var stored string
+		var size int64
+		want, err := partChecksums(r, part, checksum)
+		if err == nil {
+			stored, size, err = h.svc.DropFile(link.ID, filename, service.VerifyChecksums(part, want))
+		}
+		checksum = ""
+		part.Close()
+		if err != nil {
+			log.Printf("Failed to receive file over drop link %s: %v", link.ID, err)
+			results = append(results, UploadResult{Filename: filename, Error: dropError(err), status: statusFor(err)})
+			continue
+		}
+
+		log.Printf("Received file over drop link %s: %s", link.ID, stored)
+		results = append(results, UploadResult{
+			Filename: filename,
+			Size:     size,
+			SizeText: util.HumanReadableSize(size),
+			status:   http.StatusCreated,
+		})
+	}
+	return results, ""
+}
+
+// dropError explains why a file cannot be uploaded over a drop link, without
+// naming the folder of the link
+func dropError(err error) string {
+	switch {
+	case errors.Is(err, service.ErrDropNotFound):
+		return "This file request does not exist. Please check that the link was copied completely."
+	case errors.Is(err, service.ErrDropExpired):
+		return "This file request has expired."
+	case errors.Is(err, service.ErrDropFull):
+		return "This file request has received all the files it accepts."
+	case errors.Is(err, service.ErrFileTooLarge):
+		return err.Error()
+	case errors.Is(err, service.ErrChecksumMismatch), errors.Is(err, errInvalidChecksum):
+		return "The file does not match its checksum, it may have been damaged on the way. Please try again."
+	case errors.Is(err, service.ErrFileExists), errors.Is(err, service.ErrFileBusy):
+		return "A file with this name has already been uploaded."
+	case errors.Is(err, service.ErrInvalidFilename):
+		return "Invalid filename."
+	default:
+		return "The file cannot be uploaded right now."
+	}
+}
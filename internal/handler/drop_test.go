@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// dropUpload builds a multipart upload to a drop link from pairs of filename and
+// content; dir is sent first, like the upload form does
+func dropUpload(t *testing.T, target, dir string, files ...string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("dir", dir)
+	for i := 0; i+1 < len(files); i += 2 {
+		part, err := mw.CreateFormFile("file", files[i])
+		if err != nil {
+			t.Fatalf("CreateFormFile() error = %v", err)
+		}
+		part.Write([]byte(files[i+1]))
+	}
+	mw.Close()
+	req := httptest.NewRequest("POST", target, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestHandler_Drop(t *testing.T) {
+	h, store := setupSubDirHandler(t, true)
+	putStored(t, store, "vendor/secret-plan.txt", []byte("do not show"))
+	putStored(t, store, "private.txt", []byte("do not show"))
+	handler := requireLogin(t, h, testUser{name: "alice", role: "uploader"}, testUser{name: "bob", role: "read-only"})
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	create := func(user string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/mkdrop", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.SetBasicAuth(user, "secret")
+		return serve(req)
+	}
+
+	// Another site can make the browser post the form, but not with the token
+	crossSite := httptest.NewRequest("POST", "/mkdrop", strings.NewReader("dir=vendor"))
+	crossSite.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	crossSite.SetBasicAuth("alice", "secret")
+	if w := serve(crossSite); w.Code != http.StatusForbidden {
+		t.Errorf("creating a file request from another site = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	if w := create("bob", url.Values{"dir": {"vendor"}}); w.Code != http.StatusForbidden {
+		t.Errorf("creating a file request without upload permission = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := create("alice", url.Values{"dir": {"vendor"}, "size": {"lots"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("creating a file request with a bad size = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w := create("alice", url.Values{"dir": {"vendor"}, "expires": {"24h"}, "max": {"2"}, "size": {"1K"}})
+	var link dropResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("creating a file request = %d %s", w.Code, w.Body.String())
+	}
+	if link.Dir != "vendor" || link.MaxFiles != 2 || link.MaxSize != 1024 {
+		t.Errorf("created file request = %+v", link)
+	}
+	target := strings.TrimPrefix(link.URL, "http://localhost:8080")
+
+	// The page works without credentials and shows nothing of the store
+	w = serve(httptest.NewRequest("GET", target, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "form") ||
+		strings.Contains(w.Body.String(), "secret-plan") || strings.Contains(w.Body.String(), "private") {
+		t.Errorf("GET of the file request = %d %q", w.Code, w.Body.String())
+	}
+
+	// The folder field of the form cannot move files elsewhere
+	w = serve(dropUpload(t, target, "..", "app.log", "started"))
+	if w.Code != http.StatusOK || !isStored(store, "vendor/app.log") {
+		t.Fatalf("upload = %d %q", w.Code, w.Body.String())
+	}
+
+	// Failures do not name the folder, and do not use up the request
+	req := dropUpload(t, target, "", "secret-plan.txt", "overwrite", "huge.log", strings.Repeat("x", 2048))
+	req.Header.Set("Accept", "application/json")
+	w = serve(req)
+	if w.Code != http.StatusConflict || strings.Contains(w.Body.String(), "vendor") {
+		t.Errorf("failed upload = %d %q", w.Code, w.Body.String())
+	}
+	req = dropUpload(t, target, "", "damaged.log", "hello")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Checksum-Sha256", helloWorldSHA256)
+	if w := serve(req); w.Code != http.StatusBadRequest || isStored(store, "vendor/damaged.log") {
+		t.Errorf("upload with a wrong checksum = %d %q, want %d", w.Code, w.Body.String(), http.StatusBadRequest)
+	}
+
+	w = serve(dropUpload(t, target, "", "crash.log", "boom"))
+	if w.Code != http.StatusOK || !isStored(store, "vendor/crash.log") || strings.Contains(w.Body.String(), " form") {
+		t.Errorf("last upload = %d %q, want the form gone", w.Code, w.Body.String())
+	}
+	req = dropUpload(t, target, "", "extra.log", "x")
+	req.Header.Set("Accept", "application/json")
+	if w := serve(req); w.Code != http.StatusGone || isStored(store, "vendor/extra.log") {
+		t.Errorf("upload beyond the cap = %d %q, want %d", w.Code, w.Body.String(), http.StatusGone)
+	}
+
+	if w := serve(httptest.NewRequest("GET", "/drop/"+strings.Repeat("0", 32), nil)); w.Code != http.StatusNotFound {
+		t.Errorf("GET of an unknown file request = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	// Drop links only open the drop page, not the rest of the server
+	if w := serve(httptest.NewRequest("GET", "/files?dir=vendor", nil)); w.Code == http.StatusOK {
+		t.Errorf("GET of the file list without credentials = %d", w.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expiresHeader lets uploaders choose how long their file is kept, like the
+// "expires" form field and query parameter
+const expiresHeader = "X-Expires-In"
+
+// errInvalidExpiry is returned for lifetimes asked for that cannot be parsed
+var errInvalidExpiry = errors.New("invalid expiry")
+
+// askedTTL returns how long a request asks to keep the file it uploads, from its
+// query string or header, 0 for the server default
+func askedTTL(r *http.Request) (time.Duration, error) {
+	if expires := r.URL.Query().Get("expires"); expires != "" {
+		return parseTTL(expires)
+	}
+	return parseTTL(r.Header.Get(expiresHeader))
+}
+
+// parseTTL parses a lifetime given as a duration like "24h" or a number of
+// seconds. An empty value is 0, for the server default. Lifetimes too long for a
+// time.Duration, some 290 years, are invalid rather than wrapped around.
+func parseTTL(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if seconds, convErr := strconv.ParseInt(value, 10, 64); convErr == nil {
+		ttl, err = time.Duration(seconds)*time.Second, nil
+		if seconds > int64(math.MaxInt64/time.Second) {
+			err = errInvalidExpiry
+		}
+	}
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("%w: '%s', expected a duration like 24h or a number of seconds", errInvalidExpiry, value)
+	}
+	return ttl, nil
+}
+
+// formatExpiry formats when a file expires for responses, "" if it is kept
+// until it is deleted
+func formatExpiry(expires time.Time) string {
+	if expires.IsZero() {
+		return ""
+	}
+	return expires.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fsrv/internal/config"
+)
+
+// listedExpiries returns the remaining lifetimes the root folder lists by filename
+func listedExpiries(t *testing.T, h *Handler) map[string]string {
+	t.Helper()
+	files, err := h.svc.ListDir("")
+	if err != nil {
+		t.Fatalf("ListDir() error = %v", err)
+	}
+	expiries := make(map[string]string)
+	for _, file := range files {
+		expiries[file.Filename] = file.Expires
+	}
+	return expiries
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"24h", 24 * time.Hour, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"90", 90 * time.Second, false},
+		{" 2h ", 2 * time.Hour, false},
+		{"0", 0, true},
+		{"-1h", 0, true},
+		{"tomorrow", 0, true},
+		{"9223372036", 9223372036 * time.Second, false},
+		{"9223372037", 0, true},
+		{"9223372036854775807", 0, true},
+		{"99999999999999999999", 0, true},
+		{"9999999999h", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTTL(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTTL(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHandler_Expiry(t *testing.T) {
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, func(cfg *config.Config) { cfg.FileMaxTTL = 48 * time.Hour })
+
+	// The lifetime asked for with PUT is reported, and clamped to the maximum
+	req := httptest.NewRequest("PUT", "/files/a.txt", strings.NewReader("hello"))
+	req.Header.Set(expiresHeader, "1h")
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.FileResource(w, req)
+	var result UploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("PUT with an expiry = %d %s", w.Code, w.Body.String())
+	}
+	if expires, err := time.Parse(time.RFC3339, result.Expires); err != nil || time.Until(expires) > time.Hour {
+		t.Errorf("PUT expires = %q, want in an hour", result.Expires)
+	}
+
+	req = httptest.NewRequest("PUT", "/files/b.txt?expires=720h", strings.NewReader("hello"))
+	w = httptest.NewRecorder()
+	h.FileResource(w, req)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), "Expires: ") {
+		t.Errorf("PUT beyond the maximum = %d %q, want it to expire", w.Code, w.Body.String())
+	}
+	if got := listedExpiries(t, h)["b.txt"]; got != "1d 23h" {
+		t.Errorf("listed expiry beyond the maximum = %q, want %q", got, "1d 23h")
+	}
+
+	req = httptest.NewRequest("PUT", "/files/c.txt", strings.NewReader("hello"))
+	req.Header.Set(expiresHeader, "soon")
+	w = httptest.NewRecorder()
+	h.FileResource(w, req)
+	if w.Code != http.StatusBadRequest || isStored(store, "c.txt") {
+		t.Errorf("PUT with an invalid expiry = %d, want %d and nothing stored", w.Code, http.StatusBadRequest)
+	}
+
+	// The form field applies to the files after it
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("expires", "30m")
+	part, _ := writer.CreateFormFile("file", "d.txt")
+	part.Write([]byte("hello"))
+	writer.WriteField("expires", "never")
+	part, _ = writer.CreateFormFile("file", "e.txt")
+	part.Write([]byte("hello"))
+	writer.Close()
+	req = httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	h.UploadFile(w, req)
+	var report uploadReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || len(report.Files) != 2 {
+		t.Fatalf("form upload = %d %s", w.Code, w.Body.String())
+	}
+	if report.Files[0].Expires == "" || report.Files[0].Error != "" {
+		t.Errorf("form upload result = %+v, want it to expire", report.Files[0])
+	}
+	if !strings.Contains(report.Files[1].Error, "invalid expiry") || isStored(store, "e.txt") {
+		t.Errorf("form upload with an invalid expiry = %+v, want it refused", report.Files[1])
+	}
+	if got := listedExpiries(t, h)["d.txt"]; got != "29m" {
+		t.Errorf("listed expiry of the form upload = %q, want %q", got, "29m")
+	}
+
+	// Resumable uploads take it from their metadata
+	req = tusRequest("POST", "/tus/", "")
+	req.Header.Set("Upload-Length", "5")
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("f.txt"))+
+		",expires "+base64.StdEncoding.EncodeToString([]byte("2h")))
+	w = httptest.NewRecorder()
+	h.TusUpload(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	req = tusRequest("PATCH", w.Header().Get("Location"), "hello")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	w = httptest.NewRecorder()
+	h.TusUpload(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("PATCH status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	if got := listedExpiries(t, h)["f.txt"]; got != "1h 59m" {
+		t.Errorf("listed expiry of the resumable upload = %q, want %q", got, "1h 59m")
+	}
+}
@@ -1,12 +1,20 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"path"
+	"strings"
 
+	"fsrv/internal/auth"
 	"fsrv/internal/service"
 	"fsrv/internal/util"
 )
@@ -21,14 +29,69 @@ type PageParam struct {
 	Param4  string
 	Param5  string
 	Files   []service.File
+	Trash   []service.TrashEntry
+	History []service.FileVersion
 	Empty   bool
 	DelAble bool
+	Results []UploadResult
+	User    string // logged in user, "" without authentication
+	CSRF    string // token for the forms of the page
+
+	// CanUpload is set if the user may upload to and create folders in the folder shown
+	CanUpload bool
+	// CanPurge is set if the user may delete the trash entries shown for good
+	CanPurge bool
+
+	// Usage describes how much of the quotas is used up, one line per quota
+	Usage []string
+	// Space describes the space on the disks uploads are written to, one line per disk
+	Space []string
+	// Lifetime is how long uploads are kept by default and MaxLifetime how long
+	// at most, "" if they are kept until they are deleted
+	Lifetime    string
+	MaxLifetime string
+
+	// Versioned is set if files have a history; CanRestore if the user may
+	// restore the versions shown
+	Versioned  bool
+	CanRestore bool
+
+	// Folder navigation, used when subdirectories are enabled
+	SubDirs     bool
+	Dir         string
+	Breadcrumbs []Breadcrumb
+}
+
+// UploadResult reports the outcome of uploading a single file
+type UploadResult struct {
+	Filename string `json:"filename"`
+	Stored   string `json:"stored,omitempty"` // name the file was stored under
+	Policy   string `json:"policy,omitempty"` // conflict policy applied
+	Replaced bool   `json:"replaced,omitempty"`
+	Renamed  bool   `json:"renamed,omitempty"`
+	Size     int64  `json:"size"`
+	SizeText string `json:"sizeText,omitempty"`
+	Expires  string `json:"expires,omitempty"` // when the file is deleted, RFC 3339
+	Error    string `json:"error,omitempty"`
+
+	status int // HTTP status describing the outcome
+}
+
+// uploadReport is the JSON response of a multi-file upload
+type uploadReport struct {
+	Uploaded int            `json:"uploaded"`
+	Failed   int            `json:"failed"`
+	Time     string         `json:"time"`
+	Files    []UploadResult `json:"files"`
+	Error    string         `json:"error,omitempty"`
 }
 
 // Handler handles HTTP requests
 type Handler struct {
 	svc       *service.Service
 	templates *template.Template
+	davLocks  *davLockTable
+	auth      *auth.Authenticator // nil without authentication
 }
 
 // New creates a new HTTP handler
@@ -41,6 +104,7 @@ func New(svc *service.Service, templateFS fs.FS) (*Handler, error) {
 	return &Handler{
 		svc:       svc,
 		templates: templates,
+		davLocks:  newDAVLockTable(),
 	}, nil
 }
 
@@ -70,6 +134,35 @@ func (h *Handler) checkMethod(w http.ResponseWriter, r *http.Request, method str
 	return true
 }
 
+// statusFor maps errors from the service layer to HTTP status codes
+func statusFor(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, service.ErrFileNotExist), errors.Is(err, service.ErrUploadNotFound),
+		errors.Is(err, service.ErrDirNotExist), errors.Is(err, service.ErrDropNotFound),
+		errors.Is(err, service.ErrTrashNotFound), errors.Is(err, service.ErrVersionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrFileExists), errors.Is(err, service.ErrFileBusy),
+		errors.Is(err, service.ErrOffsetMismatch), errors.Is(err, service.ErrDirNotEmpty):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrSubDirsDisabled), errors.Is(err, service.ErrDeleteDisabled),
+		errors.Is(err, errForbidden), errors.Is(err, errCSRF), errors.Is(err, service.ErrShareInvalid):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrShareExpired), errors.Is(err, service.ErrShareExhausted),
+		errors.Is(err, service.ErrDropExpired), errors.Is(err, service.ErrDropFull):
+		return http.StatusGone
+	case errors.Is(err, service.ErrFileTooLarge), errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrQuotaExceeded), errors.Is(err, service.ErrInsufficientStorage):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, service.ErrInvalidFilename), errors.Is(err, service.ErrInvalidConflictPolicy),
+		errors.Is(err, service.ErrChecksumMismatch), errors.Is(err, errInvalidChecksum), errors.Is(err, errInvalidExpiry):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // UploadPage renders the upload page
 func (h *Handler) UploadPage(w http.ResponseWriter, r *http.Request) {
 	if !h.checkMethod(w, r, "GET") {
@@ -78,84 +171,450 @@ func (h *Handler) UploadPage(w http.ResponseWriter, r *http.Request) {
 
 	hostname, port, maxSize := h.svc.GetServerInfo()
 	param := &PageParam{
-		Title:  "FSrv Upload",
-		Param1: hostname,
-		Param2: port,
-		Param3: maxSize,
+		Title:   "FSrv Upload",
+		Param1:  hostname,
+		Param2:  port,
+		Param3:  maxSize,
+		Param4:  string(h.svc.ConflictPolicy()),
+		SubDirs: h.svc.IsSubDirsEnabled(),
+		User:    currentUser(r),
+		CSRF:    csrfToken(w, r),
+	}
+	if param.SubDirs {
+		dir := r.URL.Query().Get("dir")
+		if p := auth.FromContext(r.Context()); dir == "" && p != nil {
+			// Start in the folder the role of the user is limited to
+			dir = p.Dir
+		}
+		crumbs := breadcrumbs(dir)
+		param.Dir = crumbs[len(crumbs)-1].Path
+		param.Breadcrumbs = crumbs
+	}
+	if err := h.authorize(r, auth.PermUpload, param.Dir); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
+	if usage, err := h.svc.Usage(param.User); err != nil {
+		log.Printf("Failed to count usage: %v", err)
+	} else {
+		param.Usage = usageLines(usage)
+	}
+	param.Space = h.spaceLines(r)
+	if ttl := h.svc.FileTTL(0); ttl > 0 {
+		param.Lifetime = util.HumanReadableDuration(ttl)
+	}
+	if ttl := h.svc.FileMaxTTL(); ttl > 0 {
+		param.MaxLifetime = util.HumanReadableDuration(ttl)
 	}
 	h.renderTemplate(w, "upload.html", param)
 }
 
-// UploadFile handles file upload
+// usageLines describes the usage of every quota that is set
+func usageLines(usage service.Usage) []string {
+	var lines []string
+	if usage.MaxBytes > 0 {
+		lines = append(lines, fmt.Sprintf("%s of %s used", util.HumanReadableSize(usage.Bytes), util.HumanReadableSize(usage.MaxBytes)))
+	}
+	if usage.MaxFiles > 0 {
+		lines = append(lines, fmt.Sprintf("%d of %d files", usage.Files, usage.MaxFiles))
+	}
+	if usage.MaxUserBytes > 0 && usage.User != "" {
+		lines = append(lines, fmt.Sprintf("%s of your %s", util.HumanReadableSize(usage.UserBytes), util.HumanReadableSize(usage.MaxUserBytes)))
+	}
+	return lines
+}
+
+// UploadFile handles multipart file uploads.
+//
+// The body is read with a streaming multipart reader, so every file part goes
+// straight into the service instead of being spooled to a temporary file first.
+// Any number of file parts may be sent in one request, e.g. several files or a
+// whole folder; the size limit applies to each file.
+//
+// A file whose name is taken is handled by the conflict policy from the
+// "conflict" field sent before the files, the query string or the
+// X-Conflict-Policy header, falling back to the server default.
+//
+// A file whose checksum is declared, in the headers of its part, a "sha256"
+// field sent right before it or the X-Checksum-Sha256 header of the request, is
+// verified while it is received and discarded if it does not match.
+//
+// Files are kept for the lifetime from the "expires" field sent before them,
+// the query string or the X-Expires-In header, like "24h" or a number of
+// seconds, falling back to the server default.
+//
+// Browsers must send the form token as the first field, so that a form posted
+// by another site is refused before any of its files is read; see checkCSRF for
+// the requests that are exempt.
+//
+// The outcome of every file is reported on the info page, or as JSON when the
+// client asks for it with "Accept: application/json".
 func (h *Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	if !h.checkMethod(w, r, "POST") {
 		return
 	}
 
-	// Limit file size
-	r.Body = http.MaxBytesReader(w, r.Body, h.svc.GetMaxUploadSize())
+	// The size of the whole request is all that is known before the files arrive
+	if err := h.svc.CheckDiskSpace(r.ContentLength); err != nil {
+		h.refuseUpload(w, r, err)
+		return
+	}
+	if r.ContentLength > 0 {
+		if err := h.svc.CheckUploadQuota(currentUser(r), r.ContentLength); err != nil {
+			h.refuseUpload(w, r, err)
+			return
+		}
+	}
 
-	file, header, err := r.FormFile("file")
+	reader, err := r.MultipartReader()
 	if err != nil {
-		h.renderInfo(w, "No file selected for upload or file is too large")
 		log.Printf("Failed to upload file: %v", err)
+		h.renderUploadReport(w, r, nil, "No file selected for upload or file is too large")
 		return
 	}
-	defer file.Close()
 
-	size, err := h.svc.UploadFile(header.Filename, file)
-	if err != nil {
-		h.renderInfo(w, "File upload failed!", err.Error())
-		log.Printf("Failed to upload file: %v", err)
+	// The target folder comes from the query string or a "dir" field sent before the files,
+	// and so does the conflict policy
+	dir := r.URL.Query().Get("dir")
+	conflict := askedConflictPolicy(r)
+	ttl, ttlErr := askedTTL(r)
+	// A "sha256" field only declares the checksum of the file that follows it
+	checksum := ""
+	tokenChecked := csrfExempt(r)
+
+	var results []UploadResult
+	var readErr string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to read multipart upload: %v", err)
+			readErr = fmt.Sprintf("Failed to read upload: %v", err)
+			break
+		}
+
+		if !tokenChecked {
+			token := ""
+			if part.FileName() == "" && part.FormName() == csrfField {
+				token = readFormValue(part)
+			}
+			part.Close()
+			if err := matchCSRF(r, token); err != nil {
+				h.refuseUpload(w, r, err)
+				return
+			}
+			tokenChecked = true
+			continue
+		}
+
+		// Skip plain form fields and empty file inputs
+		if part.FileName() == "" {
+			switch part.FormName() {
+			case "dir":
+				dir = readFormValue(part)
+			case "conflict":
+				if value := readFormValue(part); value != "" {
+					conflict = value
+				}
+			case checksumField:
+				checksum = readFormValue(part)
+			case "expires":
+				if value := readFormValue(part); value != "" {
+					ttl, ttlErr = parseTTL(value)
+				}
+			}
+			part.Close()
+			continue
+		}
+
+		filename := partFileName(part)
+		if dir != "" {
+			filename = path.Join(dir, filename)
+		}
+		var stored service.StoredFile
+		var policy service.ConflictPolicy
+		err = ttlErr
+		if err == nil {
+			err = h.authorize(r, auth.PermUpload, filename)
+		}
+		if err == nil {
+			var want service.Checksums
+			want, err = partChecksums(r, part, checksum)
+			if err == nil {
+				stored, policy, err = h.storeUpload(r, filename, conflict, ttl, service.VerifyChecksums(part, want))
+			}
+		}
+		checksum = ""
+		part.Close()
+		if err != nil {
+			log.Printf("Failed to upload file: %v", err)
+			results = append(results, UploadResult{Filename: filename, Policy: string(policy), Error: err.Error(), status: statusFor(err)})
+			continue
+		}
+
+		log.Printf("Uploaded file successfully: %s", stored.Name)
+		results = append(results, uploadResult(filename, stored, policy))
+	}
+
+	h.renderUploadReport(w, r, results, readErr)
+}
+
+// refuseUpload reports an error that refuses a multipart upload as a whole,
+// before any of its files is stored
+func (h *Handler) refuseUpload(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("Failed to upload file: %v", err)
+	report, _, msgs := summarizeUploads(nil, err.Error())
+	if wantsJSON(r) {
+		writeJSON(w, statusFor(err), report)
 		return
 	}
+	w.WriteHeader(statusFor(err))
+	h.renderInfo(w, msgs...)
+}
+
+// uploadResult describes an upload that was stored
+func uploadResult(filename string, stored service.StoredFile, policy service.ConflictPolicy) UploadResult {
+	status := http.StatusCreated
+	if stored.Replaced {
+		status = http.StatusOK
+	}
+	return UploadResult{
+		Filename: filename,
+		Stored:   stored.Name,
+		Policy:   string(policy),
+		Replaced: stored.Replaced,
+		Renamed:  stored.Renamed,
+		Size:     stored.Size,
+		SizeText: util.HumanReadableSize(stored.Size),
+		Expires:  formatExpiry(stored.Expires),
+		status:   status,
+	}
+}
+
+// renderUploadReport reports the results of a multi-file upload as an info page or JSON.
+// problem, if not empty, describes an error that is not tied to a single file.
+func (h *Handler) renderUploadReport(w http.ResponseWriter, r *http.Request, results []UploadResult, problem string) {
+	report, status, msgs := summarizeUploads(results, problem)
+	if wantsJSON(r) {
+		writeJSON(w, status, report)
+		return
+	}
+
+	param := &PageParam{
+		Title:   "FSrv Info",
+		Msgs:    msgs,
+		Results: results,
+	}
+	h.renderTemplate(w, "info.html", param)
+}
+
+// summarizeUploads counts the results of a multi-file upload, returning the JSON
+// report along with its status code and the messages of the HTML page
+func summarizeUploads(results []UploadResult, problem string) (uploadReport, int, []string) {
+	report := uploadReport{Time: service.GetCurrentTime(), Files: results}
+	for _, result := range results {
+		if result.Error == "" {
+			report.Uploaded++
+		} else {
+			report.Failed++
+		}
+	}
+	if len(results) == 0 && problem == "" {
+		problem = "No file selected for upload or file is too large"
+	}
+	report.Error = problem
 
-	humanSize := util.HumanReadableSize(size)
-	currentTime := service.GetCurrentTime()
-	h.renderInfo(w,
-		"Uploaded file successfully!",
-		fmt.Sprintf("Uploaded file: %s", header.Filename),
-		fmt.Sprintf("Size: %s", humanSize),
-		fmt.Sprintf("Time: %s", currentTime))
+	status := http.StatusOK
+	switch {
+	case report.Failed == 0 && problem == "":
+	case report.Uploaded > 0:
+		status = http.StatusMultiStatus
+	case report.Failed > 0:
+		status = results[0].status
+	default:
+		status = http.StatusBadRequest
+	}
+
+	var msgs []string
+	switch {
+	case report.Uploaded == 0 && report.Failed == 0:
+		msgs = []string{problem}
+	case report.Failed == 0 && problem == "":
+		msgs = []string{"Uploaded file successfully!"}
+		if len(results) == 1 {
+			msgs = append(msgs,
+				fmt.Sprintf("Uploaded file: %s", results[0].Stored),
+				fmt.Sprintf("Size: %s", results[0].SizeText))
+			switch {
+			case results[0].Replaced:
+				msgs = append(msgs, "Replaced the existing file")
+			case results[0].Renamed:
+				msgs = append(msgs, fmt.Sprintf("Renamed from %s, the name was taken", results[0].Filename))
+			}
+			if results[0].Expires != "" {
+				msgs = append(msgs, fmt.Sprintf("Expires: %s", results[0].Expires))
+			}
+		} else {
+			msgs = append(msgs, fmt.Sprintf("Uploaded %d files", report.Uploaded))
+		}
+		msgs = append(msgs, fmt.Sprintf("Time: %s", report.Time))
+	default:
+		msgs = []string{"File upload failed!",
+			fmt.Sprintf("Uploaded %d of %d files", report.Uploaded, report.Uploaded+report.Failed)}
+		if problem != "" {
+			msgs = append(msgs, problem)
+		}
+	}
+	return report, status, msgs
 }
 
-// ListFiles renders the file list page
+// partFileName returns the filename of a multipart part including the relative path
+// that browsers send for folder uploads, which multipart.Part.FileName strips.
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	return part.FileName()
+}
+
+// readFormValue reads a small plain form field from a multipart part
+func readFormValue(part *multipart.Part) string {
+	value, _ := io.ReadAll(io.LimitReader(part, 4096))
+	return string(value)
+}
+
+// wantsJSON reports whether the client prefers a JSON response
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") || r.URL.Query().Get("format") == "json"
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+// ListFiles renders the file list page. With subdirectory support the
+// "dir" query parameter selects the folder to show.
 func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
 	if !h.checkMethod(w, r, "GET") {
 		return
 	}
 
-	files, err := h.svc.ListFiles()
+	dir := r.URL.Query().Get("dir")
+	if err := h.authorize(r, auth.PermList, dir); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
+	files, err := h.svc.ListDir(dir)
 	if err != nil {
 		h.renderInfo(w, fmt.Sprintf("Failed to list files: %v", err))
 		return
 	}
+	files = visibleFiles(r, files)
 
+	crumbs := breadcrumbs(dir)
 	param := &PageParam{
-		Title:   "FSrv Files",
-		Files:   files,
-		Empty:   len(files) == 0,
-		DelAble: h.svc.IsDeleteEnabled(),
+		Title:       "FSrv Files",
+		Files:       files,
+		Empty:       len(files) == 0,
+		DelAble:     h.svc.IsDeleteEnabled() && h.authorize(r, auth.PermDelete, dir) == nil,
+		CanUpload:   h.authorize(r, auth.PermUpload, dir) == nil,
+		Versioned:   h.svc.IsVersioningEnabled(),
+		SubDirs:     h.svc.IsSubDirsEnabled(),
+		Dir:         crumbs[len(crumbs)-1].Path,
+		Breadcrumbs: crumbs,
+		User:        currentUser(r),
+		CSRF:        csrfToken(w, r),
+		Space:       h.spaceLines(r),
 	}
 	h.renderTemplate(w, "files.html", param)
 }
 
-// DeleteFile handles file deletion
+// DeleteFile handles file deletion from the web interface.
+//
+//	GET  /del?file=<name>   asks for confirmation, without changing anything
+//	POST /del               file=<name>&csrf=<form token>, deletes the file
+//
+// Scripts use DELETE /files/<name> instead, which needs no form token.
 func (h *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
-	if !h.checkMethod(w, r, "GET") {
+	switch r.Method {
+	case http.MethodGet:
+		h.confirmDelete(w, r)
+		return
+	case http.MethodPost:
+	default:
+		h.renderInfo(w, "HTTP Method should be 'GET' or 'POST'")
 		return
 	}
 
-	filename := r.URL.Query().Get("file")
+	if err := checkCSRF(r); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
+	filename := r.PostFormValue("file")
+	if err := h.authorize(r, auth.PermDelete, filename); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
 	if err := h.svc.DeleteFile(filename); err != nil {
 		h.renderInfo(w, err.Error())
 		return
 	}
 
+	if h.svc.IsTrashEnabled() {
+		log.Printf("Moved file to the trash: %s (%s)", filename, currentUser(r))
+		h.renderInfo(w, fmt.Sprintf("Moved file to the trash: '%s'", filename))
+		return
+	}
 	log.Printf("Deleted file successfully: %s", filename)
 	h.renderInfo(w, fmt.Sprintf("Deleted file successfully: '%s'", filename))
 }
 
+// confirmDelete renders the page that asks whether to delete a file
+func (h *Handler) confirmDelete(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get("file")
+	if !h.svc.IsDeleteEnabled() {
+		h.renderInfo(w, "Delete is disabled on this server")
+		return
+	}
+	if err := h.authorize(r, auth.PermDelete, filename); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
+	if _, err := h.svc.StatFile(filename); err != nil {
+		h.renderInfo(w, err.Error())
+		return
+	}
+
+	stored, _ := h.svc.ResolvePath(filename)
+	parent := path.Dir(stored)
+	if parent == "." {
+		parent = ""
+	}
+	question := fmt.Sprintf("Delete the file '%s'? This cannot be undone.", stored)
+	if h.svc.IsTrashEnabled() {
+		question = fmt.Sprintf("Move the file '%s' to the trash? It can be restored from there.", stored)
+	}
+	param := &PageParam{
+		Title:  "FSrv Delete",
+		Msgs:   []string{question},
+		Param1: "/del",
+		Param2: "file",
+		Param3: stored,
+		Param4: listURL(parent),
+		Param5: "Delete",
+		CSRF:   csrfToken(w, r),
+	}
+	h.renderTemplate(w, "confirm.html", param)
+}
+
 // DownloadFile handles file download
 func (h *Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 	if !h.checkMethod(w, r, "GET") {
@@ -163,6 +622,14 @@ func (h *Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filename := r.URL.Query().Get("file")
+	if err := h.authorize(r, auth.PermDownload, filename); err != nil {
+		h.renderForbidden(w, err)
+		return
+	}
+	if version := r.URL.Query().Get("version"); version != "" {
+		h.downloadVersion(w, r, filename, version)
+		return
+	}
 
 	// Open file safely using service
 	file, err := h.svc.OpenFile(filename)
@@ -179,24 +646,49 @@ func (h *Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.setChecksumHeaders(w, filename, fileInfo)
+
 	// Set response headers for file download
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", path.Base(filename)))
 	w.Header().Set("Content-Type", "application/octet-stream")
 
 	// Serve the file content
 	// Note: We use ServeContent instead of ServeFile because we already hold the open file handle.
 	// This ensures that we are serving the exact file we opened under the protection of the service lock.
-	http.ServeContent(w, r, filename, fileInfo.ModTime(), file)
+	http.ServeContent(w, r, filename, fileInfo.ModTime, file)
 
 	log.Printf("Downloaded file successfully: %s", filename)
 }
 
 // RegisterRoutes registers all HTTP routes
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	if h.auth != nil {
+		mux.HandleFunc(auth.LoginPath, h.Login)
+		mux.HandleFunc(auth.LogoutPath, h.Logout)
+	}
 	mux.HandleFunc("/toUpload", h.UploadPage)
 	mux.HandleFunc("/upload", h.UploadFile)
 	mux.HandleFunc("/files", h.ListFiles)
+	mux.HandleFunc(filesPrefix, h.FileResource)
 	mux.HandleFunc("/download", h.DownloadFile)
 	mux.HandleFunc("/del", h.DeleteFile)
+	mux.HandleFunc("/mkdir", h.MakeDir)
+	mux.HandleFunc("/rmdir", h.RemoveDir)
+	mux.HandleFunc("/mkshare", h.CreateShare)
+	mux.HandleFunc(sharePath, h.Share)
+	mux.HandleFunc("/mkdrop", h.CreateDrop)
+	mux.HandleFunc(dropPrefix, h.Drop)
+	mux.HandleFunc("/trash", h.Trash)
+	mux.HandleFunc("/trash/restore", h.RestoreTrash)
+	mux.HandleFunc("/trash/purge", h.PurgeTrash)
+	mux.HandleFunc("/versions", h.Versions)
+	mux.HandleFunc("/versions/restore", h.RestoreVersion)
+	mux.HandleFunc("/SHA256SUMS", h.SHA256Sums)
+	mux.HandleFunc("/status", h.Status)
+	mux.HandleFunc("/tus/", h.TusUpload)
+	mux.HandleFunc(davPrefix, h.WebDAV)
+	if h.svc.IsS3APIEnabled() {
+		mux.HandleFunc(s3Prefix, h.S3API)
+	}
 	mux.HandleFunc("/", h.ListFiles)
 }
@@ -2,96 +2,107 @@ package handler
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"html"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"path/filepath"
+	"net/textproto"
+	"net/url"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"fsrv/internal/config"
 	"fsrv/internal/service"
+	"fsrv/internal/storage"
 )
 
-func setupTestHandler(t testing.TB) (*Handler, string) {
-	// Create temporary directory for testing
-	tmpDir, err := os.MkdirTemp("", "fsrv-test-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-
-	// Create temporary templates directory
-	templateDir := filepath.Join(tmpDir, "templates")
-	if err := os.MkdirAll(templateDir, 0755); err != nil {
-		t.Fatalf("Failed to create templates dir: %v", err)
-	}
-
-	// Create test templates
-	templates := map[string]string{
-		"files.html":  `{{.Title}}`,
-		"info.html":   `{{.Title}}{{range .Msgs}}{{.}}{{end}}`,
-		"upload.html": `{{.Title}}`,
-	}
+// testTemplates are stub templates that render just enough to check responses
+var testTemplates = fstest.MapFS{
+	"confirm.html":  {Data: []byte(`{{.Title}}{{range .Msgs}}{{.}}{{end}} {{.Param2}}={{.Param3}} csrf={{.CSRF}}`)},
+	"drop.html":     {Data: []byte(`{{.Title}}{{range .Msgs}}{{.}}{{end}}{{range .Results}} {{.Filename}}{{.Error}}{{end}}{{if .Param1}} form{{end}}`)},
+	"files.html":    {Data: []byte(`{{.Title}}{{.User}}{{range .Files}} {{.Path}}{{end}}{{if .CanUpload}} can-upload{{end}}{{if .DelAble}} can-delete{{end}}`)},
+	"info.html":     {Data: []byte(`{{.Title}}{{range .Msgs}}{{.}}{{end}}{{range .Results}}{{.Filename}}{{.Error}}{{end}}`)},
+	"login.html":    {Data: []byte(`{{.Title}}{{range .Msgs}}{{.}}{{end}}`)},
+	"trash.html":    {Data: []byte(`{{.Title}}{{range .Msgs}}{{.}}{{end}}{{range .Trash}} {{.Name}}{{end}}`)},
+	"upload.html":   {Data: []byte(`{{.Title}}{{range .Usage}} {{.}}{{end}}{{range .Space}} {{.}}{{end}}`)},
+	"versions.html": {Data: []byte(`{{.Title}} {{.Param1}}{{range .History}} v{{.Number}}{{end}}{{if .CanRestore}} can-restore{{end}}`)},
+}
 
-	for name, content := range templates {
-		if err := os.WriteFile(filepath.Join(templateDir, name), []byte(content), 0644); err != nil {
-			t.Fatalf("Failed to create template %s: %v", name, err)
-		}
+// setupTestHandler creates a handler over in-memory storage, so tests never touch
+// the store on disk. Only resumable uploads use a temporary directory.
+func setupTestHandler(t testing.TB) (*Handler, *storage.Memory) {
+	store := storage.NewMemory()
+	h, err := New(newTestService(t, store, nil), testTemplates)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
 	}
+	return h, store
+}
 
+// newTestService creates a service over store; configure may adjust the defaults
+func newTestService(t testing.TB, store storage.Storage, configure func(*config.Config)) *service.Service {
 	cfg := &config.Config{
 		Port:     "8080",
 		DelAble:  true,
 		Hostname: "localhost",
-		Store:    tmpDir,
 		Max:      32,
+		Tmp:      t.TempDir(),
 	}
-
-	svc := service.New(cfg)
-	h, err := New(svc, os.DirFS(templateDir))
-	if err != nil {
-		t.Fatalf("Failed to create handler: %v", err)
+	if configure != nil {
+		configure(cfg)
 	}
+	return service.New(cfg, store)
+}
 
-	return h, tmpDir
+// withMax sets the max upload size to 1<<max bytes
+func withMax(max int64) func(*config.Config) {
+	return func(cfg *config.Config) { cfg.Max = max }
 }
 
-func cleanupTestHandler(t testing.TB, tmpDir string) {
-	if err := os.RemoveAll(tmpDir); err != nil {
-		t.Logf("Failed to cleanup temp dir: %v", err)
+// putStored stores content as name, failing the test on error
+func putStored(t testing.TB, store storage.Storage, name string, content []byte) {
+	if _, err := store.Put(name, bytes.NewReader(content)); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
 	}
 }
 
-func TestNew(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "fsrv-test-*")
+// readStored returns the content stored as name
+func readStored(store storage.Storage, name string) ([]byte, error) {
+	file, err := store.Open(name)
 	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
+		return nil, err
 	}
-	defer os.RemoveAll(tmpDir)
+	defer file.Close()
+	return io.ReadAll(file)
+}
 
-	// Create templates directory
-	templateDir := filepath.Join(tmpDir, "templates")
-	if err := os.MkdirAll(templateDir, 0755); err != nil {
-		t.Fatalf("Failed to create templates dir: %v", err)
-	}
+// isStored reports whether anything is stored as name
+func isStored(store storage.Storage, name string) bool {
+	_, err := store.Stat(name)
+	return err == nil
+}
 
-	// Create test template
-	if err := os.WriteFile(filepath.Join(templateDir, "files.html"), []byte("test"), 0644); err != nil {
-		t.Fatalf("Failed to create template: %v", err)
-	}
+// testCSRF is the form token of the browser in tests
+const testCSRF = "0123456789abcdef0123456789abcdef"
 
-	cfg := &config.Config{
-		Port:     "8080",
-		DelAble:  true,
-		Hostname: "localhost",
-		Store:    tmpDir,
-		Max:      32,
-	}
+// postForm builds a form submission from one of our pages, with a valid form token
+func postForm(target string, form url.Values) *http.Request {
+	form.Set(csrfField, testCSRF)
+	req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: testCSRF})
+	return req
+}
+
+func TestNew(t *testing.T) {
+	templates := fstest.MapFS{"files.html": {Data: []byte("test")}}
 
-	svc := service.New(cfg)
-	h, err := New(svc, os.DirFS(templateDir))
+	svc := newTestService(t, storage.NewMemory(), nil)
+	h, err := New(svc, templates)
 	if err != nil {
 		t.Errorf("New() error = %v", err)
 		return
@@ -111,8 +122,7 @@ func TestNew(t *testing.T) {
 }
 
 func TestHandler_UploadPage(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, _ := setupTestHandler(t)
 
 	req := httptest.NewRequest("GET", "/toUpload", nil)
 	w := httptest.NewRecorder()
@@ -130,8 +140,7 @@ func TestHandler_UploadPage(t *testing.T) {
 }
 
 func TestHandler_UploadPage_WrongMethod(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, _ := setupTestHandler(t)
 
 	req := httptest.NewRequest("POST", "/toUpload", nil)
 	w := httptest.NewRecorder()
@@ -149,8 +158,7 @@ func TestHandler_UploadPage_WrongMethod(t *testing.T) {
 }
 
 func TestHandler_UploadFile(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, store := setupTestHandler(t)
 
 	// Create multipart form
 	body := &bytes.Buffer{}
@@ -163,6 +171,7 @@ func TestHandler_UploadFile(t *testing.T) {
 	writer.Close()
 
 	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	w := httptest.NewRecorder()
 
@@ -178,15 +187,189 @@ func TestHandler_UploadFile(t *testing.T) {
 	}
 
 	// Verify file was created
-	filePath := filepath.Join(tmpDir, "test.txt")
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	if !isStored(store, "test.txt") {
 		t.Error("Uploaded file does not exist")
 	}
 }
 
+func TestHandler_UploadFile_MultipleParts(t *testing.T) {
+	h, store := setupTestHandler(t)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("comment", "plain fields are ignored")
+	files := map[string]string{"one.txt": "first file", "two.txt": "second file"}
+	for _, name := range []string{"one.txt", "two.txt"} {
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		part.Write([]byte(files[name]))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	h.UploadFile(w, req)
+
+	bodyStr := w.Body.String()
+	if !strings.Contains(bodyStr, "Uploaded file successfully") {
+		t.Errorf("UploadFile() response does not contain success message. Body: %q", bodyStr)
+	}
+
+	for name, content := range files {
+		data, err := readStored(store, name)
+		if err != nil {
+			t.Errorf("Uploaded file %s does not exist", name)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("Uploaded file %s content = %q, want %q", name, data, content)
+		}
+	}
+
+	// The body was streamed, not parsed into a spooled form
+	if req.MultipartForm != nil && len(req.MultipartForm.File) > 0 {
+		t.Error("UploadFile() should not parse the multipart form into memory or temp files")
+	}
+}
+
+func TestHandler_UploadFile_TooLarge(t *testing.T) {
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, withMax(4)) // 16 bytes
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "small.txt")
+	part.Write([]byte("small"))
+	part, _ = writer.CreateFormFile("file", "big.txt")
+	part.Write(bytes.Repeat([]byte("x"), 100))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	h.UploadFile(w, req)
+
+	bodyStr := w.Body.String()
+	if !strings.Contains(bodyStr, "File upload failed") || !strings.Contains(bodyStr, "too large") {
+		t.Errorf("UploadFile() response does not report the oversized file. Body: %q", bodyStr)
+	}
+	if !isStored(store, "small.txt") {
+		t.Error("File within the limit was not uploaded")
+	}
+	if isStored(store, "big.txt") {
+		t.Error("Oversized file was stored")
+	}
+}
+
+func TestHandler_UploadFile_JSONReport(t *testing.T) {
+	h, store := setupTestHandler(t)
+
+	putStored(t, store, "taken.txt", []byte("old"))
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, name := range []string{"new.txt", "taken.txt"} {
+		part, _ := writer.CreateFormFile("file", name)
+		part.Write([]byte("content"))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+
+	h.UploadFile(w, req)
+
+	if w.Code != http.StatusMultiStatus {
+		t.Errorf("UploadFile() status = %d, want %d", w.Code, http.StatusMultiStatus)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("UploadFile() Content-Type = %q, want application/json", ct)
+	}
+
+	var report uploadReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to decode JSON report: %v", err)
+	}
+	if report.Uploaded != 1 || report.Failed != 1 || len(report.Files) != 2 {
+		t.Fatalf("report = %+v, want 1 uploaded and 1 failed", report)
+	}
+	if report.Files[0].Filename != "new.txt" || report.Files[0].Error != "" || report.Files[0].Size != 7 {
+		t.Errorf("first result = %+v", report.Files[0])
+	}
+	if report.Files[1].Filename != "taken.txt" || !strings.Contains(report.Files[1].Error, "already exists") {
+		t.Errorf("second result = %+v", report.Files[1])
+	}
+}
+
+func TestHandler_UploadFile_JSONAllFailed(t *testing.T) {
+	h, store := setupTestHandler(t)
+
+	putStored(t, store, "taken.txt", []byte("old"))
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "taken.txt")
+	part.Write([]byte("content"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload?format=json", body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	h.UploadFile(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("UploadFile() status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestHandler_UploadFile_FolderPaths(t *testing.T) {
+	h, store := setupTestHandler(t)
+
+	// Browsers send the path relative to the chosen folder as the filename
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="file"; filename="logs/app/today.log"`)
+	part, _ := writer.CreatePart(header)
+	part.Write([]byte("log line"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+
+	h.UploadFile(w, req)
+
+	var report uploadReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to decode JSON report: %v", err)
+	}
+	if len(report.Files) != 1 || report.Files[0].Filename != "logs/app/today.log" {
+		t.Errorf("report = %+v, want the relative path to be reported", report)
+	}
+
+	// Without subdirectory support the file lands in the store root
+	if !isStored(store, "today.log") {
+		t.Error("Folder upload was not stored by its base name")
+	}
+}
+
 func TestHandler_UploadFile_NoFile(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, _ := setupTestHandler(t)
 
 	req := httptest.NewRequest("POST", "/upload", nil)
 	w := httptest.NewRecorder()
@@ -204,8 +387,7 @@ func TestHandler_UploadFile_NoFile(t *testing.T) {
 }
 
 func TestHandler_UploadFile_WrongMethod(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, _ := setupTestHandler(t)
 
 	req := httptest.NewRequest("GET", "/upload", nil)
 	w := httptest.NewRecorder()
@@ -223,16 +405,12 @@ func TestHandler_UploadFile_WrongMethod(t *testing.T) {
 }
 
 func TestHandler_ListFiles(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, store := setupTestHandler(t)
 
 	// Create test files
 	testFiles := []string{"file1.txt", "file2.txt"}
 	for _, filename := range testFiles {
-		path := filepath.Join(tmpDir, filename)
-		if err := os.WriteFile(path, []byte("test content"), 0644); err != nil {
-			t.Fatalf("Failed to create test file: %v", err)
-		}
+		putStored(t, store, filename, []byte("test content"))
 	}
 
 	req := httptest.NewRequest("GET", "/files", nil)
@@ -251,8 +429,7 @@ func TestHandler_ListFiles(t *testing.T) {
 }
 
 func TestHandler_ListFiles_Empty(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, _ := setupTestHandler(t)
 
 	req := httptest.NewRequest("GET", "/files", nil)
 	w := httptest.NewRecorder()
@@ -270,8 +447,7 @@ func TestHandler_ListFiles_Empty(t *testing.T) {
 }
 
 func TestHandler_ListFiles_WrongMethod(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, _ := setupTestHandler(t)
 
 	req := httptest.NewRequest("POST", "/files", nil)
 	w := httptest.NewRecorder()
@@ -289,17 +465,13 @@ func TestHandler_ListFiles_WrongMethod(t *testing.T) {
 }
 
 func TestHandler_DeleteFile(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, store := setupTestHandler(t)
 
 	// Create test file
 	filename := "test.txt"
-	path := filepath.Join(tmpDir, filename)
-	if err := os.WriteFile(path, []byte("test content"), 0644); err != nil {
-		t.Fatalf("Failed to create test file: %v", err)
-	}
+	putStored(t, store, filename, []byte("test content"))
 
-	req := httptest.NewRequest("GET", "/del?file="+filename, nil)
+	req := postForm("/del", url.Values{"file": {filename}})
 	w := httptest.NewRecorder()
 
 	h.DeleteFile(w, req)
@@ -314,14 +486,167 @@ func TestHandler_DeleteFile(t *testing.T) {
 	}
 
 	// Verify file was deleted
-	if _, err := os.Stat(path); !os.IsNotExist(err) {
+	if isStored(store, filename) {
 		t.Error("File was not deleted")
 	}
 }
 
+func TestHandler_DeleteFile_Confirm(t *testing.T) {
+	h, store := setupTestHandler(t)
+	putStored(t, store, "test.txt", []byte("test content"))
+
+	req := httptest.NewRequest("GET", "/del?file=test.txt", nil)
+	w := httptest.NewRecorder()
+
+	h.DeleteFile(w, req)
+
+	body := html.UnescapeString(w.Body.String())
+	if w.Code != http.StatusOK || !strings.Contains(body, "Delete the file 'test.txt'?") || !strings.Contains(body, "file=test.txt") {
+		t.Errorf("DeleteFile() confirmation = %d %q", w.Code, body)
+	}
+	if !isStored(store, "test.txt") {
+		t.Error("GET must not delete the file")
+	}
+
+	// The form of the confirmation page carries the token of the cookie it sets
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie || !strings.Contains(body, "csrf="+cookies[0].Value) {
+		t.Fatalf("DeleteFile() confirmation cookies = %v, body %q", cookies, body)
+	}
+	form := url.Values{"file": {"test.txt"}, csrfField: {cookies[0].Value}}
+	req = httptest.NewRequest("POST", "/del", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+
+	h.DeleteFile(w, req)
+
+	if isStored(store, "test.txt") {
+		t.Errorf("confirmed delete did not delete the file: %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHandler_DeleteFile_CSRF(t *testing.T) {
+	h, store := setupTestHandler(t)
+	putStored(t, store, "test.txt", []byte("test content"))
+
+	forged := []struct {
+		name   string
+		cookie string
+		field  string
+	}{
+		{"no token", "", ""},
+		{"no cookie", "", testCSRF},
+		{"no field", testCSRF, ""},
+		{"mismatch", testCSRF, "fedcba9876543210fedcba9876543210"},
+	}
+	for _, tt := range forged {
+		form := url.Values{"file": {"test.txt"}}
+		if tt.field != "" {
+			form.Set(csrfField, tt.field)
+		}
+		req := httptest.NewRequest("POST", "/del", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+		}
+		w := httptest.NewRecorder()
+
+		h.DeleteFile(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: DeleteFile() status = %d, want %d", tt.name, w.Code, http.StatusForbidden)
+		}
+	}
+	if !isStored(store, "test.txt") {
+		t.Error("a forged form deleted the file")
+	}
+}
+
+func TestHandler_UploadFile_CSRF(t *testing.T) {
+	h, store := setupTestHandler(t)
+	putStored(t, store, "taken.txt", []byte("old"))
+
+	upload := func(cookie string, fields ...string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for i := 0; i+1 < len(fields); i += 2 {
+			if fields[i] == "file" {
+				part, _ := writer.CreateFormFile("file", fields[i+1])
+				part.Write([]byte("forged"))
+				continue
+			}
+			writer.WriteField(fields[i], fields[i+1])
+		}
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: csrfCookie, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		h.UploadFile(w, req)
+		return w
+	}
+
+	// A form posted by another site has no token, or not before its files
+	forged := []struct {
+		name   string
+		cookie string
+		fields []string
+	}{
+		{"no token", "", []string{"conflict", "overwrite", "file", "taken.txt"}},
+		{"no cookie", "", []string{csrfField, testCSRF, "file", "taken.txt"}},
+		{"mismatch", testCSRF, []string{csrfField, "fedcba9876543210fedcba9876543210", "file", "taken.txt"}},
+		{"token after the file", testCSRF, []string{"file", "new.txt", csrfField, testCSRF}},
+	}
+	for _, tt := range forged {
+		if w := upload(tt.cookie, tt.fields...); w.Code != http.StatusForbidden {
+			t.Errorf("%s: UploadFile() status = %d, want %d", tt.name, w.Code, http.StatusForbidden)
+		}
+	}
+	if content, _ := readStored(store, "taken.txt"); string(content) != "old" || isStored(store, "new.txt") {
+		t.Error("a forged form stored a file")
+	}
+
+	// The upload page sends its token first
+	if w := upload(testCSRF, csrfField, testCSRF, "file", "new.txt"); w.Code != http.StatusOK || !isStored(store, "new.txt") {
+		t.Errorf("UploadFile() with the form token = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// TestHandler_GetDoesNotMutate requests every route that changes the store with
+// GET, as link prefetchers, crawlers and <img> tags on other sites would
+func TestHandler_GetDoesNotMutate(t *testing.T) {
+	h, store := setupSubDirHandler(t, true)
+	putStored(t, store, "test.txt", []byte("test content"))
+	if err := store.Mkdir("empty"); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	targets := []string{
+		"/del?file=test.txt",
+		"/del?file=test.txt&csrf=" + testCSRF,
+		"/rmdir?dir=empty",
+		"/mkdir?name=docs",
+		"/files/test.txt",
+		"/upload?dir=docs",
+	}
+	for _, target := range targets {
+		req := httptest.NewRequest("GET", target, nil)
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: testCSRF})
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if !isStored(store, "test.txt") || !isStored(store, "empty") || isStored(store, "docs") {
+		t.Error("a GET request changed the store")
+	}
+}
+
 func TestHandler_DeleteFile_NotExists(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, _ := setupTestHandler(t)
 
 	req := httptest.NewRequest("GET", "/del?file=nonexistent.txt", nil)
 	w := httptest.NewRecorder()
@@ -339,10 +664,10 @@ func TestHandler_DeleteFile_NotExists(t *testing.T) {
 }
 
 func TestHandler_DeleteFile_WrongMethod(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, store := setupTestHandler(t)
+	putStored(t, store, "test.txt", []byte("test content"))
 
-	req := httptest.NewRequest("POST", "/del?file=test.txt", nil)
+	req := httptest.NewRequest("PUT", "/del?file=test.txt", nil)
 	w := httptest.NewRecorder()
 
 	h.DeleteFile(w, req)
@@ -352,22 +677,21 @@ func TestHandler_DeleteFile_WrongMethod(t *testing.T) {
 	}
 
 	body := html.UnescapeString(w.Body.String())
-	if !strings.Contains(body, "HTTP Method should be 'GET'") {
+	if !strings.Contains(body, "HTTP Method should be 'GET' or 'POST'") {
 		t.Errorf("DeleteFile() response does not contain error message. Body: %q", body)
 	}
+	if !isStored(store, "test.txt") {
+		t.Error("PUT must not delete the file")
+	}
 }
 
 func TestHandler_DownloadFile(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, store := setupTestHandler(t)
 
 	// Create test file
 	filename := "test.txt"
-	path := filepath.Join(tmpDir, filename)
 	content := []byte("test content")
-	if err := os.WriteFile(path, content, 0644); err != nil {
-		t.Fatalf("Failed to create test file: %v", err)
-	}
+	putStored(t, store, filename, content)
 
 	req := httptest.NewRequest("GET", "/download?file="+filename, nil)
 	w := httptest.NewRecorder()
@@ -398,8 +722,7 @@ func TestHandler_DownloadFile(t *testing.T) {
 }
 
 func TestHandler_DownloadFile_NotExists(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, _ := setupTestHandler(t)
 
 	req := httptest.NewRequest("GET", "/download?file=nonexistent.txt", nil)
 	w := httptest.NewRecorder()
@@ -417,8 +740,7 @@ func TestHandler_DownloadFile_NotExists(t *testing.T) {
 }
 
 func TestHandler_DownloadFile_WrongMethod(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, _ := setupTestHandler(t)
 
 	req := httptest.NewRequest("POST", "/download?file=test.txt", nil)
 	w := httptest.NewRecorder()
@@ -436,8 +758,7 @@ func TestHandler_DownloadFile_WrongMethod(t *testing.T) {
 }
 
 func TestHandler_RegisterRoutes(t *testing.T) {
-	h, tmpDir := setupTestHandler(t)
-	defer cleanupTestHandler(t, tmpDir)
+	h, _ := setupTestHandler(t)
 
 	mux := http.NewServeMux()
 	h.RegisterRoutes(mux)
@@ -449,6 +770,9 @@ func TestHandler_RegisterRoutes(t *testing.T) {
 		"/files",
 		"/download",
 		"/del",
+		"/mkdir",
+		"/rmdir",
+		"/tus/",
 		"/",
 	}
 
@@ -467,8 +791,7 @@ func TestHandler_RegisterRoutes(t *testing.T) {
 
 // BenchmarkHandler_UploadFile benchmarks the UploadFile handler
 func BenchmarkHandler_UploadFile(b *testing.B) {
-	h, tmpDir := setupTestHandler(b)
-	defer cleanupTestHandler(b, tmpDir)
+	h, store := setupTestHandler(b)
 
 	content := bytes.Repeat([]byte("test"), 1024*1024) // 4MB
 
@@ -481,25 +804,24 @@ func BenchmarkHandler_UploadFile(b *testing.B) {
 		writer.Close()
 
 		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
 		req.Header.Set("Content-Type", writer.FormDataContentType())
 		w := httptest.NewRecorder()
 
 		h.UploadFile(w, req)
 
 		// Clean up uploaded file
-		os.Remove(filepath.Join(tmpDir, "test.txt"))
+		store.Delete("test.txt")
 	}
 }
 
 // BenchmarkHandler_ListFiles benchmarks the ListFiles handler
 func BenchmarkHandler_ListFiles(b *testing.B) {
-	h, tmpDir := setupTestHandler(b)
-	defer cleanupTestHandler(b, tmpDir)
+	h, store := setupTestHandler(b)
 
 	// Create test files
 	for i := 0; i < 100; i++ {
-		filename := filepath.Join(tmpDir, "file"+string(rune(i))+".txt")
-		os.WriteFile(filename, []byte("test content"), 0644)
+		store.Put(fmt.Sprintf("file%d.txt", i), strings.NewReader("test content"))
 	}
 
 	b.ResetTimer()
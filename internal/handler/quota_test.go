@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fsrv/internal/config"
+)
+
+// withQuota limits the store to bytes
+func withQuota(bytes int64) func(*config.Config) {
+	return func(cfg *config.Config) { cfg.QuotaBytes = bytes }
+}
+
+func TestHandler_Quota(t *testing.T) {
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, withQuota(10))
+	put := func(name string, body io.Reader) int {
+		req := httptest.NewRequest("PUT", "/files/"+name, body)
+		w := httptest.NewRecorder()
+		h.FileResource(w, req)
+		return w.Code
+	}
+
+	if code := put("a.txt", strings.NewReader("hello")); code != http.StatusCreated {
+		t.Fatalf("PUT within the quota = %d, want %d", code, http.StatusCreated)
+	}
+
+	// Known sizes are refused up front, unknown ones once they go beyond the quota
+	if code := put("b.txt", strings.NewReader("hello world")); code != http.StatusInsufficientStorage {
+		t.Errorf("PUT beyond the quota = %d, want %d", code, http.StatusInsufficientStorage)
+	}
+	if code := put("b.txt", io.MultiReader(strings.NewReader("hello world"))); code != http.StatusInsufficientStorage {
+		t.Errorf("PUT of unknown size beyond the quota = %d, want %d", code, http.StatusInsufficientStorage)
+	}
+	if isStored(store, "b.txt") {
+		t.Error("an upload beyond the quota was stored")
+	}
+
+	req := tusRequest("POST", "/tus/", "")
+	req.Header.Set("Upload-Length", "6")
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("c.txt")))
+	w := httptest.NewRecorder()
+	h.TusUpload(w, req)
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("resumable upload beyond the quota = %d, want %d", w.Code, http.StatusInsufficientStorage)
+	}
+
+	formUpload := func(contentLength int64) uploadReport {
+		t.Helper()
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "d.txt")
+		part.Write([]byte("hello world"))
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Accept", "application/json")
+		if contentLength != 0 {
+			req.ContentLength = contentLength
+		}
+		w := httptest.NewRecorder()
+		h.UploadFile(w, req)
+		var report uploadReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != http.StatusInsufficientStorage {
+			t.Fatalf("form upload beyond the quota = %d %s", w.Code, w.Body.String())
+		}
+		return report
+	}
+
+	// Forms of known size are refused before any file is read, others once a file goes beyond the quota
+	if report := formUpload(0); len(report.Files) != 0 || !strings.Contains(report.Error, "quota exceeded") {
+		t.Errorf("report of a form beyond the quota = %+v, want it refused as a whole", report)
+	}
+	if report := formUpload(-1); len(report.Files) != 1 || !strings.Contains(report.Files[0].Error, "quota exceeded") {
+		t.Errorf("report of a form of unknown size = %+v, want the quota named", report)
+	}
+
+	// The upload page shows the usage next to the size limit
+	w = httptest.NewRecorder()
+	h.UploadPage(w, httptest.NewRequest("GET", "/toUpload", nil))
+	if !strings.Contains(w.Body.String(), "5 B of 10 B used") {
+		t.Errorf("upload page = %q, want the usage", w.Body.String())
+	}
+}
+
+func TestHandler_Quota_User(t *testing.T) {
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, func(cfg *config.Config) { cfg.UserQuota = 8 })
+	handler := requireLogin(t, h, testUser{name: "alice"}, testUser{name: "bob"})
+	serve := func(method, target, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.SetBasicAuth(user, "secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("PUT", "/files/a.txt", "alice", "hello"); w.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body.String())
+	}
+	if w := serve("PUT", "/dav/b.txt", "alice", "hello"); w.Code != http.StatusInsufficientStorage {
+		t.Errorf("WebDAV PUT beyond the quota of the user = %d, want %d", w.Code, http.StatusInsufficientStorage)
+	}
+	if w := serve("PUT", "/files/b.txt", "bob", "hello"); w.Code != http.StatusCreated {
+		t.Errorf("PUT by another user = %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	if w := serve("GET", "/toUpload", "alice", ""); !strings.Contains(w.Body.String(), "5 B of your 8 B") {
+		t.Errorf("upload page = %q, want the usage of alice", w.Body.String())
+	}
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/service"
+)
+
+// filesPrefix is the URL prefix under which individual files are addressed as resources
+const filesPrefix = "/files/"
+
+// FileResource handles requests addressed to a single file, /files/<name>.
+//
+//	PUT    /files/<name>   upload the raw request body as <name>
+//	DELETE /files/<name>   delete <name>
+func (h *Handler) FileResource(w http.ResponseWriter, r *http.Request) {
+	filename := strings.TrimPrefix(r.URL.Path, filesPrefix)
+	if filename == "" {
+		h.ListFiles(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		h.putFile(w, r, filename)
+	case http.MethodDelete:
+		h.deleteFile(w, r, filename)
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// putFile streams the request body straight into the store, for `curl -T` and scripts.
+//
+// Responds 201 on create, 409 if the file exists, 412 if `If-None-Match: *` was given
+// and the file exists, 413 if the body exceeds the maximum upload size, 507 if it
+// does not fit in a quota and 400 if it does not match the checksums declared in
+// its headers, see declaredChecksums. The
+// conflict policy comes from the "conflict" query parameter or X-Conflict-Policy
+// header: overwriting answers 200, renaming 201 with the new name in Location.
+// `If-None-Match: *` always fails. The result is JSON if the client asks for it.
+func (h *Handler) putFile(w http.ResponseWriter, r *http.Request, filename string) {
+	if err := h.authorize(r, auth.PermUpload, filename); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	maxSize := h.svc.GetMaxUploadSize()
+	if r.ContentLength > maxSize {
+		http.Error(w, fmt.Sprintf("File is too large, max upload file size is %s", h.svc.GetMaxUploadSizeHuman()),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := h.svc.CheckQuota(filename, currentUser(r), r.ContentLength); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	if err := h.svc.CheckDiskSpace(r.ContentLength); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	// Limit file size for bodies without Content-Length
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+
+	want, err := declaredChecksums(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	ttl, err := askedTTL(r)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	asked := askedConflictPolicy(r)
+	createOnly := r.Header.Get("If-None-Match") == "*"
+	if createOnly {
+		asked = string(service.ConflictFail)
+	}
+	stored, policy, err := h.storeUpload(r, filename, asked, ttl, service.VerifyChecksums(r.Body, want))
+	if err != nil {
+		log.Printf("Failed to upload file: %v", err)
+		status := statusFor(err)
+		if errors.Is(err, service.ErrFileExists) && createOnly {
+			status = http.StatusPreconditionFailed
+		}
+		if wantsJSON(r) {
+			writeJSON(w, status, UploadResult{Filename: filename, Policy: string(policy), Error: err.Error()})
+			return
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	log.Printf("Uploaded file successfully: %s", stored.Name)
+
+	result := uploadResult(filename, stored, policy)
+	w.Header().Set("Location", "/download?file="+url.QueryEscape(stored.Name))
+	if wantsJSON(r) {
+		writeJSON(w, result.status, result)
+		return
+	}
+	w.WriteHeader(result.status)
+	switch {
+	case stored.Replaced:
+		fmt.Fprintf(w, "Uploaded file successfully: %s (%s, replaced the existing file)\n", stored.Name, result.SizeText)
+	case stored.Renamed:
+		fmt.Fprintf(w, "Uploaded file successfully: %s (%s, renamed, the name was taken)\n", stored.Name, result.SizeText)
+	default:
+		fmt.Fprintf(w, "Uploaded file successfully: %s (%s)\n", stored.Name, result.SizeText)
+	}
+	if result.Expires != "" {
+		fmt.Fprintf(w, "Expires: %s\n", result.Expires)
+	}
+}
+
+// deleteFile deletes a file for scripts, if delete is enabled.
+//
+// Responds 204 when the file was deleted, 403 if delete is disabled and 404 if
+// there is no such file. Browsers cannot send DELETE to another site without
+// its consent, so unlike the form of the web interface this needs no form token.
+func (h *Handler) deleteFile(w http.ResponseWriter, r *http.Request, filename string) {
+	if err := h.authorize(r, auth.PermDelete, filename); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	if err := h.svc.DeleteFile(filename); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	log.Printf("Deleted file successfully: %s", filename)
+	w.WriteHeader(http.StatusNoContent)
+}
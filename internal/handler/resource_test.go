@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler_PutFile(t *testing.T) {
+	h, store := setupTestHandler(t)
+
+	content := []byte("raw body content")
+	req := httptest.NewRequest("PUT", "/files/raw.txt", bytes.NewReader(content))
+	w := httptest.NewRecorder()
+
+	h.FileResource(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("PUT status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if got := w.Header().Get("Location"); got != "/download?file=raw.txt" {
+		t.Errorf("PUT Location = %q", got)
+	}
+
+	data, err := readStored(store, "raw.txt")
+	if err != nil {
+		t.Fatalf("Failed to read uploaded file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Uploaded file content does not match")
+	}
+}
+
+func TestHandler_PutFile_Exists(t *testing.T) {
+	h, store := setupTestHandler(t)
+
+	putStored(t, store, "exists.txt", []byte("old"))
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		status      int
+	}{
+		{name: "plain", status: http.StatusConflict},
+		{name: "create only", ifNoneMatch: "*", status: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/files/exists.txt", strings.NewReader("new"))
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+
+			h.FileResource(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("PUT status = %d, want %d", w.Code, tt.status)
+			}
+			data, _ := readStored(store, "exists.txt")
+			if string(data) != "old" {
+				t.Error("PUT overwrote an existing file")
+			}
+		})
+	}
+}
+
+func TestHandler_PutFile_TooLarge(t *testing.T) {
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, withMax(4)) // 16 bytes
+
+	// Rejected up front from Content-Length
+	req := httptest.NewRequest("PUT", "/files/big.bin", strings.NewReader(strings.Repeat("x", 100)))
+	w := httptest.NewRecorder()
+	h.FileResource(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	// Rejected while streaming when the length is unknown
+	req = httptest.NewRequest("PUT", "/files/big.bin", io.NopCloser(strings.NewReader(strings.Repeat("x", 100))))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	h.FileResource(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked PUT status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	if isStored(store, "big.bin") {
+		t.Error("Oversized upload left a file in the store")
+	}
+}
+
+func TestHandler_FileResource_MethodNotAllowed(t *testing.T) {
+	h, _ := setupTestHandler(t)
+
+	req := httptest.NewRequest("POST", "/files/a.txt", nil)
+	w := httptest.NewRecorder()
+	h.FileResource(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHandler_DeleteResource(t *testing.T) {
+	h, store := setupTestHandler(t)
+	putStored(t, store, "old.txt", []byte("old"))
+
+	req := httptest.NewRequest("DELETE", "/files/old.txt", nil)
+	w := httptest.NewRecorder()
+	h.FileResource(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DELETE status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if isStored(store, "old.txt") {
+		t.Error("File was not deleted")
+	}
+
+	w = httptest.NewRecorder()
+	h.FileResource(w, httptest.NewRequest("DELETE", "/files/old.txt", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a missing file status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandler_DeleteResource_Disabled(t *testing.T) {
+	h, store := setupSubDirHandler(t, false)
+	putStored(t, store, "keep.txt", []byte("keep"))
+
+	w := httptest.NewRecorder()
+	h.FileResource(w, httptest.NewRequest("DELETE", "/files/keep.txt", nil))
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("DELETE status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if !isStored(store, "keep.txt") {
+		t.Error("File was deleted although delete is disabled")
+	}
+}
@@ -0,0 +1,894 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/service"
+	"fsrv/internal/sigv4"
+)
+
+// S3 API constants
+const (
+	s3Prefix    = "/s3/"
+	s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3MaxKeys   = 1000
+	s3MaxXML    = 1 << 20 // limit for XML request bodies
+
+	// s3EmptyETag is the entity tag of an empty object, which folder markers are
+	s3EmptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+)
+
+// s3ListParams are the query parameters of the bucket listing. Anything else but
+// presigned URL authentication asks for a bucket subresource that is not supported.
+var s3ListParams = map[string]bool{
+	"list-type": true, "prefix": true, "delimiter": true, "max-keys": true, "encoding-type": true,
+	"continuation-token": true, "start-after": true, "fetch-owner": true, "marker": true, "x-id": true,
+}
+
+// S3API serves the store as a single bucket over the S3 REST API with path-style
+// addressing, so S3 clients work against fsrv with an endpoint of http://host:port/s3.
+//
+//	GET    /s3/                                    list buckets
+//	HEAD   /s3/<bucket>                            check the bucket
+//	GET    /s3/<bucket>?list-type=2                list objects (ListObjectsV2, V1 without list-type)
+//	GET    /s3/<bucket>?location                   bucket region
+//	POST   /s3/<bucket>?delete                     delete several objects
+//	GET    /s3/<bucket>/<key>                      download, honoring Range
+//	HEAD   /s3/<bucket>/<key>                      object metadata
+//	PUT    /s3/<bucket>/<key>                      upload
+//	DELETE /s3/<bucket>/<key>                      delete, if delete is enabled
+//	POST   /s3/<bucket>/<key>?uploads              start a multipart upload
+//	PUT    /s3/<bucket>/<key>?partNumber&uploadId  upload a part
+//	GET    /s3/<bucket>/<key>?uploadId             list the uploaded parts
+//	POST   /s3/<bucket>/<key>?uploadId             complete a multipart upload
+//	DELETE /s3/<bucket>/<key>?uploadId             abort a multipart upload
+//
+// Every request must be signed with AWS Signature Version 4 using a configured
+// access key, which acts with its role like the users and tokens of the other
+// endpoints. Keys are paths in the store and folders show up as common prefixes.
+// Uploading to an existing key replaces the file only if delete is enabled,
+// since that discards the old content.
+func (h *Handler) S3API(w http.ResponseWriter, r *http.Request) {
+	signed, err := sigv4.Verify(r, h.svc.S3APISecret, time.Now())
+	if err != nil {
+		log.Printf("Rejected S3 API request %s %s: %v", r.Method, r.URL.Path, err)
+		h.s3Fail(w, r, err)
+		return
+	}
+	role, dir := h.svc.S3APIRole(signed.AccessKey)
+	p, err := auth.NewPrincipal(signed.AccessKey, role, dir)
+	if err != nil {
+		h.s3Fail(w, r, err)
+		return
+	}
+	r = r.WithContext(auth.NewContext(r.Context(), p))
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, s3Prefix), "/")
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			writeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "Method is not allowed on the service")
+			return
+		}
+		h.s3ListBuckets(w)
+		return
+	}
+	if bucket != h.svc.S3APIBucket() {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket", fmt.Sprintf("bucket '%s' does not exist", bucket))
+		return
+	}
+	if key != "" && !h.svc.IsSubDirsEnabled() && strings.Contains(key, "/") {
+		h.s3Fail(w, r, fmt.Errorf("%w: key '%s'", service.ErrSubDirsDisabled, key))
+		return
+	}
+
+	query := r.URL.Query()
+	_, uploadID := query["uploadId"]
+	switch {
+	case key == "":
+		h.s3Bucket(w, r, signed)
+	case r.Method == http.MethodGet && uploadID:
+		h.s3ListParts(w, r, key)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		h.s3GetObject(w, r, key)
+	case r.Method == http.MethodPut && uploadID:
+		h.s3UploadPart(w, r, signed, key)
+	case r.Method == http.MethodPut:
+		h.s3PutObject(w, r, signed, key)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		h.s3CreateMultipartUpload(w, r, key)
+	case r.Method == http.MethodPost && uploadID:
+		h.s3CompleteMultipartUpload(w, r, signed, key)
+	case r.Method == http.MethodDelete && uploadID:
+		h.s3AbortMultipartUpload(w, r, key)
+	case r.Method == http.MethodDelete:
+		h.s3DeleteObject(w, r, key)
+	default:
+		writeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "Method is not allowed on the object")
+	}
+}
+
+// s3Bucket handles the requests on the bucket itself
+func (h *Handler) s3Bucket(w http.ResponseWriter, r *http.Request, signed *sigv4.Auth) {
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodHead:
+		if err := h.authorize(r, auth.PermList, ""); err != nil {
+			h.s3Fail(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && query.Has("location"):
+		// An empty location constraint stands for us-east-1
+		writeXML(w, http.StatusOK, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Xmlns   string   `xml:"xmlns,attr"`
+		}{Xmlns: s3Namespace})
+	case r.Method == http.MethodGet:
+		for param := range query {
+			if !s3ListParams[param] && !strings.HasPrefix(param, "X-Amz-") {
+				writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", fmt.Sprintf("'%s' is not supported", param))
+				return
+			}
+		}
+		h.s3ListObjects(w, r)
+	case r.Method == http.MethodPost && query.Has("delete"):
+		h.s3DeleteObjects(w, r, signed)
+	case r.Method == http.MethodPut:
+		writeS3Error(w, r, http.StatusConflict, "BucketAlreadyOwnedByYou", "fsrv serves a single bucket")
+	default:
+		writeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "Method is not allowed on the bucket")
+	}
+}
+
+// s3BucketInfo is a bucket in a ListBuckets response
+type s3BucketInfo struct {
+	Name         string
+	CreationDate string
+}
+
+// s3ListBuckets lists the one bucket fsrv serves
+func (h *Handler) s3ListBuckets(w http.ResponseWriter) {
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name       `xml:"ListAllMyBucketsResult"`
+		Xmlns   string         `xml:"xmlns,attr"`
+		Owner   s3Owner        `xml:"Owner"`
+		Buckets []s3BucketInfo `xml:"Buckets>Bucket"`
+	}{
+		Xmlns:   s3Namespace,
+		Owner:   s3Owner{ID: "fsrv", DisplayName: "fsrv"},
+		Buckets: []s3BucketInfo{{Name: h.svc.S3APIBucket(), CreationDate: s3Time(time.Unix(0, 0))}},
+	})
+}
+
+// s3Owner is the owner of buckets and objects
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+// s3Object is an object in a listing
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+// s3CommonPrefix is a folder in a listing
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+// s3ListResult is the response of ListObjectsV2 and ListObjects (V1)
+type s3ListResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Xmlns                 string   `xml:"xmlns,attr"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	MaxKeys               int
+	EncodingType          string `xml:",omitempty"`
+	IsTruncated           bool
+	KeyCount              *int   `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	Marker                *string
+	NextMarker            string `xml:",omitempty"`
+	Contents              []s3Object
+	CommonPrefixes        []s3CommonPrefix
+}
+
+// s3Entry is a key in a listing, either an object or a common prefix
+type s3Entry struct {
+	key   string
+	entry *service.Entry
+}
+
+// s3ListObjects lists the objects in the bucket
+func (h *Handler) s3ListObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	v2 := query.Get("list-type") == "2"
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+
+	maxKeys := s3MaxKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative number")
+			return
+		}
+		maxKeys = min(n, s3MaxKeys)
+	}
+
+	after := query.Get("marker")
+	if v2 {
+		after = query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid continuation token")
+				return
+			}
+			after = string(decoded)
+		}
+	}
+
+	entries, err := h.s3ListKeys(r, prefix, delimiter)
+	if err != nil {
+		log.Printf("Failed to list objects: %v", err)
+		h.s3Fail(w, r, err)
+		return
+	}
+
+	encode := func(s string) string { return s }
+	if query.Get("encoding-type") == "url" {
+		encode = sigv4.EncodePath
+	}
+
+	result := s3ListResult{
+		Xmlns:        s3Namespace,
+		Name:         h.svc.S3APIBucket(),
+		Prefix:       encode(prefix),
+		Delimiter:    encode(delimiter),
+		MaxKeys:      maxKeys,
+		EncodingType: query.Get("encoding-type"),
+	}
+
+	count, last := 0, ""
+	for _, e := range entries {
+		if e.key <= after {
+			continue
+		}
+		if count == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		count++
+		last = e.key
+
+		if e.entry == nil {
+			result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: encode(e.key)})
+			continue
+		}
+		result.Contents = append(result.Contents, s3Object{
+			Key:          encode(e.key),
+			LastModified: s3Time(e.entry.ModTime),
+			ETag:         h.entityTag(e.key, e.entry.Size, e.entry.ModTime),
+			Size:         e.entry.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+
+	if v2 {
+		result.KeyCount = &count
+		result.ContinuationToken = query.Get("continuation-token")
+		result.StartAfter = encode(query.Get("start-after"))
+		if result.IsTruncated {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+	} else {
+		marker := encode(query.Get("marker"))
+		result.Marker = &marker
+		if result.IsTruncated {
+			result.NextMarker = encode(last)
+		}
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// s3ListKeys returns the objects and common prefixes matching prefix that the
+// access key of r may list, sorted by key. With "/" as delimiter only the folder
+// the prefix points into is read.
+func (h *Handler) s3ListKeys(r *http.Request, prefix, delimiter string) ([]s3Entry, error) {
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i]
+	}
+
+	entries, err := h.svc.ListTree(dir, delimiter != "/")
+	if errors.Is(err, service.ErrDirNotExist) || errors.Is(err, service.ErrInvalidFilename) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result []s3Entry
+	seen := make(map[string]bool)
+	for i := range entries {
+		key := entries[i].Path
+		if entries[i].IsDir {
+			key += "/"
+		}
+		if !strings.HasPrefix(key, prefix) || h.authorize(r, auth.PermList, entries[i].Path) != nil {
+			continue
+		}
+
+		// Keys containing the delimiter after the prefix are rolled up
+		if delimiter != "" {
+			if j := strings.Index(key[len(prefix):], delimiter); j >= 0 {
+				commonPrefix := key[:len(prefix)+j+len(delimiter)]
+				if !seen[commonPrefix] {
+					seen[commonPrefix] = true
+					result = append(result, s3Entry{key: commonPrefix})
+				}
+				continue
+			}
+		}
+		if !entries[i].IsDir {
+			result = append(result, s3Entry{key: key, entry: &entries[i]})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].key < result[j].key })
+	return result, nil
+}
+
+// s3GetObject serves GET and HEAD requests for an object, including ranges and
+// conditional requests
+func (h *Handler) s3GetObject(w http.ResponseWriter, r *http.Request, key string) {
+	name, isDir := strings.CutSuffix(key, "/")
+	perm := auth.PermDownload
+	if isDir {
+		perm = auth.PermList
+	}
+	if err := h.authorize(r, perm, name); err != nil {
+		h.s3Fail(w, r, err)
+		return
+	}
+
+	info, err := h.svc.StatFile(name)
+	if err == nil && info.IsDir != strings.HasSuffix(key, "/") {
+		err = fmt.Errorf("%w: '%s'", service.ErrFileNotExist, key)
+	}
+	if err != nil {
+		h.s3Fail(w, r, err)
+		return
+	}
+
+	// Folders read as empty folder markers
+	if info.IsDir {
+		w.Header().Set("ETag", s3EmptyETag)
+		w.Header().Set("Content-Type", "application/x-directory")
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	file, err := h.svc.OpenFile(key)
+	if err != nil {
+		h.s3Fail(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	info, err = file.Stat()
+	if err != nil {
+		h.s3Fail(w, r, err)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	h.setChecksumHeaders(w, key, info)
+	http.ServeContent(w, r, "", info.ModTime, file)
+
+	if r.Method == http.MethodGet {
+		log.Printf("Downloaded file: %s (S3 API)", key)
+	}
+}
+
+// s3PutObject stores an object. A key ending in "/" creates a folder.
+func (h *Handler) s3PutObject(w http.ResponseWriter, r *http.Request, signed *sigv4.Auth, key string) {
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "copying objects is not supported")
+		return
+	}
+
+	if err := h.authorize(r, auth.PermUpload, strings.TrimSuffix(key, "/")); err != nil {
+		h.s3Fail(w, r, err)
+		return
+	}
+	if dir, ok := strings.CutSuffix(key, "/"); ok {
+		if _, err := h.svc.MakeDir(dir); err != nil && !errors.Is(err, service.ErrFileExists) {
+			h.s3Fail(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", s3EmptyETag)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	replace, err := h.s3Replace(r, key)
+	if err == nil {
+		err = h.svc.CheckQuota(key, "", s3PayloadSize(r))
+	}
+	if err != nil {
+		h.s3Fail(w, r, err)
+		return
+	}
+	body, ok := h.s3Body(w, r, signed)
+	if !ok {
+		return
+	}
+	want, err := declaredChecksums(r.Header)
+	if err != nil {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidDigest", err.Error())
+		return
+	}
+	body = service.VerifyChecksums(body, want)
+
+	upload := h.svc.UploadFile
+	if replace {
+		upload = h.svc.ReplaceFile
+	}
+	size, err := upload(key, body)
+	if err != nil {
+		log.Printf("Failed to upload file %s over the S3 API: %v", key, err)
+		h.s3Fail(w, r, err)
+		return
+	}
+
+	h.s3SetETag(w, key)
+	w.WriteHeader(http.StatusOK)
+	log.Printf("Uploaded file successfully: %s (%d bytes, S3 API)", key, size)
+}
+
+// s3DeleteObject deletes an object
+func (h *Handler) s3DeleteObject(w http.ResponseWriter, r *http.Request, key string) {
+	if err := h.s3Delete(r, key); err != nil {
+		h.s3Fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// s3Delete removes the file or, for a key ending in "/", the empty folder a key
+// refers to, if the access key of r may. Like S3 it succeeds if there is nothing
+// to delete.
+func (h *Handler) s3Delete(r *http.Request, key string) error {
+	if !h.svc.IsDeleteEnabled() {
+		return service.ErrDeleteDisabled
+	}
+
+	dir, isDir := strings.CutSuffix(key, "/")
+	if err := h.authorize(r, auth.PermDelete, dir); err != nil {
+		return err
+	}
+	info, err := h.svc.StatFile(dir)
+	if errors.Is(err, service.ErrFileNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir != isDir {
+		return nil
+	}
+
+	if isDir {
+		err = h.svc.RemoveDir(dir)
+		// A folder with content is implied by it, as in S3
+		if errors.Is(err, service.ErrDirNotEmpty) {
+			return nil
+		}
+	} else {
+		err = h.svc.DeleteFile(key)
+	}
+	if errors.Is(err, service.ErrFileNotExist) || errors.Is(err, service.ErrDirNotExist) {
+		return nil
+	}
+	if err == nil {
+		log.Printf("Deleted successfully: %s (S3 API)", key)
+	}
+	return err
+}
+
+// s3DeleteObjects deletes several objects at once
+func (h *Handler) s3DeleteObjects(w http.ResponseWriter, r *http.Request, signed *sigv4.Auth) {
+	var request struct {
+		Quiet   bool
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	if !h.s3ReadXML(w, r, signed, &request) {
+		return
+	}
+	if len(request.Objects) > s3MaxKeys {
+		writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", fmt.Sprintf("at most %d objects can be deleted at once", s3MaxKeys))
+		return
+	}
+
+	type deleted struct {
+		Key string
+	}
+	type deleteError struct {
+		Key     string
+		Code    string
+		Message string
+	}
+	result := struct {
+		XMLName xml.Name      `xml:"DeleteResult"`
+		Xmlns   string        `xml:"xmlns,attr"`
+		Deleted []deleted     `xml:"Deleted"`
+		Errors  []deleteError `xml:"Error"`
+	}{Xmlns: s3Namespace}
+
+	for _, object := range request.Objects {
+		if err := h.s3Delete(r, object.Key); err != nil {
+			_, code := s3ErrorFor(err)
+			result.Errors = append(result.Errors, deleteError{Key: object.Key, Code: code, Message: err.Error()})
+		} else if !request.Quiet {
+			result.Deleted = append(result.Deleted, deleted{Key: object.Key})
+		}
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// s3CreateMultipartUpload starts a multipart upload
+func (h *Handler) s3CreateMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	err := h.authorize(r, auth.PermUpload, key)
+	replace := false
+	if err == nil {
+		replace, err = h.s3Replace(r, key)
+	}
+	var upload *service.MultipartUpload
+	if err == nil {
+		upload, err = h.svc.CreateMultipartUpload(key, replace)
+	}
+	if err != nil {
+		h.s3Fail(w, r, err)
+		return
+	}
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Xmlns: s3Namespace, Bucket: h.svc.S3APIBucket(), Key: key, UploadId: upload.ID})
+	log.Printf("Created multipart upload %s for file: %s", upload.ID, upload.Filename)
+}
+
+// s3UploadPart stores a part of a multipart upload
+func (h *Handler) s3UploadPart(w http.ResponseWriter, r *http.Request, signed *sigv4.Auth, key string) {
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "copying parts is not supported")
+		return
+	}
+
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid partNumber")
+		return
+	}
+
+	upload, ok := h.s3Upload(w, r, key)
+	if !ok {
+		return
+	}
+	body, ok := h.s3Body(w, r, signed)
+	if !ok {
+		return
+	}
+
+	part, err := h.svc.UploadPart(upload.ID, number, body)
+	if err != nil {
+		log.Printf("Failed to upload part %d of multipart upload %s: %v", number, upload.ID, err)
+		h.s3Fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+part.ETag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// s3ListParts lists the parts of a multipart upload received so far
+func (h *Handler) s3ListParts(w http.ResponseWriter, r *http.Request, key string) {
+	upload, ok := h.s3Upload(w, r, key)
+	if !ok {
+		return
+	}
+
+	parts, err := h.svc.ListParts(upload.ID)
+	if err != nil {
+		h.s3Fail(w, r, err)
+		return
+	}
+
+	type s3Part struct {
+		PartNumber int
+		ETag       string
+		Size       int64
+	}
+	result := struct {
+		XMLName  xml.Name `xml:"ListPartsResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadId string
+		Parts    []s3Part `xml:"Part"`
+	}{Xmlns: s3Namespace, Bucket: h.svc.S3APIBucket(), Key: key, UploadId: upload.ID}
+	for _, part := range parts {
+		result.Parts = append(result.Parts, s3Part{PartNumber: part.Number, ETag: `"` + part.ETag + `"`, Size: part.Size})
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// s3CompleteMultipartUpload joins the parts of a multipart upload into the object
+func (h *Handler) s3CompleteMultipartUpload(w http.ResponseWriter, r *http.Request, signed *sigv4.Auth, key string) {
+	upload, ok := h.s3Upload(w, r, key)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if !h.s3ReadXML(w, r, signed, &request) {
+		return
+	}
+
+	var parts []service.Part
+	for _, part := range request.Parts {
+		parts = append(parts, service.Part{Number: part.PartNumber, ETag: strings.Trim(part.ETag, `"`)})
+	}
+
+	size, err := h.svc.CompleteMultipartUpload(upload.ID, parts)
+	if err != nil {
+		log.Printf("Failed to complete multipart upload %s: %v", upload.ID, err)
+		h.s3Fail(w, r, err)
+		return
+	}
+
+	etag := h.s3SetETag(w, key)
+	writeXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{Xmlns: s3Namespace, Location: r.URL.Path, Bucket: h.svc.S3APIBucket(), Key: key, ETag: etag})
+	log.Printf("Uploaded file successfully: %s (%d bytes, S3 multipart upload %s)", upload.Filename, size, upload.ID)
+}
+
+// s3AbortMultipartUpload discards a multipart upload
+func (h *Handler) s3AbortMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	upload, ok := h.s3Upload(w, r, key)
+	if !ok {
+		return
+	}
+	if err := h.svc.AbortMultipartUpload(upload.ID); err != nil {
+		h.s3Fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// s3Upload looks up the multipart upload in the uploadId parameter, which must
+// belong to key, an upload the access key of r may make
+func (h *Handler) s3Upload(w http.ResponseWriter, r *http.Request, key string) (*service.MultipartUpload, bool) {
+	if err := h.authorize(r, auth.PermUpload, key); err != nil {
+		h.s3Fail(w, r, err)
+		return nil, false
+	}
+	id := r.URL.Query().Get("uploadId")
+	upload, err := h.svc.GetMultipartUpload(id)
+	if err == nil {
+		if resolved, resolveErr := h.svc.ResolvePath(key); resolveErr != nil || resolved != upload.Filename {
+			err = fmt.Errorf("%w: '%s' is not an upload of '%s'", service.ErrUploadNotFound, id, key)
+		}
+	}
+	if err != nil {
+		h.s3Fail(w, r, err)
+		return nil, false
+	}
+	return upload, true
+}
+
+// s3Body returns the verified payload of an upload, rejecting it up front if it
+// announces more data than the maximum upload size
+func (h *Handler) s3Body(w http.ResponseWriter, r *http.Request, signed *sigv4.Auth) (io.Reader, bool) {
+	if s3PayloadSize(r) > h.svc.GetMaxUploadSize() {
+		h.s3Fail(w, r, fmt.Errorf("%w: max upload file size is %s", service.ErrFileTooLarge, h.svc.GetMaxUploadSizeHuman()))
+		return nil, false
+	}
+	if err := h.svc.CheckDiskSpace(s3PayloadSize(r)); err != nil {
+		h.s3Fail(w, r, err)
+		return nil, false
+	}
+
+	body, err := signed.Body(r.Body)
+	if err != nil {
+		h.s3Fail(w, r, err)
+		return nil, false
+	}
+	return body, true
+}
+
+// s3PayloadSize returns the size of the data a request uploads: its
+// Content-Length, unless the body is sent in signed chunks
+func s3PayloadSize(r *http.Request) int64 {
+	if decoded := r.Header.Get("X-Amz-Decoded-Content-Length"); decoded != "" {
+		size, _ := strconv.ParseInt(decoded, 10, 64)
+		return size
+	}
+	return r.ContentLength
+}
+
+// s3ReadXML decodes the verified XML body of a request into v
+func (h *Handler) s3ReadXML(w http.ResponseWriter, r *http.Request, signed *sigv4.Auth, v any) bool {
+	body, err := signed.Body(r.Body)
+	if err != nil {
+		h.s3Fail(w, r, err)
+		return false
+	}
+
+	// Read everything, so that the payload hash gets checked
+	data, err := io.ReadAll(io.LimitReader(body, s3MaxXML))
+	if err != nil {
+		h.s3Fail(w, r, err)
+		return false
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+		return false
+	}
+	return true
+}
+
+// s3Replace reports whether an upload to key may replace an existing file: only
+// if delete is enabled and the client did not ask for If-None-Match: *. Access
+// keys that may not overwrite are refused if the file exists.
+func (h *Handler) s3Replace(r *http.Request, key string) (bool, error) {
+	if !h.svc.IsDeleteEnabled() || r.Header.Get("If-None-Match") == "*" {
+		return false, nil
+	}
+	if err := h.authorize(r, auth.PermOverwrite, key); err != nil {
+		if _, statErr := h.svc.StatFile(key); statErr == nil {
+			return false, err
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// s3SetETag sets the ETag header for a freshly stored object and returns it
+func (h *Handler) s3SetETag(w http.ResponseWriter, key string) string {
+	info, err := h.svc.StatFile(key)
+	if err != nil {
+		return ""
+	}
+	etag := h.entityTag(key, info.Size, info.ModTime)
+	w.Header().Set("ETag", etag)
+	return etag
+}
+
+// s3Time formats a time as S3 does in XML responses
+func s3Time(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// s3ErrorFor maps an error to the HTTP status and S3 error code describing it
+func s3ErrorFor(err error) (int, string) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, sigv4.ErrMissingAuth), errors.Is(err, service.ErrDeleteDisabled), errors.Is(err, errForbidden):
+		return http.StatusForbidden, "AccessDenied"
+	case errors.Is(err, sigv4.ErrUnknownAccessKey):
+		return http.StatusForbidden, "InvalidAccessKeyId"
+	case errors.Is(err, sigv4.ErrSignatureMismatch):
+		return http.StatusForbidden, "SignatureDoesNotMatch"
+	case errors.Is(err, sigv4.ErrRequestExpired):
+		return http.StatusForbidden, "RequestTimeTooSkewed"
+	case errors.Is(err, sigv4.ErrMalformedAuth):
+		return http.StatusBadRequest, "AuthorizationHeaderMalformed"
+	case errors.Is(err, sigv4.ErrPayloadMismatch):
+		return http.StatusBadRequest, "XAmzContentSHA256Mismatch"
+	case errors.Is(err, sigv4.ErrMalformedPayload), errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest, "IncompleteBody"
+	case errors.Is(err, service.ErrFileNotExist), errors.Is(err, service.ErrDirNotExist):
+		return http.StatusNotFound, "NoSuchKey"
+	case errors.Is(err, service.ErrUploadNotFound):
+		return http.StatusNotFound, "NoSuchUpload"
+	case errors.Is(err, service.ErrInvalidPart):
+		return http.StatusBadRequest, "InvalidPart"
+	case errors.Is(err, service.ErrChecksumMismatch):
+		return http.StatusBadRequest, "BadDigest"
+	case errors.Is(err, service.ErrFileTooLarge), errors.As(err, &maxBytesErr):
+		return http.StatusBadRequest, "EntityTooLarge"
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage, "QuotaExceeded"
+	case errors.Is(err, service.ErrInsufficientStorage):
+		return http.StatusInsufficientStorage, "InsufficientStorage"
+	case errors.Is(err, service.ErrFileExists):
+		return http.StatusConflict, "KeyAlreadyExists"
+	case errors.Is(err, service.ErrFileBusy):
+		return http.StatusConflict, "OperationAborted"
+	case errors.Is(err, service.ErrInvalidFilename), errors.Is(err, service.ErrSubDirsDisabled):
+		return http.StatusBadRequest, "InvalidArgument"
+	default:
+		return http.StatusInternalServerError, "InternalError"
+	}
+}
+
+// s3Fail writes the S3 error response for err
+func (h *Handler) s3Fail(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := s3ErrorFor(err)
+	// A refused conditional upload is a failed precondition
+	if code == "KeyAlreadyExists" && r.Header.Get("If-None-Match") == "*" {
+		status, code = http.StatusPreconditionFailed, "PreconditionFailed"
+	}
+	writeS3Error(w, r, status, code, err.Error())
+}
+
+// writeS3Error writes an S3 error response
+func writeS3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string
+		Message  string
+		Resource string
+	}{Code: code, Message: message, Resource: r.URL.Path})
+}
+
+// writeXML writes v as an XML response with the given status code
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding XML response: %v", err)
+	}
+}
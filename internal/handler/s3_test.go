@@ -0,0 +1,567 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"fsrv/internal/config"
+	"fsrv/internal/sigv4"
+	"fsrv/internal/storage"
+)
+
+// testS3Credentials is the access key the S3 API tests are signed with
+var testS3Credentials = sigv4.Credentials{AccessKey: "tester", SecretKey: "secret"}
+
+// setupS3Handler creates a handler with the S3 API enabled on top of in-memory storage
+func setupS3Handler(t *testing.T, configure func(*config.Config)) (*Handler, *storage.Memory) {
+	store := storage.NewMemory()
+	svc := newTestService(t, store, func(cfg *config.Config) {
+		cfg.SubDirs = true
+		cfg.S3APIBucket = "files"
+		cfg.S3APIKeys = map[string]config.S3APIKey{testS3Credentials.AccessKey: {Secret: testS3Credentials.SecretKey}}
+		if configure != nil {
+			configure(cfg)
+		}
+	})
+	h, err := New(svc, testTemplates)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	return h, store
+}
+
+// s3Request returns a request to the S3 API signed with the payload hash of body
+func s3Request(method, target string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	sigv4.Sign(req, testS3Credentials, "us-east-1", "s3", hex.EncodeToString(sum[:]), time.Now())
+	return req
+}
+
+// serveS3 sends req to the S3 API of h
+func serveS3(h *Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.S3API(w, req)
+	return w
+}
+
+// s3ErrorCode returns the code of an S3 error response
+func s3ErrorCode(w *httptest.ResponseRecorder) string {
+	var body struct{ Code string }
+	xml.Unmarshal(w.Body.Bytes(), &body)
+	return body.Code
+}
+
+// TestHandler_S3API_Client drives the S3 API with fsrv's own S3 storage client,
+// covering single and multipart uploads, listings, ranged reads and deletes
+func TestHandler_S3API_Client(t *testing.T) {
+	h, store := setupS3Handler(t, nil)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := storage.NewS3(storage.S3Config{
+		Endpoint:  server.URL + "/s3",
+		Bucket:    "files",
+		AccessKey: testS3Credentials.AccessKey,
+		SecretKey: testS3Credentials.SecretKey,
+		PartSize:  8, // force multipart uploads
+	})
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+
+	content := []byte("a file that needs several parts")
+	if _, err := client.Put("docs/report.txt", bytes.NewReader(content)); err != nil {
+		t.Fatalf("multipart Put() error = %v", err)
+	}
+	if _, err := client.Put("small.txt", strings.NewReader("tiny")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if stored, err := readStored(store, "docs/report.txt"); err != nil || !bytes.Equal(stored, content) {
+		t.Errorf("stored content = %q, %v", stored, err)
+	}
+
+	files, err := client.List("")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	if len(files) != 2 || files[0].Name != "docs" || !files[0].IsDir || files[1].Name != "small.txt" || files[1].Size != 4 {
+		t.Errorf("List() = %+v", files)
+	}
+
+	file, err := client.Open("docs/report.txt")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if _, err := file.Seek(7, io.SeekStart); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	rest, err := io.ReadAll(file)
+	file.Close()
+	if err != nil || string(rest) != string(content[7:]) {
+		t.Errorf("ranged read = %q, %v", rest, err)
+	}
+
+	// Files without recorded checksums carry a weak ETag, which cannot pin ranged reads
+	putStored(t, store, "unsummed.txt", content)
+	file, err = client.Open("unsummed.txt")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	file.Seek(7, io.SeekStart)
+	rest, err = io.ReadAll(file)
+	file.Close()
+	if err != nil || string(rest) != string(content[7:]) {
+		t.Errorf("ranged read with a weak ETag = %q, %v", rest, err)
+	}
+	store.Delete("unsummed.txt")
+
+	// Replacing needs delete to be enabled, which it is here
+	if _, err := client.Put("small.txt", strings.NewReader("replaced")); err != nil {
+		t.Fatalf("replacing Put() error = %v", err)
+	}
+	if stored, _ := readStored(store, "small.txt"); string(stored) != "replaced" {
+		t.Errorf("replaced content = %q", stored)
+	}
+
+	if err := client.Mkdir("empty"); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if info, err := store.Stat("empty"); err != nil || !info.IsDir {
+		t.Errorf("folder not created: %+v, %v", info, err)
+	}
+
+	if err := client.Delete("docs/report.txt"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if isStored(store, "docs/report.txt") {
+		t.Error("deleted file is still stored")
+	}
+	if _, err := client.Open("docs/report.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() of deleted file error = %v, want not exist", err)
+	}
+}
+
+func TestHandler_S3API_Auth(t *testing.T) {
+	h, _ := setupS3Handler(t, nil)
+
+	tests := []struct {
+		name     string
+		req      func() *http.Request
+		wantCode int
+		wantS3   string
+	}{
+		{
+			name:     "unsigned",
+			req:      func() *http.Request { return httptest.NewRequest("GET", "/s3/files?list-type=2", nil) },
+			wantCode: http.StatusForbidden,
+			wantS3:   "AccessDenied",
+		},
+		{
+			name: "wrong secret",
+			req: func() *http.Request {
+				req := httptest.NewRequest("GET", "/s3/files?list-type=2", nil)
+				sigv4.Sign(req, sigv4.Credentials{AccessKey: "tester", SecretKey: "guess"}, "us-east-1", "s3", sigv4.EmptySHA256, time.Now())
+				return req
+			},
+			wantCode: http.StatusForbidden,
+			wantS3:   "SignatureDoesNotMatch",
+		},
+		{
+			name: "unknown access key",
+			req: func() *http.Request {
+				req := httptest.NewRequest("GET", "/s3/files?list-type=2", nil)
+				sigv4.Sign(req, sigv4.Credentials{AccessKey: "nobody", SecretKey: "secret"}, "us-east-1", "s3", sigv4.EmptySHA256, time.Now())
+				return req
+			},
+			wantCode: http.StatusForbidden,
+			wantS3:   "InvalidAccessKeyId",
+		},
+		{
+			name:     "unknown bucket",
+			req:      func() *http.Request { return s3Request("GET", "/s3/other?list-type=2", nil) },
+			wantCode: http.StatusNotFound,
+			wantS3:   "NoSuchBucket",
+		},
+		{
+			name: "presigned",
+			req: func() *http.Request {
+				req := httptest.NewRequest("GET", "/s3/files?list-type=2", nil)
+				sigv4.Presign(req, testS3Credentials, "us-east-1", "s3", time.Minute, time.Now())
+				return req
+			},
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveS3(h, tt.req())
+			if w.Code != tt.wantCode {
+				t.Errorf("expected status %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if tt.wantS3 != "" && s3ErrorCode(w) != tt.wantS3 {
+				t.Errorf("expected error code %s, got %s", tt.wantS3, s3ErrorCode(w))
+			}
+		})
+	}
+}
+
+func TestHandler_S3API_Roles(t *testing.T) {
+	h, store := setupS3Handler(t, func(cfg *config.Config) {
+		cfg.DelAble = true
+		cfg.S3APIKeys[testS3Credentials.AccessKey] = config.S3APIKey{Secret: testS3Credentials.SecretKey, Role: "uploader", Dir: "team"}
+	})
+	for _, name := range []string{"team/a.txt", "other.txt"} {
+		putStored(t, store, name, []byte(name))
+	}
+
+	// Listings only show what the access key may see
+	w := serveS3(h, s3Request("GET", "/s3/files?list-type=2", nil))
+	var result s3ListResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("list = %d %s", w.Code, w.Body.String())
+	}
+	if len(result.Contents) != 1 || result.Contents[0].Key != "team/a.txt" {
+		t.Errorf("listed objects = %+v, want only team/a.txt", result.Contents)
+	}
+
+	tests := []struct {
+		name, method, target string
+		wantCode             int
+	}{
+		{"download in the folder", "GET", "/s3/files/team/a.txt", http.StatusOK},
+		{"download outside", "GET", "/s3/files/other.txt", http.StatusForbidden},
+		{"upload in the folder", "PUT", "/s3/files/team/b.txt", http.StatusOK},
+		{"upload outside", "PUT", "/s3/files/b.txt", http.StatusForbidden},
+		{"overwrite", "PUT", "/s3/files/team/a.txt", http.StatusForbidden},
+		{"delete", "DELETE", "/s3/files/team/a.txt", http.StatusForbidden},
+		{"multipart upload outside", "POST", "/s3/files/b.txt?uploads", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveS3(h, s3Request(tt.method, tt.target, []byte("new")))
+			if w.Code != tt.wantCode {
+				t.Errorf("%s %s = %d %s, want %d", tt.method, tt.target, w.Code, w.Body.String(), tt.wantCode)
+			}
+			if tt.wantCode == http.StatusForbidden && s3ErrorCode(w) != "AccessDenied" {
+				t.Errorf("expected error code AccessDenied, got %s", s3ErrorCode(w))
+			}
+		})
+	}
+	if stored, err := readStored(store, "team/a.txt"); err != nil || string(stored) != "team/a.txt" {
+		t.Errorf("team/a.txt = %q, %v, want it unchanged", stored, err)
+	}
+}
+
+func TestHandler_S3API_PutObject_PayloadMismatch(t *testing.T) {
+	h, store := setupS3Handler(t, nil)
+
+	req := s3Request("PUT", "/s3/files/file.txt", []byte("signed content"))
+	req.Body = io.NopCloser(strings.NewReader("forged content"))
+
+	w := serveS3(h, req)
+	if w.Code != http.StatusBadRequest || s3ErrorCode(w) != "XAmzContentSHA256Mismatch" {
+		t.Errorf("expected 400 XAmzContentSHA256Mismatch, got %d: %s", w.Code, w.Body.String())
+	}
+	if isStored(store, "file.txt") {
+		t.Error("upload with a mismatching payload was stored")
+	}
+}
+
+func TestHandler_S3API_PutObject_BadDigest(t *testing.T) {
+	h, store := setupS3Handler(t, nil)
+
+	req := s3Request("PUT", "/s3/files/file.txt", []byte("hello"))
+	req.Header.Set("Content-MD5", "AAAAAAAAAAAAAAAAAAAAAA==")
+	if w := serveS3(h, req); w.Code != http.StatusBadRequest || s3ErrorCode(w) != "BadDigest" {
+		t.Errorf("expected 400 BadDigest, got %d: %s", w.Code, w.Body.String())
+	}
+	if isStored(store, "file.txt") {
+		t.Error("upload with a mismatching Content-MD5 was stored")
+	}
+
+	req = s3Request("PUT", "/s3/files/file.txt", []byte("hello"))
+	req.Header.Set("Content-MD5", "not base64")
+	if w := serveS3(h, req); w.Code != http.StatusBadRequest || s3ErrorCode(w) != "InvalidDigest" {
+		t.Errorf("expected 400 InvalidDigest, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = s3Request("PUT", "/s3/files/file.txt", []byte("hello"))
+	req.Header.Set("Content-MD5", helloMD5Base64)
+	if w := serveS3(h, req); w.Code != http.StatusOK {
+		t.Errorf("expected status 200 with the right Content-MD5, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandler_S3API_PutObject_QuotaExceeded(t *testing.T) {
+	h, store := setupS3Handler(t, withQuota(8))
+
+	req := s3Request("PUT", "/s3/files/file.txt", []byte("hello world"))
+	if w := serveS3(h, req); w.Code != http.StatusInsufficientStorage || s3ErrorCode(w) != "QuotaExceeded" {
+		t.Errorf("expected 507 QuotaExceeded, got %d: %s", w.Code, w.Body.String())
+	}
+	if isStored(store, "file.txt") {
+		t.Error("upload beyond the quota was stored")
+	}
+}
+
+func TestHandler_S3API_PutObject_InsufficientStorage(t *testing.T) {
+	requireDiskSpace(t)
+	h, store := setupS3Handler(t, withReserve(1<<62))
+
+	// Objects go straight into memory, but parts are received into the tmp directory
+	if w := serveS3(h, s3Request("PUT", "/s3/files/file.txt", []byte("hello world"))); w.Code != http.StatusOK {
+		t.Errorf("PUT into memory storage with a disk reserve = %d: %s", w.Code, w.Body.String())
+	}
+
+	w := serveS3(h, s3Request("POST", "/s3/files/big.bin?uploads", nil))
+	var initiated struct{ UploadId string }
+	if err := xml.Unmarshal(w.Body.Bytes(), &initiated); err != nil || initiated.UploadId == "" {
+		t.Fatalf("create: got %d: %s", w.Code, w.Body.String())
+	}
+	req := s3Request("PUT", "/s3/files/big.bin?partNumber=1&uploadId="+initiated.UploadId, []byte("hello world"))
+	if w := serveS3(h, req); w.Code != http.StatusInsufficientStorage || s3ErrorCode(w) != "InsufficientStorage" {
+		t.Errorf("expected 507 InsufficientStorage, got %d: %s", w.Code, w.Body.String())
+	}
+	if isStored(store, "big.bin") {
+		t.Error("upload into the disk reserve was stored")
+	}
+}
+
+func TestHandler_S3API_PutObject_Chunked(t *testing.T) {
+	h, store := setupS3Handler(t, nil)
+
+	body := "6\r\nhello \r\n5\r\nworld\r\n0\r\nx-amz-checksum-crc32:DUoRhQ==\r\n\r\n"
+	req := httptest.NewRequest("PUT", "/s3/files/chunked.txt", strings.NewReader(body))
+	req.Header.Set("Content-Encoding", "aws-chunked")
+	req.Header.Set("X-Amz-Decoded-Content-Length", "11")
+	req.Header.Set("X-Amz-Trailer", "x-amz-checksum-crc32")
+	sigv4.Sign(req, testS3Credentials, "us-east-1", "s3", sigv4.StreamingUnsignedPayloadTrailer, time.Now())
+
+	w := serveS3(h, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if stored, _ := readStored(store, "chunked.txt"); string(stored) != "hello world" {
+		t.Errorf("stored content = %q", stored)
+	}
+}
+
+func TestHandler_S3API_DeleteDisabled(t *testing.T) {
+	h, store := setupS3Handler(t, func(cfg *config.Config) { cfg.DelAble = false })
+	putStored(t, store, "keep.txt", []byte("original"))
+
+	w := serveS3(h, s3Request("PUT", "/s3/files/keep.txt", []byte("overwrite")))
+	if w.Code != http.StatusConflict || s3ErrorCode(w) != "KeyAlreadyExists" {
+		t.Errorf("overwrite: expected 409 KeyAlreadyExists, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = serveS3(h, s3Request("DELETE", "/s3/files/keep.txt", nil))
+	if w.Code != http.StatusForbidden || s3ErrorCode(w) != "AccessDenied" {
+		t.Errorf("delete: expected 403 AccessDenied, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if stored, _ := readStored(store, "keep.txt"); string(stored) != "original" {
+		t.Errorf("file was modified: %q", stored)
+	}
+}
+
+func TestHandler_S3API_PutObject_IfNoneMatch(t *testing.T) {
+	h, store := setupS3Handler(t, nil)
+	putStored(t, store, "keep.txt", []byte("original"))
+
+	req := httptest.NewRequest("PUT", "/s3/files/keep.txt", strings.NewReader("overwrite"))
+	req.Header.Set("If-None-Match", "*")
+	sigv4.Sign(req, testS3Credentials, "us-east-1", "s3", sigv4.UnsignedPayload, time.Now())
+
+	w := serveS3(h, req)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412, got %d: %s", w.Code, w.Body.String())
+	}
+	if stored, _ := readStored(store, "keep.txt"); string(stored) != "original" {
+		t.Errorf("file was replaced: %q", stored)
+	}
+}
+
+func TestHandler_S3API_ListObjectsV2(t *testing.T) {
+	h, store := setupS3Handler(t, nil)
+	for _, name := range []string{"a.txt", "b c.txt", "dir/d.txt", "dir/sub/e.txt", "f.txt"} {
+		putStored(t, store, name, []byte(name))
+	}
+
+	list := func(query string) s3ListResult {
+		t.Helper()
+		w := serveS3(h, s3Request("GET", "/s3/files?list-type=2&"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var result s3ListResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to decode listing: %v", err)
+		}
+		return result
+	}
+	keys := func(result s3ListResult) string {
+		var keys []string
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		for _, prefix := range result.CommonPrefixes {
+			keys = append(keys, prefix.Prefix)
+		}
+		return strings.Join(keys, ",")
+	}
+
+	if got := keys(list("delimiter=/")); got != "a.txt,b c.txt,f.txt,dir/" {
+		t.Errorf("root listing = %s", got)
+	}
+	if got := keys(list("")); got != "a.txt,b c.txt,dir/d.txt,dir/sub/e.txt,f.txt" {
+		t.Errorf("recursive listing = %s", got)
+	}
+	if got := keys(list("prefix=dir/&delimiter=/")); got != "dir/d.txt,dir/sub/" {
+		t.Errorf("folder listing = %s", got)
+	}
+	if got := keys(list("prefix=dir/s")); got != "dir/sub/e.txt" {
+		t.Errorf("partial prefix listing = %s", got)
+	}
+	if got := keys(list("encoding-type=url&prefix=b")); got != "b%20c.txt" {
+		t.Errorf("url encoded listing = %s", got)
+	}
+
+	// Page through the recursive listing two keys at a time
+	var pages []string
+	query := "max-keys=2"
+	for {
+		result := list(query)
+		pages = append(pages, keys(result))
+		if !result.IsTruncated {
+			break
+		}
+		query = "max-keys=2&continuation-token=" + result.NextContinuationToken
+	}
+	if got := strings.Join(pages, "|"); got != "a.txt,b c.txt|dir/d.txt,dir/sub/e.txt|f.txt" {
+		t.Errorf("pages = %s", got)
+	}
+}
+
+func TestHandler_S3API_GetObject(t *testing.T) {
+	h, store := setupS3Handler(t, nil)
+	putStored(t, store, "notes.txt", []byte("0123456789"))
+
+	req := s3Request("GET", "/s3/files/notes.txt", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	w := serveS3(h, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Errorf("ranged GET: got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") == "" {
+		t.Error("ETag header missing")
+	}
+
+	w = serveS3(h, s3Request("HEAD", "/s3/files/notes.txt", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Length") != "10" {
+		t.Errorf("HEAD: got %d, Content-Length %q", w.Code, w.Header().Get("Content-Length"))
+	}
+
+	w = serveS3(h, s3Request("GET", "/s3/files/missing.txt", nil))
+	if w.Code != http.StatusNotFound || s3ErrorCode(w) != "NoSuchKey" {
+		t.Errorf("missing key: got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandler_S3API_DeleteObjects(t *testing.T) {
+	h, store := setupS3Handler(t, nil)
+	putStored(t, store, "one.txt", []byte("1"))
+	putStored(t, store, "two.txt", []byte("2"))
+
+	body := []byte(`<Delete><Object><Key>one.txt</Key></Object><Object><Key>two.txt</Key></Object>` +
+		`<Object><Key>missing.txt</Key></Object><Object><Key>../escape</Key></Object></Delete>`)
+	w := serveS3(h, s3Request("POST", "/s3/files?delete", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var result struct {
+		Deleted []struct{ Key string }
+		Error   []struct{ Key, Code string }
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode result: %v", err)
+	}
+	if len(result.Deleted) != 3 || len(result.Error) != 1 || result.Error[0].Key != "../escape" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if isStored(store, "one.txt") || isStored(store, "two.txt") {
+		t.Error("files were not deleted")
+	}
+}
+
+func TestHandler_S3API_MultipartUpload(t *testing.T) {
+	h, store := setupS3Handler(t, nil)
+
+	w := serveS3(h, s3Request("POST", "/s3/files/big.bin?uploads", nil))
+	var initiated struct{ UploadId string }
+	if err := xml.Unmarshal(w.Body.Bytes(), &initiated); err != nil || initiated.UploadId == "" {
+		t.Fatalf("create: got %d: %s", w.Code, w.Body.String())
+	}
+	target := "/s3/files/big.bin?uploadId=" + initiated.UploadId
+
+	// Parts may arrive in any order
+	etags := make(map[string]string)
+	for _, part := range []struct{ number, data string }{{"2", "world"}, {"1", "hello "}} {
+		w := serveS3(h, s3Request("PUT", target+"&partNumber="+part.number, []byte(part.data)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("part %s: got %d: %s", part.number, w.Code, w.Body.String())
+		}
+		etags[part.number] = w.Header().Get("ETag")
+	}
+
+	// The upload belongs to its key
+	w = serveS3(h, s3Request("PUT", "/s3/files/other.bin?partNumber=1&uploadId="+initiated.UploadId, []byte("x")))
+	if w.Code != http.StatusNotFound || s3ErrorCode(w) != "NoSuchUpload" {
+		t.Errorf("part for another key: got %d: %s", w.Code, w.Body.String())
+	}
+
+	complete := []byte(`<CompleteMultipartUpload>` +
+		`<Part><PartNumber>1</PartNumber><ETag>` + etags["1"] + `</ETag></Part>` +
+		`<Part><PartNumber>2</PartNumber><ETag>` + etags["2"] + `</ETag></Part>` +
+		`</CompleteMultipartUpload>`)
+	w = serveS3(h, s3Request("POST", target, complete))
+	if w.Code != http.StatusOK {
+		t.Fatalf("complete: got %d: %s", w.Code, w.Body.String())
+	}
+	if stored, _ := readStored(store, "big.bin"); string(stored) != "hello world" {
+		t.Errorf("stored content = %q", stored)
+	}
+
+	w = serveS3(h, s3Request("DELETE", target, nil))
+	if w.Code != http.StatusNotFound || s3ErrorCode(w) != "NoSuchUpload" {
+		t.Errorf("abort after completion: got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandler_S3API_Disabled(t *testing.T) {
+	h, _ := setupTestHandler(t)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	_, pattern := mux.Handler(httptest.NewRequest("GET", "/s3/files", nil))
+	if pattern == s3Prefix {
+		t.Error("S3 API is served without configured access keys")
+	}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/service"
+	"fsrv/internal/storage"
+)
+
+// sharePath is where share links point to. It is public: the signature of a
+// link is all the authorization it needs.
+const sharePath = "/share"
+
+// shareResponse is the JSON response describing a new share link
+type shareResponse struct {
+	URL          string    `json:"url"`
+	File         string    `json:"file"`
+	Expires      time.Time `json:"expires"`
+	MaxDownloads int       `json:"maxDownloads,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// CreateShare creates a share link for a file.
+//
+//	POST /mkshare   file=<name>&expires=<duration, e.g. 24h>&max=<downloads, 0 for unlimited>
+//
+// The link is shown on the info page, or returned as JSON when the client asks
+// for it with "Accept: application/json".
+func (h *Handler) CreateShare(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod(w, r, "POST") {
+		return
+	}
+	if err := checkCSRF(r); err != nil {
+		h.shareFailed(w, r, statusFor(err), err.Error())
+		return
+	}
+
+	filename := r.FormValue("file")
+	var ttl time.Duration
+	var maxDownloads int
+	var err error
+	if expires := r.FormValue("expires"); expires != "" {
+		if ttl, err = time.ParseDuration(expires); err != nil || ttl <= 0 {
+			h.shareFailed(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid expiry '%s', expected a duration like 24h", expires))
+			return
+		}
+	}
+	if max := r.FormValue("max"); max != "" {
+		if maxDownloads, err = strconv.Atoi(max); err != nil || maxDownloads < 0 {
+			h.shareFailed(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid download limit '%s'", max))
+			return
+		}
+	}
+
+	// Sharing hands out downloads, so only those who may download can share
+	if err := h.authorize(r, auth.PermDownload, filename); err != nil {
+		h.shareFailed(w, r, statusFor(err), err.Error())
+		return
+	}
+	link, err := h.svc.CreateShareLink(filename, ttl, maxDownloads)
+	if err != nil {
+		log.Printf("Failed to create share link: %v", err)
+		h.shareFailed(w, r, statusFor(err), err.Error())
+		return
+	}
+	log.Printf("Created share link for %s, valid until %s (%s)", link.Filename, link.Expires.Format(time.RFC3339), currentUser(r))
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusCreated, shareResponse{
+			URL:          link.URL,
+			File:         link.Filename,
+			Expires:      link.Expires,
+			MaxDownloads: link.MaxDownloads,
+		})
+		return
+	}
+
+	downloads := "unlimited"
+	if link.MaxDownloads > 0 {
+		downloads = strconv.Itoa(link.MaxDownloads)
+	}
+	h.renderInfo(w, fmt.Sprintf("Share link for '%s':", link.Filename), link.URL,
+		fmt.Sprintf("Valid until: %s", link.Expires.Format("2006-01-02 15:04:05")),
+		fmt.Sprintf("Downloads: %s", downloads))
+}
+
+// shareFailed reports a share link that could not be created
+func (h *Handler) shareFailed(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if wantsJSON(r) {
+		writeJSON(w, status, shareResponse{Error: msg})
+		return
+	}
+	w.WriteHeader(status)
+	h.renderInfo(w, "Failed to create share link", msg)
+}
+
+// Share downloads the file of a share link, without logging in
+func (h *Handler) Share(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.renderInfo(w, "HTTP Method should be 'GET'")
+		return
+	}
+
+	link, err := service.ParseShareLink(r.URL.Query())
+	var file storage.File
+	if err == nil {
+		file, err = h.svc.OpenShared(link, r.Method == http.MethodGet)
+	}
+	if err != nil {
+		log.Printf("Refused share link from %s: %v", r.RemoteAddr, err)
+		w.WriteHeader(statusFor(err))
+		h.renderInfo(w, shareError(err), err.Error())
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		h.renderInfo(w, "Failed to get file info: "+err.Error())
+		return
+	}
+
+	h.setChecksumHeaders(w, link.Filename, fileInfo)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", path.Base(link.Filename)))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, link.Filename, fileInfo.ModTime, file)
+
+	log.Printf("Downloaded file over a share link: %s", link.Filename)
+}
+
+// shareError explains why a share link cannot be used
+func shareError(err error) string {
+	switch {
+	case errors.Is(err, service.ErrShareExpired):
+		return "This share link has expired."
+	case errors.Is(err, service.ErrShareExhausted):
+		return "This share link has been used up: it has reached its maximum number of downloads."
+	case errors.Is(err, service.ErrShareInvalid):
+		return "This share link is not valid. Please check that it was copied completely."
+	case errors.Is(err, service.ErrFileNotExist):
+		return "The shared file no longer exists."
+	default:
+		return "The shared file cannot be downloaded right now."
+	}
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandler_Share(t *testing.T) {
+	handler := setupAuthHandler(t)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	put := httptest.NewRequest("PUT", "/files/report.txt", strings.NewReader("quarterly"))
+	put.SetBasicAuth("alice", "secret")
+	if w := serve(put); w.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d", w.Code)
+	}
+
+	create := func(form url.Values, authenticated bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/mkshare", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		if authenticated {
+			req.SetBasicAuth("alice", "secret")
+		}
+		return serve(req)
+	}
+
+	// Another site can make the browser post the form, but not with the token
+	crossSite := httptest.NewRequest("POST", "/mkshare", strings.NewReader("file=report.txt"))
+	crossSite.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	crossSite.SetBasicAuth("alice", "secret")
+	if w := serve(crossSite); w.Code != http.StatusForbidden {
+		t.Errorf("creating a link from another site = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	if w := create(url.Values{"file": {"report.txt"}}, false); w.Code != http.StatusUnauthorized {
+		t.Errorf("creating a link without credentials = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := create(url.Values{"file": {"report.txt"}, "expires": {"soon"}}, true); w.Code != http.StatusBadRequest {
+		t.Errorf("creating a link with a bad expiry = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := create(url.Values{"file": {"missing.txt"}}, true); w.Code != http.StatusNotFound {
+		t.Errorf("creating a link to a missing file = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w := create(url.Values{"file": {"report.txt"}, "expires": {"1h"}, "max": {"1"}}, true)
+	var link shareResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("creating a link = %d %s", w.Code, w.Body.String())
+	}
+	if link.File != "report.txt" || link.MaxDownloads != 1 {
+		t.Errorf("created link = %+v", link)
+	}
+	target := strings.TrimPrefix(link.URL, "http://localhost:8080")
+
+	// Links work without credentials, HEAD does not use up a download
+	if w := serve(httptest.NewRequest("HEAD", target, nil)); w.Code != http.StatusOK {
+		t.Errorf("HEAD of the link = %d", w.Code)
+	}
+	w = serve(httptest.NewRequest("GET", target, nil))
+	if w.Code != http.StatusOK || w.Body.String() != "quarterly" {
+		t.Errorf("GET of the link = %d %q", w.Code, w.Body.String())
+	}
+	w = serve(httptest.NewRequest("GET", target, nil))
+	if w.Code != http.StatusGone || !strings.Contains(w.Body.String(), "used up") {
+		t.Errorf("GET of a used up link = %d %q", w.Code, w.Body.String())
+	}
+
+	forged := strings.Replace(target, "max=1", "max=100", 1)
+	if w := serve(httptest.NewRequest("GET", forged, nil)); w.Code != http.StatusForbidden {
+		t.Errorf("GET of a forged link = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
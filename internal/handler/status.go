@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/util"
+)
+
+// diskStatus is the space on a disk uploads are written to, in bytes
+type diskStatus struct {
+	Name  string `json:"name"`
+	Total int64  `json:"total"`
+	Free  int64  `json:"free"`
+	Used  int64  `json:"used"`
+}
+
+// quotaStatus is how much of the quotas is used up, in bytes and files
+type quotaStatus struct {
+	Bytes        int64  `json:"bytes"`
+	MaxBytes     int64  `json:"maxBytes,omitempty"`
+	Files        int    `json:"files"`
+	MaxFiles     int    `json:"maxFiles,omitempty"`
+	User         string `json:"user,omitempty"`
+	UserBytes    int64  `json:"userBytes,omitempty"`
+	MaxUserBytes int64  `json:"maxUserBytes,omitempty"`
+}
+
+// statusReport is the JSON response of /status
+type statusReport struct {
+	Disks         []diskStatus `json:"disks"`
+	Reserve       int64        `json:"reserve"`
+	MaxUploadSize int64        `json:"maxUploadSize"`
+	Quota         *quotaStatus `json:"quota,omitempty"` // only with quotas
+	Error         string       `json:"error,omitempty"`
+}
+
+// Status reports as JSON the free and used space on the disks uploads are
+// written to, the reserve uploads must leave free on them and, with quotas, how
+// much of those is used up by the store and the user asking. Only admins of the
+// whole store see the disks.
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := statusReport{
+		Disks:         []diskStatus{},
+		Reserve:       h.svc.DiskReserve(),
+		MaxUploadSize: h.svc.GetMaxUploadSize(),
+	}
+	if h.authorize(r, auth.PermAdmin, "") == nil {
+		for _, disk := range h.svc.Disks() {
+			report.Disks = append(report.Disks, diskStatus{Name: disk.Name, Total: disk.Total, Free: disk.Free, Used: disk.Used})
+		}
+	}
+
+	usage, err := h.svc.Usage(currentUser(r))
+	if err != nil {
+		report.Error = err.Error()
+		writeJSON(w, statusFor(err), report)
+		return
+	}
+	if usage.MaxBytes > 0 || usage.MaxFiles > 0 || usage.MaxUserBytes > 0 {
+		report.Quota = &quotaStatus{
+			Bytes:        usage.Bytes,
+			MaxBytes:     usage.MaxBytes,
+			Files:        usage.Files,
+			MaxFiles:     usage.MaxFiles,
+			User:         usage.User,
+			UserBytes:    usage.UserBytes,
+			MaxUserBytes: usage.MaxUserBytes,
+		}
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// spaceLines describes the free and used space on every disk uploads are
+// written to, for admins of the whole store
+func (h *Handler) spaceLines(r *http.Request) []string {
+	if h.authorize(r, auth.PermAdmin, "") != nil {
+		return nil
+	}
+	var lines []string
+	for _, disk := range h.svc.Disks() {
+		lines = append(lines, fmt.Sprintf("%s: %s free of %s, %s used", disk.Name,
+			util.HumanReadableSize(disk.Free), util.HumanReadableSize(disk.Total), util.HumanReadableSize(disk.Used)))
+	}
+	return lines
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/config"
+	"fsrv/internal/storage"
+	"fsrv/internal/util"
+)
+
+// withReserve keeps bytes of disk space free
+func withReserve(bytes int64) func(*config.Config) {
+	return func(cfg *config.Config) { cfg.DiskReserve = bytes }
+}
+
+// requireDiskSpace skips the test on platforms that cannot tell the disk space
+func requireDiskSpace(t *testing.T) {
+	t.Helper()
+	if _, err := util.StatDisk(os.TempDir()); errors.Is(err, util.ErrDiskSpaceUnsupported) {
+		t.Skip(err)
+	}
+}
+
+func TestHandler_Status(t *testing.T) {
+	requireDiskSpace(t)
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, func(cfg *config.Config) {
+		cfg.DiskReserve = 1 << 20
+		cfg.QuotaBytes = 10
+	})
+	putStored(t, store, "a.txt", []byte("hello"))
+
+	w := httptest.NewRecorder()
+	h.Status(w, httptest.NewRequest("GET", "/status", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /status = %d %s", w.Code, w.Body.String())
+	}
+	var report statusReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if len(report.Disks) != 1 || report.Disks[0].Name != "tmp" || report.Disks[0].Total <= 0 {
+		t.Errorf("disks = %+v, want tmp", report.Disks)
+	}
+	if report.Reserve != 1<<20 || report.MaxUploadSize != 1<<32 {
+		t.Errorf("status = %+v, want the reserve and the upload limit", report)
+	}
+	if report.Quota == nil || report.Quota.Bytes != 5 || report.Quota.MaxBytes != 10 {
+		t.Errorf("quota = %+v, want 5 of 10 bytes", report.Quota)
+	}
+
+	w = httptest.NewRecorder()
+	h.Status(w, httptest.NewRequest("POST", "/status", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHandler_Status_Roles(t *testing.T) {
+	requireDiskSpace(t)
+	h, _ := setupTestHandler(t)
+	handler := requireLogin(t, h, testUser{name: "admin", role: auth.RoleAdmin},
+		testUser{name: "editor", role: auth.RoleEditor}, testUser{name: "team", role: auth.RoleAdmin, dir: "team"})
+
+	// The disks are only shown to admins of the whole store
+	for user, want := range map[string]int{"admin": 1, "editor": 0, "team": 0} {
+		req := httptest.NewRequest("GET", "/status", nil)
+		req.SetBasicAuth(user, "secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var report statusReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("GET /status as %s = %d %s", user, w.Code, w.Body.String())
+		}
+		if len(report.Disks) != want {
+			t.Errorf("disks shown to %s = %+v, want %d", user, report.Disks, want)
+		}
+	}
+}
+
+func TestHandler_DiskReserve(t *testing.T) {
+	requireDiskSpace(t)
+	h, _ := setupTestHandler(t)
+	dir := t.TempDir()
+	store := storage.NewLocal(dir, t.TempDir())
+	h.svc = newTestService(t, store, func(cfg *config.Config) {
+		cfg.Store = dir
+		cfg.DiskReserve = 1 << 62
+	})
+
+	req := httptest.NewRequest("PUT", "/files/a.txt", strings.NewReader("hello"))
+	w := httptest.NewRecorder()
+	h.FileResource(w, req)
+	if w.Code != http.StatusInsufficientStorage || !strings.Contains(w.Body.String(), "in reserve") {
+		t.Errorf("PUT into the reserve = %d %s, want %d", w.Code, w.Body.String(), http.StatusInsufficientStorage)
+	}
+
+	req = httptest.NewRequest("PUT", "/dav/a.txt", strings.NewReader("hello"))
+	w = httptest.NewRecorder()
+	h.WebDAV(w, req)
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("WebDAV PUT into the reserve = %d, want %d", w.Code, http.StatusInsufficientStorage)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "b.txt")
+	part.Write([]byte("hello"))
+	writer.Close()
+	req = httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	h.UploadFile(w, req)
+	var report uploadReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != http.StatusInsufficientStorage {
+		t.Errorf("form upload into the reserve = %d %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(report.Error, "insufficient storage") {
+		t.Errorf("report = %+v, want the disk space named", report)
+	}
+	if isStored(store, "a.txt") || isStored(store, "b.txt") {
+		t.Error("an upload into the reserve was stored")
+	}
+}
+
+func TestHandler_DiskReserve_Memory(t *testing.T) {
+	requireDiskSpace(t)
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, withReserve(1<<62))
+
+	// Memory storage does not write uploads to disk, only resumable uploads are
+	req := httptest.NewRequest("PUT", "/files/a.txt", strings.NewReader("hello"))
+	w := httptest.NewRecorder()
+	h.FileResource(w, req)
+	if w.Code != http.StatusCreated || !isStored(store, "a.txt") {
+		t.Errorf("PUT into memory storage with a disk reserve = %d %s, want %d", w.Code, w.Body.String(), http.StatusCreated)
+	}
+
+	req = tusRequest("POST", "/tus/", "")
+	req.Header.Set("Upload-Length", "5")
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("b.txt")))
+	w = httptest.NewRecorder()
+	h.TusUpload(w, req)
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("resumable upload into the reserve of tmp = %d, want %d", w.Code, http.StatusInsufficientStorage)
+	}
+}
+
+func TestHandler_DiskSpacePages(t *testing.T) {
+	requireDiskSpace(t)
+	h, err := New(newTestService(t, storage.NewMemory(), nil), fstest.MapFS{
+		"files.html":  {Data: []byte(`{{range .Space}}{{.}}{{end}}`)},
+		"upload.html": {Data: []byte(`{{range .Space}}{{.}}{{end}}`)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	pages := map[string]http.HandlerFunc{"/files": h.ListFiles, "/toUpload": h.UploadPage}
+	for target, page := range pages {
+		w := httptest.NewRecorder()
+		page(w, httptest.NewRequest("GET", target, nil))
+		if !strings.HasPrefix(w.Body.String(), "tmp: ") || !strings.Contains(w.Body.String(), " free of ") {
+			t.Errorf("GET %s = %q, want the disk space", target, w.Body.String())
+		}
+	}
+}
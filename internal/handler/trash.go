@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/service"
+)
+
+// trashResponse is the JSON response of the trash API
+type trashResponse struct {
+	Entries  []service.TrashEntry `json:"entries,omitempty"`
+	Restored string               `json:"restored,omitempty"`
+	Purged   string               `json:"purged,omitempty"`
+	Error    string               `json:"error,omitempty"`
+}
+
+// Trash lists the deleted files that can still be restored.
+//
+//	GET  /trash           the trash page, or the entries as JSON
+//	POST /trash/restore   id=<entry>&csrf=<form token>, puts the file back
+//	POST /trash/purge     id=<entry>&csrf=<form token>, deletes the file for good
+//
+// Everyone sees and may restore the entries of the files they may delete, but
+// only admins may purge them.
+func (h *Handler) Trash(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod(w, r, "GET") {
+		return
+	}
+
+	all, err := h.svc.ListTrash()
+	if err != nil {
+		log.Printf("Failed to list the trash: %v", err)
+		h.trashFailed(w, r, statusFor(err), err.Error())
+		return
+	}
+	var entries []service.TrashEntry
+	for _, entry := range all {
+		if h.authorize(r, auth.PermDelete, entry.Name) == nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, trashResponse{Entries: entries})
+		return
+	}
+
+	param := &PageParam{
+		Title: "FSrv Trash",
+		Trash: entries,
+		Empty: len(entries) == 0,
+		User:  currentUser(r),
+		CSRF:  csrfToken(w, r),
+
+		CanPurge: hasPermission(r, auth.PermAdmin),
+	}
+	if !h.svc.IsTrashEnabled() {
+		param.Msgs = []string{"The trash is disabled on this server: deleted files are removed right away."}
+	}
+	h.renderTemplate(w, "trash.html", param)
+}
+
+// RestoreTrash moves a file from the trash back to where it was deleted from
+func (h *Handler) RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.trashEntry(w, r)
+	if !ok {
+		return
+	}
+
+	restored, err := h.svc.RestoreTrash(entry.ID)
+	if err != nil {
+		log.Printf("Failed to restore %s from the trash: %v", entry.Name, err)
+		h.trashFailed(w, r, statusFor(err), fmt.Sprintf("Failed to restore '%s': %v", entry.Name, err))
+		return
+	}
+	log.Printf("Restored file from the trash: %s (%s)", restored, currentUser(r))
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, trashResponse{Restored: restored})
+		return
+	}
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// PurgeTrash permanently deletes a file from the trash, which takes an admin
+func (h *Handler) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.trashEntry(w, r)
+	if !ok {
+		return
+	}
+	if err := h.authorize(r, auth.PermAdmin, entry.Name); err != nil {
+		h.trashFailed(w, r, statusFor(err), err.Error())
+		return
+	}
+
+	if err := h.svc.PurgeTrash(entry.ID); err != nil {
+		log.Printf("Failed to purge %s from the trash: %v", entry.Name, err)
+		h.trashFailed(w, r, statusFor(err), fmt.Sprintf("Failed to delete '%s': %v", entry.Name, err))
+		return
+	}
+	log.Printf("Purged file from the trash: %s (%s)", entry.Name, currentUser(r))
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, trashResponse{Purged: entry.Name})
+		return
+	}
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// trashEntry checks a request to restore or purge a trash entry and returns
+// the entry. If the request is refused, the response has been written.
+func (h *Handler) trashEntry(w http.ResponseWriter, r *http.Request) (*service.TrashEntry, bool) {
+	if r.Method != http.MethodPost {
+		h.trashFailed(w, r, http.StatusMethodNotAllowed, "HTTP Method should be 'POST'")
+		return nil, false
+	}
+	if err := checkCSRF(r); err != nil {
+		h.trashFailed(w, r, statusFor(err), err.Error())
+		return nil, false
+	}
+
+	entry, err := h.svc.GetTrashEntry(r.PostFormValue("id"))
+	if err == nil {
+		err = h.authorize(r, auth.PermDelete, entry.Name)
+	}
+	if err != nil {
+		// Entries out of reach look like missing ones
+		if errors.Is(err, errForbidden) {
+			err = service.ErrTrashNotFound
+		}
+		h.trashFailed(w, r, statusFor(err), err.Error())
+		return nil, false
+	}
+	return entry, true
+}
+
+// trashFailed reports a trash request that could not be carried out
+func (h *Handler) trashFailed(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if wantsJSON(r) {
+		writeJSON(w, status, trashResponse{Error: msg})
+		return
+	}
+	w.WriteHeader(status)
+	h.renderInfo(w, msg)
+}
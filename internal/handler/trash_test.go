@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/config"
+	"fsrv/internal/storage"
+)
+
+// setupTrashHandler returns a handler with subdirectories and a trash
+func setupTrashHandler(t *testing.T) (*Handler, *storage.Memory, http.Handler) {
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, func(cfg *config.Config) {
+		cfg.SubDirs = true
+		cfg.TrashRetention = time.Hour
+	})
+	putStored(t, store, "team/report.txt", []byte("report"))
+	putStored(t, store, "private.txt", []byte("private"))
+
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	return h, store, mux
+}
+
+// listTrash returns the trash entries as seen by the API
+func listTrash(t *testing.T, handler http.Handler, user string) []string {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/trash", nil)
+	req.Header.Set("Accept", "application/json")
+	if user != "" {
+		req.SetBasicAuth(user, "secret")
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var resp trashResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /trash = %d %s", w.Code, w.Body.String())
+	}
+	var names []string
+	for _, entry := range resp.Entries {
+		names = append(names, entry.Name+"@"+entry.ID)
+	}
+	return names
+}
+
+func TestHandler_Trash(t *testing.T) {
+	_, store, handler := setupTrashHandler(t)
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(httptest.NewRequest("DELETE", "/files/team/report.txt", nil)); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(httptest.NewRequest("GET", "/download?file=team/report.txt", nil)); !strings.Contains(w.Body.String(), "does not exist") {
+		t.Errorf("download of a file in the trash = %d %q", w.Code, w.Body.String())
+	}
+	entries := listTrash(t, handler, "")
+	if len(entries) != 1 || !strings.HasPrefix(entries[0], "team/report.txt@") {
+		t.Fatalf("trash entries = %v", entries)
+	}
+	id := strings.TrimPrefix(entries[0], "team/report.txt@")
+
+	// The page lists the entry
+	if w := serve(httptest.NewRequest("GET", "/trash", nil)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "team/report.txt") {
+		t.Errorf("GET /trash page = %d %q", w.Code, w.Body.String())
+	}
+
+	// Restoring changes state, so it needs a form token or a script header
+	req := httptest.NewRequest("POST", "/trash/restore", strings.NewReader("id="+id))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := serve(req); w.Code != http.StatusForbidden {
+		t.Errorf("restore without a form token = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	req = httptest.NewRequest("POST", "/trash/restore", strings.NewReader("id="+id))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Requested-With", "fetch")
+	w := serve(req)
+	var resp trashResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || resp.Restored != "team/report.txt" {
+		t.Fatalf("restore = %d %s", w.Code, w.Body.String())
+	}
+	if got, _ := readStored(store, "team/report.txt"); string(got) != "report" {
+		t.Errorf("restored content = %q, want %q", got, "report")
+	}
+	if w := serve(postForm("/trash/restore", url.Values{"id": {id}})); w.Code != http.StatusNotFound {
+		t.Errorf("second restore = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// Purging from the page deletes the file for good
+	if w := serve(postForm("/del", url.Values{"file": {"private.txt"}})); w.Code != http.StatusOK {
+		t.Fatalf("POST /del = %d %s", w.Code, w.Body.String())
+	}
+	entries = listTrash(t, handler, "")
+	id = strings.TrimPrefix(entries[0], "private.txt@")
+	w = serve(postForm("/trash/purge", url.Values{"id": {id}}))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/trash" {
+		t.Errorf("purge = %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if entries := listTrash(t, handler, ""); len(entries) != 0 {
+		t.Errorf("trash entries after purge = %v", entries)
+	}
+	if w := serve(httptest.NewRequest("GET", "/trash/purge", nil)); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /trash/purge = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHandler_Trash_Roles(t *testing.T) {
+	h, _, _ := setupTrashHandler(t)
+	for _, name := range []string{"team/report.txt", "private.txt"} {
+		if err := h.svc.DeleteFile(name); err != nil {
+			t.Fatalf("DeleteFile() error = %v", err)
+		}
+	}
+	handler := requireLogin(t, h,
+		testUser{name: "admin", role: auth.RoleAdmin},
+		testUser{name: "reader", role: auth.RoleReadOnly},
+		testUser{name: "editor", role: auth.RoleEditor, dir: "team"})
+
+	if entries := listTrash(t, handler, "admin"); len(entries) != 2 {
+		t.Errorf("trash entries of admin = %v, want 2", entries)
+	}
+	if entries := listTrash(t, handler, "reader"); len(entries) != 0 {
+		t.Errorf("trash entries of reader = %v, want none", entries)
+	}
+	entries := listTrash(t, handler, "editor")
+	if len(entries) != 1 || !strings.HasPrefix(entries[0], "team/report.txt@") {
+		t.Fatalf("trash entries of editor = %v", entries)
+	}
+
+	// Entries outside the folder of a user look like missing ones
+	private := ""
+	for _, entry := range listTrash(t, handler, "admin") {
+		if id, ok := strings.CutPrefix(entry, "private.txt@"); ok {
+			private = id
+		}
+	}
+	req := postForm("/trash/restore", url.Values{"id": {private}})
+	req.SetBasicAuth("editor", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "private") {
+		t.Errorf("restore outside the folder = %d %q, want %d", w.Code, w.Body.String(), http.StatusNotFound)
+	}
+
+	// Only admins delete for good
+	id := strings.SplitN(entries[0], "@", 2)[1]
+	req = postForm("/trash/purge", url.Values{"id": {id}})
+	req.SetBasicAuth("editor", "secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || len(listTrash(t, handler, "editor")) != 1 {
+		t.Errorf("purge by an editor = %d, want %d and the entry kept", w.Code, http.StatusForbidden)
+	}
+	req = postForm("/trash/purge", url.Values{"id": {id}})
+	req.SetBasicAuth("admin", "secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther || len(listTrash(t, handler, "editor")) != 0 {
+		t.Errorf("purge by an admin = %d %s, want it purged", w.Code, w.Body.String())
+	}
+}
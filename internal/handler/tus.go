@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fsrv/internal/auth"
+)
+
+// tus protocol constants, see https://tus.io/protocols/resumable-upload
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,creation-with-upload,expiration,termination"
+	tusPrefix     = "/tus/"
+)
+
+// TusUpload implements the tus 1.0 resumable upload protocol.
+//
+//	OPTIONS /tus/       discover server capabilities
+//	POST    /tus/       create an upload (Upload-Length, Upload-Metadata: filename ...)
+//	HEAD    /tus/<id>   query the current offset
+//	PATCH   /tus/<id>   append data at Upload-Offset
+//	DELETE  /tus/<id>   terminate the upload
+//
+// When the last byte arrives the file is committed to the store like a normal upload.
+func (h *Handler) TusUpload(w http.ResponseWriter, r *http.Request) {
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" {
+		method = override
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.svc.GetMaxUploadSize(), 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, tusPrefix), "/")
+	switch {
+	case id == "" && method == http.MethodPost:
+		h.tusCreate(w, r)
+	case id != "" && method == http.MethodHead:
+		h.tusHead(w, r, id)
+	case id != "" && method == http.MethodPatch:
+		h.tusPatch(w, r, id)
+	case id != "" && method == http.MethodDelete:
+		h.tusTerminate(w, r, id)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// tusCreate handles the creation extension, optionally with the first chunk of data
+func (h *Handler) tusCreate(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length header", http.StatusBadRequest)
+		return
+	}
+
+	metadata := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+	if filename == "" {
+		http.Error(w, "Upload-Metadata must contain a filename", http.StatusBadRequest)
+		return
+	}
+	if err := h.authorize(r, auth.PermUpload, filename); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	// The lifetime may be asked for like for other uploads, or in the metadata
+	ttl, err := askedTTL(r)
+	if expires := metadata["expires"]; err == nil && expires != "" {
+		ttl, err = parseTTL(expires)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	upload, err := h.svc.CreateUpload(filename, length, currentUser(r), ttl)
+	if err != nil {
+		log.Printf("Failed to create resumable upload: %v", err)
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	// creation-with-upload: the request body may already carry data
+	if r.ContentLength > 0 && r.Header.Get("Content-Type") == "application/offset+octet-stream" {
+		appended, err := h.svc.AppendUpload(upload.ID, 0, r.Body)
+		if err != nil {
+			log.Printf("Failed to append to resumable upload: %v", err)
+		}
+		if appended != nil {
+			upload = appended
+		}
+	}
+
+	w.Header().Set("Location", tusPrefix+upload.ID)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+
+	log.Printf("Created resumable upload %s for file: %s", upload.ID, upload.Filename)
+}
+
+// tusHead reports how many bytes of an upload have been received
+func (h *Handler) tusHead(w http.ResponseWriter, r *http.Request, id string) {
+	upload, err := h.svc.GetUpload(id)
+	if err == nil {
+		err = h.authorize(r, auth.PermUpload, upload.Filename)
+	}
+	if err != nil {
+		w.WriteHeader(statusFor(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// tusPatch appends the request body to an upload
+func (h *Handler) tusPatch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset header", http.StatusBadRequest)
+		return
+	}
+	if !h.tusAuthorize(w, r, id) {
+		return
+	}
+
+	upload, err := h.svc.AppendUpload(id, offset, r.Body)
+	if upload != nil {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	}
+	if err != nil {
+		log.Printf("Failed to append to resumable upload %s: %v", id, err)
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		log.Printf("Uploaded file successfully: %s (resumable upload %s)", upload.Filename, id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusTerminate discards an upload
+func (h *Handler) tusTerminate(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.tusAuthorize(w, r, id) {
+		return
+	}
+	if err := h.svc.TerminateUpload(id); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusAuthorize answers 403 unless the user of r may upload the file of an upload,
+// so uploads cannot be continued or discarded by users who could not create them
+func (h *Handler) tusAuthorize(w http.ResponseWriter, r *http.Request, id string) bool {
+	upload, err := h.svc.GetUpload(id)
+	if err == nil {
+		err = h.authorize(r, auth.PermUpload, upload.Filename)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return false
+	}
+	return true
+}
+
+// parseTusMetadata decodes an Upload-Metadata header ("key base64value,key2 base64value2")
+func parseTusMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata
+}
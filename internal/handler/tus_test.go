@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// tusRequest builds a request carrying the Tus-Resumable header
+func tusRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	return req
+}
+
+// tusCreate creates an upload through the handler and returns its location
+func tusCreate(t *testing.T, h *Handler, filename string, length string) string {
+	t.Helper()
+
+	req := tusRequest("POST", "/tus/", "")
+	req.Header.Set("Upload-Length", length)
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(filename)))
+	w := httptest.NewRecorder()
+	h.TusUpload(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, tusPrefix) {
+		t.Fatalf("create Location = %q", location)
+	}
+	return location
+}
+
+func TestHandler_TusUpload_Options(t *testing.T) {
+	h, _ := setupTestHandler(t)
+
+	req := httptest.NewRequest("OPTIONS", "/tus/", nil)
+	w := httptest.NewRecorder()
+	h.TusUpload(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("OPTIONS status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := w.Header().Get("Tus-Version"); got != tusVersion {
+		t.Errorf("Tus-Version = %q, want %q", got, tusVersion)
+	}
+	if !strings.Contains(w.Header().Get("Tus-Extension"), "termination") {
+		t.Error("Tus-Extension does not advertise termination")
+	}
+}
+
+func TestHandler_TusUpload_Flow(t *testing.T) {
+	h, store := setupTestHandler(t)
+
+	location := tusCreate(t, h, "artifact.bin", "11")
+
+	// First chunk
+	req := tusRequest("PATCH", location, "hello ")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	w := httptest.NewRecorder()
+	h.TusUpload(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("PATCH status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	if got := w.Header().Get("Upload-Offset"); got != "6" {
+		t.Errorf("PATCH Upload-Offset = %q, want 6", got)
+	}
+
+	// The client lost its connection and asks where to resume
+	req = tusRequest("HEAD", location, "")
+	w = httptest.NewRecorder()
+	h.TusUpload(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HEAD status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Upload-Offset"); got != "6" {
+		t.Errorf("HEAD Upload-Offset = %q, want 6", got)
+	}
+	if got := w.Header().Get("Upload-Length"); got != "11" {
+		t.Errorf("HEAD Upload-Length = %q, want 11", got)
+	}
+
+	// Resuming at the wrong offset is rejected
+	req = tusRequest("PATCH", location, "world")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	w = httptest.NewRecorder()
+	h.TusUpload(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("PATCH with wrong offset status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	// Rest of the data
+	req = tusRequest("PATCH", location, "world")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "6")
+	w = httptest.NewRecorder()
+	h.TusUpload(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("PATCH status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+
+	data, err := readStored(store, "artifact.bin")
+	if err != nil {
+		t.Fatalf("Failed to read uploaded file: %v", err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("uploaded content = %q, want %q", data, "hello world")
+	}
+}
+
+func TestHandler_TusUpload_Terminate(t *testing.T) {
+	h, _ := setupTestHandler(t)
+
+	location := tusCreate(t, h, "a.txt", "100")
+
+	req := tusRequest("DELETE", location, "")
+	w := httptest.NewRecorder()
+	h.TusUpload(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DELETE status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	req = tusRequest("HEAD", location, "")
+	w = httptest.NewRecorder()
+	h.TusUpload(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("HEAD after DELETE status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandler_TusUpload_Errors(t *testing.T) {
+	h, _ := setupTestHandler(t)
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{
+			name: "missing Tus-Resumable",
+			req: func() *http.Request {
+				return httptest.NewRequest("POST", "/tus/", nil)
+			},
+			status: http.StatusPreconditionFailed,
+		},
+		{
+			name: "missing Upload-Length",
+			req: func() *http.Request {
+				return tusRequest("POST", "/tus/", "")
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "missing filename",
+			req: func() *http.Request {
+				req := tusRequest("POST", "/tus/", "")
+				req.Header.Set("Upload-Length", "10")
+				return req
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "too large",
+			req: func() *http.Request {
+				req := tusRequest("POST", "/tus/", "")
+				req.Header.Set("Upload-Length", "99999999999999")
+				req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("big")))
+				return req
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "unknown upload",
+			req: func() *http.Request {
+				return tusRequest("HEAD", "/tus/00000000000000000000000000000000", "")
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "wrong content type",
+			req: func() *http.Request {
+				req := tusRequest("PATCH", "/tus/00000000000000000000000000000000", "data")
+				req.Header.Set("Upload-Offset", "0")
+				return req
+			},
+			status: http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "unsupported method",
+			req: func() *http.Request {
+				return tusRequest("GET", "/tus/", "")
+			},
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.TusUpload(w, tt.req())
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	header := "filename " + base64.StdEncoding.EncodeToString([]byte("report.pdf")) +
+		",filetype " + base64.StdEncoding.EncodeToString([]byte("application/pdf")) +
+		",is_confidential"
+
+	metadata := parseTusMetadata(header)
+	if metadata["filename"] != "report.pdf" {
+		t.Errorf("filename = %q, want report.pdf", metadata["filename"])
+	}
+	if metadata["filetype"] != "application/pdf" {
+		t.Errorf("filetype = %q, want application/pdf", metadata["filetype"])
+	}
+	if _, ok := metadata["is_confidential"]; !ok {
+		t.Error("key without value was dropped")
+	}
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/service"
+)
+
+// versionsResponse is the JSON response of the versions API
+type versionsResponse struct {
+	File     string                `json:"file"`
+	Versions []service.FileVersion `json:"versions,omitempty"`
+	Restored int                   `json:"restored,omitempty"`
+	Error    string                `json:"error,omitempty"`
+}
+
+// Versions shows the previous versions of a file.
+//
+//	GET  /versions?file=<name>             the history page, or the versions as JSON
+//	GET  /download?file=<name>&version=<n> downloads a previous version
+//	POST /versions/restore                 file=<name>&version=<n>&csrf=<form token>
+//
+// Restoring needs permission to overwrite the file; the content it replaces
+// becomes the newest version.
+func (h *Handler) Versions(w http.ResponseWriter, r *http.Request) {
+	if !h.checkMethod(w, r, "GET") {
+		return
+	}
+
+	filename := r.URL.Query().Get("file")
+	if err := h.authorize(r, auth.PermDownload, filename); err != nil {
+		h.versionsFailed(w, r, filename, http.StatusForbidden, err.Error())
+		return
+	}
+	versions, err := h.svc.ListVersions(filename)
+	if err != nil {
+		h.versionsFailed(w, r, filename, statusFor(err), err.Error())
+		return
+	}
+	stored, _ := h.svc.ResolvePath(filename)
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, versionsResponse{File: stored, Versions: versions})
+		return
+	}
+
+	parent := path.Dir(stored)
+	if parent == "." {
+		parent = ""
+	}
+	param := &PageParam{
+		Title:      "FSrv History",
+		Param1:     stored,
+		Param2:     listURL(parent),
+		History:    versions,
+		Empty:      len(versions) == 0,
+		CanRestore: h.authorize(r, auth.PermOverwrite, stored) == nil,
+		User:       currentUser(r),
+		CSRF:       csrfToken(w, r),
+	}
+	if h.svc.IsVersioningEnabled() {
+		param.Msgs = []string{fmt.Sprintf("Up to %d previous versions are kept for each file.", h.svc.MaxVersions())}
+	} else {
+		param.Msgs = []string{"Versioning is disabled on this server: uploads to taken names are refused."}
+	}
+	h.renderTemplate(w, "versions.html", param)
+}
+
+// RestoreVersion makes a previous version the current content of its file
+func (h *Handler) RestoreVersion(w http.ResponseWriter, r *http.Request) {
+	filename := r.PostFormValue("file")
+	if r.Method != http.MethodPost {
+		h.versionsFailed(w, r, filename, http.StatusMethodNotAllowed, "HTTP Method should be 'POST'")
+		return
+	}
+	if err := checkCSRF(r); err != nil {
+		h.versionsFailed(w, r, filename, statusFor(err), err.Error())
+		return
+	}
+	if err := h.authorize(r, auth.PermOverwrite, filename); err != nil {
+		h.versionsFailed(w, r, filename, statusFor(err), err.Error())
+		return
+	}
+
+	number, _ := strconv.Atoi(r.PostFormValue("version"))
+	if err := h.svc.RestoreVersion(filename, number); err != nil {
+		log.Printf("Failed to restore version %d of %s: %v", number, filename, err)
+		h.versionsFailed(w, r, filename, statusFor(err), fmt.Sprintf("Failed to restore version %d: %v", number, err))
+		return
+	}
+	stored, _ := h.svc.ResolvePath(filename)
+	log.Printf("Restored version %d of %s (%s)", number, stored, currentUser(r))
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, versionsResponse{File: stored, Restored: number})
+		return
+	}
+	http.Redirect(w, r, "/versions?file="+url.QueryEscape(stored), http.StatusSeeOther)
+}
+
+// downloadVersion sends a previous version of a file, for DownloadFile
+func (h *Handler) downloadVersion(w http.ResponseWriter, r *http.Request, filename, version string) {
+	number, err := strconv.Atoi(version)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		h.renderInfo(w, fmt.Sprintf("%v: '%s'", service.ErrVersionNotFound, version))
+		return
+	}
+	file, info, err := h.svc.OpenVersion(filename, number)
+	if err != nil {
+		w.WriteHeader(statusFor(err))
+		h.renderInfo(w, err.Error())
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", path.Base(info.Name)))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, info.Name, info.Modified, file)
+	log.Printf("Downloaded version %d of %s", number, info.Name)
+}
+
+// versionsFailed reports a versions request that could not be carried out
+func (h *Handler) versionsFailed(w http.ResponseWriter, r *http.Request, filename string, status int, msg string) {
+	if wantsJSON(r) {
+		writeJSON(w, status, versionsResponse{File: filename, Error: msg})
+		return
+	}
+	w.WriteHeader(status)
+	h.renderInfo(w, msg)
+}
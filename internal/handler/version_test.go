@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"fsrv/internal/auth"
+	"fsrv/internal/config"
+)
+
+// setupVersionHandler returns a handler keeping 3 versions per file
+func setupVersionHandler(t *testing.T) (*Handler, http.Handler) {
+	h, store := setupTestHandler(t)
+	h.svc = newTestService(t, store, func(cfg *config.Config) {
+		cfg.SubDirs = true
+		cfg.Versions = 3
+	})
+	putStored(t, store, "team/app.zip", []byte("build 1"))
+
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	return h, mux
+}
+
+func TestHandler_Versions(t *testing.T) {
+	_, handler := setupVersionHandler(t)
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	download := func(target string) string {
+		w := serve(httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d %s", target, w.Code, w.Body.String())
+		}
+		return w.Body.String()
+	}
+
+	// Uploading to a taken name keeps the previous content
+	req := dropUpload(t, "/upload", "team", "app.zip", "build 2")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	if w := serve(req); w.Code != http.StatusOK {
+		t.Fatalf("upload of a new version = %d %s", w.Code, w.Body.String())
+	}
+	w := serve(httptest.NewRequest("PUT", "/files/team/app.zip", strings.NewReader("build 3")))
+	if w.Code != http.StatusOK {
+		t.Errorf("PUT of a new version = %d, want %d", w.Code, http.StatusOK)
+	}
+	req = httptest.NewRequest("PUT", "/files/team/app.zip", strings.NewReader("build 4"))
+	req.Header.Set("If-None-Match", "*")
+	if w := serve(req); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with If-None-Match = %d, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+
+	if got := download("/download?file=team/app.zip"); got != "build 3" {
+		t.Errorf("current content = %q, want %q", got, "build 3")
+	}
+	if got := download("/download?file=team/app.zip&version=1"); got != "build 1" {
+		t.Errorf("version 1 = %q, want %q", got, "build 1")
+	}
+	if w := serve(httptest.NewRequest("GET", "/download?file=team/app.zip&version=9", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("GET of a missing version = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	req = httptest.NewRequest("GET", "/versions?file=team/app.zip", nil)
+	req.Header.Set("Accept", "application/json")
+	w = serve(req)
+	var resp versionsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Versions) != 2 || resp.Versions[0].Number != 2 {
+		t.Fatalf("GET /versions = %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(httptest.NewRequest("GET", "/versions?file=team/app.zip", nil)); !strings.Contains(w.Body.String(), " v2 v1 can-restore") {
+		t.Errorf("history page = %q", w.Body.String())
+	}
+
+	// Restoring needs a form token and brings the old content back
+	req = httptest.NewRequest("POST", "/versions/restore", strings.NewReader("file=team/app.zip&version=1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := serve(req); w.Code != http.StatusForbidden {
+		t.Errorf("restore without a form token = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w = serve(postForm("/versions/restore", url.Values{"file": {"team/app.zip"}, "version": {"1"}}))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/versions?file=team%2Fapp.zip" {
+		t.Errorf("restore = %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if got := download("/download?file=team/app.zip"); got != "build 1" {
+		t.Errorf("restored content = %q, want %q", got, "build 1")
+	}
+	if got := download("/download?file=team/app.zip&version=3"); got != "build 3" {
+		t.Errorf("version 3 = %q, want the content replaced by the restore", got)
+	}
+}
+
+func TestHandler_Versions_Roles(t *testing.T) {
+	h, _ := setupVersionHandler(t)
+	handler := requireLogin(t, h,
+		testUser{name: "uploader", role: auth.RoleUploader},
+		testUser{name: "editor", role: auth.RoleEditor})
+	serve := func(req *http.Request, user string) *httptest.ResponseRecorder {
+		req.SetBasicAuth(user, "secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Users who may not overwrite files cannot add versions either
+	req := dropUpload(t, "/upload", "team", "app.zip", "build 2")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Accept", "application/json")
+	if w := serve(req, "uploader"); w.Code != http.StatusConflict {
+		t.Errorf("upload to a taken name by an uploader = %d, want %d", w.Code, http.StatusConflict)
+	}
+	req = dropUpload(t, "/upload", "team", "app.zip", "build 2")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	if w := serve(req, "editor"); w.Code != http.StatusOK {
+		t.Fatalf("upload of a new version by an editor = %d %s", w.Code, w.Body.String())
+	}
+
+	if w := serve(httptest.NewRequest("GET", "/versions?file=team/app.zip", nil), "uploader"); strings.Contains(w.Body.String(), "can-restore") ||
+		!strings.Contains(w.Body.String(), " v1") {
+		t.Errorf("history page of an uploader = %q", w.Body.String())
+	}
+	req = postForm("/versions/restore", url.Values{"file": {"team/app.zip"}, "version": {"1"}})
+	if w := serve(req, "uploader"); w.Code != http.StatusForbidden {
+		t.Errorf("restore by an uploader = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"fsrv/internal/blake2b"
+	"fsrv/internal/storage"
+)
+
+// ErrChecksumMismatch is returned for uploads whose content does not match the
+// checksums the client declared for it
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksumsDir is the hidden folder in the store root that holds the checksums
+// of files. Each file has a description named after a hash of its path, like
+// the folders of its versions.
+const checksumsDir = ".fsrv-checksums"
+
+// Checksums are the hex encoded checksums of the content of a file. SHA-256 is
+// always computed, MD5 and BLAKE2b-512 only if enabled.
+type Checksums struct {
+	SHA256  string `json:"sha256"`
+	MD5     string `json:"md5,omitempty"`
+	BLAKE2b string `json:"blake2b,omitempty"`
+}
+
+// checksumInfo is the persisted description of the checksums of a file. They
+// only apply while the file still has the size and modification time they
+// were computed for.
+type checksumInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Checksums
+}
+
+// checksummer computes the enabled checksums of everything written to it
+type checksummer struct {
+	sha256  hash.Hash
+	md5     hash.Hash
+	blake2b hash.Hash
+	w       io.Writer
+}
+
+// newChecksummer returns a checksummer for the checksums enabled in the configuration
+func (s *Service) newChecksummer() *checksummer {
+	c := &checksummer{sha256: sha256.New()}
+	writers := []io.Writer{c.sha256}
+	if s.checksumEnabled("md5") {
+		c.md5 = md5.New()
+		writers = append(writers, c.md5)
+	}
+	if s.checksumEnabled("blake2b") {
+		c.blake2b = blake2b.New512()
+		writers = append(writers, c.blake2b)
+	}
+	c.w = io.MultiWriter(writers...)
+	return c
+}
+
+// checksumEnabled reports whether the optional checksum name is computed
+func (s *Service) checksumEnabled(name string) bool {
+	return slices.Contains(s.cfg.Checksums, name)
+}
+
+func (c *checksummer) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+// checksums returns the checksums of what has been written so far
+func (c *checksummer) checksums() Checksums {
+	sums := Checksums{SHA256: hex.EncodeToString(c.sha256.Sum(nil))}
+	if c.md5 != nil {
+		sums.MD5 = hex.EncodeToString(c.md5.Sum(nil))
+	}
+	if c.blake2b != nil {
+		sums.BLAKE2b = hex.EncodeToString(c.blake2b.Sum(nil))
+	}
+	return sums
+}
+
+// VerifyChecksums returns a reader for src that computes the checksums set in
+// want while it is read. Its final Read fails with ErrChecksumMismatch if any of
+// them differs, so handing it to UploadFile or StoreFile makes a corrupted
+// upload fail like an interrupted one and never land in the store. Checksums
+// are compared as lowercase hex.
+func VerifyChecksums(src io.Reader, want Checksums) io.Reader {
+	v := &verifyingReader{r: src}
+	add := func(name, sum string, h hash.Hash) {
+		if sum != "" {
+			v.checks = append(v.checks, checksumCheck{name: name, want: strings.ToLower(sum), hash: h})
+		}
+	}
+	add("SHA-256", want.SHA256, sha256.New())
+	add("MD5", want.MD5, md5.New())
+	add("BLAKE2b", want.BLAKE2b, blake2b.New512())
+	if len(v.checks) == 0 {
+		return src
+	}
+	return v
+}
+
+// checksumCheck is a checksum a verifyingReader compares
+type checksumCheck struct {
+	name string
+	want string
+	hash hash.Hash
+}
+
+// verifyingReader hashes what is read and compares the checksums at the end
+type verifyingReader struct {
+	r      io.Reader
+	checks []checksumCheck
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	for _, check := range v.checks {
+		check.hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		for _, check := range v.checks {
+			if got := hex.EncodeToString(check.hash.Sum(nil)); got != check.want {
+				return n, fmt.Errorf("%w: the %s of the upload is %s, expected %s", ErrChecksumMismatch, check.name, got, check.want)
+			}
+		}
+	}
+	return n, err
+}
+
+// FileChecksums returns the checksums of a file.
+//
+// Uploads record the checksums of a file while it is received. Files that got
+// into the store another way, such as resumable uploads, moves or restores, are
+// read once to compute them.
+func (s *Service) FileChecksums(filename string) (Checksums, error) {
+	name, err := s.cleanPath(filename)
+	if err != nil {
+		return Checksums{}, err
+	}
+	file, err := s.OpenFile(name)
+	if err != nil {
+		return Checksums{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return Checksums{}, fmt.Errorf("failed to check file: %w", err)
+	}
+	if sums, _, ok := s.recordedChecksums(name, info.Size, info.ModTime); ok {
+		return sums, nil
+	}
+
+	c := s.newChecksummer()
+	if _, err := io.Copy(c, file); err != nil {
+		return Checksums{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	sums := c.checksums()
+	s.recordChecksums(name, info, sums)
+	return sums, nil
+}
+
+// RecordedChecksums returns the checksums of a file described by info, which
+// was opened with OpenFile(filename), if they have been computed already. It
+// never reads the file, so downloads can send them before the first byte; the
+// janitor computes the missing ones in the background. exact is false if the
+// storage keeps modification times too coarsely to be sure the checksums still
+// describe the file, which then only makes a weak validator.
+func (s *Service) RecordedChecksums(filename string, info storage.FileInfo) (sums Checksums, exact, ok bool) {
+	name, err := s.cleanPath(filename)
+	if err != nil {
+		return Checksums{}, false, false
+	}
+	return s.recordedChecksums(name, info.Size, info.ModTime)
+}
+
+// recordMissingChecksums computes the checksums of the files that have none
+// recorded, so that downloads can carry them. It returns how many it computed.
+func (s *Service) recordMissingChecksums() (int, error) {
+	entries, err := s.ListTree("", true)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, entry := range entries {
+		if _, _, ok := s.recordedChecksums(entry.Path, entry.Size, entry.ModTime); ok {
+			continue
+		}
+		// Files that are being replaced or went away in the meantime are left for later
+		if _, err := s.FileChecksums(entry.Path); err == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// recordChecksums saves the checksums of the file stored under a clean path,
+// described by info. A failure is only logged: the checksums are computed
+// again the next time they are needed.
+func (s *Service) recordChecksums(name string, info storage.FileInfo, sums Checksums) {
+	data, err := json.Marshal(checksumInfo{Name: name, Size: info.Size, Modified: info.ModTime, Checksums: sums})
+	if err == nil {
+		_, err = s.store.Put(checksumPath(name), bytes.NewReader(data))
+	}
+	if err != nil {
+		log.Printf("Failed to save the checksums of %s: %v", name, err)
+	}
+}
+
+// recordedChecksums returns the checksums recorded for a clean path, if they
+// were computed for a file of the given size and modification time. exact is
+// false if the times could only be compared to the second, see sameTime.
+func (s *Service) recordedChecksums(name string, size int64, modTime time.Time) (sums Checksums, exact, ok bool) {
+	file, err := s.store.Open(checksumPath(name))
+	if err != nil {
+		return Checksums{}, false, false
+	}
+	defer file.Close()
+
+	var info checksumInfo
+	if err := json.NewDecoder(file).Decode(&info); err != nil {
+		return Checksums{}, false, false
+	}
+	same, exact := sameTime(info.Modified, modTime)
+	if info.Name != name || info.Size != size || !same {
+		return Checksums{}, false, false
+	}
+	// Checksums enabled since then are missing
+	if (s.checksumEnabled("md5") && info.MD5 == "") || (s.checksumEnabled("blake2b") && info.BLAKE2b == "") {
+		return Checksums{}, false, false
+	}
+	return info.Checksums, exact, true
+}
+
+// sameTime reports whether two modification times of a file are the same, and
+// whether that is certain. They are compared at full precision where the
+// storage provides it. Times without fractional seconds come from storages that
+// keep them to the second only, or report them so for a single file like the
+// HEAD requests of S3, and are compared to the second, which cannot tell apart
+// files rewritten within the same second.
+func sameTime(a, b time.Time) (same, exact bool) {
+	if a.Nanosecond() != 0 && b.Nanosecond() != 0 {
+		return a.Equal(b), true
+	}
+	return a.Truncate(time.Second).Equal(b.Truncate(time.Second)), false
+}
+
+// forgetChecksums deletes the checksums recorded for a clean path, once
+// something else is or may be stored there
+func (s *Service) forgetChecksums(name string) {
+	s.store.Delete(checksumPath(name))
+}
+
+// checksumPath returns where the checksums of a clean path are recorded
+func checksumPath(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return path.Join(checksumsDir, hex.EncodeToString(sum[:16])+".json")
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	helloSHA256  = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	helloMD5     = "5d41402abc4b2a76b9719d911017c592"
+	helloBLAKE2b = "e4cfa39a3d37be31c59609e807970799caa68a19bfaa15135f165085e01d41a65ba1e1b146aeb6bd0092b49eac214c103ccfa3a365954bbbe52f74a2b3620c94"
+
+	helloWorldSHA256 = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+)
+
+// listedChecksums returns the checksums ListDir shows for the file name in dir
+func listedChecksums(t *testing.T, svc *Service, dir, name string) Checksums {
+	t.Helper()
+	files, err := svc.ListDir(dir)
+	if err != nil {
+		t.Fatalf("ListDir() error = %v", err)
+	}
+	for _, file := range files {
+		if file.Path == name {
+			return file.Checksums
+		}
+	}
+	t.Fatalf("ListDir(%s) = %+v, want %s in it", dir, files, name)
+	return Checksums{}
+}
+
+func TestService_Checksums(t *testing.T) {
+	for name, svc := range moveStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc.cfg.Checksums = []string{"md5", "blake2b"}
+			if _, err := svc.UploadFile("docs/a.txt", strings.NewReader("hello")); err != nil {
+				t.Fatalf("UploadFile() error = %v", err)
+			}
+
+			// Uploads record their checksums, which the listing shows
+			want := Checksums{SHA256: helloSHA256, MD5: helloMD5, BLAKE2b: helloBLAKE2b}
+			if got := listedChecksums(t, svc, "docs", "docs/a.txt"); got != want {
+				t.Errorf("listed checksums = %+v, want %+v", got, want)
+			}
+			if got, err := svc.FileChecksums("docs/a.txt"); err != nil || got != want {
+				t.Errorf("FileChecksums() = %+v, %v, want %+v", got, err, want)
+			}
+			if files, _ := svc.ListDir(""); len(files) != 1 || files[0].Path != "docs" {
+				t.Errorf("ListDir() = %+v, want only docs", files)
+			}
+
+			// Replacing the file replaces its checksums
+			if _, err := svc.ReplaceFile("docs/a.txt", strings.NewReader("hello world")); err != nil {
+				t.Fatalf("ReplaceFile() error = %v", err)
+			}
+			if got := listedChecksums(t, svc, "docs", "docs/a.txt"); got.SHA256 != helloWorldSHA256 {
+				t.Errorf("listed SHA-256 after replace = %s, want %s", got.SHA256, helloWorldSHA256)
+			}
+
+			// A moved file has its checksums computed when they are asked for
+			if _, err := svc.MoveFile("docs/a.txt", "b.txt", false); err != nil {
+				t.Fatalf("MoveFile() error = %v", err)
+			}
+			if got := listedChecksums(t, svc, "", "b.txt"); got != (Checksums{}) && got.SHA256 != helloWorldSHA256 {
+				t.Errorf("listed checksums after move = %+v", got)
+			}
+			if got, err := svc.FileChecksums("b.txt"); err != nil || got.SHA256 != helloWorldSHA256 {
+				t.Errorf("FileChecksums() after move = %+v, %v", got, err)
+			}
+			if got := listedChecksums(t, svc, "", "b.txt"); got.SHA256 != helloWorldSHA256 {
+				t.Errorf("listed SHA-256 after computing = %s, want %s", got.SHA256, helloWorldSHA256)
+			}
+		})
+	}
+}
+
+func TestService_Checksums_Resumable(t *testing.T) {
+	for name, svc := range moveStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc.cfg.Checksums = []string{"md5", "blake2b"}
+			upload, err := svc.CreateUpload("a.txt", 5, "", 0)
+			if err != nil {
+				t.Fatalf("CreateUpload() error = %v", err)
+			}
+			if _, err := svc.AppendUpload(upload.ID, 0, strings.NewReader("hello")); err != nil {
+				t.Fatalf("AppendUpload() error = %v", err)
+			}
+
+			// Completed resumable uploads record their checksums like other uploads
+			want := Checksums{SHA256: helloSHA256, MD5: helloMD5, BLAKE2b: helloBLAKE2b}
+			if got := listedChecksums(t, svc, "", "a.txt"); got != want {
+				t.Errorf("listed checksums = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestService_Checksums_Stale(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	if _, err := svc.UploadFile("a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+
+	// Content changed behind the service's back no longer matches the recorded checksums
+	if _, err := store.Put("a.txt", strings.NewReader("hello world")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if got := listedChecksums(t, svc, "", "a.txt"); got != (Checksums{}) {
+		t.Errorf("listed checksums of a changed file = %+v, want none", got)
+	}
+	if got, _ := svc.FileChecksums("a.txt"); got.SHA256 != helloWorldSHA256 || got.MD5 != "" {
+		t.Errorf("FileChecksums() = %+v, want the SHA-256 of the new content only", got)
+	}
+
+	// Enabling another checksum computes it for files recorded without it
+	svc.cfg.Checksums = []string{"md5"}
+	if got := listedChecksums(t, svc, "", "a.txt"); got != (Checksums{}) {
+		t.Errorf("listed checksums without MD5 = %+v, want none", got)
+	}
+	if got, _ := svc.FileChecksums("a.txt"); got.MD5 == "" {
+		t.Errorf("FileChecksums() = %+v, want an MD5", got)
+	}
+
+	// Deleted files lose their checksums
+	svc.cfg.TrashRetention = 0
+	if err := svc.DeleteFile("a.txt"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	if _, err := store.Stat(checksumPath("a.txt")); err == nil {
+		t.Error("checksums of a deleted file are still recorded")
+	}
+}
+
+func TestService_RecordMissingChecksums(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	if _, err := store.Put("a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	info, err := store.Stat("a.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+
+	// Downloads never compute checksums themselves, the janitor does
+	if sums, _, ok := svc.RecordedChecksums("a.txt", info); ok {
+		t.Errorf("RecordedChecksums() of a file stored behind the service's back = %+v", sums)
+	}
+	if n, err := svc.recordMissingChecksums(); err != nil || n != 1 {
+		t.Errorf("recordMissingChecksums() = %d, %v, want 1", n, err)
+	}
+	if sums, exact, ok := svc.RecordedChecksums("a.txt", info); !ok || !exact || sums.SHA256 != helloSHA256 {
+		t.Errorf("RecordedChecksums() after the janitor = %+v, %v, %v", sums, exact, ok)
+	}
+
+	// Storages that report times to the second only cannot be sure of them
+	info.ModTime = info.ModTime.Truncate(time.Second)
+	if _, exact, ok := svc.RecordedChecksums("a.txt", info); !ok || exact {
+		t.Errorf("RecordedChecksums() to the second = %v, %v, want a match that is not exact", exact, ok)
+	}
+	info.ModTime = info.ModTime.Add(-time.Second)
+	if _, _, ok := svc.RecordedChecksums("a.txt", info); ok {
+		t.Error("RecordedChecksums() of an older file matched")
+	}
+	if n, err := svc.recordMissingChecksums(); err != nil || n != 0 {
+		t.Errorf("recordMissingChecksums() again = %d, %v, want 0", n, err)
+	}
+}
+
+func TestSameTime(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		a, b        time.Time
+		same, exact bool
+	}{
+		{"equal", base.Add(1500 * time.Millisecond), base.Add(1500 * time.Millisecond), true, true},
+		{"same second", base.Add(1500 * time.Millisecond), base.Add(1700 * time.Millisecond), false, true},
+		{"truncated", base.Add(1500 * time.Millisecond), base.Add(time.Second), true, false},
+		{"whole seconds", base, base, true, false},
+		{"other second", base.Add(2500 * time.Millisecond), base.Add(time.Second), false, false},
+	}
+	for _, tt := range tests {
+		if same, exact := sameTime(tt.a, tt.b); same != tt.same || exact != tt.exact {
+			t.Errorf("%s: sameTime() = %v, %v, want %v, %v", tt.name, same, exact, tt.same, tt.exact)
+		}
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	read := func(want Checksums) error {
+		_, err := io.ReadAll(VerifyChecksums(strings.NewReader("hello"), want))
+		return err
+	}
+	for _, want := range []Checksums{{}, {SHA256: helloSHA256}, {MD5: strings.ToUpper(helloMD5)}, {SHA256: helloSHA256, BLAKE2b: helloBLAKE2b}} {
+		if err := read(want); err != nil {
+			t.Errorf("reading with %+v error = %v", want, err)
+		}
+	}
+	for _, want := range []Checksums{{SHA256: helloWorldSHA256}, {SHA256: helloSHA256, MD5: strings.Repeat("0", 32)}} {
+		if err := read(want); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("reading with %+v error = %v, want %v", want, err, ErrChecksumMismatch)
+		}
+	}
+}
+
+func TestService_UploadFile_ChecksumMismatch(t *testing.T) {
+	for name, svc := range moveStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc.cfg.Versions = 2
+			wrong := Checksums{SHA256: helloWorldSHA256}
+			if _, err := svc.UploadFile("docs/a.txt", VerifyChecksums(strings.NewReader("hello"), wrong)); !errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("UploadFile() error = %v, want %v", err, ErrChecksumMismatch)
+			}
+			if svc.exists("docs/a.txt") {
+				t.Error("an upload with the wrong checksum was stored")
+			}
+
+			// A replacement that does not match leaves the file and its versions alone
+			if _, err := svc.UploadFile("docs/a.txt", VerifyChecksums(strings.NewReader("hello"), Checksums{SHA256: helloSHA256})); err != nil {
+				t.Fatalf("UploadFile() error = %v", err)
+			}
+			if _, err := svc.ReplaceFile("docs/a.txt", VerifyChecksums(strings.NewReader("corrupted"), wrong)); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("ReplaceFile() error = %v, want %v", err, ErrChecksumMismatch)
+			}
+			if got := readFile(t, svc, "docs/a.txt"); got != "hello" {
+				t.Errorf("content = %q, want hello", got)
+			}
+			if versions, _ := svc.ListVersions("docs/a.txt"); len(versions) != 0 {
+				t.Errorf("versions = %+v, want none", versions)
+			}
+		})
+	}
+}
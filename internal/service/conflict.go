@@ -0,0 +1,119 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrInvalidConflictPolicy is returned for conflict policies that do not exist
+var ErrInvalidConflictPolicy = errors.New("invalid conflict policy, expected fail, overwrite or rename")
+
+// ConflictPolicy decides what an upload does when its name is taken
+type ConflictPolicy string
+
+// Conflict policies
+const (
+	ConflictFail      ConflictPolicy = "fail"      // refuse the upload with ErrFileExists
+	ConflictOverwrite ConflictPolicy = "overwrite" // replace the file, keeping a version if enabled
+	ConflictRename    ConflictPolicy = "rename"    // store the upload as "name (1).ext" and so on
+)
+
+// maxRenames is how many numbered names rename tries before giving up
+const maxRenames = 1000
+
+// StoredFile describes where an upload ended up
+type StoredFile struct {
+	Name     string // clean path the file is stored under
+	Size     int64
+	Replaced bool      // an existing file was overwritten
+	Renamed  bool      // the name was taken, so a numbered one was used
+	Expires  time.Time // zero if the file is kept until it is deleted
+}
+
+// ParseConflictPolicy parses the name of a conflict policy
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(strings.ToLower(strings.TrimSpace(name))); policy {
+	case ConflictFail, ConflictOverwrite, ConflictRename:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidConflictPolicy, name)
+	}
+}
+
+// ConflictPolicy returns what uploads do by default when their name is taken:
+// the configured policy, or overwrite with versioning, which keeps the previous
+// content as a version, and fail otherwise
+func (s *Service) ConflictPolicy() ConflictPolicy {
+	if s.cfg.OnConflict != "" {
+		return ConflictPolicy(s.cfg.OnConflict)
+	}
+	if s.IsVersioningEnabled() {
+		return ConflictOverwrite
+	}
+	return ConflictFail
+}
+
+// StoreFile saves an uploaded file like UploadFile, applying policy if the name
+// is taken, and reports where it ended up. The file counts toward the quota of
+// owner, the user uploading it, unless that is empty, and is kept for ttl, see
+// FileTTL.
+//
+// Renaming numbers the name before its extension, "report (1).pdf", "report
+// (2).pdf" and so on, taking the first name that is neither stored nor being
+// uploaded. Folders are never overwritten, whatever the policy.
+func (s *Service) StoreFile(filename string, src io.Reader, policy ConflictPolicy, owner string, ttl time.Duration) (StoredFile, error) {
+	safeFilename, err := s.cleanPath(filename)
+	if err != nil {
+		return StoredFile{}, err
+	}
+
+	switch policy {
+	case ConflictFail, ConflictOverwrite:
+		if !s.locks.reserve(safeFilename) {
+			return StoredFile{}, fmt.Errorf("%w: '%s'", ErrFileBusy, safeFilename)
+		}
+		defer s.locks.unreserve(safeFilename)
+		stored, err := s.save(safeFilename, src, policy == ConflictOverwrite, owner, ttl)
+		stored.Name = safeFilename
+		return stored, err
+
+	case ConflictRename:
+		for i := 0; i <= maxRenames; i++ {
+			name := numberedName(safeFilename, i)
+			if !s.locks.reserve(name) {
+				continue
+			}
+			if s.exists(name) {
+				s.locks.unreserve(name)
+				continue
+			}
+			stored, err := s.save(name, src, false, owner, ttl)
+			s.locks.unreserve(name)
+			stored.Name, stored.Renamed = name, i > 0
+			return stored, err
+		}
+		return StoredFile{}, fmt.Errorf("%w: '%s' and %d numbered names", ErrFileExists, safeFilename, maxRenames)
+
+	default:
+		return StoredFile{}, fmt.Errorf("%w: '%s'", ErrInvalidConflictPolicy, policy)
+	}
+}
+
+// numberedName returns the clean path name with " (n)" inserted before its
+// extension, or name itself for n == 0
+func numberedName(name string, n int) string {
+	if n == 0 {
+		return name
+	}
+	dir, base := path.Split(name)
+	ext := path.Ext(base)
+	if ext == base {
+		// A name like ".env" is all extension
+		ext = ""
+	}
+	return dir + fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(base, ext), n, ext)
+}
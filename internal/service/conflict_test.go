@@ -0,0 +1,116 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNumberedName(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"report.pdf", 0, "report.pdf"},
+		{"report.pdf", 1, "report (1).pdf"},
+		{"docs/2024/report.pdf", 12, "docs/2024/report (12).pdf"},
+		{"archive.tar.gz", 2, "archive.tar (2).gz"},
+		{"Makefile", 1, "Makefile (1)"},
+		{".env", 1, ".env (1)"},
+		{"v1.2/notes", 3, "v1.2/notes (3)"},
+	}
+	for _, tt := range tests {
+		if got := numberedName(tt.name, tt.n); got != tt.want {
+			t.Errorf("numberedName(%q, %d) = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, name := range []string{"fail", "overwrite", " Rename "} {
+		if _, err := ParseConflictPolicy(name); err != nil {
+			t.Errorf("ParseConflictPolicy(%q) error = %v", name, err)
+		}
+	}
+	if _, err := ParseConflictPolicy("merge"); !errors.Is(err, ErrInvalidConflictPolicy) {
+		t.Errorf("ParseConflictPolicy(merge) error = %v, want %v", err, ErrInvalidConflictPolicy)
+	}
+}
+
+func TestService_StoreFile(t *testing.T) {
+	for name, svc := range moveStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := func(content string, policy ConflictPolicy) (StoredFile, error) {
+				return svc.StoreFile("docs/a.txt", strings.NewReader(content), policy, "", 0)
+			}
+
+			if stored, err := store("first", ConflictFail); err != nil || stored.Name != "docs/a.txt" || stored.Size != 5 {
+				t.Fatalf("StoreFile() = %+v, %v", stored, err)
+			}
+			if _, err := store("second", ConflictFail); !errors.Is(err, ErrFileExists) {
+				t.Errorf("StoreFile() with fail error = %v, want %v", err, ErrFileExists)
+			}
+
+			for i, want := range []string{"docs/a (1).txt", "docs/a (2).txt"} {
+				stored, err := store("renamed", ConflictRename)
+				if err != nil || stored.Name != want || !stored.Renamed || stored.Replaced {
+					t.Errorf("StoreFile() with rename #%d = %+v, %v, want %s", i+1, stored, err, want)
+				}
+			}
+			if got := readFile(t, svc, "docs/a.txt"); got != "first" {
+				t.Errorf("content after renamed uploads = %q, want first", got)
+			}
+
+			stored, err := store("replaced", ConflictOverwrite)
+			if err != nil || stored.Name != "docs/a.txt" || !stored.Replaced || stored.Renamed {
+				t.Errorf("StoreFile() with overwrite = %+v, %v", stored, err)
+			}
+			if got := readFile(t, svc, "docs/a.txt"); got != "replaced" {
+				t.Errorf("content after overwrite = %q, want replaced", got)
+			}
+
+			// A free name is used as is, whatever the policy
+			stored, err = svc.StoreFile("docs/b.txt", strings.NewReader("b"), ConflictRename, "", 0)
+			if err != nil || stored.Name != "docs/b.txt" || stored.Renamed {
+				t.Errorf("StoreFile() with rename of a free name = %+v, %v", stored, err)
+			}
+			// Folders are never overwritten
+			if _, err := svc.StoreFile("docs", strings.NewReader("x"), ConflictOverwrite, "", 0); !errors.Is(err, ErrFileExists) {
+				t.Errorf("StoreFile() over a folder error = %v, want %v", err, ErrFileExists)
+			}
+		})
+	}
+}
+
+func TestService_StoreFile_RenameSkipsUploads(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	if _, err := svc.UploadFile("a.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+
+	// A name that is still being uploaded counts as taken
+	if !svc.locks.reserve("a (1).txt") {
+		t.Fatal("reserve() failed")
+	}
+	stored, err := svc.StoreFile("a.txt", strings.NewReader("b"), ConflictRename, "", 0)
+	svc.locks.unreserve("a (1).txt")
+	if err != nil || stored.Name != "a (2).txt" {
+		t.Errorf("StoreFile() = %+v, %v, want a (2).txt", stored, err)
+	}
+}
+
+func TestService_ConflictPolicy(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	if got := svc.ConflictPolicy(); got != ConflictFail {
+		t.Errorf("default ConflictPolicy() = %s, want fail", got)
+	}
+	svc.cfg.Versions = 3
+	if got := svc.ConflictPolicy(); got != ConflictOverwrite {
+		t.Errorf("ConflictPolicy() with versioning = %s, want overwrite", got)
+	}
+	svc.cfg.OnConflict = "rename"
+	if got := svc.ConflictPolicy(); got != ConflictRename {
+		t.Errorf("configured ConflictPolicy() = %s, want rename", got)
+	}
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"fsrv/internal/storage"
+	"fsrv/internal/util"
+)
+
+// Errors returned by the folder operations
+var (
+	ErrDirNotExist     = errors.New("directory does not exist")
+	ErrDirNotEmpty     = errors.New("directory is not empty")
+	ErrSubDirsDisabled = errors.New("subdirectories are disabled")
+)
+
+// ListDir returns the contents of a folder relative to the store root, "" being the root.
+//
+// Folders come first, sorted by name, followed by files sorted by modification
+// time (newest first). Without subdirectory support only the root can be listed
+// and folders are left out. Staging files, the trash, the versions and the
+// checksums of files are never listed, nor are files whose lifetime is over.
+// Files carry their checksums if they have been computed already, and their
+// remaining lifetime if it is limited.
+func (s *Service) ListDir(dir string) ([]File, error) {
+	cleanDir, err := s.cleanDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.store.List(cleanDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: '%s'", ErrDirNotExist, cleanDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	// Sort folders by name and files by modification time (newest first)
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].IsDir != files[j].IsDir {
+			return files[i].IsDir
+		}
+		if files[i].IsDir {
+			return files[i].Name < files[j].Name
+		}
+		return files[i].ModTime.After(files[j].ModTime)
+	})
+
+	var result []File
+	for _, file := range files {
+		fileName := file.Name
+		filePath := path.Join(cleanDir, fileName)
+		if isHidden(filePath) {
+			continue
+		}
+
+		if file.IsDir {
+			if s.cfg.SubDirs {
+				folder := File{Filename: fileName, Path: filePath, IsDir: true}
+				// Object stores do not keep modification times for folders
+				if !file.ModTime.IsZero() {
+					folder.ModifyTime = file.ModTime.Format("2006-01-02 15:04:05")
+				}
+				result = append(result, folder)
+			}
+			continue
+		}
+
+		// Files whose lifetime is over are as good as deleted
+		expires := s.expiryOf(filePath)
+		if !expires.IsZero() && !expires.After(time.Now()) {
+			continue
+		}
+
+		downloadURL := fmt.Sprintf("%s/download?file=%s", s.getURLRoot(), escapePath(filePath))
+		sums, _, _ := s.recordedChecksums(filePath, file.Size, file.ModTime)
+		result = append(result, File{
+			Filename:     fileName,
+			Path:         filePath,
+			DownloadLink: downloadURL,
+			Size:         util.HumanReadableSize(file.Size),
+			ModifyTime:   file.ModTime.Format("2006-01-02 15:04:05"),
+			Curl:         fmt.Sprintf("curl -L -o '%s' '%s'", fileName, downloadURL),
+			Checksums:    sums,
+			Expires:      remainingLifetime(expires),
+		})
+	}
+
+	return result, nil
+}
+
+// Entry is a file or folder in the store with its path relative to the store root
+type Entry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// ListTree returns the entries of a folder relative to the store root, in no
+// particular order. With recursive it returns all files below the folder instead,
+// leaving out the folders themselves. Files whose lifetime is over are left out.
+func (s *Service) ListTree(dir string, recursive bool) ([]Entry, error) {
+	cleanDir, err := s.cleanDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.store.List(cleanDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: '%s'", ErrDirNotExist, cleanDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var entries []Entry
+	for _, file := range files {
+		entry := Entry{Path: path.Join(cleanDir, file.Name), Size: file.Size, ModTime: file.ModTime, IsDir: file.IsDir}
+		switch {
+		case isHidden(entry.Path):
+		case !entry.IsDir && s.isExpired(entry.Path):
+		case !entry.IsDir:
+			entries = append(entries, entry)
+		case !s.cfg.SubDirs:
+		case recursive:
+			children, err := s.ListTree(entry.Path, true)
+			// The folder may have been removed in the meantime
+			if err != nil && !errors.Is(err, ErrDirNotExist) {
+				return nil, err
+			}
+			entries = append(entries, children...)
+		default:
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// MakeDir creates a folder, including missing parents. It returns the clean path of the folder.
+func (s *Service) MakeDir(dir string) (string, error) {
+	if !s.cfg.SubDirs {
+		return "", ErrSubDirsDisabled
+	}
+	cleanDir, err := s.cleanDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if cleanDir == "" || strings.HasPrefix(path.Base(cleanDir), storage.StagingPrefix) {
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidFilename, dir)
+	}
+
+	if s.exists(cleanDir) {
+		return "", fmt.Errorf("%w: '%s'", ErrFileExists, cleanDir)
+	}
+	if err := s.store.Mkdir(cleanDir); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+	return cleanDir, nil
+}
+
+// RemoveDir removes an empty folder, if delete is enabled. The store root cannot be removed.
+func (s *Service) RemoveDir(dir string) error {
+	if !s.cfg.SubDirs {
+		return ErrSubDirsDisabled
+	}
+	if !s.cfg.DelAble {
+		return ErrDeleteDisabled
+	}
+	cleanDir, err := s.cleanDir(dir)
+	if err != nil {
+		return err
+	}
+	if cleanDir == "" {
+		return fmt.Errorf("%w: cannot remove the store root", ErrInvalidFilename)
+	}
+
+	l := s.locks.acquire(cleanDir)
+	defer s.locks.release(cleanDir, l)
+	l.Lock()
+	defer l.Unlock()
+
+	info, err := s.store.Stat(cleanDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: '%s'", ErrDirNotExist, cleanDir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check directory: %w", err)
+	}
+	if !info.IsDir {
+		return fmt.Errorf("%w: '%s' is a file", ErrDirNotExist, cleanDir)
+	}
+
+	err = s.store.Delete(cleanDir)
+	if errors.Is(err, storage.ErrNotEmpty) {
+		return fmt.Errorf("%w: '%s'", ErrDirNotEmpty, cleanDir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove directory: %w", err)
+	}
+	return nil
+}
+
+// ResolvePath returns the clean path relative to the store root that a client
+// supplied filename refers to, i.e. the name the file is stored under.
+func (s *Service) ResolvePath(name string) (string, error) {
+	return s.cleanPath(name)
+}
+
+// cleanPath turns a client supplied file path into a clean slash separated path
+// relative to the store root. Without subdirectory support every path is flattened
+// to its base name, as fsrv has always done.
+func (s *Service) cleanPath(name string) (string, error) {
+	var clean string
+	if s.cfg.SubDirs {
+		var err error
+		if clean, err = util.SafePath(name); err != nil {
+			return "", fmt.Errorf("%w: '%s'", ErrInvalidFilename, name)
+		}
+	} else {
+		clean = util.SafeFileName(name)
+	}
+
+	base := path.Base(clean)
+	if clean == "" || base == "." || base == ".." || strings.HasPrefix(base, storage.StagingPrefix) || isHidden(clean) {
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidFilename, name)
+	}
+	return clean, nil
+}
+
+// cleanDir is like cleanPath for folders, returning "" for the store root.
+// Without subdirectory support only the root is accepted.
+func (s *Service) cleanDir(dir string) (string, error) {
+	clean, err := util.SafePath(dir)
+	if err != nil {
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidFilename, dir)
+	}
+	if clean != "" && !s.cfg.SubDirs {
+		return "", fmt.Errorf("%w: '%s'", ErrDirNotExist, clean)
+	}
+	if isHidden(clean) {
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidFilename, dir)
+	}
+	return clean, nil
+}
+
+// isHidden reports whether a clean path lies in one of the folders fsrv keeps
+// its own data in, the trash, the versions and the checksums of files, and the
+// blobs of a deduplicating store, or is the owners file, which clients cannot
+// address directly
+func isHidden(name string) bool {
+	return isWithin(name, trashDir) || isWithin(name, versionsDir) || isWithin(name, checksumsDir) ||
+		isWithin(name, storage.DedupDir) || name == ownersFile || name == expiriesFile
+}
+
+// IsMetadata reports whether a clean path is one of the files fsrv keeps its
+// bookkeeping in: the checksums of files, the descriptions of versions and of
+// files in the trash, and the owners and expiries of files. Unlike the content
+// of files, versions and the trash, a deduplicating store keeps them as they are.
+func IsMetadata(name string) bool {
+	return name == ownersFile || name == expiriesFile || isWithin(name, checksumsDir) ||
+		((isWithin(name, versionsDir) || isWithin(name, trashDir)) && strings.HasSuffix(name, ".json"))
+}
+
+// escapePath escapes a relative path for use in a query string, keeping slashes readable
+func escapePath(p string) string {
+	return strings.ReplaceAll(url.QueryEscape(p), "%2F", "/")
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupSubDirService returns a test service with subdirectory support enabled
+func setupSubDirService(t *testing.T) (*Service, string) {
+	svc, tmpDir := setupTestService(t)
+	svc.cfg.SubDirs = true
+	return svc, tmpDir
+}
+
+func TestService_ListDir(t *testing.T) {
+	svc, tmpDir := setupSubDirService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "docs", "2024"), 0755); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "docs", "readme.txt"), []byte("read me"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "root.txt"), []byte("root"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	root, err := svc.ListDir("")
+	if err != nil {
+		t.Fatalf("ListDir() error = %v", err)
+	}
+	if len(root) != 2 || !root[0].IsDir || root[0].Path != "docs" || root[1].Path != "root.txt" {
+		t.Errorf("ListDir(\"\") = %+v, want folder docs then root.txt", root)
+	}
+
+	docs, err := svc.ListDir("docs")
+	if err != nil {
+		t.Fatalf("ListDir() error = %v", err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("ListDir(\"docs\") returned %d entries, want 2", len(docs))
+	}
+	if !docs[0].IsDir || docs[0].Path != "docs/2024" {
+		t.Errorf("first entry = %+v, want folder docs/2024", docs[0])
+	}
+	if docs[1].IsDir || docs[1].Path != "docs/readme.txt" || docs[1].Filename != "readme.txt" {
+		t.Errorf("second entry = %+v, want file docs/readme.txt", docs[1])
+	}
+	if docs[1].DownloadLink != "http://localhost:8080/download?file=docs/readme.txt" {
+		t.Errorf("DownloadLink = %q", docs[1].DownloadLink)
+	}
+}
+
+func TestService_ListDir_Errors(t *testing.T) {
+	svc, tmpDir := setupSubDirService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	if _, err := svc.ListDir("missing"); !errors.Is(err, ErrDirNotExist) {
+		t.Errorf("ListDir(missing) error = %v, want ErrDirNotExist", err)
+	}
+	if _, err := svc.ListDir("../"); !errors.Is(err, ErrInvalidFilename) {
+		t.Errorf("ListDir(../) error = %v, want ErrInvalidFilename", err)
+	}
+
+	// Without subdirectory support only the root exists
+	svc.cfg.SubDirs = false
+	if err := os.Mkdir(filepath.Join(tmpDir, "docs"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if _, err := svc.ListDir("docs"); !errors.Is(err, ErrDirNotExist) {
+		t.Errorf("ListDir(docs) without subdirs error = %v, want ErrDirNotExist", err)
+	}
+}
+
+func TestService_ListTree(t *testing.T) {
+	svc, tmpDir := setupSubDirService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	for _, name := range []string{"root.txt", "docs/readme.txt", "docs/2024/report.txt"} {
+		if _, err := svc.UploadFile(name, bytes.NewReader([]byte(name))); err != nil {
+			t.Fatalf("UploadFile() error = %v", err)
+		}
+	}
+	if _, err := svc.MakeDir("empty"); err != nil {
+		t.Fatalf("MakeDir() error = %v", err)
+	}
+
+	paths := func(entries []Entry) map[string]bool {
+		result := make(map[string]bool)
+		for _, entry := range entries {
+			result[entry.Path] = entry.IsDir
+		}
+		return result
+	}
+
+	entries, err := svc.ListTree("docs", false)
+	if err != nil {
+		t.Fatalf("ListTree() error = %v", err)
+	}
+	if got := paths(entries); len(got) != 2 || got["docs/readme.txt"] || !got["docs/2024"] {
+		t.Errorf("ListTree(docs) = %v", got)
+	}
+
+	entries, err = svc.ListTree("", true)
+	if err != nil {
+		t.Fatalf("recursive ListTree() error = %v", err)
+	}
+	if got := paths(entries); len(got) != 3 || !contains(got, "root.txt", "docs/readme.txt", "docs/2024/report.txt") {
+		t.Errorf("recursive ListTree() = %v", got)
+	}
+
+	if _, err := svc.ListTree("missing", true); !errors.Is(err, ErrDirNotExist) {
+		t.Errorf("ListTree() of a missing folder error = %v, want %v", err, ErrDirNotExist)
+	}
+}
+
+// contains reports whether all names are keys of m
+func contains(m map[string]bool, names ...string) bool {
+	for _, name := range names {
+		if _, ok := m[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func TestService_UploadFile_SubDir(t *testing.T) {
+	svc, tmpDir := setupSubDirService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	content := []byte("nested content")
+	if _, err := svc.UploadFile("a/b/c.txt", bytes.NewReader(content)); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "a", "b", "c.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read uploaded file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Uploaded file content does not match")
+	}
+
+	// Download and delete by relative path
+	file, err := svc.OpenFile("a/b/c.txt")
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	file.Close()
+
+	if err := svc.DeleteFile("a/b/c.txt"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+}
+
+func TestService_SubDir_PathTraversal(t *testing.T) {
+	svc, tmpDir := setupSubDirService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	outside := filepath.Join(filepath.Dir(tmpDir), "outside.txt")
+	defer os.Remove(outside)
+
+	for _, name := range []string{"../outside.txt", "a/../../outside.txt", `..\outside.txt`, "", "a/.."} {
+		if _, err := svc.UploadFile(name, bytes.NewReader([]byte("x"))); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("UploadFile(%q) error = %v, want ErrInvalidFilename", name, err)
+		}
+		if _, err := svc.OpenFile(name); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("OpenFile(%q) error = %v, want ErrInvalidFilename", name, err)
+		}
+		if err := svc.DeleteFile(name); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("DeleteFile(%q) error = %v, want ErrInvalidFilename", name, err)
+		}
+	}
+
+	if _, err := os.Stat(outside); !os.IsNotExist(err) {
+		t.Error("Upload escaped the store directory")
+	}
+}
+
+func TestService_MakeDir_RemoveDir(t *testing.T) {
+	svc, tmpDir := setupSubDirService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	created, err := svc.MakeDir("/projects//fsrv/")
+	if err != nil {
+		t.Fatalf("MakeDir() error = %v", err)
+	}
+	if created != "projects/fsrv" {
+		t.Errorf("MakeDir() = %q, want projects/fsrv", created)
+	}
+	if info, err := os.Stat(filepath.Join(tmpDir, "projects", "fsrv")); err != nil || !info.IsDir() {
+		t.Fatal("MakeDir() did not create the folder")
+	}
+
+	if _, err := svc.MakeDir("projects"); !errors.Is(err, ErrFileExists) {
+		t.Errorf("MakeDir() of existing folder error = %v, want ErrFileExists", err)
+	}
+
+	if err := svc.RemoveDir("projects"); !errors.Is(err, ErrDirNotEmpty) {
+		t.Errorf("RemoveDir() of non-empty folder error = %v, want ErrDirNotEmpty", err)
+	}
+	if err := svc.RemoveDir("projects/fsrv"); err != nil {
+		t.Errorf("RemoveDir() error = %v", err)
+	}
+	if err := svc.RemoveDir("projects/fsrv"); !errors.Is(err, ErrDirNotExist) {
+		t.Errorf("RemoveDir() of missing folder error = %v, want ErrDirNotExist", err)
+	}
+	if err := svc.RemoveDir(""); !errors.Is(err, ErrInvalidFilename) {
+		t.Errorf("RemoveDir() of the root error = %v, want ErrInvalidFilename", err)
+	}
+}
+
+func TestService_MakeDir_Disabled(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	if _, err := svc.MakeDir("docs"); !errors.Is(err, ErrSubDirsDisabled) {
+		t.Errorf("MakeDir() error = %v, want ErrSubDirsDisabled", err)
+	}
+	if err := svc.RemoveDir("docs"); !errors.Is(err, ErrSubDirsDisabled) {
+		t.Errorf("RemoveDir() error = %v, want ErrSubDirsDisabled", err)
+	}
+}
+
+func TestService_ResolvePath(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	if got, _ := svc.ResolvePath("a/b/c.txt"); got != "c.txt" {
+		t.Errorf("ResolvePath() without subdirs = %q, want c.txt", got)
+	}
+
+	svc.cfg.SubDirs = true
+	if got, _ := svc.ResolvePath("/a//b/c.txt"); got != "a/b/c.txt" {
+		t.Errorf("ResolvePath() with subdirs = %q, want a/b/c.txt", got)
+	}
+}
+
+func TestIsMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{ownersFile, true},
+		{expiriesFile, true},
+		{checksumPath("a.txt"), true},
+		{versionPath("a.txt", 1) + ".json", true},
+		{versionPath("a.txt", 1), false},
+		{trashPath("abc") + ".json", true},
+		{trashPath("abc"), false},
+		{"a.json", false},
+		{"docs/" + ownersFile, false},
+	}
+	for _, tt := range tests {
+		if got := IsMetadata(tt.name); got != tt.want {
+			t.Errorf("IsMetadata(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"fsrv/internal/util"
+)
+
+// ErrInsufficientStorage is returned for uploads that would leave less free disk
+// space than the configured reserve, or that the disk has no room for at all
+var ErrInsufficientStorage = errors.New("insufficient storage")
+
+// diskCheckInterval is how many bytes of an upload are received between checks
+// of the free disk space
+const diskCheckInterval = 32 << 20
+
+// Disk is the space on a filesystem uploads are written to
+type Disk struct {
+	Name string // "store" or "tmp"
+	Path string
+	util.DiskSpace
+}
+
+// Disks returns the space on the filesystems of the tmp directory, which
+// resumable and multipart uploads are received into, and, for local storage, of
+// the store. Filesystems whose space cannot be found out, such as on platforms
+// without support for it, are left out.
+func (s *Service) Disks() []Disk {
+	dirs := []Disk{{Name: "tmp", Path: s.tmpDir()}}
+	if s.isLocal() {
+		dirs = append([]Disk{{Name: "store", Path: s.cfg.Store}}, dirs...)
+	}
+
+	var disks []Disk
+	for _, disk := range dirs {
+		space, err := util.StatDisk(disk.Path)
+		if err != nil {
+			continue
+		}
+		disk.DiskSpace = space
+		disks = append(disks, disk)
+	}
+	return disks
+}
+
+// DiskReserve returns the disk space uploads must leave free
+func (s *Service) DiskReserve() int64 {
+	return s.cfg.DiskReserve
+}
+
+// isLocal reports whether the store is a folder on a local disk, and so is
+// written to along with the tmp directory, which local storage stages files in
+func (s *Service) isLocal() bool {
+	return (s.cfg.Storage == "" || s.cfg.Storage == "local") && s.cfg.Store != ""
+}
+
+// CheckDiskSpace checks whether an upload of size bytes into the store leaves
+// the reserve free on every disk the storage writes it to, failing with
+// ErrInsufficientStorage if not. Storage that keeps files off the local disks,
+// in memory or S3, writes to none. A negative size, for uploads whose size is
+// not known, only checks that the reserve is still free. Uploads are checked
+// again as they are received.
+func (s *Service) CheckDiskSpace(size int64) error {
+	return s.checkDiskSpace(size, false)
+}
+
+// checkDiskSpace implements CheckDiskSpace. staged checks the tmp directory
+// whatever the storage, for uploads that are received there before they are
+// stored, such as resumable and multipart uploads.
+func (s *Service) checkDiskSpace(size int64, staged bool) error {
+	size = max(size, 0)
+	for _, disk := range s.Disks() {
+		if disk.Name == "tmp" && !staged && !s.isLocal() {
+			continue
+		}
+		if disk.Free-size < s.cfg.DiskReserve {
+			return fmt.Errorf("%w: %s needed, %s free on the %s disk with %s kept in reserve",
+				ErrInsufficientStorage, util.HumanReadableSize(size), util.HumanReadableSize(disk.Free),
+				disk.Name, util.HumanReadableSize(s.cfg.DiskReserve))
+		}
+	}
+	return nil
+}
+
+// diskReader returns src checking the free disk space before the first byte is
+// read from it and every diskCheckInterval bytes after that, so that an upload
+// of unknown size fails with ErrInsufficientStorage once it eats into the
+// reserve. staged is as for checkDiskSpace.
+func (s *Service) diskReader(src io.Reader, staged bool) io.Reader {
+	return &diskReader{r: src, s: s, staged: staged}
+}
+
+// diskReader checks the free disk space as an upload is read
+type diskReader struct {
+	r       io.Reader
+	s       *Service
+	staged  bool
+	checked bool
+	read    int64 // bytes read since the last check
+}
+
+func (d *diskReader) Read(p []byte) (int, error) {
+	if !d.checked || d.read >= diskCheckInterval {
+		if err := d.s.checkDiskSpace(0, d.staged); err != nil {
+			return 0, err
+		}
+		d.checked, d.read = true, 0
+	}
+	n, err := d.r.Read(p)
+	d.read += int64(n)
+	return n, err
+}
+
+// diskError turns err into ErrInsufficientStorage if it comes from a full disk
+func diskError(err error) error {
+	if err != nil && util.IsDiskFull(err) {
+		return fmt.Errorf("%w: %v", ErrInsufficientStorage, err)
+	}
+	return err
+}
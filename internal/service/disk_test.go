@@ -0,0 +1,81 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"fsrv/internal/util"
+)
+
+func TestService_Disks(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+	if _, err := util.StatDisk(tmpDir); errors.Is(err, util.ErrDiskSpaceUnsupported) {
+		t.Skip(err)
+	}
+
+	disks := svc.Disks()
+	if len(disks) != 2 || disks[0].Name != "store" || disks[0].Path != tmpDir || disks[1].Name != "tmp" {
+		t.Fatalf("Disks() = %+v, want the store and tmp", disks)
+	}
+	for _, disk := range disks {
+		if disk.Total <= 0 || disk.Free > disk.Total {
+			t.Errorf("Disks() = %+v, want sensible sizes", disk)
+		}
+	}
+
+	// Storage elsewhere only writes uploads to tmp
+	memory, _ := setupMemoryService(t)
+	if disks := memory.Disks(); len(disks) != 1 || disks[0].Name != "tmp" {
+		t.Errorf("Disks() of memory storage = %+v, want tmp", disks)
+	}
+}
+
+func TestService_CheckDiskSpace(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+	if _, err := util.StatDisk(tmpDir); errors.Is(err, util.ErrDiskSpaceUnsupported) {
+		t.Skip(err)
+	}
+
+	if err := svc.CheckDiskSpace(5); err != nil {
+		t.Errorf("CheckDiskSpace() without a reserve error = %v", err)
+	}
+	if err := svc.CheckDiskSpace(1 << 62); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("CheckDiskSpace() of more than the disk error = %v, want %v", err, ErrInsufficientStorage)
+	}
+
+	// With all of the disk kept in reserve, nothing fits
+	svc.cfg.DiskReserve = 1 << 62
+	if _, err := svc.UploadFile("a.txt", strings.NewReader("hello")); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("UploadFile() into the reserve error = %v, want %v", err, ErrInsufficientStorage)
+	}
+	if svc.exists("a.txt") {
+		t.Error("an upload into the reserve was stored")
+	}
+	if _, err := svc.CreateUpload("b.txt", 5, "", 0); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("CreateUpload() into the reserve error = %v, want %v", err, ErrInsufficientStorage)
+	}
+
+	// A resumable upload created with room left is refused once it runs out
+	svc.cfg.DiskReserve = 0
+	upload, err := svc.CreateUpload("b.txt", 5, "", 0)
+	if err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+	svc.cfg.DiskReserve = 1 << 62
+	if _, err := svc.AppendUpload(upload.ID, 0, strings.NewReader("hello")); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("AppendUpload() into the reserve error = %v, want %v", err, ErrInsufficientStorage)
+	}
+
+	// Memory storage only writes resumable and multipart uploads to disk
+	memory, _ := setupMemoryService(t)
+	memory.cfg.DiskReserve = 1 << 62
+	if _, err := memory.UploadFile("a.txt", strings.NewReader("hello")); err != nil {
+		t.Errorf("UploadFile() into memory with a disk reserve error = %v", err)
+	}
+	if _, err := memory.CreateUpload("b.txt", 5, "", 0); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("CreateUpload() into the reserve of tmp error = %v, want %v", err, ErrInsufficientStorage)
+	}
+}
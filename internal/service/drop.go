@@ -0,0 +1,236 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fsrv/internal/util"
+)
+
+// Errors returned by the drop link methods
+var (
+	ErrDropNotFound = errors.New("drop link not found")
+	ErrDropExpired  = errors.New("drop link has expired")
+	ErrDropFull     = errors.New("drop link has received all the files it accepts")
+)
+
+// DefaultDropTTL is how long drop links last unless asked otherwise
+const DefaultDropTTL = 7 * 24 * time.Hour
+
+// dropDir is the directory below the tmp directory that holds drop links
+const dropDir = "drops"
+
+// DropLink lets people without an account upload files into a single folder,
+// without seeing anything that is in the store.
+//
+// Unlike share links, drop links are not signed: the URL only holds a random
+// ID, and the link itself is kept in the tmp directory along with the number
+// of files it received.
+type DropLink struct {
+	ID       string    `json:"-"`
+	Dir      string    `json:"dir"`
+	MaxSize  int64     `json:"maxSize"`  // per file
+	MaxFiles int       `json:"maxFiles"` // 0 for unlimited
+	Files    int       `json:"files"`    // files received or being received
+	Expires  time.Time `json:"expires"`
+	URL      string    `json:"-"` // absolute URL of the upload page
+}
+
+// Remaining returns how many more files the link accepts, or -1 for unlimited
+func (l *DropLink) Remaining() int {
+	if l.MaxFiles == 0 {
+		return -1
+	}
+	return max(l.MaxFiles-l.Files, 0)
+}
+
+// CreateDropLink creates a link to upload files into dir. The link lasts ttl, or
+// DefaultDropTTL if ttl is not positive, but never longer than the configured
+// maximum for share links. Each file may be up to maxSize bytes, capped at the
+// maximum upload size, and the link accepts maxFiles files, 0 meaning unlimited.
+func (s *Service) CreateDropLink(dir string, ttl time.Duration, maxSize int64, maxFiles int) (*DropLink, error) {
+	cleanDir, err := s.cleanDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if cleanDir != "" {
+		info, err := s.store.Stat(cleanDir)
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.IsDir) {
+			return nil, fmt.Errorf("%w: '%s'", ErrDirNotExist, cleanDir)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check folder: %w", err)
+		}
+	}
+	if maxFiles < 0 {
+		return nil, fmt.Errorf("invalid file limit: %d", maxFiles)
+	}
+	if maxSize <= 0 || maxSize > s.GetMaxUploadSize() {
+		maxSize = s.GetMaxUploadSize()
+	}
+	if ttl <= 0 {
+		ttl = DefaultDropTTL
+	}
+	if maxTTL := s.GetShareMaxTTL(); ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	link := &DropLink{
+		ID:       id,
+		Dir:      cleanDir,
+		MaxSize:  maxSize,
+		MaxFiles: maxFiles,
+		Expires:  time.Now().Add(ttl).Truncate(time.Second),
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.tmpDir(), dropDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create drop link directory: %w", err)
+	}
+	s.dropMu.Lock()
+	defer s.dropMu.Unlock()
+	if err := s.writeDropLink(link); err != nil {
+		return nil, err
+	}
+	link.URL = s.getURLRoot() + "/drop/" + id
+	return link, nil
+}
+
+// GetDropLink returns a drop link that has not expired
+func (s *Service) GetDropLink(id string) (*DropLink, error) {
+	s.dropMu.Lock()
+	defer s.dropMu.Unlock()
+	return s.readDropLink(id)
+}
+
+// DropFile uploads a file through a drop link. Only the base name of filename
+// is used, the file always goes into the folder of the link. It returns the
+// path the file was stored as.
+//
+// A slot is taken from the file limit before any data is received, so
+// concurrent uploads cannot exceed it; a failed upload gives the slot back.
+func (s *Service) DropFile(id, filename string, src io.Reader) (string, int64, error) {
+	link, err := s.takeDropSlot(id)
+	if err != nil {
+		return "", 0, err
+	}
+
+	name := path.Join(link.Dir, path.Base(strings.ReplaceAll(filename, "\\", "/")))
+	limited := &maxReader{r: io.LimitReader(src, link.MaxSize+1), max: link.MaxSize}
+	size, err := s.UploadFile(name, limited)
+	if err != nil {
+		s.returnDropSlot(id)
+		if errors.Is(err, ErrFileTooLarge) {
+			return "", 0, fmt.Errorf("%w: max upload file size is %s", ErrFileTooLarge, util.HumanReadableSize(link.MaxSize))
+		}
+		return "", 0, err
+	}
+
+	stored, _ := s.cleanPath(name)
+	return stored, size, nil
+}
+
+// purgeExpiredDrops removes drop links that have expired
+func (s *Service) purgeExpiredDrops() (int, error) {
+	matches, err := filepath.Glob(filepath.Join(s.tmpDir(), dropDir, "*.json"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find drop links: %w", err)
+	}
+
+	s.dropMu.Lock()
+	defer s.dropMu.Unlock()
+
+	removed := 0
+	for _, match := range matches {
+		_, err := s.readDropLink(strings.TrimSuffix(filepath.Base(match), ".json"))
+		if errors.Is(err, ErrDropExpired) {
+			if err := os.Remove(match); err == nil {
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+// takeDropSlot counts a file against the limit of a drop link
+func (s *Service) takeDropSlot(id string) (*DropLink, error) {
+	s.dropMu.Lock()
+	defer s.dropMu.Unlock()
+
+	link, err := s.readDropLink(id)
+	if err != nil {
+		return nil, err
+	}
+	if link.Remaining() == 0 {
+		return nil, ErrDropFull
+	}
+	link.Files++
+	if err := s.writeDropLink(link); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// returnDropSlot gives back a slot taken by takeDropSlot
+func (s *Service) returnDropSlot(id string) {
+	s.dropMu.Lock()
+	defer s.dropMu.Unlock()
+
+	link, err := s.readDropLink(id)
+	if err != nil && !errors.Is(err, ErrDropExpired) {
+		return
+	}
+	link.Files--
+	s.writeDropLink(link)
+}
+
+// readDropLink loads a drop link; an expired one is returned along with
+// ErrDropExpired. The caller must hold s.dropMu.
+func (s *Service) readDropLink(id string) (*DropLink, error) {
+	if !validID(id) {
+		return nil, ErrDropNotFound
+	}
+	data, err := os.ReadFile(s.dropPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrDropNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read drop link: %w", err)
+	}
+
+	link := &DropLink{ID: id, URL: s.getURLRoot() + "/drop/" + id}
+	if err := json.Unmarshal(data, link); err != nil {
+		return nil, fmt.Errorf("failed to decode drop link: %w", err)
+	}
+	if time.Now().After(link.Expires) {
+		return link, fmt.Errorf("%w on %s", ErrDropExpired, link.Expires.Format("2006-01-02 15:04:05"))
+	}
+	return link, nil
+}
+
+// writeDropLink saves a drop link. The caller must hold s.dropMu.
+func (s *Service) writeDropLink(link *DropLink) error {
+	data, err := json.Marshal(link)
+	if err != nil {
+		return fmt.Errorf("failed to encode drop link: %w", err)
+	}
+	if err := os.WriteFile(s.dropPath(link.ID), data, 0644); err != nil {
+		return fmt.Errorf("failed to save drop link: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) dropPath(id string) string {
+	return filepath.Join(s.tmpDir(), dropDir, id+".json")
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_DropLink(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	svc.cfg.SubDirs = true
+	if _, err := svc.MakeDir("vendor"); err != nil {
+		t.Fatalf("MakeDir() error = %v", err)
+	}
+
+	link, err := svc.CreateDropLink("/vendor/", time.Hour, 0, 2)
+	if err != nil {
+		t.Fatalf("CreateDropLink() error = %v", err)
+	}
+	if link.URL != "http://localhost:8080/drop/"+link.ID || link.Dir != "vendor" || link.MaxSize != svc.GetMaxUploadSize() {
+		t.Errorf("CreateDropLink() = %+v", link)
+	}
+
+	// Files always land in the folder of the link
+	stored, size, err := svc.DropFile(link.ID, "../../etc/app.log", strings.NewReader("log line"))
+	if err != nil || stored != "vendor/app.log" || size != 8 {
+		t.Fatalf("DropFile() = %q, %d, %v, want vendor/app.log", stored, size, err)
+	}
+	if got, _ := readMemory(store, "vendor/app.log"); got != "log line" {
+		t.Errorf("stored content = %q", got)
+	}
+	if stored, _, err := svc.DropFile(link.ID, `C:\logs\crash.log`, strings.NewReader("x")); err != nil || stored != "vendor/crash.log" {
+		t.Fatalf("DropFile() with a Windows path = %q, %v", stored, err)
+	}
+
+	if _, _, err := svc.DropFile(link.ID, "third.log", strings.NewReader("x")); !errors.Is(err, ErrDropFull) {
+		t.Errorf("DropFile() beyond the cap error = %v, want %v", err, ErrDropFull)
+	}
+	if got, err := svc.GetDropLink(link.ID); err != nil || got.Remaining() != 0 {
+		t.Errorf("GetDropLink() = %+v, %v, want no files remaining", got, err)
+	}
+
+	if _, err := svc.GetDropLink(strings.Repeat("0", 32)); !errors.Is(err, ErrDropNotFound) {
+		t.Errorf("GetDropLink() of an unknown link error = %v, want %v", err, ErrDropNotFound)
+	}
+	if _, err := svc.CreateDropLink("missing", time.Hour, 0, 0); !errors.Is(err, ErrDirNotExist) {
+		t.Errorf("CreateDropLink() of a missing folder error = %v, want %v", err, ErrDirNotExist)
+	}
+}
+
+func TestService_DropLink_Limits(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	svc.cfg.ShareMaxTTL = 2 * time.Hour
+
+	link, err := svc.CreateDropLink("", 1000*time.Hour, 4, 1)
+	if err != nil {
+		t.Fatalf("CreateDropLink() error = %v", err)
+	}
+	if time.Until(link.Expires) > 2*time.Hour {
+		t.Errorf("CreateDropLink() expires %s, want at most the configured 2h", link.Expires)
+	}
+
+	// A rejected file does not use up the link
+	if _, _, err := svc.DropFile(link.ID, "big.log", strings.NewReader("12345")); !errors.Is(err, ErrFileTooLarge) || !strings.Contains(err.Error(), "4 B") {
+		t.Errorf("DropFile() of an oversized file error = %v, want %v with the limit of the link", err, ErrFileTooLarge)
+	}
+	if _, err := store.Stat("big.log"); err == nil {
+		t.Error("oversized file was kept")
+	}
+	if _, _, err := svc.DropFile(link.ID, "ok.log", strings.NewReader("1234")); err != nil {
+		t.Errorf("DropFile() within the limits error = %v", err)
+	}
+
+	expired, err := svc.CreateDropLink("", time.Hour, 0, 0)
+	if err != nil {
+		t.Fatalf("CreateDropLink() error = %v", err)
+	}
+	expired.Expires = time.Now().Add(-time.Minute)
+	svc.writeDropLink(expired)
+	if _, _, err := svc.DropFile(expired.ID, "late.log", strings.NewReader("x")); !errors.Is(err, ErrDropExpired) {
+		t.Errorf("DropFile() over an expired link error = %v, want %v", err, ErrDropExpired)
+	}
+	if n, err := svc.purgeExpiredDrops(); err != nil || n != 1 {
+		t.Errorf("purgeExpiredDrops() = %d, %v, want 1", n, err)
+	}
+	if _, err := svc.GetDropLink(expired.ID); !errors.Is(err, ErrDropNotFound) {
+		t.Errorf("GetDropLink() after purge error = %v, want %v", err, ErrDropNotFound)
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"fsrv/internal/util"
+)
+
+// expiriesFile records when the files with a limited lifetime expire
+const expiriesFile = ".fsrv-expiries.json"
+
+// expiries keeps track of when files expire. Files kept until they are deleted
+// are not in it.
+type expiries struct {
+	saveMu sync.Mutex // serializes writing the expiries file
+
+	mu     sync.Mutex // guards the fields below
+	loaded bool
+	times  map[string]time.Time // clean path -> when it expires
+}
+
+// FileTTL returns how long a file whose uploader asked to keep it for asked is
+// kept: the server default for 0, and no longer than the maximum. 0 keeps the
+// file until it is deleted.
+func (s *Service) FileTTL(asked time.Duration) time.Duration {
+	ttl := asked
+	if ttl <= 0 {
+		ttl = s.cfg.FileTTL
+	}
+	if maxTTL := s.cfg.FileMaxTTL; maxTTL > 0 && (ttl <= 0 || ttl > maxTTL) {
+		ttl = maxTTL
+	}
+	return ttl
+}
+
+// FileMaxTTL returns how long files are kept at most, 0 for no limit
+func (s *Service) FileMaxTTL() time.Duration {
+	return s.cfg.FileMaxTTL
+}
+
+// expiryOf returns when the file under the clean path name expires, or the zero
+// time if it is kept until it is deleted
+func (s *Service) expiryOf(name string) time.Time {
+	var expires time.Time
+	s.withExpiries(func(times map[string]time.Time) bool {
+		expires = times[name]
+		return false
+	})
+	return expires
+}
+
+// isExpired reports whether the lifetime of the file under the clean path name
+// is over. Such a file is as good as deleted until the janitor gets to it.
+func (s *Service) isExpired(name string) bool {
+	expires := s.expiryOf(name)
+	return !expires.IsZero() && !expires.After(time.Now())
+}
+
+// remainingLifetime describes how long a file expiring at expires has left,
+// "" if it is kept until it is deleted
+func remainingLifetime(expires time.Time) string {
+	if expires.IsZero() {
+		return ""
+	}
+	left := time.Until(expires)
+	if left <= 0 {
+		return "expired"
+	}
+	return util.HumanReadableDuration(left)
+}
+
+// setExpiry records that the file under the clean path name, which has just
+// been stored, expires after ttl, or never for 0. It returns when it expires.
+func (s *Service) setExpiry(name string, ttl time.Duration) time.Time {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).Truncate(time.Second)
+	}
+	s.withExpiries(func(times map[string]time.Time) bool {
+		if expires.IsZero() {
+			_, changed := times[name]
+			delete(times, name)
+			return changed
+		}
+		times[name] = expires
+		return true
+	})
+	return expires
+}
+
+// expiryRemoved forgets when the file or folder under the clean path name and
+// everything in it expires, once it has been deleted for good
+func (s *Service) expiryRemoved(name string) {
+	s.withExpiries(func(times map[string]time.Time) bool {
+		changed := false
+		for file := range times {
+			if isWithin(file, name) {
+				delete(times, file)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// expiryMoved accounts for the file or folder under the clean path from having
+// been moved to the clean path to. Files keep their expiry wherever they go,
+// but the janitor leaves alone those in the trash or the versions.
+func (s *Service) expiryMoved(from, to string) {
+	s.withExpiries(func(times map[string]time.Time) bool {
+		moved := make(map[string]time.Time)
+		for name, expires := range times {
+			if isWithin(name, from) {
+				moved[to+strings.TrimPrefix(name, from)] = expires
+				delete(times, name)
+			}
+		}
+		for name, expires := range moved {
+			times[name] = expires
+		}
+		return len(moved) > 0
+	})
+}
+
+// purgeExpiredFiles deletes the files whose lifetime is over for good, logging
+// every one of them
+func (s *Service) purgeExpiredFiles() (int, error) {
+	now := time.Now()
+	var due []string
+	s.withExpiries(func(times map[string]time.Time) bool {
+		for name, expires := range times {
+			if !isHidden(name) && !expires.After(now) {
+				due = append(due, name)
+			}
+		}
+		return false
+	})
+
+	removed := 0
+	var errs []error
+	for _, name := range due {
+		// The file may have been uploaded again in the meantime
+		expires := s.expiryOf(name)
+		if expires.IsZero() || expires.After(now) {
+			continue
+		}
+		err := s.deleteFile(name, false)
+		switch {
+		case err == nil:
+			log.Printf("Deleted expired file: %s (expired %s)", name, expires.Format(time.RFC3339))
+			removed++
+		case errors.Is(err, ErrFileNotExist):
+			// Deleted behind the service's back
+			s.expiryRemoved(name)
+		case errors.Is(err, ErrFileBusy):
+			// Being replaced, which sets a new expiry
+		default:
+			errs = append(errs, err)
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// withExpiries calls fn with the expiries of the files, loading them first if
+// need be, and saves them if fn reports a change. The expiries must not be
+// changed if they cannot be loaded, so fn is not called then.
+func (s *Service) withExpiries(fn func(times map[string]time.Time) bool) {
+	e := &s.expiries
+	e.mu.Lock()
+	if !e.loaded {
+		times, err := s.readExpiries()
+		if err != nil {
+			e.mu.Unlock()
+			log.Printf("Failed to load the expiries of files: %v", err)
+			return
+		}
+		e.times, e.loaded = times, true
+	}
+	changed := fn(e.times)
+	e.mu.Unlock()
+
+	if changed {
+		s.saveExpiries()
+	}
+}
+
+// readExpiries loads when the files in the store expire
+func (s *Service) readExpiries() (map[string]time.Time, error) {
+	times := make(map[string]time.Time)
+	file, err := s.store.Open(expiriesFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return times, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read expiries: %w", err)
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&times); err != nil {
+		return nil, fmt.Errorf("failed to decode expiries: %w", err)
+	}
+	return times, nil
+}
+
+// saveExpiries writes when the files in the store expire. Failing to do so is
+// logged: the expiries are still known until the server restarts.
+func (s *Service) saveExpiries() {
+	e := &s.expiries
+	e.saveMu.Lock()
+	defer e.saveMu.Unlock()
+
+	// Encoding after taking saveMu makes the last write the newest
+	e.mu.Lock()
+	data, err := json.Marshal(e.times)
+	e.mu.Unlock()
+	if err == nil {
+		_, err = s.store.Put(expiriesFile, bytes.NewReader(data))
+	}
+	if err != nil {
+		log.Printf("Failed to save the expiries of files: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expire makes the file under name of svc expire an hour ago
+func expire(svc *Service, name string) {
+	svc.withExpiries(func(times map[string]time.Time) bool {
+		times[name] = time.Now().Add(-time.Hour)
+		return true
+	})
+}
+
+// listedExpiry returns the remaining lifetime ListDir shows for name in dir
+func listedExpiry(t *testing.T, svc *Service, dir, name string) string {
+	t.Helper()
+	files, err := svc.ListDir(dir)
+	if err != nil {
+		t.Fatalf("ListDir() error = %v", err)
+	}
+	for _, file := range files {
+		if file.Filename == name {
+			return file.Expires
+		}
+	}
+	t.Fatalf("ListDir() = %+v, want %s in it", files, name)
+	return ""
+}
+
+func TestService_FileTTL(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+
+	tests := []struct {
+		name        string
+		ttl, maxTTL time.Duration
+		asked, want time.Duration
+	}{
+		{"kept by default", 0, 0, 0, 0},
+		{"asked for", 0, 0, time.Hour, time.Hour},
+		{"server default", 2 * time.Hour, 0, 0, 2 * time.Hour},
+		{"asked beyond the default", 2 * time.Hour, 0, 5 * time.Hour, 5 * time.Hour},
+		{"clamped to the maximum", 0, 3 * time.Hour, 5 * time.Hour, 3 * time.Hour},
+		{"maximum as the default", 0, 3 * time.Hour, 0, 3 * time.Hour},
+		{"default below the maximum", time.Hour, 3 * time.Hour, 0, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc.cfg.FileTTL, svc.cfg.FileMaxTTL = tt.ttl, tt.maxTTL
+			if got := svc.FileTTL(tt.asked); got != tt.want {
+				t.Errorf("FileTTL(%v) = %v, want %v", tt.asked, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_Expiry(t *testing.T) {
+	for name, svc := range moveStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc.cfg.TrashRetention = time.Hour
+			stored, err := svc.StoreFile("docs/a.txt", strings.NewReader("hello"), ConflictFail, "", 90*time.Minute)
+			if err != nil {
+				t.Fatalf("StoreFile() error = %v", err)
+			}
+			if left := time.Until(stored.Expires); left <= 89*time.Minute || left > 90*time.Minute {
+				t.Errorf("StoreFile() expires in %v, want 90m", left)
+			}
+			if got := listedExpiry(t, svc, "docs", "a.txt"); got != "1h 29m" {
+				t.Errorf("ListDir() expires in %q, want %q", got, "1h 29m")
+			}
+			if _, err := svc.UploadFile("b.txt", strings.NewReader("hello")); err != nil {
+				t.Fatalf("UploadFile() error = %v", err)
+			}
+			if got := listedExpiry(t, svc, "", "b.txt"); got != "" {
+				t.Errorf("ListDir() of a file kept expires in %q, want it kept", got)
+			}
+
+			// The expiry follows the file when it is moved
+			if _, err := svc.MoveFile("docs/a.txt", "c.txt", false); err != nil {
+				t.Fatalf("MoveFile() error = %v", err)
+			}
+			if !svc.expiryOf("docs/a.txt").IsZero() || !svc.expiryOf("c.txt").Equal(stored.Expires) {
+				t.Errorf("expiry after the move = %v, want %v", svc.expiryOf("c.txt"), stored.Expires)
+			}
+
+			// Expired files are gone before the janitor gets to them
+			expire(svc, "c.txt")
+			files, err := svc.ListDir("")
+			if err != nil {
+				t.Fatalf("ListDir() error = %v", err)
+			}
+			for _, file := range files {
+				if file.Filename == "c.txt" {
+					t.Errorf("ListDir() = %+v, want the expired file left out", files)
+				}
+			}
+			if entries, err := svc.ListTree("", true); err != nil || len(entries) != 1 || entries[0].Path != "b.txt" {
+				t.Errorf("ListTree() = %+v, %v, want the expired file left out", entries, err)
+			}
+			if _, err := svc.StatFile("c.txt"); !errors.Is(err, ErrFileNotExist) {
+				t.Errorf("StatFile() of an expired file error = %v, want ErrFileNotExist", err)
+			}
+			if _, err := svc.OpenFile("c.txt"); !errors.Is(err, ErrFileNotExist) {
+				t.Errorf("OpenFile() of an expired file error = %v, want ErrFileNotExist", err)
+			}
+
+			// Expired files are deleted for good, the others are left alone
+			if n, err := svc.purgeExpiredFiles(); err != nil || n != 1 {
+				t.Errorf("purgeExpiredFiles() = %d, %v, want 1 file deleted", n, err)
+			}
+			if svc.exists("c.txt") || !svc.exists("b.txt") {
+				t.Error("purgeExpiredFiles() did not delete just the expired file")
+			}
+			if trash, err := svc.ListTrash(); err != nil || len(trash) != 0 {
+				t.Errorf("ListTrash() = %+v, %v, want the expired file deleted for good", trash, err)
+			}
+			if !svc.expiryOf("c.txt").IsZero() {
+				t.Error("the expiry of a deleted file is still recorded")
+			}
+
+			// Replacing a file without asking for a lifetime keeps it until it is deleted
+			if _, err := svc.StoreFile("b.txt", strings.NewReader("hi"), ConflictOverwrite, "", time.Hour); err != nil {
+				t.Fatalf("StoreFile() error = %v", err)
+			}
+			if _, err := svc.ReplaceFile("b.txt", strings.NewReader("hey")); err != nil {
+				t.Fatalf("ReplaceFile() error = %v", err)
+			}
+			if !svc.expiryOf("b.txt").IsZero() {
+				t.Error("a replaced file kept the expiry of the file it replaced")
+			}
+		})
+	}
+}
+
+func TestService_ExpiredReplaced(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	svc.cfg.Versions = 3
+	if _, err := svc.StoreFile("a.txt", strings.NewReader("hello"), ConflictFail, "", time.Hour); err != nil {
+		t.Fatalf("StoreFile() error = %v", err)
+	}
+	expire(svc, "a.txt")
+
+	// The name of an expired file is free again, and its content is not kept
+	stored, err := svc.StoreFile("a.txt", strings.NewReader("hi"), ConflictFail, "", 0)
+	if err != nil || stored.Replaced {
+		t.Fatalf("StoreFile() over an expired file = %+v, %v, want it stored as new", stored, err)
+	}
+	if !svc.expiryOf("a.txt").IsZero() {
+		t.Error("the new file kept the expiry of the expired one")
+	}
+	if versions, err := svc.ListVersions("a.txt"); err != nil || len(versions) != 0 {
+		t.Errorf("ListVersions() = %+v, %v, want the expired content not kept", versions, err)
+	}
+}
+
+func TestService_ExpiryInTrash(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	svc.cfg.TrashRetention = time.Hour
+	if _, err := svc.StoreFile("a.txt", strings.NewReader("hello"), ConflictFail, "", time.Hour); err != nil {
+		t.Fatalf("StoreFile() error = %v", err)
+	}
+	if err := svc.DeleteFile("a.txt"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+
+	// The janitor leaves files in the trash to their own retention
+	trash, err := svc.ListTrash()
+	if err != nil || len(trash) != 1 {
+		t.Fatalf("ListTrash() = %+v, %v, want one entry", trash, err)
+	}
+	expire(svc, trashPath(trash[0].ID))
+	if n, err := svc.purgeExpiredFiles(); err != nil || n != 0 {
+		t.Errorf("purgeExpiredFiles() = %d, %v, want nothing deleted from the trash", n, err)
+	}
+
+	// A restored file expires again
+	if _, err := svc.RestoreTrash(trash[0].ID); err != nil {
+		t.Fatalf("RestoreTrash() error = %v", err)
+	}
+	if n, err := svc.purgeExpiredFiles(); err != nil || n != 1 || svc.exists("a.txt") {
+		t.Errorf("purgeExpiredFiles() = %d, %v, want the restored file deleted", n, err)
+	}
+}
+
+func TestService_ExpiryPersisted(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	svc.cfg.FileTTL = time.Hour
+	if _, err := svc.UploadFile("a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+	expires := svc.expiryOf("a.txt")
+	if expires.IsZero() {
+		t.Fatal("an upload with a default lifetime does not expire")
+	}
+
+	// The expiries survive a restart and are not listed
+	restarted := New(svc.cfg, store)
+	if got := restarted.expiryOf("a.txt"); !got.Equal(expires) {
+		t.Errorf("expiryOf() after a restart = %v, want %v", got, expires)
+	}
+	files, err := restarted.ListDir("")
+	if err != nil {
+		t.Fatalf("ListDir() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Filename != "a.txt" {
+		t.Errorf("ListDir() = %+v, want only a.txt", files)
+	}
+}
+
+func TestService_ExpiryResumable(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	upload, err := svc.CreateUpload("a.txt", 5, "", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+	if _, err := svc.AppendUpload(upload.ID, 0, strings.NewReader("hello")); err != nil {
+		t.Fatalf("AppendUpload() error = %v", err)
+	}
+	if left := time.Until(svc.expiryOf("a.txt")); left <= time.Hour || left > 2*time.Hour {
+		t.Errorf("resumable upload expires in %v, want 2h", left)
+	}
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// StartJanitor runs periodic housekeeping in the background, such as removing
+// expired resumable and multipart uploads, the download counts of expired share
+// links, expired drop links, files whose lifetime is over and files that have
+// been in the trash too long, and computing the checksums of files that have
+// none recorded. The first round runs right away, to catch up on
+// what expired while the server was down.
+// It returns a function that stops the janitor.
+func (s *Service) StartJanitor(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		s.housekeeping()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.housekeeping()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// housekeeping performs one round of cleanup tasks, logging failures instead of stopping
+func (s *Service) housekeeping() {
+	if n, err := s.PurgeExpiredUploads(); err != nil {
+		log.Printf("Failed to purge expired uploads: %v", err)
+	} else if n > 0 {
+		log.Printf("Purged %d expired resumable upload(s)", n)
+	}
+	if n, err := s.purgeExpiredMultipartUploads(); err != nil {
+		log.Printf("Failed to purge expired multipart uploads: %v", err)
+	} else if n > 0 {
+		log.Printf("Purged %d expired multipart upload(s)", n)
+	}
+	if n, err := s.purgeExpiredShares(); err != nil {
+		log.Printf("Failed to purge expired share links: %v", err)
+	} else if n > 0 {
+		log.Printf("Purged the download counts of %d expired share link(s)", n)
+	}
+	if n, err := s.purgeExpiredDrops(); err != nil {
+		log.Printf("Failed to purge expired drop links: %v", err)
+	} else if n > 0 {
+		log.Printf("Purged %d expired drop link(s)", n)
+	}
+	if n, err := s.purgeExpiredFiles(); err != nil {
+		log.Printf("Failed to delete expired files: %v", err)
+	} else if n > 0 {
+		log.Printf("Deleted %d expired file(s)", n)
+	}
+	if n, err := s.purgeExpiredTrash(); err != nil {
+		log.Printf("Failed to purge the trash: %v", err)
+	} else if n > 0 {
+		log.Printf("Purged %d file(s) from the trash", n)
+	}
+	if n, err := s.recordMissingChecksums(); err != nil {
+		log.Printf("Failed to compute missing checksums: %v", err)
+	} else if n > 0 {
+		log.Printf("Computed the checksums of %d file(s)", n)
+	}
+}
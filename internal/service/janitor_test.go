@@ -0,0 +1,38 @@
+package service
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestService_Housekeeping(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+	svc.cfg.TusExpiry = time.Minute
+
+	upload, err := svc.CreateUpload("stale.txt", 10, "", 0)
+	if err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(svc.uploadDataPath(upload.ID), old, old); err != nil {
+		t.Fatalf("Failed to age upload: %v", err)
+	}
+
+	svc.housekeeping()
+
+	if _, err := svc.GetUpload(upload.ID); err == nil {
+		t.Error("housekeeping() did not purge the expired upload")
+	}
+}
+
+func TestService_StartJanitor(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	stop := svc.StartJanitor(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	stop()
+	stop() // stopping twice must be safe
+}
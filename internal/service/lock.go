@@ -0,0 +1,109 @@
+package service
+
+import "sync"
+
+// lockTable hands out per-filename locks so that operations on different files
+// never contend with each other.
+//
+// Each entry carries a RWMutex for short critical sections (stat, open, remove)
+// and an "uploading" reservation flag. Uploads hold the reservation, not the
+// mutex, for the whole transfer, so a long upload never blocks readers of other
+// files, and only conflicts with operations on its own name.
+type lockTable struct {
+	mu    sync.Mutex
+	locks map[string]*fileLock
+}
+
+// fileLock is a reference counted lock for a single filename
+type fileLock struct {
+	sync.RWMutex
+	refs      int
+	uploading bool
+}
+
+func newLockTable() *lockTable {
+	return &lockTable{locks: make(map[string]*fileLock)}
+}
+
+// acquire returns the lock entry for name, creating it if necessary.
+// Every call must be paired with a call to release.
+func (t *lockTable) acquire(name string) *fileLock {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.locks[name]
+	if !ok {
+		l = &fileLock{}
+		t.locks[name] = l
+	}
+	l.refs++
+	return l
+}
+
+// release drops a reference obtained by acquire and forgets the entry
+// once nobody uses it any more.
+func (t *lockTable) release(name string, l *fileLock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l.refs--
+	if l.refs == 0 && !l.uploading {
+		delete(t.locks, name)
+	}
+}
+
+// reserve marks name as being uploaded. It returns false if another upload
+// already holds the reservation.
+func (t *lockTable) reserve(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.locks[name]
+	if !ok {
+		l = &fileLock{}
+		t.locks[name] = l
+	}
+	if l.uploading {
+		return false
+	}
+	l.uploading = true
+	return true
+}
+
+// unreserve clears the upload reservation taken by reserve.
+func (t *lockTable) unreserve(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.locks[name]
+	if !ok {
+		return
+	}
+	l.uploading = false
+	if l.refs == 0 {
+		delete(t.locks, name)
+	}
+}
+
+// isUploading reports whether an upload currently holds the reservation for name.
+func (t *lockTable) isUploading(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.locks[name]
+	return ok && l.uploading
+}
+
+// isUploadingWithin reports whether an upload holds the reservation for dir or
+// any name below it, "" being the store root.
+func (t *lockTable) isUploadingWithin(dir string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, l := range t.locks {
+		if l.uploading && (dir == "" || isWithin(name, dir)) {
+			return true
+		}
+	}
+	return false
+}
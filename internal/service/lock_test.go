@@ -0,0 +1,76 @@
+package service
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLockTable_Reserve(t *testing.T) {
+	lt := newLockTable()
+
+	if !lt.reserve("a.txt") {
+		t.Fatal("reserve() should succeed for a free name")
+	}
+	if lt.reserve("a.txt") {
+		t.Error("reserve() should fail while the name is reserved")
+	}
+	if !lt.reserve("b.txt") {
+		t.Error("reserve() should succeed for a different name")
+	}
+	if !lt.isUploading("a.txt") {
+		t.Error("isUploading() = false, want true")
+	}
+
+	lt.unreserve("a.txt")
+	if lt.isUploading("a.txt") {
+		t.Error("isUploading() = true after unreserve, want false")
+	}
+	if !lt.reserve("a.txt") {
+		t.Error("reserve() should succeed again after unreserve")
+	}
+}
+
+func TestLockTable_ReleaseForgetsEntries(t *testing.T) {
+	lt := newLockTable()
+
+	l := lt.acquire("a.txt")
+	l2 := lt.acquire("a.txt")
+	if l != l2 {
+		t.Fatal("acquire() returned different locks for the same name")
+	}
+	lt.release("a.txt", l)
+	lt.release("a.txt", l2)
+
+	lt.reserve("b.txt")
+	lt.unreserve("b.txt")
+
+	if n := len(lt.locks); n != 0 {
+		t.Errorf("lock table holds %d entries, want 0", n)
+	}
+}
+
+func TestLockTable_Concurrent(t *testing.T) {
+	lt := newLockTable()
+
+	var wg sync.WaitGroup
+	counter := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l := lt.acquire("shared")
+			defer lt.release("shared", l)
+			l.Lock()
+			counter++
+			l.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if counter != 50 {
+		t.Errorf("counter = %d, want 50", counter)
+	}
+	if n := len(lt.locks); n != 0 {
+		t.Errorf("lock table holds %d entries, want 0", n)
+	}
+}
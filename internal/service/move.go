@@ -0,0 +1,242 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"fsrv/internal/storage"
+)
+
+// MoveFile moves a file or, with subdirectory support, a folder and everything
+// in it from src to dst, creating missing parents of dst.
+//
+// If dst exists, it fails with ErrFileExists unless overwrite is set, in which
+// case dst is removed first; that counts as a delete and fails with
+// ErrDeleteDisabled if delete is disabled. It reports whether dst was replaced.
+func (s *Service) MoveFile(src, dst string, overwrite bool) (bool, error) {
+	from, to, info, err := s.prepareTransfer(src, dst)
+	if err != nil {
+		return false, err
+	}
+	replaced, err := s.clearDestination(to, overwrite)
+	if err != nil {
+		return false, err
+	}
+
+	if renamer, ok := s.store.(storage.Renamer); ok {
+		return replaced, s.rename(renamer, from, to)
+	}
+
+	// Storages that cannot rename get a copy followed by a delete. The owners
+	// move over to the copies before the originals go.
+	defer s.lendQuota(from, info)()
+	if err := s.copyTree(from, to, info.IsDir); err != nil {
+		return replaced, err
+	}
+	s.quotaMoved(from, to)
+	s.expiryMoved(from, to)
+	return replaced, s.removeAll(from, false)
+}
+
+// CopyFile copies a file or, with subdirectory support, a folder from src to dst.
+// A folder is copied with everything in it if recursive is set, otherwise only
+// an empty folder is created. An existing dst is handled like in MoveFile.
+//
+// Every copied file counts as an upload, so it is subject to the maximum upload size.
+func (s *Service) CopyFile(src, dst string, overwrite, recursive bool) (bool, error) {
+	from, to, _, err := s.prepareTransfer(src, dst)
+	if err != nil {
+		return false, err
+	}
+	replaced, err := s.clearDestination(to, overwrite)
+	if err != nil {
+		return false, err
+	}
+	return replaced, s.copyTree(from, to, recursive)
+}
+
+// RemoveAll removes a file or a folder with everything in it, if delete is
+// enabled. The store root cannot be removed.
+func (s *Service) RemoveAll(name string) error {
+	if !s.cfg.DelAble {
+		return ErrDeleteDisabled
+	}
+	safeName, err := s.cleanPath(name)
+	if err != nil {
+		return err
+	}
+	return s.removeAll(safeName, true)
+}
+
+// prepareTransfer cleans the paths of a move or copy and describes its source
+func (s *Service) prepareTransfer(src, dst string) (string, string, storage.FileInfo, error) {
+	from, err := s.cleanPath(src)
+	if err != nil {
+		return "", "", storage.FileInfo{}, err
+	}
+	to, err := s.cleanPath(dst)
+	if err != nil {
+		return "", "", storage.FileInfo{}, err
+	}
+	if isWithin(to, from) {
+		return "", "", storage.FileInfo{}, fmt.Errorf("%w: cannot move or copy '%s' into itself", ErrInvalidFilename, from)
+	}
+
+	info, err := s.StatFile(from)
+	if err != nil {
+		return "", "", storage.FileInfo{}, err
+	}
+	if info.IsDir && !s.cfg.SubDirs {
+		return "", "", storage.FileInfo{}, ErrSubDirsDisabled
+	}
+	return from, to, info, nil
+}
+
+// clearDestination makes way for a move or copy to the clean path to. It
+// reports whether something was removed.
+func (s *Service) clearDestination(to string, overwrite bool) (bool, error) {
+	_, err := s.store.Stat(to)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check file: %w", err)
+	}
+
+	if !overwrite {
+		return false, fmt.Errorf("%w: '%s'", ErrFileExists, to)
+	}
+	if !s.cfg.DelAble {
+		return false, ErrDeleteDisabled
+	}
+	if err := s.removeAll(to, false); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// rename moves the clean path from to the clean path to within the store.
+//
+// The destination is reserved like an upload and the source is write locked, so
+// the move neither races an upload to the destination nor a read of the source.
+func (s *Service) rename(renamer storage.Renamer, from, to string) error {
+	if !s.locks.reserve(to) {
+		return fmt.Errorf("%w: '%s'", ErrFileBusy, to)
+	}
+	defer s.locks.unreserve(to)
+
+	l := s.locks.acquire(from)
+	defer s.locks.release(from, l)
+	l.Lock()
+	defer l.Unlock()
+
+	// Nothing that is still being written can be moved
+	if s.locks.isUploadingWithin(from) {
+		return fmt.Errorf("%w: '%s'", ErrFileBusy, from)
+	}
+
+	err := renamer.Rename(from, to)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: '%s'", ErrFileNotExist, from)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w: '%s'", ErrFileExists, to)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	s.forgetChecksums(from)
+	s.quotaMoved(from, to)
+	s.expiryMoved(from, to)
+	return nil
+}
+
+// copyTree copies the clean path from to the clean path to, descending into
+// folders if recursive is set. Files go through upload like any other.
+func (s *Service) copyTree(from, to string, recursive bool) error {
+	info, err := s.StatFile(from)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir {
+		file, err := s.OpenFile(from)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = s.upload(to, file, false)
+		return err
+	}
+
+	if err := s.store.Mkdir(to); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if !recursive {
+		return nil
+	}
+
+	entries, err := s.store.List(from)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, entry := range entries {
+		if err := s.copyTree(path.Join(from, entry.Name), path.Join(to, entry.Name), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAll removes the file or folder stored under a clean path, without
+// checking whether delete is enabled. With discard set files go to the trash.
+func (s *Service) removeAll(name string, discard bool) error {
+	info, err := s.store.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: '%s'", ErrFileNotExist, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check file: %w", err)
+	}
+	if !info.IsDir {
+		return s.deleteFile(name, discard)
+	}
+
+	// An upload into the folder would keep it from being removed
+	if s.locks.isUploadingWithin(name) {
+		return fmt.Errorf("%w: '%s'", ErrFileBusy, name)
+	}
+
+	entries, err := s.store.List(name)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, entry := range entries {
+		if err := s.removeAll(path.Join(name, entry.Name), discard); err != nil {
+			return err
+		}
+	}
+
+	l := s.locks.acquire(name)
+	defer s.locks.release(name, l)
+	l.Lock()
+	defer l.Unlock()
+
+	err = s.store.Delete(name)
+	if errors.Is(err, storage.ErrNotEmpty) {
+		return fmt.Errorf("%w: '%s'", ErrDirNotEmpty, name)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove directory: %w", err)
+	}
+	return nil
+}
+
+// isWithin reports whether the clean path name is dir or lies below it
+func isWithin(name, dir string) bool {
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"fsrv/internal/storage"
+)
+
+// plainStorage hides the optional interfaces of a storage, such as Renamer
+type plainStorage struct {
+	storage.Storage
+}
+
+// moveStores returns services over a local storage, a memory storage, a
+// storage that cannot rename and a deduplicating storage, all with
+// subdirectory support
+func moveStores(t *testing.T) map[string]*Service {
+	local, tmpDir := setupSubDirService(t)
+	t.Cleanup(func() { cleanupTestService(t, tmpDir) })
+
+	memory, _ := setupMemoryService(t)
+	memory.cfg.SubDirs = true
+
+	plain, _ := setupMemoryService(t)
+	plain.cfg.SubDirs = true
+	plain.store = plainStorage{plain.store}
+
+	dedup, _ := setupMemoryService(t)
+	dedup.cfg.SubDirs = true
+	store, err := storage.NewDedup(storage.NewMemory(), IsMetadata)
+	if err != nil {
+		t.Fatalf("NewDedup() error = %v", err)
+	}
+	dedup.store = store
+
+	return map[string]*Service{"local": local, "memory": memory, "plain": plain, "dedup": dedup}
+}
+
+// readFile returns the content of a file in the store of svc
+func readFile(t *testing.T, svc *Service, name string) string {
+	t.Helper()
+	file, err := svc.OpenFile(name)
+	if err != nil {
+		t.Fatalf("OpenFile(%s) error = %v", name, err)
+	}
+	defer file.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(file); err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return buf.String()
+}
+
+// uploadAll stores files whose content is their name
+func uploadAll(t *testing.T, svc *Service, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if _, err := svc.UploadFile(name, bytes.NewReader([]byte(name))); err != nil {
+			t.Fatalf("UploadFile(%s) error = %v", name, err)
+		}
+	}
+}
+
+func TestService_MoveFile(t *testing.T) {
+	for name, svc := range moveStores(t) {
+		t.Run(name, func(t *testing.T) {
+			uploadAll(t, svc, "a.txt", "docs/readme.txt", "docs/2024/report.txt", "taken.txt")
+
+			if replaced, err := svc.MoveFile("a.txt", "archive/b.txt", false); err != nil || replaced {
+				t.Fatalf("MoveFile() = %v, %v", replaced, err)
+			}
+			if svc.exists("a.txt") || readFile(t, svc, "archive/b.txt") != "a.txt" {
+				t.Error("MoveFile() did not move the file")
+			}
+
+			if _, err := svc.MoveFile("docs", "papers", false); err != nil {
+				t.Fatalf("MoveFile() of a folder error = %v", err)
+			}
+			if svc.exists("docs") || readFile(t, svc, "papers/2024/report.txt") != "docs/2024/report.txt" {
+				t.Error("MoveFile() did not move the folder")
+			}
+
+			if _, err := svc.MoveFile("archive/b.txt", "taken.txt", false); !errors.Is(err, ErrFileExists) {
+				t.Errorf("MoveFile() onto an existing file error = %v, want ErrFileExists", err)
+			}
+			if replaced, err := svc.MoveFile("archive/b.txt", "taken.txt", true); err != nil || !replaced {
+				t.Errorf("MoveFile() with overwrite = %v, %v", replaced, err)
+			}
+			if readFile(t, svc, "taken.txt") != "a.txt" {
+				t.Error("MoveFile() with overwrite did not replace the file")
+			}
+
+			if _, err := svc.MoveFile("papers", "papers/inner", false); !errors.Is(err, ErrInvalidFilename) {
+				t.Errorf("MoveFile() into itself error = %v, want ErrInvalidFilename", err)
+			}
+			if _, err := svc.MoveFile("missing.txt", "other.txt", false); !errors.Is(err, ErrFileNotExist) {
+				t.Errorf("MoveFile() of a missing file error = %v, want ErrFileNotExist", err)
+			}
+		})
+	}
+}
+
+func TestService_CopyFile(t *testing.T) {
+	for name, svc := range moveStores(t) {
+		t.Run(name, func(t *testing.T) {
+			uploadAll(t, svc, "docs/readme.txt", "docs/2024/report.txt")
+
+			if _, err := svc.CopyFile("docs", "copy", false, true); err != nil {
+				t.Fatalf("CopyFile() error = %v", err)
+			}
+			if readFile(t, svc, "docs/2024/report.txt") != readFile(t, svc, "copy/2024/report.txt") {
+				t.Error("CopyFile() did not copy the folder")
+			}
+
+			if _, err := svc.CopyFile("docs", "shallow", false, false); err != nil {
+				t.Fatalf("CopyFile() without recursion error = %v", err)
+			}
+			if entries, err := svc.ListTree("shallow", false); err != nil || len(entries) != 0 {
+				t.Errorf("CopyFile() without recursion created %v, %v", entries, err)
+			}
+
+			if _, err := svc.CopyFile("docs/readme.txt", "copy/readme.txt", false, false); !errors.Is(err, ErrFileExists) {
+				t.Errorf("CopyFile() onto an existing file error = %v, want ErrFileExists", err)
+			}
+		})
+	}
+}
+
+func TestService_CopyFile_TooLarge(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	if _, err := store.Put("big.bin", bytes.NewReader(make([]byte, 2048))); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	svc.cfg.Max = 10 // 1KB
+
+	if _, err := svc.CopyFile("big.bin", "copy.bin", false, false); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("CopyFile() error = %v, want ErrFileTooLarge", err)
+	}
+	if svc.exists("copy.bin") {
+		t.Error("CopyFile() left a partial copy behind")
+	}
+}
+
+func TestService_RemoveAll(t *testing.T) {
+	for name, svc := range moveStores(t) {
+		t.Run(name, func(t *testing.T) {
+			uploadAll(t, svc, "docs/readme.txt", "docs/2024/report.txt", "keep.txt")
+
+			if err := svc.RemoveAll("docs"); err != nil {
+				t.Fatalf("RemoveAll() error = %v", err)
+			}
+			if svc.exists("docs") || !svc.exists("keep.txt") {
+				t.Error("RemoveAll() did not remove exactly the folder")
+			}
+			if err := svc.RemoveAll("docs"); !errors.Is(err, ErrFileNotExist) {
+				t.Errorf("RemoveAll() of a missing folder error = %v, want ErrFileNotExist", err)
+			}
+			if err := svc.RemoveAll(""); !errors.Is(err, ErrInvalidFilename) {
+				t.Errorf("RemoveAll() of the root error = %v, want ErrInvalidFilename", err)
+			}
+		})
+	}
+}
+
+func TestService_DeleteDisabled(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	svc.cfg.SubDirs = true
+	uploadAll(t, svc, "a.txt", "b.txt", "docs/c.txt")
+	svc.cfg.DelAble = false
+
+	if err := svc.DeleteFile("a.txt"); !errors.Is(err, ErrDeleteDisabled) {
+		t.Errorf("DeleteFile() error = %v, want ErrDeleteDisabled", err)
+	}
+	if err := svc.RemoveAll("docs"); !errors.Is(err, ErrDeleteDisabled) {
+		t.Errorf("RemoveAll() error = %v, want ErrDeleteDisabled", err)
+	}
+	if _, err := svc.MoveFile("a.txt", "b.txt", true); !errors.Is(err, ErrDeleteDisabled) {
+		t.Errorf("MoveFile() with overwrite error = %v, want ErrDeleteDisabled", err)
+	}
+	if _, err := svc.CopyFile("a.txt", "b.txt", true, false); !errors.Is(err, ErrDeleteDisabled) {
+		t.Errorf("CopyFile() with overwrite error = %v, want ErrDeleteDisabled", err)
+	}
+	if !svc.exists("a.txt") || !svc.exists("b.txt") || !svc.exists("docs/c.txt") {
+		t.Error("a disabled delete removed files")
+	}
+
+	// Moving to a free name does not delete anything
+	if _, err := svc.MoveFile("a.txt", "docs/a.txt", false); err != nil {
+		t.Errorf("MoveFile() error = %v", err)
+	}
+}
+
+func TestService_MoveFile_Busy(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	svc.cfg.SubDirs = true
+	uploadAll(t, svc, "docs/a.txt")
+
+	pw, done := startSlowUpload(t, svc, "docs/slow.txt")
+	if _, err := svc.MoveFile("docs", "papers", false); !errors.Is(err, ErrFileBusy) {
+		t.Errorf("MoveFile() of a folder with an upload in flight error = %v, want ErrFileBusy", err)
+	}
+	if err := svc.RemoveAll("docs"); !errors.Is(err, ErrFileBusy) {
+		t.Errorf("RemoveAll() of a folder with an upload in flight error = %v, want ErrFileBusy", err)
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("slow upload error = %v", err)
+	}
+}
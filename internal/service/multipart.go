@@ -0,0 +1,334 @@
+package service
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fsrv/internal/util"
+)
+
+// Errors returned by the multipart upload methods
+var (
+	ErrInvalidPart = errors.New("invalid part")
+)
+
+// multipartDir is the directory below the tmp directory that holds multipart uploads
+const multipartDir = "multipart"
+
+// MaxPartNumber is the highest part number of a multipart upload, as in S3
+const MaxPartNumber = 10000
+
+// MultipartUpload describes an upload whose parts are sent independently, possibly
+// in parallel and in any order, and joined when the client completes it (S3 API).
+//
+// Every upload is a directory under the tmp directory holding an info file and
+// one file per received part.
+type MultipartUpload struct {
+	ID       string
+	Filename string
+	Replace  bool
+	Created  time.Time
+}
+
+// Part is a received part of a multipart upload. The ETag is the hex encoded MD5
+// of its data, as S3 clients expect.
+type Part struct {
+	Number int
+	ETag   string
+	Size   int64
+}
+
+// multipartInfo is the part of a multipart upload that is persisted next to its parts
+type multipartInfo struct {
+	Filename string    `json:"filename"`
+	Replace  bool      `json:"replace"`
+	Created  time.Time `json:"created"`
+}
+
+// CreateMultipartUpload starts a multipart upload that will be stored as filename.
+// With replace, completing it replaces an existing file like ReplaceFile does.
+func (s *Service) CreateMultipartUpload(filename string, replace bool) (*MultipartUpload, error) {
+	safeFilename, err := s.cleanPath(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail early instead of after all parts have been transferred
+	if info, err := s.store.Stat(safeFilename); err == nil && (!replace || info.IsDir) {
+		return nil, fmt.Errorf("%w: '%s'", ErrFileExists, safeFilename)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(s.multipartPath(id), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	upload := &MultipartUpload{ID: id, Filename: safeFilename, Replace: replace, Created: time.Now()}
+	data, err := json.Marshal(multipartInfo{Filename: upload.Filename, Replace: replace, Created: upload.Created})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode upload info: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(s.multipartPath(id), "info.json"), data, 0644); err != nil {
+		os.RemoveAll(s.multipartPath(id))
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	return upload, nil
+}
+
+// GetMultipartUpload returns a multipart upload
+func (s *Service) GetMultipartUpload(id string) (*MultipartUpload, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("%w: '%s'", ErrUploadNotFound, id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.multipartPath(id), "info.json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: '%s'", ErrUploadNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload info: %w", err)
+	}
+
+	var info multipartInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode upload info: %w", err)
+	}
+
+	return &MultipartUpload{ID: id, Filename: info.Filename, Replace: info.Replace, Created: info.Created}, nil
+}
+
+// UploadPart stores part number of a multipart upload, replacing an earlier
+// part with the same number. Parts of one upload can be sent in parallel.
+func (s *Service) UploadPart(id string, number int, src io.Reader) (*Part, error) {
+	if number < 1 || number > MaxPartNumber {
+		return nil, fmt.Errorf("%w: part number %d is not between 1 and %d", ErrInvalidPart, number, MaxPartNumber)
+	}
+
+	// Parts share the read lock, completing and aborting take the write lock
+	l := s.uploadLocks.acquire(id)
+	defer s.uploadLocks.release(id, l)
+	l.RLock()
+	defer l.RUnlock()
+
+	if s.uploadLocks.isUploading(id) {
+		return nil, fmt.Errorf("%w: '%s'", ErrFileBusy, id)
+	}
+	if _, err := s.GetMultipartUpload(id); err != nil {
+		return nil, err
+	}
+
+	dst, err := os.CreateTemp(s.multipartPath(id), ".part-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create part: %w", err)
+	}
+	defer os.Remove(dst.Name())
+
+	hash := md5.New()
+	buffer := make([]byte, 1024*1024) // 1MB buffer
+	size, copyErr := io.CopyBuffer(io.MultiWriter(dst, hash), s.diskReader(s.limitReader(src), true), buffer)
+	closeErr := dst.Close()
+	if errors.Is(copyErr, ErrFileTooLarge) {
+		return nil, fmt.Errorf("%w: max upload file size is %s", ErrFileTooLarge, s.GetMaxUploadSizeHuman())
+	}
+	if errors.Is(copyErr, ErrInsufficientStorage) {
+		return nil, copyErr
+	}
+	for _, err := range []error{copyErr, closeErr} {
+		if util.IsDiskFull(err) {
+			return nil, diskError(err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to save part: %w", err)
+		}
+	}
+
+	part := &Part{Number: number, ETag: hex.EncodeToString(hash.Sum(nil)), Size: size}
+	if err := os.Rename(dst.Name(), s.partPath(id, number)); err != nil {
+		return nil, fmt.Errorf("failed to save part: %w", err)
+	}
+	if err := os.WriteFile(s.partPath(id, number)+".etag", []byte(part.ETag), 0644); err != nil {
+		return nil, fmt.Errorf("failed to save part: %w", err)
+	}
+
+	return part, nil
+}
+
+// ListParts returns the parts of a multipart upload received so far, ordered by number
+func (s *Service) ListParts(id string) ([]Part, error) {
+	if _, err := s.GetMultipartUpload(id); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(s.multipartPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	var parts []Part
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".part")
+		if !ok {
+			continue
+		}
+		number, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		part, err := s.readPart(id, number)
+		if err != nil {
+			continue // still being written
+		}
+		parts = append(parts, *part)
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+// CompleteMultipartUpload joins the given parts, which must be in ascending order
+// and match the ETags handed out by UploadPart, and commits the result to the store.
+// Parts that are not listed are discarded. It returns the size of the file.
+func (s *Service) CompleteMultipartUpload(id string, parts []Part) (int64, error) {
+	if len(parts) == 0 {
+		return 0, fmt.Errorf("%w: no parts given", ErrInvalidPart)
+	}
+
+	if !s.uploadLocks.reserve(id) {
+		return 0, fmt.Errorf("%w: '%s'", ErrFileBusy, id)
+	}
+	defer s.uploadLocks.unreserve(id)
+
+	// Wait for parts that are still being written
+	l := s.uploadLocks.acquire(id)
+	defer s.uploadLocks.release(id, l)
+	l.Lock()
+	defer l.Unlock()
+
+	upload, err := s.GetMultipartUpload(id)
+	if err != nil {
+		return 0, err
+	}
+
+	var readers []io.Reader
+	for i, want := range parts {
+		if i > 0 && want.Number <= parts[i-1].Number {
+			return 0, fmt.Errorf("%w: parts must be listed in ascending order", ErrInvalidPart)
+		}
+		part, err := s.readPart(id, want.Number)
+		if err != nil || !strings.EqualFold(part.ETag, want.ETag) {
+			return 0, fmt.Errorf("%w: part %d was not uploaded", ErrInvalidPart, want.Number)
+		}
+
+		data, err := os.Open(s.partPath(id, want.Number))
+		if err != nil {
+			return 0, fmt.Errorf("failed to open part: %w", err)
+		}
+		defer data.Close()
+		readers = append(readers, data)
+	}
+
+	size, err := s.upload(upload.Filename, io.MultiReader(readers...), upload.Replace)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.RemoveAll(s.multipartPath(id)); err != nil {
+		return size, fmt.Errorf("failed to remove upload: %w", err)
+	}
+	return size, nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (s *Service) AbortMultipartUpload(id string) error {
+	if !s.uploadLocks.reserve(id) {
+		return fmt.Errorf("%w: '%s'", ErrFileBusy, id)
+	}
+	defer s.uploadLocks.unreserve(id)
+
+	l := s.uploadLocks.acquire(id)
+	defer s.uploadLocks.release(id, l)
+	l.Lock()
+	defer l.Unlock()
+
+	if _, err := s.GetMultipartUpload(id); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.multipartPath(id)); err != nil {
+		return fmt.Errorf("failed to remove upload: %w", err)
+	}
+	return nil
+}
+
+// purgeExpiredMultipartUploads removes multipart uploads that have not received a
+// part within the configured expiry. It returns the number of uploads removed.
+func (s *Service) purgeExpiredMultipartUploads() (int, error) {
+	entries, err := os.ReadDir(filepath.Join(s.tmpDir(), multipartDir))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find uploads: %w", err)
+	}
+
+	removed := 0
+	now := time.Now()
+	for _, entry := range entries {
+		id := entry.Name()
+		if !validID(id) || !s.uploadLocks.reserve(id) {
+			continue
+		}
+
+		// Adding a part touches the directory, and an upload with a part in
+		// flight is clearly not expired
+		l := s.uploadLocks.acquire(id)
+		if l.TryLock() {
+			info, err := os.Stat(s.multipartPath(id))
+			if err == nil && now.After(info.ModTime().Add(s.uploadExpiry())) {
+				if err := os.RemoveAll(s.multipartPath(id)); err == nil {
+					removed++
+				}
+			}
+			l.Unlock()
+		}
+		s.uploadLocks.release(id, l)
+		s.uploadLocks.unreserve(id)
+	}
+
+	return removed, nil
+}
+
+// readPart returns a received part
+func (s *Service) readPart(id string, number int) (*Part, error) {
+	stat, err := os.Stat(s.partPath(id, number))
+	if err != nil {
+		return nil, err
+	}
+	etag, err := os.ReadFile(s.partPath(id, number) + ".etag")
+	if err != nil {
+		return nil, err
+	}
+	return &Part{Number: number, ETag: string(etag), Size: stat.Size()}, nil
+}
+
+func (s *Service) multipartPath(id string) string {
+	return filepath.Join(s.tmpDir(), multipartDir, id)
+}
+
+func (s *Service) partPath(id string, number int) string {
+	return filepath.Join(s.multipartPath(id), fmt.Sprintf("%05d.part", number))
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestService_MultipartUpload(t *testing.T) {
+	svc, store := setupMemoryService(t)
+
+	upload, err := svc.CreateMultipartUpload("joined.txt", false)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+
+	// Parts arrive in parallel and out of order
+	chunks := []string{"one ", "two ", "three"}
+	parts := make([]Part, len(chunks))
+	var wg sync.WaitGroup
+	for i := len(chunks) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			part, err := svc.UploadPart(upload.ID, i+1, strings.NewReader(chunks[i]))
+			if err != nil {
+				t.Errorf("UploadPart(%d) error = %v", i+1, err)
+				return
+			}
+			parts[i] = *part
+		}(i)
+	}
+	wg.Wait()
+
+	listed, err := svc.ListParts(upload.ID)
+	if err != nil || len(listed) != 3 || listed[0].Number != 1 || listed[2].Size != 5 {
+		t.Errorf("ListParts() = %+v, %v", listed, err)
+	}
+
+	// A part that is re-sent replaces the earlier one
+	part, err := svc.UploadPart(upload.ID, 2, strings.NewReader("2 "))
+	if err != nil {
+		t.Fatalf("UploadPart() error = %v", err)
+	}
+	if _, err := svc.CompleteMultipartUpload(upload.ID, parts); !errors.Is(err, ErrInvalidPart) {
+		t.Errorf("CompleteMultipartUpload() with a stale ETag error = %v, want %v", err, ErrInvalidPart)
+	}
+	parts[1] = *part
+
+	size, err := svc.CompleteMultipartUpload(upload.ID, parts)
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload() error = %v", err)
+	}
+	if size != 11 {
+		t.Errorf("CompleteMultipartUpload() size = %d, want 11", size)
+	}
+	if data, _ := readMemory(store, "joined.txt"); data != "one 2 three" {
+		t.Errorf("stored content = %q", data)
+	}
+
+	// The upload is gone once completed
+	if _, err := svc.GetMultipartUpload(upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("GetMultipartUpload() after completion error = %v, want %v", err, ErrUploadNotFound)
+	}
+}
+
+func TestService_MultipartUpload_Errors(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	if _, err := svc.UploadFile("exists.txt", strings.NewReader("x")); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+
+	if _, err := svc.CreateMultipartUpload("exists.txt", false); !errors.Is(err, ErrFileExists) {
+		t.Errorf("CreateMultipartUpload() of existing file error = %v, want %v", err, ErrFileExists)
+	}
+
+	upload, err := svc.CreateMultipartUpload("exists.txt", true)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() with replace error = %v", err)
+	}
+	if _, err := svc.UploadPart(upload.ID, 0, strings.NewReader("x")); !errors.Is(err, ErrInvalidPart) {
+		t.Errorf("UploadPart(0) error = %v, want %v", err, ErrInvalidPart)
+	}
+	if _, err := svc.UploadPart("../../etc", 1, strings.NewReader("x")); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("UploadPart() with a bad id error = %v, want %v", err, ErrUploadNotFound)
+	}
+	if _, err := svc.CompleteMultipartUpload(upload.ID, []Part{{Number: 1, ETag: "missing"}}); !errors.Is(err, ErrInvalidPart) {
+		t.Errorf("CompleteMultipartUpload() of a missing part error = %v, want %v", err, ErrInvalidPart)
+	}
+
+	part, err := svc.UploadPart(upload.ID, 1, strings.NewReader("replaced"))
+	if err != nil {
+		t.Fatalf("UploadPart() error = %v", err)
+	}
+	if _, err := svc.CompleteMultipartUpload(upload.ID, []Part{*part}); err != nil {
+		t.Fatalf("CompleteMultipartUpload() error = %v", err)
+	}
+	if data, _ := readMemory(store, "exists.txt"); data != "replaced" {
+		t.Errorf("stored content = %q", data)
+	}
+}
+
+func TestService_MultipartUpload_TooLarge(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	svc.cfg.Max = 4 // 16 bytes
+
+	upload, err := svc.CreateMultipartUpload("big.bin", false)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+	if _, err := svc.UploadPart(upload.ID, 1, strings.NewReader(strings.Repeat("x", 17))); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("UploadPart() of an oversized part error = %v, want %v", err, ErrFileTooLarge)
+	}
+
+	// Parts within the limit can still add up to too much
+	var parts []Part
+	for i := 1; i <= 2; i++ {
+		part, err := svc.UploadPart(upload.ID, i, strings.NewReader(strings.Repeat("x", 10)))
+		if err != nil {
+			t.Fatalf("UploadPart() error = %v", err)
+		}
+		parts = append(parts, *part)
+	}
+	if _, err := svc.CompleteMultipartUpload(upload.ID, parts); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("CompleteMultipartUpload() error = %v, want %v", err, ErrFileTooLarge)
+	}
+	if _, err := store.Stat("big.bin"); err == nil {
+		t.Error("oversized multipart upload was stored")
+	}
+}
+
+func TestService_AbortMultipartUpload(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+
+	upload, err := svc.CreateMultipartUpload("aborted.txt", false)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+	if _, err := svc.UploadPart(upload.ID, 1, strings.NewReader("data")); err != nil {
+		t.Fatalf("UploadPart() error = %v", err)
+	}
+
+	if err := svc.AbortMultipartUpload(upload.ID); err != nil {
+		t.Fatalf("AbortMultipartUpload() error = %v", err)
+	}
+	if _, err := os.Stat(svc.multipartPath(upload.ID)); !os.IsNotExist(err) {
+		t.Error("aborted upload left its parts behind")
+	}
+	if err := svc.AbortMultipartUpload(upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("second AbortMultipartUpload() error = %v, want %v", err, ErrUploadNotFound)
+	}
+}
+
+func TestService_PurgeExpiredMultipartUploads(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	svc.cfg.TusExpiry = time.Minute
+
+	stale, _ := svc.CreateMultipartUpload("stale.txt", false)
+	fresh, _ := svc.CreateMultipartUpload("fresh.txt", false)
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(svc.multipartPath(stale.ID), old, old); err != nil {
+		t.Fatalf("Failed to age upload: %v", err)
+	}
+
+	removed, err := svc.purgeExpiredMultipartUploads()
+	if err != nil || removed != 1 {
+		t.Errorf("purgeExpiredMultipartUploads() = %d, %v, want 1", removed, err)
+	}
+	if _, err := svc.GetMultipartUpload(stale.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Error("expired upload was not purged")
+	}
+	if _, err := svc.GetMultipartUpload(fresh.ID); err != nil {
+		t.Errorf("active upload was purged: %v", err)
+	}
+}
@@ -0,0 +1,537 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"fsrv/internal/storage"
+	"fsrv/internal/util"
+)
+
+// ErrQuotaExceeded is returned for uploads that would take the store, or the
+// user uploading them, beyond a quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ownersFile records who uploaded which file, for the per-user quota
+const ownersFile = ".fsrv-owners.json"
+
+// usageRecount is how long the counted usage is trusted before the store is
+// walked again, which catches up with files changed behind the service's back
+const usageRecount = 10 * time.Minute
+
+// Usage is how much of the store, and of the share of a user, the files in it
+// take up. Files in the trash and previous versions do not count. A maximum of
+// 0 is no limit.
+type Usage struct {
+	Bytes    int64
+	MaxBytes int64
+	Files    int
+	MaxFiles int
+
+	// User is empty for anonymous requests, and UserBytes only counted with a per-user quota
+	User         string
+	UserBytes    int64
+	MaxUserBytes int64
+}
+
+// usage keeps count of what the files in the store take up. The totals are
+// counted by walking the store, and kept up to date as files come and go.
+type usage struct {
+	countMu sync.Mutex // serializes counting
+	saveMu  sync.Mutex // serializes writing the owners file
+
+	mu      sync.Mutex // guards the fields below
+	counted time.Time  // zero until the first count
+	stale   bool       // something happened that the totals cannot follow
+	bytes   int64
+	files   int
+	users   map[string]int64  // bytes per owner
+	owners  map[string]string // clean path -> user who uploaded it
+
+	// Room taken by uploads that are being received
+	pendingBytes int64
+	pendingFiles int
+	pendingUsers map[string]int64
+}
+
+// quotasEnabled reports whether any quota is configured. Without one the usage
+// is not counted at all.
+func (s *Service) quotasEnabled() bool {
+	return s.cfg.QuotaBytes > 0 || s.cfg.QuotaFiles > 0 || s.cfg.UserQuota > 0
+}
+
+// Usage returns how much of its quotas the store takes up, and user of theirs,
+// or nothing if there are no quotas. The usage is counted when it is first asked
+// for, which walks the whole store.
+func (s *Service) Usage(user string) (Usage, error) {
+	usage := Usage{MaxBytes: s.cfg.QuotaBytes, MaxFiles: s.cfg.QuotaFiles, User: user, MaxUserBytes: s.cfg.UserQuota}
+	if !s.quotasEnabled() {
+		return usage, nil
+	}
+	if err := s.countUsage(); err != nil {
+		return usage, err
+	}
+
+	u := &s.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	usage.Bytes, usage.Files = u.bytes, u.files
+	if user != "" {
+		usage.UserBytes = u.users[user]
+	}
+	return usage, nil
+}
+
+// CheckQuota checks up front whether an upload of size bytes to filename by
+// owner fits in the quotas, before any of it is received. A file it replaces
+// makes room for it. A negative size, for uploads whose size is not known, only
+// checks the number of files. Uploads are checked again as they are received,
+// so this only saves transferring what would be refused anyway.
+func (s *Service) CheckQuota(filename, owner string, size int64) error {
+	if !s.quotasEnabled() {
+		return nil
+	}
+	size = max(size, 0)
+	name, err := s.cleanPath(filename)
+	if err != nil {
+		return err
+	}
+
+	newFile, freed := true, int64(0)
+	if info, err := s.store.Stat(name); err == nil && !info.IsDir {
+		newFile, freed = false, info.Size
+	}
+	quota, err := s.reserveQuota(name, owner, newFile, freed)
+	if err != nil {
+		return err
+	}
+	defer quota.done(false)
+	return quota.grow(size)
+}
+
+// CheckUploadQuota is like CheckQuota for a request of size bytes by owner
+// whose files are not known until they are received, such as a form with any
+// number of files. Only the bytes are checked, all of them as new content:
+// a file the request replaces does not make room for it up front.
+func (s *Service) CheckUploadQuota(owner string, size int64) error {
+	quota, err := s.reserveQuota("", owner, false, 0)
+	if err != nil {
+		return err
+	}
+	defer quota.done(false)
+	return quota.grow(max(size, 0))
+}
+
+// countUsage counts what the files in the store take up, unless that has been
+// done recently. Owners of files that no longer exist are forgotten.
+func (s *Service) countUsage() error {
+	u := &s.usage
+	fresh := func() bool {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		return !u.counted.IsZero() && !u.stale && time.Since(u.counted) < usageRecount
+	}
+	if fresh() {
+		return nil
+	}
+	u.countMu.Lock()
+	defer u.countMu.Unlock()
+	if fresh() {
+		return nil
+	}
+
+	u.mu.Lock()
+	owners := make(map[string]string, len(u.owners))
+	for name, owner := range u.owners {
+		owners[name] = owner
+	}
+	loaded := u.owners != nil
+	u.mu.Unlock()
+	if !loaded && s.cfg.UserQuota > 0 {
+		var err error
+		if owners, err = s.readOwners(); err != nil {
+			return err
+		}
+	}
+
+	var bytes int64
+	var files int
+	users := make(map[string]int64)
+	found := make(map[string]bool)
+	err := s.walkFiles("", func(name string, size int64) {
+		bytes += size
+		files++
+		found[name] = true
+		if owner := owners[name]; owner != "" {
+			users[owner] += size
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to count usage: %w", err)
+	}
+
+	// Owners of files in the trash and of versions are kept for when they come back
+	var gone []string
+	for name := range owners {
+		if isHidden(name) {
+			if !s.exists(name) {
+				gone = append(gone, name)
+			}
+		} else if !found[name] {
+			gone = append(gone, name)
+		}
+	}
+
+	u.mu.Lock()
+	if !loaded {
+		u.owners = owners
+	}
+	for _, name := range gone {
+		// Unless it changed hands while the store was walked
+		if u.owners[name] == owners[name] {
+			delete(u.owners, name)
+		}
+	}
+	u.bytes, u.files, u.users = bytes, files, users
+	if u.pendingUsers == nil {
+		u.pendingUsers = make(map[string]int64)
+	}
+	u.counted, u.stale = time.Now(), false
+	u.mu.Unlock()
+
+	if len(gone) > 0 {
+		s.saveOwners()
+	}
+	return nil
+}
+
+// walkFiles calls fn for every file below the clean path dir, leaving out the
+// files fsrv keeps its own data in
+func (s *Service) walkFiles(dir string, fn func(name string, size int64)) error {
+	files, err := s.store.List(dir)
+	if errors.Is(err, fs.ErrNotExist) && dir != "" {
+		// The folder was removed in the meantime
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := path.Join(dir, file.Name)
+		switch {
+		case isHidden(name):
+		case file.IsDir:
+			if err := s.walkFiles(name, fn); err != nil {
+				return err
+			}
+		default:
+			fn(name, file.Size)
+		}
+	}
+	return nil
+}
+
+// quotaReservation is the room an upload takes up in the quotas while it is
+// received. A nil reservation stands for no quotas, so all its methods can be
+// called on one.
+type quotaReservation struct {
+	s         *Service
+	name      string
+	owner     string
+	newFile   bool
+	freed     int64 // size of the file the upload replaces
+	userFreed int64 // the part of freed that belonged to owner
+	size      int64 // bytes received so far
+}
+
+// reserveQuota starts accounting for an upload to the clean path name by owner,
+// which adds a file to the store if newFile is set and replaces one of freed
+// bytes otherwise. It
+// fails with ErrQuotaExceeded if there is no room for another file; the bytes
+// are reserved as they arrive, see grow. The reservation must be ended with done.
+func (s *Service) reserveQuota(name, owner string, newFile bool, freed int64) (*quotaReservation, error) {
+	if !s.quotasEnabled() {
+		return nil, nil
+	}
+	if err := s.countUsage(); err != nil {
+		return nil, err
+	}
+	if s.cfg.UserQuota <= 0 {
+		owner = ""
+	}
+
+	u := &s.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if newFile {
+		if s.cfg.QuotaFiles > 0 && u.files+u.pendingFiles >= s.cfg.QuotaFiles {
+			return nil, fmt.Errorf("%w: the store is limited to %d files", ErrQuotaExceeded, s.cfg.QuotaFiles)
+		}
+		u.pendingFiles++
+	}
+	quota := &quotaReservation{s: s, name: name, owner: owner, newFile: newFile, freed: freed}
+	if owner != "" && u.owners[name] == owner {
+		quota.userFreed = freed
+	}
+	return quota, nil
+}
+
+// grow reserves n more bytes for the upload, failing with ErrQuotaExceeded if
+// they do not fit
+func (r *quotaReservation) grow(n int64) error {
+	if r == nil {
+		return nil
+	}
+	cfg, u := r.s.cfg, &r.s.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if cfg.QuotaBytes > 0 && u.bytes+u.pendingBytes+n-r.freed > cfg.QuotaBytes {
+		return fmt.Errorf("%w: the store is limited to %s", ErrQuotaExceeded, util.HumanReadableSize(cfg.QuotaBytes))
+	}
+	if r.owner != "" && u.users[r.owner]+u.pendingUsers[r.owner]+n-r.userFreed > cfg.UserQuota {
+		return fmt.Errorf("%w: %s is limited to %s", ErrQuotaExceeded, r.owner, util.HumanReadableSize(cfg.UserQuota))
+	}
+	u.pendingBytes += n
+	r.size += n
+	if r.owner != "" {
+		u.pendingUsers[r.owner] += n
+	}
+	return nil
+}
+
+// reader returns src reserving the bytes read from it, so that the upload fails
+// with ErrQuotaExceeded as soon as it goes beyond a quota
+func (r *quotaReservation) reader(src io.Reader) io.Reader {
+	if r == nil {
+		return src
+	}
+	return &quotaReader{r: src, quota: r}
+}
+
+// done ends the reservation. With stored set the upload has been stored under
+// its name, replacing what was there, and belongs to its owner.
+func (r *quotaReservation) done(stored bool) {
+	if r == nil {
+		return
+	}
+	u := &r.s.usage
+	u.mu.Lock()
+	u.pendingBytes -= r.size
+	if r.newFile {
+		u.pendingFiles--
+	}
+	if r.owner != "" {
+		u.pendingUsers[r.owner] -= r.size
+		if u.pendingUsers[r.owner] == 0 {
+			delete(u.pendingUsers, r.owner)
+		}
+	}
+	changed := false
+	if stored {
+		u.bytes += r.size - r.freed
+		if r.newFile {
+			u.files++
+		}
+		if previous := u.owners[r.name]; previous != "" {
+			u.users[previous] -= r.freed
+		}
+		if r.owner != "" {
+			u.users[r.owner] += r.size
+		}
+		changed = u.owners[r.name] != r.owner
+		u.setOwner(r.name, r.owner)
+	}
+	u.mu.Unlock()
+
+	if changed {
+		r.s.saveOwners()
+	}
+}
+
+// quotaReader reserves the bytes read from an upload
+type quotaReader struct {
+	r     io.Reader
+	quota *quotaReservation
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	if n > 0 {
+		if err := q.quota.grow(int64(n)); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// quotaRemoved accounts for the file of size bytes under the clean path name
+// having been deleted for good
+func (s *Service) quotaRemoved(name string, size int64) {
+	if !s.quotasEnabled() {
+		return
+	}
+	u := &s.usage
+	u.mu.Lock()
+	if u.counted.IsZero() {
+		u.mu.Unlock()
+		return
+	}
+	if !isHidden(name) {
+		u.bytes -= size
+		u.files--
+		if owner := u.owners[name]; owner != "" {
+			u.users[owner] -= size
+		}
+	}
+	_, changed := u.owners[name]
+	delete(u.owners, name)
+	u.mu.Unlock()
+
+	if changed {
+		s.saveOwners()
+	}
+}
+
+// quotaMoved accounts for the file or folder under the clean path from having
+// been moved to the clean path to. Owners move along, and a file moving into
+// the trash or the versions stops counting, and counts again when it comes back.
+func (s *Service) quotaMoved(from, to string) {
+	if !s.quotasEnabled() {
+		return
+	}
+	var size int64
+	if isHidden(from) != isHidden(to) {
+		info, err := s.store.Stat(to)
+		if err != nil {
+			s.usage.invalidate()
+			return
+		}
+		size = info.Size
+	}
+
+	u := &s.usage
+	u.mu.Lock()
+	if u.counted.IsZero() {
+		u.mu.Unlock()
+		return
+	}
+	moved := make(map[string]string)
+	for name, owner := range u.owners {
+		if isWithin(name, from) {
+			moved[to+strings.TrimPrefix(name, from)] = owner
+			delete(u.owners, name)
+		}
+	}
+	for name, owner := range moved {
+		u.owners[name] = owner
+	}
+	if isHidden(from) != isHidden(to) {
+		sign := int64(1)
+		if isHidden(to) {
+			sign = -1
+		}
+		u.bytes += sign * size
+		u.files += int(sign)
+		if owner := u.owners[to]; owner != "" {
+			u.users[owner] += sign * size
+		}
+	}
+	u.mu.Unlock()
+
+	if len(moved) > 0 {
+		s.saveOwners()
+	}
+}
+
+// lendQuota makes room in the quotas for copies of the file or folder under the
+// clean path name, described by info, which is deleted once it has been copied.
+// The returned function takes the room back.
+func (s *Service) lendQuota(name string, info storage.FileInfo) func() {
+	if !s.quotasEnabled() {
+		return func() {}
+	}
+	bytes, files := info.Size, 1
+	if info.IsDir {
+		bytes, files = 0, 0
+		s.walkFiles(name, func(_ string, size int64) {
+			bytes += size
+			files++
+		})
+	}
+
+	u := &s.usage
+	lend := func(sign int) {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		u.pendingBytes -= int64(sign) * bytes
+		u.pendingFiles -= sign * files
+	}
+	lend(1)
+	return func() { lend(-1) }
+}
+
+// setOwner records owner as the owner of the clean path name. The caller must hold mu.
+func (u *usage) setOwner(name, owner string) {
+	if owner == "" {
+		delete(u.owners, name)
+		return
+	}
+	u.owners[name] = owner
+}
+
+// invalidate makes the next use of the usage count it again
+func (u *usage) invalidate() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stale = true
+}
+
+// readOwners loads the owners of the files in the store
+func (s *Service) readOwners() (map[string]string, error) {
+	owners := make(map[string]string)
+	file, err := s.store.Open(ownersFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return owners, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read owners: %w", err)
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&owners); err != nil {
+		return nil, fmt.Errorf("failed to decode owners: %w", err)
+	}
+	return owners, nil
+}
+
+// saveOwners writes the owners of the files in the store, if they are tracked
+// for a per-user quota. Failing to do so is logged: the owners are still known
+// until the server restarts.
+func (s *Service) saveOwners() {
+	if s.cfg.UserQuota <= 0 {
+		return
+	}
+	u := &s.usage
+	u.saveMu.Lock()
+	defer u.saveMu.Unlock()
+
+	// Encoding after taking saveMu makes the last write the newest
+	u.mu.Lock()
+	data, err := json.Marshal(u.owners)
+	u.mu.Unlock()
+	if err == nil {
+		_, err = s.store.Put(ownersFile, bytes.NewReader(data))
+	}
+	if err != nil {
+		log.Printf("Failed to save the owners of files: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// usageOf returns the usage of svc as seen by user, failing the test on errors
+func usageOf(t *testing.T, svc *Service, user string) Usage {
+	t.Helper()
+	usage, err := svc.Usage(user)
+	if err != nil {
+		t.Fatalf("Usage() error = %v", err)
+	}
+	return usage
+}
+
+func TestService_Quota(t *testing.T) {
+	for name, svc := range moveStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc.cfg.QuotaBytes = 10
+			svc.cfg.TrashRetention = time.Hour
+			if _, err := svc.UploadFile("docs/a.txt", strings.NewReader("hello")); err != nil {
+				t.Fatalf("UploadFile() error = %v", err)
+			}
+
+			// Uploads going beyond the quota fail halfway and leave nothing behind
+			if _, err := svc.UploadFile("b.txt", strings.NewReader("hello world")); !errors.Is(err, ErrQuotaExceeded) {
+				t.Errorf("UploadFile() beyond the quota error = %v, want %v", err, ErrQuotaExceeded)
+			}
+			if svc.exists("b.txt") {
+				t.Error("an upload beyond the quota was stored")
+			}
+			if err := svc.CheckQuota("b.txt", "", 6); !errors.Is(err, ErrQuotaExceeded) {
+				t.Errorf("CheckQuota() error = %v, want %v", err, ErrQuotaExceeded)
+			}
+
+			// Replacing a file makes room for its new content
+			if err := svc.CheckQuota("docs/a.txt", "", 10); err != nil {
+				t.Errorf("CheckQuota() of a replacement error = %v", err)
+			}
+			if _, err := svc.ReplaceFile("docs/a.txt", strings.NewReader("0123456789")); err != nil {
+				t.Fatalf("ReplaceFile() error = %v", err)
+			}
+			if got := usageOf(t, svc, ""); got.Bytes != 10 || got.Files != 1 || got.MaxBytes != 10 {
+				t.Errorf("Usage() = %+v, want 10 bytes in 1 file", got)
+			}
+
+			// Moves keep the usage, the trash does not count, and restoring counts again
+			if _, err := svc.MoveFile("docs/a.txt", "c.txt", false); err != nil {
+				t.Fatalf("MoveFile() error = %v", err)
+			}
+			if err := svc.DeleteFile("c.txt"); err != nil {
+				t.Fatalf("DeleteFile() error = %v", err)
+			}
+			if got := usageOf(t, svc, ""); got.Bytes != 0 || got.Files != 0 {
+				t.Errorf("Usage() with the file in the trash = %+v, want nothing", got)
+			}
+			trash, err := svc.ListTrash()
+			if err != nil || len(trash) != 1 {
+				t.Fatalf("ListTrash() = %+v, %v", trash, err)
+			}
+			if _, err := svc.RestoreTrash(trash[0].ID); err != nil {
+				t.Fatalf("RestoreTrash() error = %v", err)
+			}
+			if got := usageOf(t, svc, ""); got.Bytes != 10 || got.Files != 1 {
+				t.Errorf("Usage() after restoring = %+v, want 10 bytes in 1 file", got)
+			}
+		})
+	}
+}
+
+func TestService_Quota_Files(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	svc.cfg.QuotaFiles = 2
+	svc.cfg.Versions = 2
+	uploadAll(t, svc, "a.txt", "b.txt")
+
+	if _, err := svc.UploadFile("c.txt", strings.NewReader("c")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("UploadFile() of one file too many error = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if _, err := svc.CreateUpload("c.txt", 1, "", 0); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("CreateUpload() of one file too many error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// Previous versions do not count
+	if _, err := svc.ReplaceFile("a.txt", strings.NewReader("new")); err != nil {
+		t.Errorf("ReplaceFile() error = %v", err)
+	}
+	if got := usageOf(t, svc, ""); got.Files != 2 || got.Bytes != 8 {
+		t.Errorf("Usage() = %+v, want 8 bytes in 2 files", got)
+	}
+
+	svc.cfg.TrashRetention = 0
+	if err := svc.DeleteFile("b.txt"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	if _, err := svc.UploadFile("c.txt", strings.NewReader("c")); err != nil {
+		t.Errorf("UploadFile() after a delete error = %v", err)
+	}
+}
+
+func TestService_Quota_User(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	svc.cfg.SubDirs = true
+	svc.cfg.UserQuota = 8
+	store1 := func(name, content, owner string) error {
+		_, err := svc.StoreFile(name, strings.NewReader(content), ConflictFail, owner, 0)
+		return err
+	}
+
+	if err := store1("alice/a.txt", "hello", "alice"); err != nil {
+		t.Fatalf("StoreFile() error = %v", err)
+	}
+	if err := store1("alice/b.txt", "hello", "alice"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("StoreFile() beyond the quota of the user error = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := svc.CheckQuota("alice/b.txt", "alice", 4); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("CheckQuota() error = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := svc.CheckUploadQuota("alice", 4); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("CheckUploadQuota() error = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := svc.CheckUploadQuota("alice", 3); err != nil {
+		t.Errorf("CheckUploadQuota() within the quota error = %v", err)
+	}
+	// Other users and anonymous uploads have their own room
+	if err := store1("bob/b.txt", "hello", "bob"); err != nil {
+		t.Errorf("StoreFile() by another user error = %v", err)
+	}
+	if err := store1("c.txt", "hello world", ""); err != nil {
+		t.Errorf("StoreFile() without a user error = %v", err)
+	}
+
+	// The file stays with its owner when it moves, and stops counting when deleted
+	if _, err := svc.MoveFile("alice", "shared", false); err != nil {
+		t.Fatalf("MoveFile() error = %v", err)
+	}
+	if got := usageOf(t, svc, "alice"); got.UserBytes != 5 || got.MaxUserBytes != 8 || got.Bytes != 21 {
+		t.Errorf("Usage(alice) = %+v, want 5 of 8 bytes", got)
+	}
+
+	// Owners are remembered across restarts
+	restarted := New(svc.cfg, store)
+	if got := usageOf(t, restarted, "alice"); got.UserBytes != 5 {
+		t.Errorf("Usage(alice) after restarting = %+v, want 5 bytes", got)
+	}
+	if err := restarted.DeleteFile("shared/a.txt"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	if got := usageOf(t, restarted, "alice"); got.UserBytes != 0 {
+		t.Errorf("Usage(alice) after deleting = %+v, want nothing", got)
+	}
+	if files, _ := restarted.ListDir(""); len(files) != 3 {
+		t.Errorf("ListDir() = %+v, want the owners file hidden", files)
+	}
+}
+
+func TestService_Quota_Disabled(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	uploadAll(t, svc, "a.txt")
+	if err := svc.CheckQuota("b.txt", "alice", 1<<40); err != nil {
+		t.Errorf("CheckQuota() without quotas error = %v", err)
+	}
+	if err := svc.CheckUploadQuota("alice", 1<<40); err != nil {
+		t.Errorf("CheckUploadQuota() without quotas error = %v", err)
+	}
+	if got := usageOf(t, svc, "alice"); got != (Usage{User: "alice"}) {
+		t.Errorf("Usage() without quotas = %+v, want nothing counted", got)
+	}
+}
@@ -1,174 +1,339 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
 	"os"
-	"path/filepath"
-	"sort"
 	"sync"
 	"time"
 
 	"fsrv/internal/config"
+	"fsrv/internal/storage"
 	"fsrv/internal/util"
 )
 
-// File represents a file in the store
+// Errors returned by the service. They are wrapped with the offending filename,
+// so callers should match them with errors.Is.
+var (
+	ErrFileExists      = errors.New("file already exists")
+	ErrFileNotExist    = errors.New("file does not exist")
+	ErrFileBusy        = errors.New("file is being uploaded")
+	ErrFileTooLarge    = errors.New("file is too large")
+	ErrInvalidFilename = errors.New("invalid filename")
+	ErrDeleteDisabled  = errors.New("delete is disabled")
+)
+
+// File represents a file or, with subdirectory support, a folder in the store
 type File struct {
 	Filename     string
+	Path         string // slash separated path relative to the store root
+	IsDir        bool
 	DownloadLink string
 	Size         string
 	ModifyTime   string
 	Curl         string
+	Checksums    Checksums // empty until they have been computed
+	Expires      string    // remaining lifetime, empty if kept until deleted
 }
 
-// Service handles file operations
+// Service handles file operations on top of a storage backend.
+//
+// There is no global lock: every filename has its own lock entry, so uploads,
+// downloads, listings and deletes of unrelated files run in parallel.
 type Service struct {
-	cfg *config.Config
-	mu  sync.RWMutex
+	cfg         *config.Config
+	store       storage.Storage
+	locks       *lockTable
+	uploadLocks *lockTable // keyed by resumable upload id
+
+	shareMu     sync.Mutex // guards shareSecret and the download counts of share links
+	shareSecret []byte
+
+	dropMu  sync.Mutex // guards the files of drop links
+	trashMu sync.Mutex // serializes restoring and purging trash entries
+
+	usage    usage    // what the files take up, counted for the quotas
+	expiries expiries // when files with a limited lifetime expire
 }
 
-// New creates a new file service
-func New(cfg *config.Config) *Service {
-	return &Service{cfg: cfg}
+// New creates a new file service keeping its files in store
+func New(cfg *config.Config, store storage.Storage) *Service {
+	return &Service{
+		cfg:         cfg,
+		store:       store,
+		locks:       newLockTable(),
+		uploadLocks: newLockTable(),
+	}
 }
 
-// ListFiles returns a list of all files in the store directory
+// ListFiles returns a list of all files in the root of the store directory.
+// Files that are still being uploaded are left out.
 func (s *Service) ListFiles() ([]File, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	return s.ListDir("")
+}
 
-	dir, err := os.Open(s.cfg.Store)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open directory: %w", err)
+// UploadFile saves an uploaded file to the store.
+//
+// The filename is reserved for the duration of the transfer instead of holding
+// a lock, so a slow upload only conflicts with operations on the same name:
+// a second upload to that name fails with ErrFileBusy.
+//
+// The storage only makes the file visible once it has been received completely,
+// so an interrupted or oversized upload never leaves a truncated file behind.
+func (s *Service) UploadFile(filename string, src io.Reader) (int64, error) {
+	return s.upload(filename, src, false)
+}
+
+// ReplaceFile is like UploadFile, but an existing file is replaced instead of
+// failing with ErrFileExists. Readers see either the old or the new content.
+//
+// With versioning enabled the replaced content is kept as the newest version of
+// the file, and the oldest versions beyond the number kept per file are deleted.
+func (s *Service) ReplaceFile(filename string, src io.Reader) (int64, error) {
+	return s.upload(filename, src, true)
+}
+
+// upload implements UploadFile and ReplaceFile
+func (s *Service) upload(filename string, src io.Reader, replace bool) (int64, error) {
+	policy := ConflictFail
+	if replace {
+		policy = ConflictOverwrite
+	}
+	stored, err := s.StoreFile(filename, src, policy, "", 0)
+	return stored.Size, err
+}
+
+// save receives an upload by owner into the clean path name, whose reservation
+// the caller holds, to be kept for ttl or the server default. It reports whether
+// an existing file was replaced.
+func (s *Service) save(name string, src io.Reader, replace bool, owner string, ttl time.Duration) (StoredFile, error) {
+	// Check if file already exists before receiving any data. A file whose
+	// lifetime is over is replaced for good, without keeping a version.
+	info, err := s.store.Stat(name)
+	exists := err == nil
+	expired := exists && !info.IsDir && s.isExpired(name)
+	if exists && (!replace && !expired || info.IsDir) {
+		return StoredFile{}, fmt.Errorf("%w: '%s'", ErrFileExists, name)
+	}
+
+	// The current content steps aside into the versions first, and is put back
+	// if the upload fails
+	version := 0
+	if exists && !expired && s.IsVersioningEnabled() {
+		if version, err = s.archiveVersion(name, info); err != nil {
+			return StoredFile{}, err
+		}
 	}
-	defer dir.Close()
 
-	files, err := dir.Readdir(-1)
+	// Archived content no longer counts, so the upload is a new file then
+	freed := int64(0)
+	if exists && version == 0 {
+		freed = info.Size
+	}
+	var size int64
+	sums := s.newChecksummer()
+	quota, err := s.reserveQuota(name, owner, !exists || version > 0, freed)
+	if err == nil {
+		size, err = s.store.Put(name, io.TeeReader(quota.reader(s.diskReader(s.limitReader(src), false)), sums))
+		quota.done(err == nil)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to read directory: %w", err)
-	}
-
-	// Sort files by modification time (newest first)
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].ModTime().After(files[j].ModTime())
-	})
-
-	var result []File
-	for _, file := range files {
-		if !file.IsDir() {
-			fileName := file.Name()
-			downloadURL := fmt.Sprintf("%s/download?file=%s", s.getURLRoot(), fileName)
-
-			result = append(result, File{
-				Filename:     fileName,
-				DownloadLink: downloadURL,
-				Size:         util.HumanReadableSize(file.Size()),
-				ModifyTime:   file.ModTime().Format("2006-01-02 15:04:05"),
-				Curl:         fmt.Sprintf("curl -L -o '%s' '%s'", fileName, downloadURL),
-			})
+		if version > 0 {
+			if err := s.unarchiveVersion(name, version); err != nil {
+				log.Printf("Failed to put back %s after a failed upload: %v", name, err)
+			}
+		}
+		if errors.Is(err, ErrFileTooLarge) {
+			return StoredFile{}, fmt.Errorf("%w: max upload file size is %s", ErrFileTooLarge, s.GetMaxUploadSizeHuman())
+		}
+		if errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrInsufficientStorage) {
+			return StoredFile{}, err
 		}
+		if util.IsDiskFull(err) {
+			return StoredFile{}, diskError(err)
+		}
+		return StoredFile{}, fmt.Errorf("failed to save file: %w", err)
+	}
+	expires := s.setExpiry(name, s.FileTTL(ttl))
+	if version > 0 {
+		s.pruneVersions(name)
+	}
+	if info, err := s.store.Stat(name); err == nil {
+		s.recordChecksums(name, info, sums.checksums())
 	}
 
-	return result, nil
+	return StoredFile{Size: size, Replaced: exists && !expired, Expires: expires}, nil
 }
 
-// UploadFile saves an uploaded file to the store directory
-func (s *Service) UploadFile(filename string, src io.Reader) (int64, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// limitReader wraps src so that reading fails with ErrFileTooLarge once it has
+// yielded more than the maximum upload size, which makes the storage discard the file.
+func (s *Service) limitReader(src io.Reader) io.Reader {
+	maxSize := s.GetMaxUploadSize()
+	// Read one byte past the limit to detect oversized files
+	return &maxReader{r: io.LimitReader(src, maxSize+1), max: maxSize}
+}
 
-	safeFilename := util.SafeFileName(filename)
-	fullPath := filepath.Join(s.cfg.Store, safeFilename)
+// maxReader fails with ErrFileTooLarge after more than max bytes
+type maxReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
 
-	// Check if file already exists
-	if _, err := os.Stat(fullPath); err == nil {
-		return 0, fmt.Errorf("file already exists: '%s'", safeFilename)
+func (m *maxReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.read += int64(n)
+	if m.read > m.max {
+		return n, ErrFileTooLarge
 	}
+	return n, err
+}
 
-	// Create destination file
-	dst, err := os.Create(fullPath)
-	if err != nil {
-		return 0, fmt.Errorf("failed to create file: %w", err)
-	}
-	defer dst.Close()
+// exists reports whether anything is stored under name
+func (s *Service) exists(name string) bool {
+	_, err := s.store.Stat(name)
+	return err == nil
+}
 
-	// Copy file with buffer
-	buffer := make([]byte, 1024*1024) // 1MB buffer
-	size, err := io.CopyBuffer(dst, src, buffer)
-	if err != nil {
-		return 0, fmt.Errorf("failed to save file: %w", err)
+// tmpDir returns the directory partial resumable uploads are kept in
+func (s *Service) tmpDir() string {
+	if s.cfg.Tmp != "" {
+		return s.cfg.Tmp
 	}
-
-	return size, nil
+	return os.TempDir()
 }
 
-// DeleteFile removes a file from the store directory
+// DeleteFile removes a file from the store, if delete is enabled
 func (s *Service) DeleteFile(filename string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if !s.cfg.DelAble {
+		return ErrDeleteDisabled
+	}
 
-	safeFilename := util.SafeFileName(filename)
-	filePath := filepath.Join(s.cfg.Store, safeFilename)
+	safeFilename, err := s.cleanPath(filename)
+	if err != nil {
+		return err
+	}
+	return s.deleteFile(safeFilename, true)
+}
+
+// deleteFile removes the file stored under a clean path. With discard set the
+// file goes to the trash, if enabled; moves and overwrites clear it instead.
+func (s *Service) deleteFile(safeFilename string, discard bool) error {
+	l := s.locks.acquire(safeFilename)
+	defer s.locks.release(safeFilename, l)
+	l.Lock()
+	defer l.Unlock()
+
+	// A file that is still being written cannot be deleted
+	if s.locks.isUploading(safeFilename) {
+		return fmt.Errorf("%w: '%s'", ErrFileBusy, safeFilename)
+	}
 
 	// Check if file exists
-	info, err := os.Stat(filePath)
-	if os.IsNotExist(err) {
-		return fmt.Errorf("file does not exist: '%s'", safeFilename)
+	info, err := s.store.Stat(safeFilename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: '%s'", ErrFileNotExist, safeFilename)
 	}
 	if err != nil {
 		return fmt.Errorf("failed to check file: %w", err)
 	}
 
 	// Cannot delete directories
-	if info.IsDir() {
+	if info.IsDir {
 		return fmt.Errorf("cannot delete directory: '%s'", safeFilename)
 	}
 
-	// Delete file
-	if err := os.Remove(filePath); err != nil {
+	s.forgetChecksums(safeFilename)
+	if discard && s.IsTrashEnabled() {
+		return s.moveToTrash(safeFilename, info)
+	}
+	if err := s.store.Delete(safeFilename); err != nil {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
+	s.quotaRemoved(safeFilename, info.Size)
+	s.expiryRemoved(safeFilename)
 
 	return nil
 }
 
-// OpenFile safely opens a file for reading under a read lock.
+// StatFile returns information about a file or folder in the store. Files whose
+// lifetime is over do not exist anymore.
+func (s *Service) StatFile(filename string) (storage.FileInfo, error) {
+	safeFilename, err := s.cleanPath(filename)
+	if err != nil {
+		return storage.FileInfo{}, err
+	}
+
+	l := s.locks.acquire(safeFilename)
+	defer s.locks.release(safeFilename, l)
+	l.RLock()
+	defer l.RUnlock()
+
+	if s.locks.isUploading(safeFilename) {
+		return storage.FileInfo{}, fmt.Errorf("%w: '%s'", ErrFileBusy, safeFilename)
+	}
+
+	info, err := s.store.Stat(safeFilename)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && !info.IsDir && s.isExpired(safeFilename) {
+		return storage.FileInfo{}, fmt.Errorf("%w: '%s'", ErrFileNotExist, safeFilename)
+	}
+	if err != nil {
+		return storage.FileInfo{}, fmt.Errorf("failed to check file: %w", err)
+	}
+	return info, nil
+}
+
+// OpenFile safely opens a file for reading under the file's read lock.
 //
 // Concurrency Safety Note:
-// This method uses a read lock (RLock) to protect the file opening process.
-// Once the file is successfully opened and the handle (*os.File) is returned,
-// the lock is released. This is safe because:
+// This method uses the per-file read lock (RLock) to protect the file opening process.
+// Once the file is successfully opened and the handle is returned, the lock is released.
+// This is safe because every storage keeps serving an open file even if it is deleted
+// or replaced afterwards:
 //  1. On Unix-like systems, an open file handle remains valid even if the underlying
 //     directory entry is removed (e.g., via DeleteFile). The data can still be read fully.
 //  2. On Windows, the OS prevents deleting a file that is currently open. DeleteFile
 //     will fail, effectively protecting the ongoing download.
+//  3. Other storages hand out a snapshot of the content.
 //
 // This design minimizes lock contention by only holding the lock during the Open operation,
 // avoiding blocking other operations (like Upload/Delete) during long downloads.
-func (s *Service) OpenFile(filename string) (*os.File, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// Files that are still being uploaded cannot be opened, and those whose lifetime
+// is over do not exist anymore.
+func (s *Service) OpenFile(filename string) (storage.File, error) {
+	safeFilename, err := s.cleanPath(filename)
+	if err != nil {
+		return nil, err
+	}
 
-	safeFilename := util.SafeFileName(filename)
-	filePath := filepath.Join(s.cfg.Store, safeFilename)
+	l := s.locks.acquire(safeFilename)
+	defer s.locks.release(safeFilename, l)
+	l.RLock()
+	defer l.RUnlock()
 
-	// Check if file exists
-	info, err := os.Stat(filePath)
-	if os.IsNotExist(err) {
-		return nil, fmt.Errorf("file does not exist: '%s'", safeFilename)
+	if s.locks.isUploading(safeFilename) {
+		return nil, fmt.Errorf("%w: '%s'", ErrFileBusy, safeFilename)
+	}
+
+	// Check if file exists and its lifetime is not over
+	info, err := s.store.Stat(safeFilename)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && !info.IsDir && s.isExpired(safeFilename) {
+		return nil, fmt.Errorf("%w: '%s'", ErrFileNotExist, safeFilename)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to check file: %w", err)
 	}
 
 	// Cannot download directories
-	if info.IsDir() {
+	if info.IsDir {
 		return nil, fmt.Errorf("cannot download directory: '%s'", safeFilename)
 	}
 
 	// Open the file while holding the read lock
-	file, err := os.Open(filePath)
+	file, err := s.store.Open(safeFilename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
@@ -191,6 +356,34 @@ func (s *Service) IsDeleteEnabled() bool {
 	return s.cfg.DelAble
 }
 
+// IsSubDirsEnabled returns whether files can be organized in folders
+func (s *Service) IsSubDirsEnabled() bool {
+	return s.cfg.SubDirs
+}
+
+// IsS3APIEnabled returns whether the store is served over the S3 API
+func (s *Service) IsS3APIEnabled() bool {
+	return len(s.cfg.S3APIKeys) > 0
+}
+
+// S3APIBucket returns the bucket name the S3 API serves the store as
+func (s *Service) S3APIBucket() string {
+	return s.cfg.S3APIBucket
+}
+
+// S3APISecret returns the secret of an S3 API access key
+func (s *Service) S3APISecret(accessKey string) (string, bool) {
+	key, ok := s.cfg.S3APIKeys[accessKey]
+	return key.Secret, ok
+}
+
+// S3APIRole returns the role an S3 API access key acts with, "" for the default
+// role of users, and the folder it is limited to, "" for the whole store
+func (s *Service) S3APIRole(accessKey string) (role, dir string) {
+	key := s.cfg.S3APIKeys[accessKey]
+	return key.Role, key.Dir
+}
+
 // getURLRoot returns the base URL for the server
 func (s *Service) getURLRoot() string {
 	return fmt.Sprintf("http://%s:%s", s.cfg.Hostname, s.cfg.Port)
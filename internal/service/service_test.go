@@ -2,14 +2,18 @@ package service
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"fsrv/internal/config"
+	"fsrv/internal/storage"
 )
 
 // setupTestService creates a temporary directory and a service instance for testing.
@@ -21,18 +25,52 @@ func setupTestService(t testing.TB) (*Service, string) {
 		t.Fatalf("Failed to create temp dir: %v", err)
 	}
 
+	// Uploads are staged outside the store, like in production
+	stagingDir, err := os.MkdirTemp("", "fsrv-test-tmp-*")
+	if err != nil {
+		t.Fatalf("Failed to create staging dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(stagingDir) })
+
 	cfg := &config.Config{
 		Port:     "8080",
 		DelAble:  true,
 		Hostname: "localhost",
 		Store:    tmpDir,
 		Max:      32,
+		Tmp:      stagingDir,
 	}
 
-	svc := New(cfg)
+	svc := New(cfg, storage.NewLocal(tmpDir, stagingDir))
 	return svc, tmpDir
 }
 
+// setupMemoryService creates a service backed by in-memory storage.
+// Only resumable uploads touch the disk, in a temporary directory.
+func setupMemoryService(t testing.TB) (*Service, *storage.Memory) {
+	cfg := &config.Config{
+		Port:     "8080",
+		DelAble:  true,
+		Hostname: "localhost",
+		Max:      32,
+		Tmp:      t.TempDir(),
+	}
+
+	store := storage.NewMemory()
+	return New(cfg, store), store
+}
+
+// readMemory returns the content stored as name
+func readMemory(store *storage.Memory, name string) (string, error) {
+	file, err := store.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	return string(data), err
+}
+
 // cleanupTestService removes the temporary directory
 func cleanupTestService(t testing.TB, tmpDir string) {
 	if err := os.RemoveAll(tmpDir); err != nil {
@@ -49,7 +87,8 @@ func TestNew(t *testing.T) {
 		Max:      32,
 	}
 
-	svc := New(cfg)
+	store := storage.NewMemory()
+	svc := New(cfg, store)
 	if svc == nil {
 		t.Error("New() returned nil")
 	}
@@ -57,6 +96,10 @@ func TestNew(t *testing.T) {
 	if svc.cfg != cfg {
 		t.Error("New() did not set config correctly")
 	}
+
+	if svc.store != store {
+		t.Error("New() did not set storage correctly")
+	}
 }
 
 func TestService_ListFiles(t *testing.T) {
@@ -166,6 +209,37 @@ func TestService_UploadFile_AlreadyExists(t *testing.T) {
 	}
 }
 
+func TestService_ReplaceFile(t *testing.T) {
+	svc, store := setupMemoryService(t)
+
+	if _, err := svc.ReplaceFile("notes.txt", strings.NewReader("first")); err != nil {
+		t.Fatalf("ReplaceFile() of a new file error = %v", err)
+	}
+	if _, err := svc.ReplaceFile("notes.txt", strings.NewReader("second")); err != nil {
+		t.Fatalf("ReplaceFile() error = %v", err)
+	}
+	if data, _ := readMemory(store, "notes.txt"); data != "second" {
+		t.Errorf("stored content = %q, want second", data)
+	}
+
+	// A failed replacement keeps the old content
+	if _, err := svc.ReplaceFile("notes.txt", &failingReader{data: []byte("third")}); err == nil {
+		t.Error("ReplaceFile() should return error when the source fails")
+	}
+	if data, _ := readMemory(store, "notes.txt"); data != "second" {
+		t.Errorf("stored content after failed replace = %q, want second", data)
+	}
+
+	// Folders are never replaced
+	svc.cfg.SubDirs = true
+	if _, err := svc.MakeDir("folder"); err != nil {
+		t.Fatalf("MakeDir() error = %v", err)
+	}
+	if _, err := svc.ReplaceFile("folder", strings.NewReader("x")); !errors.Is(err, ErrFileExists) {
+		t.Errorf("ReplaceFile() of a folder error = %v, want %v", err, ErrFileExists)
+	}
+}
+
 func TestService_UploadFile_PathTraversal(t *testing.T) {
 	svc, tmpDir := setupTestService(t)
 	defer cleanupTestService(t, tmpDir)
@@ -187,6 +261,263 @@ func TestService_UploadFile_PathTraversal(t *testing.T) {
 	}
 }
 
+// failingReader returns some data and then fails, like a client that disconnects mid-upload
+type failingReader struct {
+	data []byte
+	done bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, errors.New("connection reset")
+	}
+	r.done = true
+	return copy(p, r.data), nil
+}
+
+func TestService_UploadFile_Interrupted(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	_, err := svc.UploadFile("partial.bin", &failingReader{data: []byte("partial data")})
+	if err == nil {
+		t.Fatal("UploadFile() should return error when the source fails")
+	}
+
+	// Nothing may appear in the store
+	if _, err := os.Stat(filepath.Join(tmpDir, "partial.bin")); !os.IsNotExist(err) {
+		t.Error("Interrupted upload left a file in the store")
+	}
+	files, err := svc.ListFiles()
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("ListFiles() returned %d files, want 0", len(files))
+	}
+
+	// And the staging file must be gone too
+	entries, err := os.ReadDir(svc.cfg.Tmp)
+	if err != nil {
+		t.Fatalf("Failed to read staging dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Interrupted upload left %d staging file(s)", len(entries))
+	}
+
+	// The name is free to be uploaded again
+	if _, err := svc.UploadFile("partial.bin", bytes.NewReader([]byte("full data"))); err != nil {
+		t.Errorf("UploadFile() retry error = %v", err)
+	}
+}
+
+func TestService_UploadFile_TooLarge(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+	svc.cfg.Max = 4 // 16 bytes
+
+	if _, err := svc.UploadFile("exact.bin", bytes.NewReader(make([]byte, 16))); err != nil {
+		t.Errorf("UploadFile() at the limit error = %v", err)
+	}
+
+	_, err := svc.UploadFile("big.bin", bytes.NewReader(make([]byte, 17)))
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("UploadFile() error = %v, want ErrFileTooLarge", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "big.bin")); !os.IsNotExist(err) {
+		t.Error("Oversized upload left a file in the store")
+	}
+}
+
+func TestService_UploadFile_StagingPrefix(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	_, err := svc.UploadFile(storage.StagingPrefix+"evil", bytes.NewReader([]byte("data")))
+	if err == nil {
+		t.Error("UploadFile() should reject names that look like staging files")
+	}
+}
+
+func TestService_MemoryStorage(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	svc.cfg.Max = 4 // 16 bytes
+
+	content := []byte("in memory")
+	if _, err := svc.UploadFile("memo.txt", bytes.NewReader(content)); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+	if _, err := svc.UploadFile("memo.txt", bytes.NewReader(content)); !errors.Is(err, ErrFileExists) {
+		t.Errorf("UploadFile() of existing file error = %v, want ErrFileExists", err)
+	}
+
+	// Failed uploads leave nothing behind
+	if _, err := svc.UploadFile("big.bin", bytes.NewReader(make([]byte, 17))); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("UploadFile() error = %v, want ErrFileTooLarge", err)
+	}
+	if _, err := svc.UploadFile("partial.bin", &failingReader{data: []byte("partial")}); err == nil {
+		t.Error("UploadFile() should return error when the source fails")
+	}
+
+	files, err := svc.ListFiles()
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Filename != "memo.txt" || files[0].Size != "9 B" {
+		t.Errorf("ListFiles() = %+v, want only memo.txt", files)
+	}
+
+	file, err := svc.OpenFile("memo.txt")
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	data, _ := io.ReadAll(file)
+	file.Close()
+	if !bytes.Equal(data, content) {
+		t.Errorf("OpenFile() content = %q, want %q", data, content)
+	}
+
+	if err := svc.DeleteFile("memo.txt"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	if _, err := store.Stat("memo.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("DeleteFile() did not remove the file from storage")
+	}
+	if err := svc.DeleteFile("memo.txt"); !errors.Is(err, ErrFileNotExist) {
+		t.Errorf("DeleteFile() of missing file error = %v, want ErrFileNotExist", err)
+	}
+}
+
+// startSlowUpload begins uploading filename from a pipe and returns once the
+// first chunk has been written, leaving the upload blocked mid-stream.
+// Closing the returned writer finishes the upload; its result arrives on the channel.
+func startSlowUpload(t *testing.T, svc *Service, filename string) (*io.PipeWriter, <-chan error) {
+	t.Helper()
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := svc.UploadFile(filename, pr)
+		done <- err
+	}()
+
+	if _, err := pw.Write([]byte("first chunk")); err != nil {
+		t.Fatalf("Failed to write first chunk: %v", err)
+	}
+	return pw, done
+}
+
+// within fails the test if fn does not return before the timeout
+func within(t *testing.T, what string, fn func()) {
+	t.Helper()
+
+	finished := make(chan struct{})
+	go func() {
+		fn()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s blocked behind an unrelated upload", what)
+	}
+}
+
+func TestService_SlowUploadDoesNotBlockOthers(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	content := []byte("other content")
+	if err := os.WriteFile(filepath.Join(tmpDir, "other.txt"), content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	pw, done := startSlowUpload(t, svc, "big.bin")
+
+	within(t, "ListFiles()", func() {
+		files, err := svc.ListFiles()
+		if err != nil {
+			t.Errorf("ListFiles() error = %v", err)
+			return
+		}
+		for _, f := range files {
+			if f.Filename == "big.bin" {
+				t.Error("ListFiles() should not list a file that is still being uploaded")
+			}
+		}
+	})
+
+	within(t, "OpenFile()", func() {
+		file, err := svc.OpenFile("other.txt")
+		if err != nil {
+			t.Errorf("OpenFile() error = %v", err)
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		if !bytes.Equal(data, content) {
+			t.Error("OpenFile() content does not match")
+		}
+	})
+
+	within(t, "UploadFile() of another name", func() {
+		if _, err := svc.UploadFile("small.txt", bytes.NewReader([]byte("small"))); err != nil {
+			t.Errorf("UploadFile() error = %v", err)
+		}
+	})
+
+	within(t, "DeleteFile() of another name", func() {
+		if err := svc.DeleteFile("small.txt"); err != nil {
+			t.Errorf("DeleteFile() error = %v", err)
+		}
+	})
+
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("slow UploadFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "big.bin"))
+	if err != nil {
+		t.Fatalf("Failed to read uploaded file: %v", err)
+	}
+	if string(data) != "first chunk" {
+		t.Errorf("uploaded content = %q, want %q", data, "first chunk")
+	}
+}
+
+func TestService_ConcurrentUploadSameName(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	pw, done := startSlowUpload(t, svc, "same.txt")
+
+	_, err := svc.UploadFile("same.txt", bytes.NewReader([]byte("second")))
+	if !errors.Is(err, ErrFileBusy) {
+		t.Errorf("second UploadFile() error = %v, want ErrFileBusy", err)
+	}
+
+	if err := svc.DeleteFile("same.txt"); !errors.Is(err, ErrFileBusy) {
+		t.Errorf("DeleteFile() during upload error = %v, want ErrFileBusy", err)
+	}
+
+	if _, err := svc.OpenFile("same.txt"); !errors.Is(err, ErrFileBusy) {
+		t.Errorf("OpenFile() during upload error = %v, want ErrFileBusy", err)
+	}
+
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("first UploadFile() error = %v", err)
+	}
+
+	// Once the first upload finished, the name is simply taken
+	_, err = svc.UploadFile("same.txt", bytes.NewReader([]byte("third")))
+	if !errors.Is(err, ErrFileExists) {
+		t.Errorf("UploadFile() after completion error = %v, want ErrFileExists", err)
+	}
+}
+
 func TestService_DeleteFile(t *testing.T) {
 	svc, tmpDir := setupTestService(t)
 	defer cleanupTestService(t, tmpDir)
@@ -300,7 +631,7 @@ func TestService_GetMaxUploadSize(t *testing.T) {
 		Max:      32,
 	}
 
-	svc := New(cfg)
+	svc := New(cfg, storage.NewMemory())
 	expected := int64(1 << 32) // 4GB
 
 	if size := svc.GetMaxUploadSize(); size != expected {
@@ -317,7 +648,7 @@ func TestService_GetMaxUploadSizeHuman(t *testing.T) {
 		Max:      32,
 	}
 
-	svc := New(cfg)
+	svc := New(cfg, storage.NewMemory())
 	expected := "4.0 GB"
 
 	if size := svc.GetMaxUploadSizeHuman(); size != expected {
@@ -353,7 +684,7 @@ func TestService_IsDeleteEnabled(t *testing.T) {
 				Max:      32,
 			}
 
-			svc := New(cfg)
+			svc := New(cfg, storage.NewMemory())
 			if enabled := svc.IsDeleteEnabled(); enabled != tt.expected {
 				t.Errorf("IsDeleteEnabled() = %v, want %v", enabled, tt.expected)
 			}
@@ -370,7 +701,7 @@ func TestService_GetServerInfo(t *testing.T) {
 		Max:      32,
 	}
 
-	svc := New(cfg)
+	svc := New(cfg, storage.NewMemory())
 	hostname, port, maxSize := svc.GetServerInfo()
 
 	if hostname != "test-server" {
@@ -451,7 +782,7 @@ func ExampleService_ListFiles() {
 		Max:      32,
 	}
 
-	svc := New(cfg)
+	svc := New(cfg, storage.NewLocal(tmpDir, ""))
 
 	// Create a dummy file for listing
 	if err := os.WriteFile(filepath.Join(tmpDir, "example.txt"), []byte("content"), 0644); err != nil {
@@ -486,7 +817,7 @@ func ExampleService_UploadFile() {
 		Max:      32,
 	}
 
-	svc := New(cfg)
+	svc := New(cfg, storage.NewLocal(tmpDir, ""))
 	content := []byte("Hello, World!")
 	reader := bytes.NewReader(content)
 
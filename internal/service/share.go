@@ -0,0 +1,267 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"fsrv/internal/storage"
+)
+
+// Errors returned when a share link is used
+var (
+	ErrShareInvalid   = errors.New("invalid share link")
+	ErrShareExpired   = errors.New("share link has expired")
+	ErrShareExhausted = errors.New("share link has reached its download limit")
+)
+
+// DefaultShareTTL is how long share links last unless asked otherwise
+const DefaultShareTTL = 24 * time.Hour
+
+// shareDir is the directory below the tmp directory that holds the key share links
+// are signed with and the download counts of links with a download limit
+const shareDir = "shares"
+
+// ShareLink is a signed link to download a single file without logging in.
+//
+// Everything about the link is in its URL, protected by an HMAC signature, so
+// links need no storage of their own. Only the downloads of links with a limit
+// are counted on disk, which keeps the count across restarts.
+type ShareLink struct {
+	ID           string
+	Filename     string
+	Expires      time.Time
+	MaxDownloads int // 0 for unlimited
+	Signature    string
+	URL          string // absolute URL of the link, set by CreateShareLink
+}
+
+// shareCount is the persisted download count of a share link
+type shareCount struct {
+	Downloads int       `json:"downloads"`
+	Expires   time.Time `json:"expires"`
+}
+
+// Query encodes the link as the query string of a share URL
+func (l *ShareLink) Query() string {
+	values := url.Values{}
+	values.Set("file", l.Filename)
+	values.Set("expires", strconv.FormatInt(l.Expires.Unix(), 10))
+	if l.MaxDownloads > 0 {
+		values.Set("max", strconv.Itoa(l.MaxDownloads))
+	}
+	values.Set("id", l.ID)
+	values.Set("sig", l.Signature)
+	return values.Encode()
+}
+
+// ParseShareLink decodes the query of a share URL. The signature is only checked
+// by OpenShared.
+func ParseShareLink(query url.Values) (*ShareLink, error) {
+	link := &ShareLink{
+		ID:        query.Get("id"),
+		Filename:  query.Get("file"),
+		Signature: query.Get("sig"),
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || !validID(link.ID) || link.Filename == "" || link.Signature == "" {
+		return nil, ErrShareInvalid
+	}
+	link.Expires = time.Unix(expires, 0)
+	if max := query.Get("max"); max != "" {
+		if link.MaxDownloads, err = strconv.Atoi(max); err != nil || link.MaxDownloads <= 0 {
+			return nil, ErrShareInvalid
+		}
+	}
+	return link, nil
+}
+
+// CreateShareLink signs a link to download filename. The link lasts ttl, or
+// DefaultShareTTL if ttl is not positive, but never longer than the configured
+// maximum. maxDownloads limits how often it can be used, 0 means unlimited.
+func (s *Service) CreateShareLink(filename string, ttl time.Duration, maxDownloads int) (*ShareLink, error) {
+	info, err := s.StatFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	safeFilename, _ := s.cleanPath(filename)
+	if info.IsDir {
+		return nil, fmt.Errorf("%w: cannot share folder '%s'", ErrInvalidFilename, safeFilename)
+	}
+	if maxDownloads < 0 {
+		return nil, fmt.Errorf("invalid download limit: %d", maxDownloads)
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultShareTTL
+	}
+	if maxTTL := s.GetShareMaxTTL(); ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	key, err := s.shareKey()
+	if err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	link := &ShareLink{
+		ID:           id,
+		Filename:     safeFilename,
+		Expires:      time.Now().Add(ttl).Truncate(time.Second),
+		MaxDownloads: maxDownloads,
+	}
+	link.Signature = signShare(key, link)
+	link.URL = s.getURLRoot() + "/share?" + link.Query()
+	return link, nil
+}
+
+// OpenShared checks the signature, expiry and download limit of a link and opens
+// its file. With count set the download is counted against the limit; callers
+// leave it unset for requests that do not transfer the file, such as HEAD.
+func (s *Service) OpenShared(link *ShareLink, count bool) (storage.File, error) {
+	key, err := s.shareKey()
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(signShare(key, link)), []byte(link.Signature)) {
+		return nil, ErrShareInvalid
+	}
+	if time.Now().After(link.Expires) {
+		return nil, fmt.Errorf("%w on %s", ErrShareExpired, link.Expires.Format("2006-01-02 15:04:05"))
+	}
+	if link.MaxDownloads == 0 {
+		return s.OpenFile(link.Filename)
+	}
+
+	s.shareMu.Lock()
+	defer s.shareMu.Unlock()
+
+	counted := s.readShareCount(link.ID)
+	if counted.Downloads >= link.MaxDownloads {
+		return nil, fmt.Errorf("%w of %d", ErrShareExhausted, link.MaxDownloads)
+	}
+	file, err := s.OpenFile(link.Filename)
+	if err != nil || !count {
+		return file, err
+	}
+
+	counted.Downloads++
+	counted.Expires = link.Expires
+	if err := s.writeShareCount(link.ID, counted); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// GetShareMaxTTL returns the longest time a share link may last
+func (s *Service) GetShareMaxTTL() time.Duration {
+	if s.cfg.ShareMaxTTL > 0 {
+		return s.cfg.ShareMaxTTL
+	}
+	return 30 * 24 * time.Hour
+}
+
+// purgeExpiredShares removes the download counts of links that have expired
+func (s *Service) purgeExpiredShares() (int, error) {
+	matches, err := filepath.Glob(filepath.Join(s.tmpDir(), shareDir, "*.json"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find share links: %w", err)
+	}
+
+	s.shareMu.Lock()
+	defer s.shareMu.Unlock()
+
+	removed := 0
+	now := time.Now()
+	for _, match := range matches {
+		counted := s.readShareCount(strings.TrimSuffix(filepath.Base(match), ".json"))
+		if now.After(counted.Expires) {
+			if err := os.Remove(match); err == nil {
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+// shareKey returns the key share links are signed with, creating it on first use.
+// It is kept in the tmp directory, so links stay valid across restarts.
+func (s *Service) shareKey() ([]byte, error) {
+	s.shareMu.Lock()
+	defer s.shareMu.Unlock()
+	if s.shareSecret != nil {
+		return s.shareSecret, nil
+	}
+
+	path := filepath.Join(s.tmpDir(), shareDir, "key")
+	key, err := os.ReadFile(path)
+	if err == nil && len(key) >= 32 {
+		s.shareSecret = key
+		return key, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read share key: %w", err)
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate share key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create share directory: %w", err)
+	}
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save share key: %w", err)
+	}
+	s.shareSecret = key
+	return key, nil
+}
+
+// readShareCount returns the download count of a link, zero if it was never used.
+// The caller must hold s.shareMu.
+func (s *Service) readShareCount(id string) shareCount {
+	var counted shareCount
+	if data, err := os.ReadFile(s.shareCountPath(id)); err == nil {
+		json.Unmarshal(data, &counted)
+	}
+	return counted
+}
+
+// writeShareCount persists the download count of a link. The caller must hold s.shareMu.
+func (s *Service) writeShareCount(id string, counted shareCount) error {
+	data, err := json.Marshal(counted)
+	if err != nil {
+		return fmt.Errorf("failed to encode download count: %w", err)
+	}
+	if err := os.WriteFile(s.shareCountPath(id), data, 0644); err != nil {
+		return fmt.Errorf("failed to save download count: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) shareCountPath(id string) string {
+	return filepath.Join(s.tmpDir(), shareDir, id+".json")
+}
+
+// signShare returns the signature of a link. The filename comes last, as it is
+// the only field that may contain the separator.
+func signShare(key []byte, link *ShareLink) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d\n%d\n%s", link.ID, link.Expires.Unix(), link.MaxDownloads, link.Filename)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
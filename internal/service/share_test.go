@@ -0,0 +1,133 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openShareURL parses a share URL and opens its file like a GET request would
+func openShareURL(svc *Service, shareURL string) (string, error) {
+	u, err := url.Parse(shareURL)
+	if err != nil {
+		return "", err
+	}
+	link, err := ParseShareLink(u.Query())
+	if err != nil {
+		return "", err
+	}
+	file, err := svc.OpenShared(link, true)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	return string(data), err
+}
+
+func TestService_ShareLink(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	svc.cfg.SubDirs = true
+	if _, err := svc.UploadFile("docs/report.txt", strings.NewReader("quarterly")); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+
+	link, err := svc.CreateShareLink("docs/report.txt", time.Hour, 0)
+	if err != nil {
+		t.Fatalf("CreateShareLink() error = %v", err)
+	}
+	if !strings.HasPrefix(link.URL, "http://localhost:8080/share?") {
+		t.Errorf("CreateShareLink() URL = %q", link.URL)
+	}
+	if until := time.Until(link.Expires); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("CreateShareLink() expires in %s, want 1h", until)
+	}
+
+	for i := 0; i < 3; i++ {
+		if content, err := openShareURL(svc, link.URL); err != nil || content != "quarterly" {
+			t.Fatalf("download %d = %q, %v", i, content, err)
+		}
+	}
+
+	// Anything changed in the link breaks its signature
+	tampered := []string{
+		strings.Replace(link.URL, "report.txt", "other.txt", 1),
+		strings.Replace(link.URL, "expires=", "expires=9", 1),
+		link.URL + "&max=1",
+	}
+	for _, u := range tampered {
+		if _, err := openShareURL(svc, u); !errors.Is(err, ErrShareInvalid) {
+			t.Errorf("tampered link %s: error = %v, want %v", u, err, ErrShareInvalid)
+		}
+	}
+
+	store.Delete("docs/report.txt")
+	if _, err := openShareURL(svc, link.URL); !errors.Is(err, ErrFileNotExist) {
+		t.Errorf("link to a deleted file: error = %v, want %v", err, ErrFileNotExist)
+	}
+
+	if _, err := svc.CreateShareLink("docs", time.Hour, 0); !errors.Is(err, ErrInvalidFilename) {
+		t.Errorf("CreateShareLink() of a folder error = %v, want %v", err, ErrInvalidFilename)
+	}
+	if _, err := svc.CreateShareLink("missing.txt", time.Hour, 0); !errors.Is(err, ErrFileNotExist) {
+		t.Errorf("CreateShareLink() of a missing file error = %v, want %v", err, ErrFileNotExist)
+	}
+}
+
+func TestService_ShareLink_Limits(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	svc.cfg.ShareMaxTTL = 2 * time.Hour
+	if _, err := svc.UploadFile("a.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+
+	link, err := svc.CreateShareLink("a.txt", 1000*time.Hour, 2)
+	if err != nil {
+		t.Fatalf("CreateShareLink() error = %v", err)
+	}
+	if time.Until(link.Expires) > 2*time.Hour {
+		t.Errorf("CreateShareLink() expires %s, want at most the configured 2h", link.Expires)
+	}
+
+	// HEAD requests do not count
+	file, err := svc.OpenShared(link, false)
+	if err != nil {
+		t.Fatalf("OpenShared() without counting error = %v", err)
+	}
+	file.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := openShareURL(svc, link.URL); err != nil {
+			t.Fatalf("download %d error = %v", i, err)
+		}
+	}
+	if _, err := openShareURL(svc, link.URL); !errors.Is(err, ErrShareExhausted) {
+		t.Errorf("third download error = %v, want %v", err, ErrShareExhausted)
+	}
+
+	// The count survives a restart, which keeps the key and counts in the tmp directory
+	restarted := New(svc.cfg, svc.store)
+	if _, err := openShareURL(restarted, link.URL); !errors.Is(err, ErrShareExhausted) {
+		t.Errorf("download after restart error = %v, want %v", err, ErrShareExhausted)
+	}
+
+	expired, err := svc.CreateShareLink("a.txt", time.Hour, 1)
+	if err != nil {
+		t.Fatalf("CreateShareLink() error = %v", err)
+	}
+	key, _ := svc.shareKey()
+	expired.Expires = time.Now().Add(-time.Minute).Truncate(time.Second)
+	expired.Signature = signShare(key, expired)
+	if _, err := svc.OpenShared(expired, true); !errors.Is(err, ErrShareExpired) {
+		t.Errorf("expired link error = %v, want %v", err, ErrShareExpired)
+	}
+
+	// The download counts of expired links are purged
+	svc.writeShareCount(expired.ID, shareCount{Downloads: 1, Expires: expired.Expires})
+	if n, err := svc.purgeExpiredShares(); err != nil || n != 1 {
+		t.Errorf("purgeExpiredShares() = %d, %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"fsrv/internal/storage"
+	"fsrv/internal/util"
+)
+
+// ErrTrashNotFound is returned for trash entries that do not exist
+var ErrTrashNotFound = errors.New("trash entry not found")
+
+// trashDir is the hidden folder in the store root that holds deleted files.
+// It is kept in the store rather than the tmp directory, so deleting and
+// restoring is a rename for the storages that support it.
+const trashDir = ".fsrv-trash"
+
+// TrashEntry is a deleted file waiting in the trash to be restored or purged.
+//
+// Each entry is stored as two files in the trash folder: the content under its
+// ID and a description under its ID with a ".json" extension.
+type TrashEntry struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"` // where the file was deleted from
+	Size     int64     `json:"size"`
+	SizeText string    `json:"-"`
+	Deleted  time.Time `json:"deleted"`
+	Expires  time.Time `json:"expires"` // when the janitor purges it
+}
+
+// trashInfo is the persisted description of a trash entry
+type trashInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Deleted time.Time `json:"deleted"`
+}
+
+// IsTrashEnabled reports whether deleted files go to the trash
+func (s *Service) IsTrashEnabled() bool {
+	return s.cfg.TrashRetention > 0
+}
+
+// ListTrash returns the entries in the trash, most recently deleted first
+func (s *Service) ListTrash() ([]TrashEntry, error) {
+	files, err := s.store.List(trashDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trash: %w", err)
+	}
+
+	var entries []TrashEntry
+	for _, file := range files {
+		id, ok := strings.CutSuffix(file.Name, ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+		entry, err := s.readTrashEntry(id)
+		if err != nil {
+			// The entry may have been restored or purged in the meantime
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Deleted.After(entries[j].Deleted)
+	})
+	return entries, nil
+}
+
+// GetTrashEntry returns an entry in the trash
+func (s *Service) GetTrashEntry(id string) (*TrashEntry, error) {
+	if !validID(id) {
+		return nil, ErrTrashNotFound
+	}
+	return s.readTrashEntry(id)
+}
+
+// RestoreTrash moves a file from the trash back to where it was deleted from,
+// returning its path. It fails with ErrFileExists if something has been stored
+// there since.
+func (s *Service) RestoreTrash(id string) (string, error) {
+	entry, err := s.GetTrashEntry(id)
+	if err != nil {
+		return "", err
+	}
+
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+
+	name := entry.Name
+	if !s.locks.reserve(name) {
+		return "", fmt.Errorf("%w: '%s'", ErrFileBusy, name)
+	}
+	defer s.locks.unreserve(name)
+
+	if _, err := s.store.Stat(name); err == nil {
+		return "", fmt.Errorf("%w: '%s'", ErrFileExists, name)
+	}
+	if err := s.transfer(trashPath(id), name); err != nil {
+		return "", fmt.Errorf("failed to restore file: %w", err)
+	}
+	s.store.Delete(trashPath(id) + ".json")
+	return name, nil
+}
+
+// PurgeTrash permanently deletes an entry from the trash
+func (s *Service) PurgeTrash(id string) error {
+	if !validID(id) {
+		return ErrTrashNotFound
+	}
+
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+
+	dataErr := s.store.Delete(trashPath(id))
+	infoErr := s.store.Delete(trashPath(id) + ".json")
+	s.quotaRemoved(trashPath(id), 0)
+	s.expiryRemoved(trashPath(id))
+	if errors.Is(dataErr, fs.ErrNotExist) && errors.Is(infoErr, fs.ErrNotExist) {
+		return ErrTrashNotFound
+	}
+	for _, err := range []error{dataErr, infoErr} {
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to purge trash entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// purgeExpiredTrash permanently deletes the entries that have been in the trash
+// longer than the retention period
+func (s *Service) purgeExpiredTrash() (int, error) {
+	if !s.IsTrashEnabled() {
+		return 0, nil
+	}
+	entries, err := s.ListTrash()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	now := time.Now()
+	for _, entry := range entries {
+		if now.After(entry.Expires) {
+			if err := s.PurgeTrash(entry.ID); err == nil {
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+// moveToTrash moves the file stored under a clean path into the trash.
+// The caller must hold the write lock of name.
+func (s *Service) moveToTrash(name string, info storage.FileInfo) error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	// The description goes first, so the content is never left without one
+	data, err := json.Marshal(trashInfo{Name: name, Size: info.Size, Deleted: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to encode trash entry: %w", err)
+	}
+	if _, err := s.store.Put(trashPath(id)+".json", bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to save trash entry: %w", err)
+	}
+	if err := s.transfer(name, trashPath(id)); err != nil {
+		s.store.Delete(trashPath(id) + ".json")
+		return fmt.Errorf("failed to move file to the trash: %w", err)
+	}
+	return nil
+}
+
+// transfer moves a file within the store without any locking, renaming it if
+// the storage supports that and copying it otherwise
+func (s *Service) transfer(from, to string) error {
+	if err := s.move(from, to); err != nil {
+		return err
+	}
+	s.quotaMoved(from, to)
+	s.expiryMoved(from, to)
+	return nil
+}
+
+// move implements transfer
+func (s *Service) move(from, to string) error {
+	if renamer, ok := s.store.(storage.Renamer); ok {
+		return renamer.Rename(from, to)
+	}
+
+	file, err := s.store.Open(from)
+	if err != nil {
+		return err
+	}
+	_, err = s.store.Put(to, file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return s.store.Delete(from)
+}
+
+// readTrashEntry loads the description of a trash entry
+func (s *Service) readTrashEntry(id string) (*TrashEntry, error) {
+	file, err := s.store.Open(trashPath(id) + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrTrashNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trash entry: %w", err)
+	}
+	defer file.Close()
+
+	var info trashInfo
+	if err := json.NewDecoder(file).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode trash entry: %w", err)
+	}
+	return &TrashEntry{
+		ID:       id,
+		Name:     info.Name,
+		Size:     info.Size,
+		SizeText: util.HumanReadableSize(info.Size),
+		Deleted:  info.Deleted,
+		Expires:  info.Deleted.Add(s.cfg.TrashRetention),
+	}, nil
+}
+
+// trashPath returns where the content of a trash entry is stored
+func trashPath(id string) string {
+	return path.Join(trashDir, id)
+}
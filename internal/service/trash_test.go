@@ -0,0 +1,133 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_Trash(t *testing.T) {
+	// Storages that cannot rename copy files in and out of the trash
+	for name, svc := range moveStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc.cfg.TrashRetention = time.Hour
+			if _, err := svc.UploadFile("docs/a.txt", strings.NewReader("first")); err != nil {
+				t.Fatalf("UploadFile() error = %v", err)
+			}
+
+			if err := svc.DeleteFile("docs/a.txt"); err != nil {
+				t.Fatalf("DeleteFile() error = %v", err)
+			}
+			if _, err := svc.StatFile("docs/a.txt"); !errors.Is(err, ErrFileNotExist) {
+				t.Errorf("StatFile() of a deleted file error = %v, want %v", err, ErrFileNotExist)
+			}
+			entries, err := svc.ListTrash()
+			if err != nil || len(entries) != 1 || entries[0].Name != "docs/a.txt" || entries[0].Size != 5 {
+				t.Fatalf("ListTrash() = %+v, %v", entries, err)
+			}
+			if until := time.Until(entries[0].Expires); until <= 59*time.Minute || until > time.Hour {
+				t.Errorf("trash entry expires in %s, want 1h", until)
+			}
+			id := entries[0].ID
+
+			// The trash is hidden from listings and cannot be addressed
+			files, _ := svc.ListDir("")
+			tree, _ := svc.ListTree("", true)
+			if len(files) != 1 || files[0].Path != "docs" || len(tree) != 0 {
+				t.Errorf("listings show the trash: %+v %+v", files, tree)
+			}
+			for _, name := range []string{trashDir, trashDir + "/" + id, "docs/../" + trashDir + "/" + id} {
+				if _, err := svc.OpenFile(name); !errors.Is(err, ErrInvalidFilename) {
+					t.Errorf("OpenFile(%q) error = %v, want %v", name, err, ErrInvalidFilename)
+				}
+			}
+			if _, err := svc.ListDir(trashDir); !errors.Is(err, ErrInvalidFilename) {
+				t.Errorf("ListDir() of the trash error = %v, want %v", err, ErrInvalidFilename)
+			}
+
+			// Restoring refuses to replace a file stored under the name since
+			if _, err := svc.UploadFile("docs/a.txt", strings.NewReader("second")); err != nil {
+				t.Fatalf("UploadFile() error = %v", err)
+			}
+			if _, err := svc.RestoreTrash(id); !errors.Is(err, ErrFileExists) {
+				t.Errorf("RestoreTrash() over an existing file error = %v, want %v", err, ErrFileExists)
+			}
+			if err := svc.DeleteFile("docs/a.txt"); err != nil {
+				t.Fatalf("DeleteFile() error = %v", err)
+			}
+			restored, err := svc.RestoreTrash(id)
+			if err != nil || restored != "docs/a.txt" || readFile(t, svc, "docs/a.txt") != "first" {
+				t.Fatalf("RestoreTrash() = %q, %v", restored, err)
+			}
+			if _, err := svc.RestoreTrash(id); !errors.Is(err, ErrTrashNotFound) {
+				t.Errorf("second RestoreTrash() error = %v, want %v", err, ErrTrashNotFound)
+			}
+
+			// The other version is still in the trash, until it is purged
+			entries, _ = svc.ListTrash()
+			if len(entries) != 1 {
+				t.Fatalf("ListTrash() = %+v, want the second version", entries)
+			}
+			if err := svc.PurgeTrash(entries[0].ID); err != nil {
+				t.Errorf("PurgeTrash() error = %v", err)
+			}
+			if err := svc.PurgeTrash(entries[0].ID); !errors.Is(err, ErrTrashNotFound) {
+				t.Errorf("second PurgeTrash() error = %v, want %v", err, ErrTrashNotFound)
+			}
+			if entries, _ := svc.ListTrash(); len(entries) != 0 {
+				t.Errorf("ListTrash() after purge = %+v", entries)
+			}
+		})
+	}
+}
+
+func TestService_Trash_Folders(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	svc.cfg.SubDirs = true
+	svc.cfg.TrashRetention = time.Hour
+	for _, name := range []string{"old/a.txt", "old/sub/b.txt", "keep.txt", "other.txt"} {
+		if _, err := svc.UploadFile(name, strings.NewReader(name)); err != nil {
+			t.Fatalf("UploadFile() error = %v", err)
+		}
+	}
+
+	// Removing a folder puts every file in it into the trash
+	if err := svc.RemoveAll("old"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if entries, _ := svc.ListTrash(); len(entries) != 2 {
+		t.Errorf("ListTrash() after RemoveAll = %+v, want 2 entries", entries)
+	}
+
+	// A file replaced by a move is not deleted by the user, so it skips the trash
+	if _, err := svc.MoveFile("other.txt", "keep.txt", true); err != nil {
+		t.Fatalf("MoveFile() error = %v", err)
+	}
+	if entries, _ := svc.ListTrash(); len(entries) != 2 {
+		t.Errorf("ListTrash() after an overwriting move = %+v, want 2 entries", entries)
+	}
+
+	// Entries are purged once they have been kept for the retention period
+	svc.cfg.TrashRetention = time.Nanosecond
+	if n, err := svc.purgeExpiredTrash(); err != nil || n != 2 {
+		t.Errorf("purgeExpiredTrash() = %d, %v, want 2", n, err)
+	}
+}
+
+func TestService_Trash_Disabled(t *testing.T) {
+	svc, store := setupMemoryService(t)
+	if _, err := svc.UploadFile("a.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+
+	if err := svc.DeleteFile("a.txt"); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	if entries, err := svc.ListTrash(); err != nil || len(entries) != 0 {
+		t.Errorf("ListTrash() = %+v, %v, want nothing", entries, err)
+	}
+	if _, err := store.Stat(trashDir); err == nil {
+		t.Error("trash folder was created although the trash is disabled")
+	}
+}
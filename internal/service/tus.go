@@ -0,0 +1,391 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fsrv/internal/storage"
+	"fsrv/internal/util"
+)
+
+// Errors returned by the resumable upload methods
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrOffsetMismatch   = errors.New("upload offset mismatch")
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+)
+
+// resumableDir is the directory below the tmp directory that holds resumable uploads
+const resumableDir = "resumable"
+
+// ResumableUpload describes an upload that is received in several requests (tus protocol).
+//
+// The received bytes live in a data file under the tmp directory, so the offset
+// is simply the size of that file and survives restarts. Once the last byte
+// arrives, the data file is committed to the store like a regular upload.
+type ResumableUpload struct {
+	ID       string
+	Filename string
+	Owner    string        // user the file counts toward the quota of, if any
+	TTL      time.Duration // how long the file is kept once complete, see FileTTL
+	Length   int64
+	Offset   int64
+	Expires  time.Time
+}
+
+// resumableInfo is the part of a resumable upload that is persisted next to its data
+type resumableInfo struct {
+	Filename string        `json:"filename"`
+	Owner    string        `json:"owner,omitempty"`
+	TTL      time.Duration `json:"ttl,omitempty"`
+	Length   int64         `json:"length"`
+	Created  time.Time     `json:"created"`
+}
+
+// CreateUpload starts a new resumable upload of length bytes by owner that will
+// be stored as filename and kept for ttl. It fails with ErrQuotaExceeded right
+// away if the file would not fit in the quotas.
+func (s *Service) CreateUpload(filename string, length int64, owner string, ttl time.Duration) (*ResumableUpload, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidFilename, filename)
+	}
+	safeFilename, err := s.cleanPath(filename)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("invalid upload length: %d", length)
+	}
+	if length > s.GetMaxUploadSize() {
+		return nil, fmt.Errorf("%w: %s exceeds the limit of %s", ErrFileTooLarge,
+			util.HumanReadableSize(length), s.GetMaxUploadSizeHuman())
+	}
+
+	// Fail early instead of after the whole file has been transferred
+	if s.exists(safeFilename) {
+		return nil, fmt.Errorf("%w: '%s'", ErrFileExists, safeFilename)
+	}
+	if err := s.CheckQuota(safeFilename, owner, length); err != nil {
+		return nil, err
+	}
+	if err := s.checkDiskSpace(length, true); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(s.tmpDir(), resumableDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	info := resumableInfo{Filename: safeFilename, Owner: owner, TTL: ttl, Length: length, Created: time.Now()}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode upload info: %w", err)
+	}
+	if err := os.WriteFile(s.uploadDataPath(id), nil, 0644); err != nil {
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	if err := os.WriteFile(s.uploadInfoPath(id), data, 0644); err != nil {
+		os.Remove(s.uploadDataPath(id))
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	upload := &ResumableUpload{
+		ID:       id,
+		Filename: safeFilename,
+		Owner:    owner,
+		TTL:      ttl,
+		Length:   length,
+		Expires:  time.Now().Add(s.uploadExpiry()),
+	}
+
+	// A zero length upload is complete as soon as it is created
+	if length == 0 {
+		if err := s.finishUpload(upload); err != nil {
+			return nil, err
+		}
+	}
+
+	return upload, nil
+}
+
+// GetUpload returns the current state of a resumable upload
+func (s *Service) GetUpload(id string) (*ResumableUpload, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("%w: '%s'", ErrUploadNotFound, id)
+	}
+
+	data, err := os.ReadFile(s.uploadInfoPath(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: '%s'", ErrUploadNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload info: %w", err)
+	}
+
+	var info resumableInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode upload info: %w", err)
+	}
+
+	stat, err := os.Stat(s.uploadDataPath(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: '%s'", ErrUploadNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check upload: %w", err)
+	}
+
+	return &ResumableUpload{
+		ID:       id,
+		Filename: info.Filename,
+		Owner:    info.Owner,
+		TTL:      info.TTL,
+		Length:   info.Length,
+		Offset:   stat.Size(),
+		Expires:  stat.ModTime().Add(s.uploadExpiry()),
+	}, nil
+}
+
+// AppendUpload appends data from src to a resumable upload. offset must match the
+// number of bytes received so far. Data beyond the declared length is ignored.
+// When the upload becomes complete it is committed to the store.
+func (s *Service) AppendUpload(id string, offset int64, src io.Reader) (*ResumableUpload, error) {
+	// Only one request may append to an upload at a time
+	if !s.uploadLocks.reserve(id) {
+		return nil, fmt.Errorf("%w: '%s'", ErrFileBusy, id)
+	}
+	defer s.uploadLocks.unreserve(id)
+
+	upload, err := s.GetUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return nil, fmt.Errorf("%w: got %d, want %d", ErrOffsetMismatch, offset, upload.Offset)
+	}
+
+	if upload.Offset < upload.Length {
+		// Other uploads may have taken the room since this one was created
+		if err := s.checkDiskSpace(upload.Length-upload.Offset, true); err != nil {
+			return nil, err
+		}
+		dst, err := os.OpenFile(s.uploadDataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open upload: %w", err)
+		}
+
+		// Keep whatever arrived even if the client disconnects halfway,
+		// so that it can resume from there
+		buffer := make([]byte, 1024*1024) // 1MB buffer
+		n, copyErr := io.CopyBuffer(dst, io.LimitReader(src, upload.Length-upload.Offset), buffer)
+		syncErr := dst.Sync()
+		closeErr := dst.Close()
+		upload.Offset += n
+		upload.Expires = time.Now().Add(s.uploadExpiry())
+
+		for _, err := range []error{copyErr, syncErr, closeErr} {
+			if util.IsDiskFull(err) {
+				return upload, diskError(err)
+			}
+			if err != nil {
+				return upload, fmt.Errorf("failed to save upload data: %w", err)
+			}
+		}
+	}
+
+	if upload.Offset == upload.Length {
+		if err := s.finishUpload(upload); err != nil {
+			return upload, err
+		}
+	}
+
+	return upload, nil
+}
+
+// TerminateUpload aborts a resumable upload and discards the received data
+func (s *Service) TerminateUpload(id string) error {
+	if !s.uploadLocks.reserve(id) {
+		return fmt.Errorf("%w: '%s'", ErrFileBusy, id)
+	}
+	defer s.uploadLocks.unreserve(id)
+
+	if _, err := s.GetUpload(id); err != nil {
+		return err
+	}
+	return s.removeUpload(id)
+}
+
+// PurgeExpiredUploads removes incomplete resumable uploads that have not received
+// any data within the configured expiry. It returns the number of uploads removed.
+func (s *Service) PurgeExpiredUploads() (int, error) {
+	matches, err := filepath.Glob(filepath.Join(s.tmpDir(), resumableDir, "*.json"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find uploads: %w", err)
+	}
+
+	removed := 0
+	now := time.Now()
+	for _, match := range matches {
+		id := strings.TrimSuffix(filepath.Base(match), ".json")
+		if !s.uploadLocks.reserve(id) {
+			continue // being appended to right now, so clearly not expired
+		}
+
+		// Uploads whose data file went missing are useless, so they go as well
+		upload, err := s.GetUpload(id)
+		if errors.Is(err, ErrUploadNotFound) || (err == nil && now.After(upload.Expires)) {
+			if err := s.removeUpload(id); err == nil {
+				removed++
+			}
+		}
+		s.uploadLocks.unreserve(id)
+	}
+
+	return removed, nil
+}
+
+// finishUpload commits the data of a complete resumable upload to the store,
+// going through the same reservation as UploadFile. Storages that can import
+// local files take the data file over without copying it.
+func (s *Service) finishUpload(upload *ResumableUpload) error {
+	if upload.Offset != upload.Length {
+		return fmt.Errorf("%w: '%s'", ErrUploadIncomplete, upload.ID)
+	}
+
+	if !s.locks.reserve(upload.Filename) {
+		return fmt.Errorf("%w: '%s'", ErrFileBusy, upload.Filename)
+	}
+	defer s.locks.unreserve(upload.Filename)
+
+	// Something may have appeared under the name while the data was trickling in
+	if s.exists(upload.Filename) {
+		return fmt.Errorf("%w: '%s'", ErrFileExists, upload.Filename)
+	}
+
+	// The quotas may have filled up while the data was trickling in
+	quota, err := s.reserveQuota(upload.Filename, upload.Owner, true, 0)
+	if err != nil {
+		return err
+	}
+	var sums Checksums
+	if err = quota.grow(upload.Length); err == nil {
+		sums, err = s.commitUpload(upload)
+	}
+	quota.done(err == nil)
+	if err != nil {
+		return err
+	}
+	s.setExpiry(upload.Filename, s.FileTTL(upload.TTL))
+	if info, err := s.store.Stat(upload.Filename); err == nil {
+		s.recordChecksums(upload.Filename, info, sums)
+	}
+	return s.removeUpload(upload.ID)
+}
+
+// commitUpload moves the data of a complete resumable upload into the store,
+// returning its checksums like other uploads record them
+func (s *Service) commitUpload(upload *ResumableUpload) (Checksums, error) {
+	sums := s.newChecksummer()
+	if importer, ok := s.store.(storage.Importer); ok {
+		// The data is read once more for its checksums before it is moved
+		if err := s.readUploadData(upload, sums); err != nil {
+			return Checksums{}, err
+		}
+		if err := importer.Import(upload.Filename, s.uploadDataPath(upload.ID)); err != nil {
+			return Checksums{}, diskError(err)
+		}
+		return sums.checksums(), nil
+	}
+	if err := s.putUploadData(upload, sums); err != nil {
+		return Checksums{}, diskError(err)
+	}
+	return sums.checksums(), nil
+}
+
+// readUploadData reads the data of a resumable upload into sums
+func (s *Service) readUploadData(upload *ResumableUpload, sums io.Writer) error {
+	data, err := os.Open(s.uploadDataPath(upload.ID))
+	if err != nil {
+		return fmt.Errorf("failed to open upload data: %w", err)
+	}
+	defer data.Close()
+
+	if _, err := io.Copy(sums, data); err != nil {
+		return fmt.Errorf("failed to read upload data: %w", err)
+	}
+	return nil
+}
+
+// putUploadData copies the data of a resumable upload into the store, and into sums
+func (s *Service) putUploadData(upload *ResumableUpload, sums io.Writer) error {
+	data, err := os.Open(s.uploadDataPath(upload.ID))
+	if err != nil {
+		return fmt.Errorf("failed to open upload data: %w", err)
+	}
+	defer data.Close()
+
+	if _, err := s.store.Put(upload.Filename, io.TeeReader(data, sums)); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+	return nil
+}
+
+// removeUpload deletes the files belonging to a resumable upload
+func (s *Service) removeUpload(id string) error {
+	if err := os.Remove(s.uploadDataPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove upload: %w", err)
+	}
+	if err := os.Remove(s.uploadInfoPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove upload: %w", err)
+	}
+	return nil
+}
+
+// uploadExpiry returns how long an incomplete resumable upload is kept without activity
+func (s *Service) uploadExpiry() time.Duration {
+	if s.cfg.TusExpiry > 0 {
+		return s.cfg.TusExpiry
+	}
+	return 24 * time.Hour
+}
+
+func (s *Service) uploadDataPath(id string) string {
+	return filepath.Join(s.tmpDir(), resumableDir, id+".bin")
+}
+
+func (s *Service) uploadInfoPath(id string) string {
+	return filepath.Join(s.tmpDir(), resumableDir, id+".json")
+}
+
+// newID returns a random identifier for an upload or share link
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validID reports whether id looks like an identifier made by newID.
+// This keeps client supplied ids from escaping the directories they are used in.
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_ResumableUpload(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	content := []byte("hello resumable world")
+	upload, err := svc.CreateUpload("resume.txt", int64(len(content)), "", 0)
+	if err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+	if upload.Offset != 0 {
+		t.Errorf("CreateUpload() offset = %d, want 0", upload.Offset)
+	}
+
+	// First chunk
+	upload, err = svc.AppendUpload(upload.ID, 0, bytes.NewReader(content[:5]))
+	if err != nil {
+		t.Fatalf("AppendUpload() error = %v", err)
+	}
+	if upload.Offset != 5 {
+		t.Errorf("AppendUpload() offset = %d, want 5", upload.Offset)
+	}
+
+	// Nothing is visible in the store until the upload is complete
+	if _, err := os.Stat(filepath.Join(tmpDir, "resume.txt")); !os.IsNotExist(err) {
+		t.Error("Incomplete upload is visible in the store")
+	}
+
+	// The offset survives a lookup, as it would survive a restart
+	got, err := svc.GetUpload(upload.ID)
+	if err != nil {
+		t.Fatalf("GetUpload() error = %v", err)
+	}
+	if got.Offset != 5 || got.Length != int64(len(content)) || got.Filename != "resume.txt" {
+		t.Errorf("GetUpload() = %+v", got)
+	}
+
+	// Rest of the data
+	upload, err = svc.AppendUpload(upload.ID, 5, bytes.NewReader(content[5:]))
+	if err != nil {
+		t.Fatalf("AppendUpload() error = %v", err)
+	}
+	if upload.Offset != int64(len(content)) {
+		t.Errorf("AppendUpload() offset = %d, want %d", upload.Offset, len(content))
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "resume.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read committed file: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("committed content = %q, want %q", data, content)
+	}
+
+	// The upload state is gone after commit
+	if _, err := svc.GetUpload(upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("GetUpload() after commit error = %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestService_ResumableUpload_MemoryStorage(t *testing.T) {
+	svc, store := setupMemoryService(t)
+
+	upload, err := svc.CreateUpload("docs.txt", 8, "", 0)
+	if err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+	if _, err := svc.AppendUpload(upload.ID, 0, bytes.NewReader([]byte("complete"))); err != nil {
+		t.Fatalf("AppendUpload() error = %v", err)
+	}
+
+	// Storages that cannot import local files get a copy of the data
+	file, err := store.Open("docs.txt")
+	if err != nil {
+		t.Fatalf("Completed upload is missing from storage: %v", err)
+	}
+	defer file.Close()
+	if info, _ := file.Stat(); info.Size != 8 {
+		t.Errorf("stored size = %d, want 8", info.Size)
+	}
+	if _, err := svc.GetUpload(upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("GetUpload() after completion error = %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestService_AppendUpload_OffsetMismatch(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	upload, err := svc.CreateUpload("a.txt", 10, "", 0)
+	if err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+
+	_, err = svc.AppendUpload(upload.ID, 3, strings.NewReader("abc"))
+	if !errors.Is(err, ErrOffsetMismatch) {
+		t.Errorf("AppendUpload() error = %v, want ErrOffsetMismatch", err)
+	}
+}
+
+func TestService_AppendUpload_IgnoresExcess(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	upload, err := svc.CreateUpload("short.txt", 3, "", 0)
+	if err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+
+	upload, err = svc.AppendUpload(upload.ID, 0, strings.NewReader("abcdef"))
+	if err != nil {
+		t.Fatalf("AppendUpload() error = %v", err)
+	}
+	if upload.Offset != 3 {
+		t.Errorf("AppendUpload() offset = %d, want 3", upload.Offset)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(tmpDir, "short.txt"))
+	if string(data) != "abc" {
+		t.Errorf("committed content = %q, want %q", data, "abc")
+	}
+}
+
+func TestService_CreateUpload_Errors(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "exists.txt"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		length   int64
+		want     error
+	}{
+		{name: "existing file", filename: "exists.txt", length: 1, want: ErrFileExists},
+		{name: "too large", filename: "big.bin", length: svc.GetMaxUploadSize() + 1, want: ErrFileTooLarge},
+		{name: "no filename", filename: "", length: 1, want: ErrInvalidFilename},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.CreateUpload(tt.filename, tt.length, "", 0)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("CreateUpload() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_CreateUpload_Empty(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	if _, err := svc.CreateUpload("empty.txt", 0, "", 0); err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "empty.txt")); err != nil {
+		t.Error("Zero length upload was not committed")
+	}
+}
+
+func TestService_TerminateUpload(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	upload, err := svc.CreateUpload("a.txt", 10, "", 0)
+	if err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+
+	if err := svc.TerminateUpload(upload.ID); err != nil {
+		t.Fatalf("TerminateUpload() error = %v", err)
+	}
+	if _, err := svc.GetUpload(upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("GetUpload() after terminate error = %v, want ErrUploadNotFound", err)
+	}
+	if err := svc.TerminateUpload(upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("second TerminateUpload() error = %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestService_GetUpload_InvalidID(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+
+	for _, id := range []string{"", "../../etc/passwd", "not-hex-not-hex-not-hex-not-hex!"} {
+		if _, err := svc.GetUpload(id); !errors.Is(err, ErrUploadNotFound) {
+			t.Errorf("GetUpload(%q) error = %v, want ErrUploadNotFound", id, err)
+		}
+	}
+}
+
+func TestService_PurgeExpiredUploads(t *testing.T) {
+	svc, tmpDir := setupTestService(t)
+	defer cleanupTestService(t, tmpDir)
+	svc.cfg.TusExpiry = time.Hour
+
+	stale, err := svc.CreateUpload("stale.txt", 10, "", 0)
+	if err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+	fresh, err := svc.CreateUpload("fresh.txt", 10, "", 0)
+	if err != nil {
+		t.Fatalf("CreateUpload() error = %v", err)
+	}
+
+	// Pretend the stale upload has not seen any data for two hours
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(svc.uploadDataPath(stale.ID), old, old); err != nil {
+		t.Fatalf("Failed to age upload: %v", err)
+	}
+
+	n, err := svc.PurgeExpiredUploads()
+	if err != nil {
+		t.Fatalf("PurgeExpiredUploads() error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("PurgeExpiredUploads() removed %d uploads, want 1", n)
+	}
+	if _, err := svc.GetUpload(stale.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Error("Expired upload was not purged")
+	}
+	if _, err := svc.GetUpload(fresh.ID); err != nil {
+		t.Errorf("Active upload was purged: %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"fsrv/internal/storage"
+	"fsrv/internal/util"
+)
+
+// ErrVersionNotFound is returned for versions that do not exist
+var ErrVersionNotFound = errors.New("version not found")
+
+// versionsDir is the hidden folder in the store root that holds the previous
+// versions of files. Like the trash it lives in the store, so keeping a version
+// is a rename for the storages that support it.
+const versionsDir = ".fsrv-versions"
+
+// FileVersion is a previous content of a file, kept when a newer one was uploaded.
+//
+// The versions of a file live in a folder named after a hash of its path, which
+// keeps the layout flat whatever the path looks like. Each version is stored as
+// its content under its number and a description under its number with a
+// ".json" extension. Numbers count up from 1 and are never reused for a path.
+type FileVersion struct {
+	Number   int       `json:"version"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	SizeText string    `json:"-"`
+	Modified time.Time `json:"modified"` // when this content was uploaded
+	Replaced time.Time `json:"replaced"` // when a newer content took its place
+}
+
+// versionInfo is the persisted description of a version
+type versionInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Replaced time.Time `json:"replaced"`
+}
+
+// IsVersioningEnabled reports whether uploading to a taken name keeps the
+// previous content as a version
+func (s *Service) IsVersioningEnabled() bool {
+	return s.cfg.Versions > 0
+}
+
+// MaxVersions returns how many previous versions are kept per file
+func (s *Service) MaxVersions() int {
+	return s.cfg.Versions
+}
+
+// ListVersions returns the previous versions of a file, newest first.
+//
+// Versions belong to the path rather than the file: they stay when the file is
+// deleted or moved away, and carry on if something is stored under the path again.
+func (s *Service) ListVersions(filename string) ([]FileVersion, error) {
+	name, err := s.cleanPath(filename)
+	if err != nil {
+		return nil, err
+	}
+	numbers, err := s.versionNumbers(name)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]FileVersion, 0, len(numbers))
+	for i := len(numbers) - 1; i >= 0; i-- {
+		version, err := s.readVersion(name, numbers[i])
+		if err != nil {
+			// The version may have been restored or pruned in the meantime
+			continue
+		}
+		versions = append(versions, *version)
+	}
+	return versions, nil
+}
+
+// OpenVersion opens a previous version of a file for reading
+func (s *Service) OpenVersion(filename string, number int) (storage.File, *FileVersion, error) {
+	name, err := s.cleanPath(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	version, err := s.readVersion(name, number)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := s.store.Open(versionPath(name, number))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%w: %d of '%s'", ErrVersionNotFound, number, name)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open version: %w", err)
+	}
+	return file, version, nil
+}
+
+// RestoreVersion makes a previous version the current content of a file again.
+// The content it replaces is kept as the newest version, so a restore can be
+// undone like any other upload.
+func (s *Service) RestoreVersion(filename string, number int) error {
+	name, err := s.cleanPath(filename)
+	if err != nil {
+		return err
+	}
+
+	if !s.locks.reserve(name) {
+		return fmt.Errorf("%w: '%s'", ErrFileBusy, name)
+	}
+	defer s.locks.unreserve(name)
+
+	if _, err := s.readVersion(name, number); err != nil {
+		return err
+	}
+
+	info, err := s.store.Stat(name)
+	switch {
+	case err == nil && info.IsDir:
+		return fmt.Errorf("%w: '%s'", ErrFileExists, name)
+	case err == nil:
+		if _, err := s.archiveVersion(name, info); err != nil {
+			return err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("failed to check file: %w", err)
+	}
+
+	if err := s.transfer(versionPath(name, number), name); err != nil {
+		return fmt.Errorf("failed to restore version: %w", err)
+	}
+	s.forgetChecksums(name)
+	s.store.Delete(versionPath(name, number) + ".json")
+	s.pruneVersions(name)
+	return nil
+}
+
+// archiveVersion moves the file stored under a clean path into its versions,
+// returning the number it was given. The caller must hold the reservation of name.
+func (s *Service) archiveVersion(name string, info storage.FileInfo) (int, error) {
+	numbers, err := s.versionNumbers(name)
+	if err != nil {
+		return 0, err
+	}
+	number := 1
+	if len(numbers) > 0 {
+		number = numbers[len(numbers)-1] + 1
+	}
+
+	// The description goes first, so the content is never left without one
+	data, err := json.Marshal(versionInfo{Name: name, Size: info.Size, Modified: info.ModTime, Replaced: time.Now()})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode version: %w", err)
+	}
+	if _, err := s.store.Put(versionPath(name, number)+".json", bytes.NewReader(data)); err != nil {
+		return 0, fmt.Errorf("failed to save version: %w", err)
+	}
+
+	// Wait for readers that are opening the file right now
+	l := s.locks.acquire(name)
+	defer s.locks.release(name, l)
+	l.Lock()
+	defer l.Unlock()
+
+	if err := s.transfer(name, versionPath(name, number)); err != nil {
+		s.store.Delete(versionPath(name, number) + ".json")
+		return 0, fmt.Errorf("failed to keep previous version: %w", err)
+	}
+	return number, nil
+}
+
+// unarchiveVersion puts the content archived for an upload that failed back
+// in place, as if the upload had never happened
+func (s *Service) unarchiveVersion(name string, number int) error {
+	if err := s.transfer(versionPath(name, number), name); err != nil {
+		return fmt.Errorf("failed to put back previous version: %w", err)
+	}
+	return s.store.Delete(versionPath(name, number) + ".json")
+}
+
+// pruneVersions deletes the oldest versions of a clean path beyond the number
+// kept per file
+func (s *Service) pruneVersions(name string) {
+	if !s.IsVersioningEnabled() {
+		return
+	}
+	numbers, err := s.versionNumbers(name)
+	if err != nil {
+		return
+	}
+	for len(numbers) > s.cfg.Versions {
+		s.store.Delete(versionPath(name, numbers[0]))
+		s.store.Delete(versionPath(name, numbers[0]) + ".json")
+		s.quotaRemoved(versionPath(name, numbers[0]), 0)
+		s.expiryRemoved(versionPath(name, numbers[0]))
+		numbers = numbers[1:]
+	}
+}
+
+// versionNumbers returns the numbers of the versions kept for a clean path, in
+// ascending order
+func (s *Service) versionNumbers(name string) ([]int, error) {
+	files, err := s.store.List(versionFolder(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read versions: %w", err)
+	}
+
+	var numbers []int
+	for _, file := range files {
+		if n, err := strconv.Atoi(file.Name); err == nil && n > 0 && !file.IsDir {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// readVersion loads the description of a version
+func (s *Service) readVersion(name string, number int) (*FileVersion, error) {
+	if number <= 0 {
+		return nil, fmt.Errorf("%w: %d of '%s'", ErrVersionNotFound, number, name)
+	}
+	file, err := s.store.Open(versionPath(name, number) + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %d of '%s'", ErrVersionNotFound, number, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version: %w", err)
+	}
+	defer file.Close()
+
+	var info versionInfo
+	if err := json.NewDecoder(file).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode version: %w", err)
+	}
+	return &FileVersion{
+		Number:   number,
+		Name:     info.Name,
+		Size:     info.Size,
+		SizeText: util.HumanReadableSize(info.Size),
+		Modified: info.Modified,
+		Replaced: info.Replaced,
+	}, nil
+}
+
+// versionFolder returns the folder the versions of a clean path are kept in
+func versionFolder(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return path.Join(versionsDir, hex.EncodeToString(sum[:16]))
+}
+
+// versionPath returns where the content of a version is stored
+func versionPath(name string, number int) string {
+	return path.Join(versionFolder(name), strconv.Itoa(number))
+}
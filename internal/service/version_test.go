@@ -0,0 +1,132 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// listVersionNumbers lists the numbers of the versions of name, newest first
+func listVersionNumbers(t *testing.T, svc *Service, name string) []int {
+	t.Helper()
+	versions, err := svc.ListVersions(name)
+	if err != nil {
+		t.Fatalf("ListVersions() error = %v", err)
+	}
+	var numbers []int
+	for _, v := range versions {
+		numbers = append(numbers, v.Number)
+	}
+	return numbers
+}
+
+// readVersionContent returns the content of a version
+func readVersionContent(t *testing.T, svc *Service, name string, number int) string {
+	t.Helper()
+	file, _, err := svc.OpenVersion(name, number)
+	if err != nil {
+		t.Fatalf("OpenVersion(%s, %d) error = %v", name, number, err)
+	}
+	defer file.Close()
+	data, _ := io.ReadAll(file)
+	return string(data)
+}
+
+func TestService_Versions(t *testing.T) {
+	for name, svc := range moveStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc.cfg.Versions = 2
+			for _, content := range []string{"v1", "v2", "v3", "v4"} {
+				if _, err := svc.ReplaceFile("docs/a.txt", strings.NewReader(content)); err != nil {
+					t.Fatalf("ReplaceFile() error = %v", err)
+				}
+			}
+
+			// The oldest version beyond the cap is gone
+			if got := readFile(t, svc, "docs/a.txt"); got != "v4" {
+				t.Errorf("current content = %q, want v4", got)
+			}
+			if got := listVersionNumbers(t, svc, "docs/a.txt"); len(got) != 2 || got[0] != 3 || got[1] != 2 {
+				t.Fatalf("versions = %v, want [3 2]", got)
+			}
+			versions, _ := svc.ListVersions("docs/a.txt")
+			if v := versions[0]; v.Name != "docs/a.txt" || v.Size != 2 || v.Replaced.Before(v.Modified) {
+				t.Errorf("newest version = %+v", v)
+			}
+			if got := readVersionContent(t, svc, "docs/a.txt", 3); got != "v3" {
+				t.Errorf("version 3 = %q, want v3", got)
+			}
+
+			// UploadFile still refuses taken names, and the versions stay hidden
+			if _, err := svc.UploadFile("docs/a.txt", strings.NewReader("v5")); !errors.Is(err, ErrFileExists) {
+				t.Errorf("UploadFile() to a taken name error = %v, want %v", err, ErrFileExists)
+			}
+			if files, _ := svc.ListDir(""); len(files) != 1 || files[0].Path != "docs" {
+				t.Errorf("ListDir() = %+v, want only docs", files)
+			}
+			if _, err := svc.OpenFile(versionPath("docs/a.txt", 3)); !errors.Is(err, ErrInvalidFilename) {
+				t.Errorf("OpenFile() of a version error = %v, want %v", err, ErrInvalidFilename)
+			}
+
+			// A failed upload leaves the file and its versions as they were
+			svc.cfg.Max = 3
+			if _, err := svc.ReplaceFile("docs/a.txt", strings.NewReader("far too large")); !errors.Is(err, ErrFileTooLarge) {
+				t.Errorf("oversized ReplaceFile() error = %v, want %v", err, ErrFileTooLarge)
+			}
+			svc.cfg.Max = 32
+			if got := readFile(t, svc, "docs/a.txt"); got != "v4" {
+				t.Errorf("content after a failed upload = %q, want v4", got)
+			}
+			if got := listVersionNumbers(t, svc, "docs/a.txt"); len(got) != 2 || got[0] != 3 {
+				t.Errorf("versions after a failed upload = %v, want [3 2]", got)
+			}
+
+			// Restoring keeps the content it replaces as the newest version
+			if err := svc.RestoreVersion("docs/a.txt", 2); err != nil {
+				t.Fatalf("RestoreVersion() error = %v", err)
+			}
+			if got := readFile(t, svc, "docs/a.txt"); got != "v2" {
+				t.Errorf("restored content = %q, want v2", got)
+			}
+			if got := listVersionNumbers(t, svc, "docs/a.txt"); len(got) != 2 || got[0] != 4 || got[1] != 3 {
+				t.Fatalf("versions after restore = %v, want [4 3]", got)
+			}
+			if got := readVersionContent(t, svc, "docs/a.txt", 4); got != "v4" {
+				t.Errorf("version 4 = %q, want v4", got)
+			}
+			if err := svc.RestoreVersion("docs/a.txt", 2); !errors.Is(err, ErrVersionNotFound) {
+				t.Errorf("second RestoreVersion() error = %v, want %v", err, ErrVersionNotFound)
+			}
+
+			// Versions belong to the path, so they outlive the file
+			if err := svc.DeleteFile("docs/a.txt"); err != nil {
+				t.Fatalf("DeleteFile() error = %v", err)
+			}
+			if err := svc.RestoreVersion("docs/a.txt", 3); err != nil {
+				t.Fatalf("RestoreVersion() of a deleted file error = %v", err)
+			}
+			if got := readFile(t, svc, "docs/a.txt"); got != "v3" {
+				t.Errorf("restored content = %q, want v3", got)
+			}
+			if got := listVersionNumbers(t, svc, "docs/a.txt"); len(got) != 1 || got[0] != 4 {
+				t.Errorf("versions = %v, want [4]", got)
+			}
+		})
+	}
+}
+
+func TestService_Versions_Disabled(t *testing.T) {
+	svc, _ := setupMemoryService(t)
+	for _, content := range []string{"v1", "v2"} {
+		if _, err := svc.ReplaceFile("a.txt", strings.NewReader(content)); err != nil {
+			t.Fatalf("ReplaceFile() error = %v", err)
+		}
+	}
+	if got := listVersionNumbers(t, svc, "a.txt"); len(got) != 0 {
+		t.Errorf("versions = %v, want none", got)
+	}
+	if _, _, err := svc.OpenVersion("a.txt", 1); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("OpenVersion() error = %v, want %v", err, ErrVersionNotFound)
+	}
+}
@@ -0,0 +1,172 @@
+package sigv4
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxChunkSize bounds the chunks of an aws-chunked body, which are buffered
+// until their signature has been checked. Clients use 64 KiB to a few MiB.
+const maxChunkSize = 16 << 20
+
+// Body returns a reader for the payload of a verified request.
+//
+// A body with a declared SHA-256 is hashed while it is read and the final Read
+// fails with ErrPayloadMismatch if it does not match. aws-chunked bodies are
+// decoded and every chunk signature is checked before its data is handed out.
+// Trailers, which only carry checksums, are skipped. An unsigned payload is
+// returned as is.
+func (a *Auth) Body(body io.Reader) (io.Reader, error) {
+	switch a.PayloadHash {
+	case UnsignedPayload:
+		return body, nil
+	case StreamingPayload, StreamingPayloadTrailer:
+		return &chunkedReader{r: bufio.NewReader(body), auth: a, prevSignature: a.signature}, nil
+	case StreamingUnsignedPayloadTrailer:
+		return &chunkedReader{r: bufio.NewReader(body)}, nil
+	}
+
+	want, err := hex.DecodeString(a.PayloadHash)
+	if err != nil || len(want) != sha256.Size {
+		return nil, fmt.Errorf("%w: unsupported payload hash '%s'", ErrMalformedAuth, a.PayloadHash)
+	}
+	return &hashReader{r: body, hash: sha256.New(), want: want}, nil
+}
+
+// hashReader fails at the end of r if the content does not hash to want
+type hashReader struct {
+	r    io.Reader
+	hash hash.Hash
+	want []byte
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(h.hash.Sum(nil), h.want) {
+		return n, ErrPayloadMismatch
+	}
+	return n, err
+}
+
+// chunkedReader decodes an aws-chunked body:
+//
+//	<hex size>[;chunk-signature=<signature>]\r\n<data>\r\n ... 0[;chunk-signature=<signature>]\r\n[trailers]\r\n
+//
+// With auth set, each chunk is signed over the signature of the previous one,
+// starting with the seed signature of the request.
+type chunkedReader struct {
+	r             *bufio.Reader
+	auth          *Auth
+	prevSignature string
+	chunk         []byte
+	done          bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+// next reads and checks the following chunk
+func (c *chunkedReader) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	sizeField, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return fmt.Errorf("%w: invalid chunk size '%s'", ErrMalformedPayload, sizeField)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return unexpected(err)
+	}
+
+	if c.auth != nil {
+		signature, ok := strings.CutPrefix(extension, "chunk-signature=")
+		if !ok {
+			return fmt.Errorf("%w: missing chunk signature", ErrMalformedPayload)
+		}
+		if !hmac.Equal([]byte(signature), []byte(c.auth.chunkSignature(c.prevSignature, data))) {
+			return ErrPayloadMismatch
+		}
+		c.prevSignature = signature
+	}
+
+	if size == 0 {
+		// The final chunk is followed by optional trailers and an empty line
+		for {
+			line, err := c.readLine()
+			if err != nil {
+				return err
+			}
+			if line == "" {
+				break
+			}
+		}
+		c.done = true
+		return nil
+	}
+
+	if line, err := c.readLine(); err != nil || line != "" {
+		return fmt.Errorf("%w: chunk is longer than declared", ErrMalformedPayload)
+	}
+	c.chunk = data
+	return nil
+}
+
+// readLine reads a CRLF terminated line of reasonable length
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("%w: line too long", ErrMalformedPayload)
+	}
+	if err != nil {
+		return "", unexpected(err)
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+// chunkSignature computes the signature of a chunk following prevSignature
+func (a *Auth) chunkSignature(prevSignature string, data []byte) string {
+	sum := sha256.Sum256(data)
+	stringToSign := strings.Join([]string{
+		Algorithm + "-PAYLOAD",
+		a.amzDate,
+		a.scope,
+		prevSignature,
+		EmptySHA256,
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+	return hex.EncodeToString(hmacSHA256(a.signingKey, stringToSign))
+}
+
+// unexpected turns a premature end of the body into an error, since a chunked
+// body is only complete once its final chunk has been read
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,378 @@
+// Package sigv4 implements AWS Signature Version 4 as used by S3, both for
+// signing outgoing requests and for verifying incoming ones.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants of the signing protocol
+const (
+	Algorithm       = "AWS4-HMAC-SHA256"
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+	EmptySHA256     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	TimeFormat      = "20060102T150405Z"
+	dateFormat      = "20060102"
+
+	// Payload hashes announcing an aws-chunked body, see Auth.Body
+	StreamingPayload                = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	StreamingPayloadTrailer         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	StreamingUnsignedPayloadTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	// MaxSkew is how far the request time may be from the server's clock
+	MaxSkew = 15 * time.Minute
+)
+
+// Errors returned by Verify and, for the payload, by the readers of Auth.Body
+var (
+	ErrMissingAuth       = errors.New("request is not signed")
+	ErrMalformedAuth     = errors.New("malformed authorization")
+	ErrUnknownAccessKey  = errors.New("unknown access key")
+	ErrSignatureMismatch = errors.New("signature does not match")
+	ErrRequestExpired    = errors.New("request time is too skewed")
+	ErrPayloadMismatch   = errors.New("payload does not match its signature")
+	ErrMalformedPayload  = errors.New("malformed chunked payload")
+)
+
+// Credentials is an access key and its secret
+type Credentials struct {
+	AccessKey string
+	SecretKey string
+}
+
+// Sign adds the X-Amz-Date, X-Amz-Content-Sha256 and Authorization headers to req.
+//
+// payloadHash is the hex encoded SHA-256 of the request body, or UnsignedPayload.
+// The host, Content-Type, Content-MD5, Range and all X-Amz-* headers are signed,
+// so they must be set before calling Sign.
+func Sign(req *http.Request, creds Credentials, region, service, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := headersToSign(req)
+	scope := credentialScope(amzDate[:8], region, service)
+	canonical := canonicalRequest(req, canonicalQuery(req.URL.Query()), signed, payloadHash)
+	key := signingKey(creds.SecretKey, amzDate[:8], region, service)
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign(amzDate, scope, canonical)))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		Algorithm, creds.AccessKey, scope, strings.Join(signed, ";"), signature))
+}
+
+// Presign adds query string authentication to the URL of req, valid for expires.
+// Only the host header is signed, so the URL can be used by any client.
+func Presign(req *http.Request, creds Credentials, region, service string, expires time.Duration, now time.Time) {
+	amzDate := now.UTC().Format(TimeFormat)
+	scope := credentialScope(amzDate[:8], region, service)
+
+	query := req.URL.Query()
+	query.Set("X-Amz-Algorithm", Algorithm)
+	query.Set("X-Amz-Credential", creds.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonical := canonicalRequest(req, canonicalQuery(query), []string{"host"}, UnsignedPayload)
+	key := signingKey(creds.SecretKey, amzDate[:8], region, service)
+	query.Set("X-Amz-Signature", hex.EncodeToString(hmacSHA256(key, stringToSign(amzDate, scope, canonical))))
+	req.URL.RawQuery = canonicalQuery(query)
+}
+
+// Auth is the result of a successful verification
+type Auth struct {
+	AccessKey string
+
+	// PayloadHash is what the client declared in X-Amz-Content-Sha256: a hex
+	// encoded SHA-256, UnsignedPayload or one of the Streaming* values
+	PayloadHash string
+
+	amzDate    string
+	scope      string
+	signature  string
+	signingKey []byte
+}
+
+// Verify checks the signature of req, given in the Authorization header or as a
+// presigned URL, and returns who signed it. secretFor looks up the secret of an
+// access key.
+//
+// Only the headers are verified. The payload hash the client declared is part
+// of the signature, but checking that the body matches it is up to the caller,
+// see Auth.Body.
+func Verify(req *http.Request, secretFor func(accessKey string) (string, bool), now time.Time) (*Auth, error) {
+	if req.URL.Query().Get("X-Amz-Algorithm") != "" {
+		return verifyPresigned(req, secretFor, now)
+	}
+
+	header := req.Header.Get("Authorization")
+	if header == "" {
+		return nil, ErrMissingAuth
+	}
+
+	auth, err := parseAuthorization(header)
+	if err != nil {
+		return nil, err
+	}
+
+	amzDate := req.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse(TimeFormat, amzDate)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid X-Amz-Date", ErrMalformedAuth)
+	}
+	if skew := now.Sub(signedAt); skew > MaxSkew || skew < -MaxSkew {
+		return nil, ErrRequestExpired
+	}
+
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = EmptySHA256
+	}
+
+	canonical := canonicalRequest(req, canonicalQuery(req.URL.Query()), auth.signedHeaders, payloadHash)
+	return auth.check(amzDate, canonical, payloadHash, secretFor)
+}
+
+// verifyPresigned checks query string authentication
+func verifyPresigned(req *http.Request, secretFor func(accessKey string) (string, bool), now time.Time) (*Auth, error) {
+	query := req.URL.Query()
+	if query.Get("X-Amz-Algorithm") != Algorithm {
+		return nil, fmt.Errorf("%w: unsupported algorithm '%s'", ErrMalformedAuth, query.Get("X-Amz-Algorithm"))
+	}
+
+	auth, err := parseCredential(query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders"), query.Get("X-Amz-Signature"))
+	if err != nil {
+		return nil, err
+	}
+
+	amzDate := query.Get("X-Amz-Date")
+	signedAt, err := time.Parse(TimeFormat, amzDate)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid X-Amz-Date", ErrMalformedAuth)
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || expires > 7*24*3600 {
+		return nil, fmt.Errorf("%w: invalid X-Amz-Expires", ErrMalformedAuth)
+	}
+	if now.Before(signedAt.Add(-MaxSkew)) || now.After(signedAt.Add(time.Duration(expires)*time.Second)) {
+		return nil, ErrRequestExpired
+	}
+
+	query.Del("X-Amz-Signature")
+	canonical := canonicalRequest(req, canonicalQuery(query), auth.signedHeaders, UnsignedPayload)
+	return auth.check(amzDate, canonical, UnsignedPayload, secretFor)
+}
+
+// check compares the signature against the one computed for canonical
+func (a *authorization) check(amzDate, canonical, payloadHash string, secretFor func(string) (string, bool)) (*Auth, error) {
+	if a.date != amzDate[:8] {
+		return nil, fmt.Errorf("%w: credential date does not match X-Amz-Date", ErrMalformedAuth)
+	}
+
+	secret, ok := secretFor(a.accessKey)
+	if !ok {
+		return nil, ErrUnknownAccessKey
+	}
+
+	scope := credentialScope(a.date, a.region, a.service)
+	key := signingKey(secret, a.date, a.region, a.service)
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign(amzDate, scope, canonical)))
+	if !hmac.Equal([]byte(expected), []byte(a.signature)) {
+		return nil, ErrSignatureMismatch
+	}
+
+	return &Auth{
+		AccessKey:   a.accessKey,
+		PayloadHash: payloadHash,
+		amzDate:     amzDate,
+		scope:       scope,
+		signature:   a.signature,
+		signingKey:  key,
+	}, nil
+}
+
+// authorization is a parsed Authorization header
+type authorization struct {
+	accessKey     string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+}
+
+// parseAuthorization parses "AWS4-HMAC-SHA256 Credential=..., SignedHeaders=..., Signature=..."
+func parseAuthorization(header string) (*authorization, error) {
+	algorithm, rest, _ := strings.Cut(header, " ")
+	if algorithm != Algorithm {
+		return nil, fmt.Errorf("%w: unsupported algorithm '%s'", ErrMalformedAuth, algorithm)
+	}
+
+	fields := make(map[string]string)
+	for _, field := range strings.Split(rest, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: '%s'", ErrMalformedAuth, field)
+		}
+		fields[key] = value
+	}
+
+	return parseCredential(fields["Credential"], fields["SignedHeaders"], fields["Signature"])
+}
+
+// parseCredential parses "<access key>/<date>/<region>/<service>/aws4_request" along
+// with the signed headers and the signature
+func parseCredential(credential, signedHeaders, signature string) (*authorization, error) {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return nil, fmt.Errorf("%w: invalid credential", ErrMalformedAuth)
+	}
+	if signedHeaders == "" || signature == "" {
+		return nil, fmt.Errorf("%w: missing signed headers or signature", ErrMalformedAuth)
+	}
+
+	return &authorization{
+		accessKey:     parts[0],
+		date:          parts[1],
+		region:        parts[2],
+		service:       parts[3],
+		signedHeaders: strings.Split(signedHeaders, ";"),
+		signature:     signature,
+	}, nil
+}
+
+// headersToSign returns the sorted, lower case names of the headers Sign covers
+func headersToSign(req *http.Request) []string {
+	signed := []string{"host"}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		switch {
+		case strings.HasPrefix(lower, "x-amz-"),
+			lower == "content-type", lower == "content-md5", lower == "range":
+			signed = append(signed, lower)
+		}
+	}
+	sort.Strings(signed)
+	return signed
+}
+
+// canonicalRequest builds the canonical form of req covering the given headers
+func canonicalRequest(req *http.Request, query string, signedHeaders []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		headers.WriteString(name)
+		headers.WriteByte(':')
+		headers.WriteString(headerValue(req, name))
+		headers.WriteByte('\n')
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		EncodePath(req.URL.Path),
+		query,
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// headerValue returns the canonical value of a header: values joined by commas,
+// with surrounding whitespace trimmed and inner runs of spaces collapsed
+func headerValue(req *http.Request, name string) string {
+	if name == "host" {
+		if req.Host != "" {
+			return req.Host
+		}
+		return req.URL.Host
+	}
+
+	values := req.Header.Values(name)
+	// Servers take Content-Length out of the headers
+	if name == "content-length" && len(values) == 0 && req.ContentLength >= 0 {
+		values = []string{strconv.FormatInt(req.ContentLength, 10)}
+	}
+	for i, v := range values {
+		values[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+// canonicalQuery returns the query parameters sorted and strictly URI encoded
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, Encode(key)+"="+Encode(value))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// credentialScope returns "<date>/<region>/<service>/aws4_request"
+func credentialScope(date, region, service string) string {
+	return date + "/" + region + "/" + service + "/aws4_request"
+}
+
+// stringToSign combines the request time, scope and canonical request hash
+func stringToSign(amzDate, scope, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	return Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+}
+
+// signingKey derives the key requests within a credential scope are signed with
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Encode URI encodes s the way SigV4 requires: everything but A-Z, a-z, 0-9,
+// '-', '.', '_' and '~' is percent encoded with upper case hex digits.
+func Encode(s string) string {
+	return encode(s, false)
+}
+
+// EncodePath is like Encode but leaves slashes alone, for URL paths
+func EncodePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return encode(p, true)
+}
+
+func encode(s string, keepSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || keepSlash && c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
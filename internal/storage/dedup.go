@@ -0,0 +1,598 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DedupDir is the folder Dedup keeps the content of files in. It is hidden from
+// listings and cannot be used as a name.
+const DedupDir = ".fsrv-blobs"
+
+// dedupStaging is where uploads are received before their checksum is known
+const dedupStaging = DedupDir + "/staging"
+
+// dedupKey holds the key pointers are signed with
+const dedupKey = DedupDir + "/key"
+
+// maxPointerSize is the largest file that is looked at as a pointer. Files no
+// larger than that are not worth deduplicating.
+const maxPointerSize = 512
+
+// Dedup stores the content of files once, no matter under how many names.
+//
+// Every distinct content is a blob named by its SHA-256 in DedupDir, and the
+// names in the store are small pointers to blobs. Blobs are reference counted:
+// deleting or replacing a name only deletes its blob when no other name points
+// to it any more. To the outside, names behave like in any other Storage,
+// with the size and modification time of the file they stand for.
+//
+// The reference counts are not stored but counted by NewDedup, which also
+// takes over files that were put into the inner storage without going through
+// a Dedup. Such files are served as they are until then.
+//
+// Pointers are signed with a key kept in DedupDir, so that a file whose content
+// merely looks like a pointer is never taken for one.
+//
+// Files no larger than a pointer gain nothing from deduplication, and neither
+// does bookkeeping that is rewritten all the time, so both are stored in the
+// inner storage as they are.
+type Dedup struct {
+	inner   Storage
+	renamer Renamer
+	key     []byte
+	plain   func(name string) bool
+
+	mu    sync.Mutex // guards blobs, and orders blob changes with the pointers to them
+	blobs map[string]*blob
+}
+
+// blob is the bookkeeping of a stored content
+type blob struct {
+	refs int
+	size int64
+}
+
+// pointer is the content of a name in the inner storage
+type pointer struct {
+	Blob     string    `json:"fsrv-blob"` // hex SHA-256 of the content
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Sig      string    `json:"sig"` // hex HMAC-SHA256 of the fields above
+}
+
+// DedupStats describes how much space deduplication saves
+type DedupStats struct {
+	Files  int   // number of deduplicated names
+	Blobs  int   // number of distinct contents
+	Size   int64 // total size of the files
+	Stored int64 // bytes actually stored for them
+}
+
+// NewDedup returns a deduplicating Storage on top of inner, which must be able
+// to rename files. plain, if not nil, reports the names of bookkeeping files,
+// which are stored as they are. It counts the references to every blob, turns
+// files that are not pointers yet into blobs, and deletes blobs no name points
+// to and uploads that were interrupted.
+func NewDedup(inner Storage, plain func(name string) bool) (*Dedup, error) {
+	renamer, ok := inner.(Renamer)
+	if !ok {
+		return nil, errors.New("deduplication needs a storage that can rename files")
+	}
+	d := &Dedup{inner: inner, renamer: renamer, plain: plain, blobs: make(map[string]*blob)}
+
+	if err := d.loadKey(); err != nil {
+		return nil, fmt.Errorf("failed to load the pointer key: %w", err)
+	}
+	if err := d.removeAll(dedupStaging); err != nil {
+		return nil, fmt.Errorf("failed to remove interrupted uploads: %w", err)
+	}
+	if err := d.scan(""); err != nil {
+		return nil, fmt.Errorf("failed to count file references: %w", err)
+	}
+	if err := d.sweep(); err != nil {
+		return nil, fmt.Errorf("failed to remove unused blobs: %w", err)
+	}
+	return d, nil
+}
+
+// IsDeduplicated reports whether inner has been used by a Dedup. Its files are
+// pointers then, which are only served as the files they stand for by a Dedup.
+func IsDeduplicated(inner Storage) (bool, error) {
+	_, err := inner.Stat(DedupDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Stats reports how many files share how many blobs
+func (d *Dedup) Stats() DedupStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var stats DedupStats
+	for _, b := range d.blobs {
+		stats.Files += b.refs
+		stats.Blobs++
+		stats.Size += int64(b.refs) * b.size
+		stats.Stored += b.size
+	}
+	return stats
+}
+
+// List returns the entries of a folder, with the sizes and times of the files
+// the pointers in it stand for
+func (d *Dedup) List(dir string) ([]FileInfo, error) {
+	if hiddenBlob(dir) {
+		return nil, pathError("list", dir, fs.ErrInvalid)
+	}
+
+	entries, err := d.inner.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := entries[:0]
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name)
+		if hiddenBlob(name) {
+			continue
+		}
+		if !entry.IsDir {
+			p, ok, err := d.readPointer(name, entry.Size)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // removed while listing
+			}
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				entry.Size, entry.ModTime = p.Size, p.Modified
+			}
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+// Put receives src as a blob, unless the same content is stored already, and
+// points name to it. Bookkeeping and files no larger than a pointer are stored
+// as they are.
+func (d *Dedup) Put(name string, src io.Reader) (int64, error) {
+	if !validName(name) || name == "" || hiddenBlob(name) {
+		return 0, pathError("put", name, fs.ErrInvalid)
+	}
+	if d.isPlain(name) {
+		return d.putPlain(name, src)
+	}
+	head, err := io.ReadAll(io.LimitReader(src, maxPointerSize+1))
+	if err != nil {
+		return 0, err
+	}
+	src = io.MultiReader(bytes.NewReader(head), src)
+	if len(head) <= maxPointerSize {
+		return d.putPlain(name, src)
+	}
+	return d.put(name, src, time.Now())
+}
+
+// putPlain stores src as name in the inner storage, releasing the blob name
+// pointed to before
+func (d *Dedup) putPlain(name string, src io.Reader) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	replaced, replacing, err := d.pointerAt(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	size, err := d.inner.Put(name, src)
+	if err != nil {
+		return 0, err
+	}
+	if replacing {
+		d.release(replaced.Blob)
+	}
+	return size, nil
+}
+
+// isPlain reports whether name is bookkeeping, which is stored as it is
+func (d *Dedup) isPlain(name string) bool {
+	return d.plain != nil && d.plain(name)
+}
+
+// put implements Put, giving the file the modification time modTime
+func (d *Dedup) put(name string, src io.Reader, modTime time.Time) (int64, error) {
+	staged, err := stagingName()
+	if err != nil {
+		return 0, err
+	}
+	hash := sha256.New()
+	size, err := d.inner.Put(staged, io.TeeReader(src, hash))
+	if err != nil {
+		return 0, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// The content is stored before anything points to it
+	if _, err := d.inner.Stat(blobPath(sum)); err == nil {
+		d.inner.Delete(staged)
+	} else if err := d.renamer.Rename(staged, blobPath(sum)); err != nil {
+		d.inner.Delete(staged)
+		return 0, fmt.Errorf("failed to store blob: %w", err)
+	}
+
+	replaced, replacing, err := d.pointerAt(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		d.dropUnused(sum)
+		return 0, err
+	}
+	p := pointer{Blob: sum, Size: size, Modified: modTime}
+	p.Sig = d.sign(p)
+	data, _ := json.Marshal(p)
+	if _, err := d.inner.Put(name, bytes.NewReader(data)); err != nil {
+		d.dropUnused(sum)
+		return 0, err
+	}
+	d.addRef(sum, size)
+	if replacing {
+		d.release(replaced.Blob)
+	}
+	return size, nil
+}
+
+// Open opens the blob name points to
+func (d *Dedup) Open(name string) (File, error) {
+	if hiddenBlob(name) {
+		return nil, pathError("open", name, fs.ErrInvalid)
+	}
+
+	// Holding the lock keeps the blob from being deleted before it is open
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p, ok, err := d.pointerAt(name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return d.inner.Open(name)
+	}
+	f, err := d.inner.Open(blobPath(p.Blob))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the content of %s: %w", name, err)
+	}
+	return dedupFile{File: f, info: FileInfo{Name: path.Base(name), Size: p.Size, ModTime: p.Modified}}, nil
+}
+
+// Stat describes a file or folder
+func (d *Dedup) Stat(name string) (FileInfo, error) {
+	if hiddenBlob(name) {
+		return FileInfo{}, pathError("stat", name, fs.ErrInvalid)
+	}
+
+	info, err := d.inner.Stat(name)
+	if err != nil || info.IsDir {
+		return info, err
+	}
+	p, ok, err := d.readPointer(name, info.Size)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if ok {
+		info.Size, info.ModTime = p.Size, p.Modified
+	}
+	return info, nil
+}
+
+// Delete removes a file or an empty folder. The blob of a file is deleted
+// along with the last name pointing to it.
+func (d *Dedup) Delete(name string) error {
+	if !validName(name) || name == "" || hiddenBlob(name) {
+		return pathError("delete", name, fs.ErrInvalid)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p, ok, err := d.pointerAt(name)
+	if err != nil {
+		return err
+	}
+	if err := d.inner.Delete(name); err != nil {
+		return err
+	}
+	if ok {
+		d.release(p.Blob)
+	}
+	return nil
+}
+
+// Mkdir creates a folder along with any missing parents
+func (d *Dedup) Mkdir(dir string) error {
+	if hiddenBlob(dir) {
+		return pathError("mkdir", dir, fs.ErrInvalid)
+	}
+	return d.inner.Mkdir(dir)
+}
+
+// Rename moves a file or folder by moving the pointers, leaving the blobs alone
+func (d *Dedup) Rename(oldName, newName string) error {
+	if hiddenBlob(oldName) || hiddenBlob(newName) {
+		return pathError("rename", oldName, fs.ErrInvalid)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	replaced, replacing, err := d.pointerAt(newName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := d.renamer.Rename(oldName, newName); err != nil {
+		return err
+	}
+	if replacing {
+		d.release(replaced.Blob)
+	}
+	return nil
+}
+
+// pointerAt reads the pointer stored as name. It reports false for folders and
+// files that are not pointers.
+func (d *Dedup) pointerAt(name string) (pointer, bool, error) {
+	info, err := d.inner.Stat(name)
+	if err != nil || info.IsDir {
+		return pointer{}, false, err
+	}
+	return d.readPointer(name, info.Size)
+}
+
+// readPointer reads the file name of the given size in the inner storage as a
+// pointer, reporting false if it is none
+func (d *Dedup) readPointer(name string, size int64) (pointer, bool, error) {
+	if size > maxPointerSize {
+		return pointer{}, false, nil
+	}
+	f, err := d.inner.Open(name)
+	if err != nil {
+		return pointer{}, false, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxPointerSize+1))
+	if err != nil {
+		return pointer{}, false, err
+	}
+
+	var p pointer
+	if json.Unmarshal(data, &p) != nil || !validSum(p.Blob) || p.Size < 0 || !hmac.Equal([]byte(p.Sig), []byte(d.sign(p))) {
+		return pointer{}, false, nil
+	}
+	return p, true, nil
+}
+
+// sign returns the signature of the pointer p
+func (d *Dedup) sign(p pointer) string {
+	mac := hmac.New(sha256.New, d.key)
+	fmt.Fprintf(mac, "%s\n%d\n%s", p.Blob, p.Size, p.Modified.UTC().Format(time.RFC3339Nano))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// loadKey reads the key pointers are signed with, creating it for a new store
+func (d *Dedup) loadKey() error {
+	f, err := d.inner.Open(dedupKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		if _, err := d.inner.Put(dedupKey, strings.NewReader(hex.EncodeToString(key))); err != nil {
+			return err
+		}
+		d.key = key
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, 256))
+	if err != nil {
+		return err
+	}
+	if d.key, err = hex.DecodeString(strings.TrimSpace(string(data))); err != nil || len(d.key) == 0 {
+		return fmt.Errorf("invalid key in %s", dedupKey)
+	}
+	return nil
+}
+
+// addRef counts another name pointing to the blob sum
+func (d *Dedup) addRef(sum string, size int64) {
+	b, ok := d.blobs[sum]
+	if !ok {
+		b = &blob{size: size}
+		d.blobs[sum] = b
+	}
+	b.refs++
+}
+
+// release drops a reference to the blob sum, deleting the blob with the last one.
+// A blob that cannot be deleted now is swept by the next NewDedup.
+func (d *Dedup) release(sum string) {
+	b, ok := d.blobs[sum]
+	if !ok {
+		return
+	}
+	if b.refs--; b.refs > 0 {
+		return
+	}
+	delete(d.blobs, sum)
+	d.deleteBlob(sum)
+}
+
+// deleteBlob deletes the blob sum, and its folder if that is empty now
+func (d *Dedup) deleteBlob(sum string) {
+	if d.inner.Delete(blobPath(sum)) == nil {
+		d.inner.Delete(path.Dir(blobPath(sum))) // fails unless empty
+	}
+}
+
+// dropUnused deletes the blob sum if nothing points to it, after a failed Put
+func (d *Dedup) dropUnused(sum string) {
+	if _, ok := d.blobs[sum]; !ok {
+		d.deleteBlob(sum)
+	}
+}
+
+// scan counts the references in the folder dir and below it, turning files
+// that are not pointers into blobs unless they are to be stored as they are
+func (d *Dedup) scan(dir string) error {
+	entries, err := d.inner.List(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name)
+		switch {
+		case hiddenBlob(name):
+		case entry.IsDir:
+			if err := d.scan(name); err != nil {
+				return err
+			}
+		default:
+			p, ok, err := d.readPointer(name, entry.Size)
+			if err != nil {
+				return err
+			}
+			if ok {
+				d.addRef(p.Blob, p.Size)
+				continue
+			}
+			if entry.Size <= maxPointerSize || d.isPlain(name) {
+				continue
+			}
+			if err := d.adopt(name, entry.ModTime); err != nil {
+				return fmt.Errorf("failed to deduplicate %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// adopt replaces a plain file with a pointer to a blob of its content
+func (d *Dedup) adopt(name string, modTime time.Time) error {
+	f, err := d.inner.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = d.put(name, f, modTime)
+	return err
+}
+
+// sweep deletes the blobs nothing points to
+func (d *Dedup) sweep() error {
+	shards, err := d.inner.List(DedupDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir || !validShard(shard.Name) {
+			continue
+		}
+		dir := path.Join(DedupDir, shard.Name)
+		entries, err := d.inner.List(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if _, used := d.blobs[entry.Name]; !used && !entry.IsDir {
+				d.deleteBlob(entry.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// removeAll deletes the folder dir of the inner storage with everything in it
+func (d *Dedup) removeAll(dir string) error {
+	entries, err := d.inner.List(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name)
+		if entry.IsDir {
+			err = d.removeAll(name)
+		} else {
+			err = d.inner.Delete(name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return d.inner.Delete(dir)
+}
+
+// dedupFile is an open blob that describes itself as the file pointing to it
+type dedupFile struct {
+	File
+	info FileInfo
+}
+
+func (f dedupFile) Stat() (FileInfo, error) {
+	return f.info, nil
+}
+
+// blobPath returns where the blob with the hex SHA-256 sum is kept. Blobs are
+// spread over folders named by the first byte, to keep folders small.
+func blobPath(sum string) string {
+	return DedupDir + "/" + sum[:2] + "/" + sum
+}
+
+// stagingName returns a new name to receive an upload under
+func stagingName() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate staging name: %w", err)
+	}
+	return dedupStaging + "/" + hex.EncodeToString(random), nil
+}
+
+// hiddenBlob reports whether name lies in the blob folder
+func hiddenBlob(name string) bool {
+	return name != "" && within(name, DedupDir)
+}
+
+// validSum reports whether s is a lowercase hex SHA-256
+func validSum(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == 2*sha256.Size && s == strings.ToLower(s)
+}
+
+// validShard reports whether s names a folder of blobs
+func validShard(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == 2 && s == strings.ToLower(s)
+}
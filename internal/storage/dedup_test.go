@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDedup returns a Dedup over inner, failing the test on errors
+func newTestDedup(t *testing.T, inner Storage) *Dedup {
+	t.Helper()
+	d, err := NewDedup(inner, func(name string) bool { return strings.HasSuffix(name, ".meta") })
+	if err != nil {
+		t.Fatalf("NewDedup() error = %v", err)
+	}
+	return d
+}
+
+// Contents too large to be stored as they are, and where Dedup keeps the first
+var (
+	hello     = strings.Repeat("hello ", 100)
+	other     = strings.Repeat("other ", 100)
+	helloSum  = sha256Hex(hello)
+	helloBlob = blobPath(helloSum)
+)
+
+// sha256Hex returns the hex SHA-256 of s
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDedup_SharesBlobs(t *testing.T) {
+	inner := NewMemory()
+	d := newTestDedup(t, inner)
+	for name, content := range map[string]string{"a.txt": hello, "docs/b.txt": hello, "c.txt": other} {
+		if _, err := d.Put(name, bytes.NewReader([]byte(content))); err != nil {
+			t.Fatalf("Put(%s) error = %v", name, err)
+		}
+	}
+
+	want := DedupStats{Files: 3, Blobs: 2, Size: 1800, Stored: 1200}
+	if got := d.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	if info, err := d.Stat("docs/b.txt"); err != nil || info.Size != 600 || info.Name != "b.txt" {
+		t.Errorf("Stat() = %+v, %v", info, err)
+	}
+	if entries, _ := d.List(""); len(entries) != 3 {
+		t.Errorf("List() = %+v, want a.txt, c.txt and docs", entries)
+	}
+
+	// The content stays until the last name pointing to it goes
+	if err := d.Delete("a.txt"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if data, err := readAll(d, "docs/b.txt"); err != nil || data != hello {
+		t.Errorf("content of the other name = %q, %v", data, err)
+	}
+	if _, err := inner.Stat(helloBlob); err != nil {
+		t.Errorf("blob deleted while still in use: %v", err)
+	}
+
+	// Replacing a file releases its old content
+	if _, err := d.Put("docs/b.txt", strings.NewReader(other)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, err := inner.Stat(helloBlob); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() of an unused blob error = %v, want not exist", err)
+	}
+	if got := d.Stats(); got != (DedupStats{Files: 2, Blobs: 1, Size: 1200, Stored: 600}) {
+		t.Errorf("Stats() after replacing = %+v", got)
+	}
+
+	// So does a file renamed onto it
+	if _, err := d.Put("a.txt", strings.NewReader(hello)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := d.Rename("c.txt", "a.txt"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if _, err := inner.Stat(helloBlob); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() of a blob replaced by a rename error = %v, want not exist", err)
+	}
+}
+
+func TestDedup_PlainFiles(t *testing.T) {
+	inner := NewMemory()
+	d := newTestDedup(t, inner)
+
+	// Small files and bookkeeping are stored as they are, even if their content is shared
+	files := map[string]string{"small.txt": "hello", "index.meta": hello, "docs/a.txt": hello}
+	for name, content := range files {
+		if _, err := d.Put(name, strings.NewReader(content)); err != nil {
+			t.Fatalf("Put(%s) error = %v", name, err)
+		}
+	}
+	for name, content := range files {
+		if data, err := readAll(d, name); err != nil || data != content {
+			t.Errorf("content of %s = %q, %v", name, data, err)
+		}
+	}
+	for _, name := range []string{"small.txt", "index.meta"} {
+		if data, _ := readAll(inner, name); data != files[name] {
+			t.Errorf("stored %s = %q, want the file itself", name, data)
+		}
+	}
+	if got := d.Stats(); got != (DedupStats{Files: 1, Blobs: 1, Size: 600, Stored: 600}) {
+		t.Errorf("Stats() = %+v, want only docs/a.txt deduplicated", got)
+	}
+
+	// Replacing a pointer by a small file releases its content
+	if _, err := d.Put("docs/a.txt", strings.NewReader("small")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, err := inner.Stat(helloBlob); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() of a blob replaced by a small file error = %v, want not exist", err)
+	}
+
+	// Nor are they taken over when counting again
+	if _, err := inner.Put("large.meta", strings.NewReader(other)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	restarted := newTestDedup(t, inner)
+	if got := restarted.Stats(); got != (DedupStats{}) {
+		t.Errorf("Stats() after restarting = %+v, want nothing deduplicated", got)
+	}
+	if data, _ := readAll(inner, "large.meta"); data != other {
+		t.Error("bookkeeping was taken over when counting again")
+	}
+}
+
+func TestDedup_HidesBlobs(t *testing.T) {
+	d := newTestDedup(t, NewMemory())
+	if _, err := d.Put("a.txt", strings.NewReader(hello)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if entries, _ := d.List(""); len(entries) != 1 || entries[0].Name != "a.txt" {
+		t.Errorf("List() = %+v, want only a.txt", entries)
+	}
+	if _, err := d.Stat(helloBlob); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Stat() of a blob error = %v, want fs.ErrInvalid", err)
+	}
+	if _, err := d.Put(DedupDir+"/x", bytes.NewReader(nil)); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Put() into the blob folder error = %v, want fs.ErrInvalid", err)
+	}
+}
+
+func TestNewDedup_TakesOverFiles(t *testing.T) {
+	inner := NewMemory()
+	putAll := func(files map[string]string) {
+		for name, content := range files {
+			if _, err := inner.Put(name, bytes.NewReader([]byte(content))); err != nil {
+				t.Fatalf("Put(%s) error = %v", name, err)
+			}
+		}
+	}
+	putAll(map[string]string{
+		"a.txt":                   hello,
+		"docs/b.txt":              hello,
+		dedupStaging + "/partial": "interrupted upload",
+		DedupDir + "/00/" + "00000000000000000000000000000000000000000000000000000000000000ff": "unused",
+	})
+	modTime := time.Now().Add(-time.Hour)
+	inner.files["a.txt"].modTime = modTime
+
+	d := newTestDedup(t, inner)
+	if got := d.Stats(); got != (DedupStats{Files: 2, Blobs: 1, Size: 1200, Stored: 600}) {
+		t.Errorf("Stats() = %+v", got)
+	}
+	if data, err := readAll(d, "a.txt"); err != nil || data != hello {
+		t.Errorf("content of a file taken over = %q, %v", data, err)
+	}
+	if info, err := d.Stat("a.txt"); err != nil || !info.ModTime.Equal(modTime) {
+		t.Errorf("Stat() = %+v, %v, want the original modification time", info, err)
+	}
+	if info, _ := inner.Stat("a.txt"); info.Size == 600 {
+		t.Error("file was not replaced by a pointer")
+	}
+	if entries, _ := inner.List(DedupDir); len(entries) != 3 {
+		t.Errorf("blob folder = %+v, want the key, the staging folder and one shard", entries)
+	}
+	if entries, _ := inner.List(dedupStaging); len(entries) != 0 {
+		t.Errorf("staging folder = %+v, want it empty", entries)
+	}
+
+	// Files stored behind its back are served as they are
+	putAll(map[string]string{"c.txt": other})
+	if data, err := readAll(d, "c.txt"); err != nil || data != other {
+		t.Errorf("content of a plain file = %q, %v", data, err)
+	}
+	if err := d.Delete("c.txt"); err != nil {
+		t.Errorf("Delete() of a plain file error = %v", err)
+	}
+
+	// Counting again finds the same references
+	if got := newTestDedup(t, inner).Stats(); got != d.Stats() {
+		t.Errorf("Stats() after restarting = %+v, want %+v", got, d.Stats())
+	}
+}
+
+func TestDedup_PointerLookalikes(t *testing.T) {
+	inner := NewMemory()
+	d := newTestDedup(t, inner)
+	if _, err := d.Put("secret.txt", strings.NewReader(hello)); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	// Files that look like pointers to the content of another file, unsigned or
+	// with a signature made up or copied from another pointer, are plain files
+	stolen := inner.files["secret.txt"]
+	lookalikes := map[string]string{
+		"unsigned.json": fmt.Sprintf(`{"fsrv-blob":%q,"size":600,"modified":"2024-01-01T00:00:00Z"}`, helloSum),
+		"forged.json":   fmt.Sprintf(`{"fsrv-blob":%q,"size":600,"modified":"2024-01-01T00:00:00Z","sig":"00"}`, helloSum),
+		"copied.json":   string(bytes.Replace(stolen.data, []byte(`"size":600`), []byte(`"size":599`), 1)),
+	}
+	for name, content := range lookalikes {
+		if _, err := d.Put(name, bytes.NewReader([]byte(content))); err != nil {
+			t.Fatalf("Put(%s) error = %v", name, err)
+		}
+		if data, err := readAll(d, name); err != nil || data != content {
+			t.Errorf("content of %s = %q, %v, want it byte for byte", name, data, err)
+		}
+		// Stored behind the back of the Dedup, and read when it starts
+		if _, err := inner.Put("plain/"+name, bytes.NewReader([]byte(content))); err != nil {
+			t.Fatalf("Put(%s) error = %v", name, err)
+		}
+		if data, err := readAll(d, "plain/"+name); err != nil || data != content {
+			t.Errorf("content of plain/%s = %q, %v, want it byte for byte", name, data, err)
+		}
+	}
+
+	restarted := newTestDedup(t, inner)
+	for name, content := range lookalikes {
+		if data, err := readAll(restarted, "plain/"+name); err != nil || data != content {
+			t.Errorf("content of plain/%s after restarting = %q, %v, want it byte for byte", name, data, err)
+		}
+	}
+	if data, err := readAll(restarted, "secret.txt"); err != nil || data != hello {
+		t.Errorf("content of secret.txt after restarting = %q, %v", data, err)
+	}
+}
+
+func TestIsDeduplicated(t *testing.T) {
+	inner := NewMemory()
+	if _, err := inner.Put("a.txt", bytes.NewReader([]byte("hello"))); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if dedup, err := IsDeduplicated(inner); err != nil || dedup {
+		t.Errorf("IsDeduplicated() of a plain store = %v, %v, want false", dedup, err)
+	}
+	newTestDedup(t, inner)
+	if dedup, err := IsDeduplicated(inner); err != nil || !dedup {
+		t.Errorf("IsDeduplicated() of a store used by a Dedup = %v, %v, want true", dedup, err)
+	}
+}
+
+func TestNewDedup_NeedsRename(t *testing.T) {
+	_, s3 := newFakeS3(t, 8)
+	if _, err := NewDedup(s3, nil); err == nil {
+		t.Error("NewDedup() over a storage that cannot rename should fail")
+	}
+}
@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// StagingPrefix marks the temporary files Local writes uploads to before they are
+// renamed into place. Such files are never listed and are swept by CleanStaging.
+const StagingPrefix = ".fsrv-upload-"
+
+// Local keeps files in a directory on the local filesystem.
+//
+// Files are written to a staging file in the tmp directory, synced, and renamed
+// into the store only once they have been received completely, so an interrupted
+// upload never leaves a truncated file behind.
+type Local struct {
+	root string
+	tmp  string
+}
+
+// NewLocal returns a Storage over the directory root, staging writes in tmp.
+// An empty tmp stages in the system's temporary directory.
+func NewLocal(root, tmp string) *Local {
+	if tmp == "" {
+		tmp = os.TempDir()
+	}
+	return &Local{root: root, tmp: tmp}
+}
+
+// List returns the entries of a folder, leaving out staging files
+func (l *Local) List(dir string) ([]FileInfo, error) {
+	if !validName(dir) {
+		return nil, pathError("list", dir, fs.ErrInvalid)
+	}
+
+	entries, err := os.ReadDir(l.fullPath(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), StagingPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // removed while listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fileInfo(info))
+	}
+	return result, nil
+}
+
+// Put stages src in the tmp directory and renames it into place
+func (l *Local) Put(name string, src io.Reader) (int64, error) {
+	if !validName(name) || name == "" {
+		return 0, pathError("put", name, fs.ErrInvalid)
+	}
+
+	staged, size, err := l.stage(src)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(staged) // no-op once the file has been renamed
+
+	if err := l.Import(name, staged); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// stage copies src into a new staging file and syncs it to disk.
+// On failure the staging file is removed.
+func (l *Local) stage(src io.Reader) (string, int64, error) {
+	dst, err := os.CreateTemp(l.tmp, StagingPrefix+"*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create staging file: %w", err)
+	}
+
+	// Errors while copying are passed on as they are: mostly they come from src,
+	// and the caller knows best what they mean
+	buffer := make([]byte, 1024*1024) // 1MB buffer
+	size, err := io.CopyBuffer(dst, src, buffer)
+	if err == nil {
+		if err = dst.Sync(); err != nil {
+			err = fmt.Errorf("failed to sync staging file: %w", err)
+		}
+	}
+	if closeErr := dst.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close staging file: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", 0, err
+	}
+
+	return dst.Name(), size, nil
+}
+
+// Import moves a fully written local file into the store as name,
+// creating missing parent folders.
+func (l *Local) Import(name, staged string) error {
+	if !validName(name) || name == "" {
+		return pathError("import", name, fs.ErrInvalid)
+	}
+	fullPath := l.fullPath(name)
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := os.Rename(staged, fullPath); err == nil {
+		return nil
+	}
+
+	// The tmp directory may live on another filesystem, in which case rename fails.
+	// Fall back to copying into a staging file next to the destination, so the
+	// final step is still an atomic rename within the store.
+	local, err := l.copyToStore(staged)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(local, fullPath); err != nil {
+		os.Remove(local)
+		return fmt.Errorf("failed to move file into store: %w", err)
+	}
+	return nil
+}
+
+// copyToStore copies a staging file into a new staging file inside the store directory
+func (l *Local) copyToStore(staged string) (string, error) {
+	src, err := os.Open(staged)
+	if err != nil {
+		return "", fmt.Errorf("failed to open staging file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(l.root, StagingPrefix+"*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging file: %w", err)
+	}
+
+	buffer := make([]byte, 1024*1024) // 1MB buffer
+	_, err = io.CopyBuffer(dst, src, buffer)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", fmt.Errorf("failed to move file into store: %w", err)
+	}
+
+	return dst.Name(), nil
+}
+
+// Rename moves a file or folder within the store
+func (l *Local) Rename(oldName, newName string) error {
+	if !validName(oldName) || oldName == "" || !validName(newName) || newName == "" || within(newName, oldName) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrInvalid}
+	}
+
+	oldInfo, err := os.Stat(l.fullPath(oldName))
+	if err != nil {
+		return err
+	}
+	// os.Rename would happily replace an empty folder, or a file with a folder
+	if newInfo, err := os.Stat(l.fullPath(newName)); err == nil && (newInfo.IsDir() || oldInfo.IsDir()) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrExist}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.fullPath(newName)), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return os.Rename(l.fullPath(oldName), l.fullPath(newName))
+}
+
+// Open opens a file for reading
+func (l *Local) Open(name string) (File, error) {
+	if !validName(name) {
+		return nil, pathError("open", name, fs.ErrInvalid)
+	}
+	f, err := os.Open(l.fullPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return localFile{f}, nil
+}
+
+// Stat describes a file or folder
+func (l *Local) Stat(name string) (FileInfo, error) {
+	if !validName(name) {
+		return FileInfo{}, pathError("stat", name, fs.ErrInvalid)
+	}
+	info, err := os.Stat(l.fullPath(name))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	result := fileInfo(info)
+	if name == "" {
+		result.Name = ""
+	}
+	return result, nil
+}
+
+// Delete removes a file or an empty folder
+func (l *Local) Delete(name string) error {
+	if !validName(name) || name == "" {
+		return pathError("delete", name, fs.ErrInvalid)
+	}
+
+	fullPath := l.fullPath(name)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		entries, err := os.ReadDir(fullPath)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return pathError("delete", name, ErrNotEmpty)
+		}
+	}
+	return os.Remove(fullPath)
+}
+
+// Mkdir creates a folder along with any missing parents
+func (l *Local) Mkdir(dir string) error {
+	if !validName(dir) {
+		return pathError("mkdir", dir, fs.ErrInvalid)
+	}
+	if info, err := os.Stat(l.fullPath(dir)); err == nil && !info.IsDir() {
+		return pathError("mkdir", dir, fs.ErrExist)
+	}
+	return os.MkdirAll(l.fullPath(dir), 0755)
+}
+
+// CleanStaging removes staging files left behind by interrupted uploads, both in the
+// tmp directory and in the store. It must only be called while no upload is running,
+// typically on startup. It returns the number of files removed.
+func (l *Local) CleanStaging() (int, error) {
+	removed := 0
+	for _, dir := range []string{l.tmp, l.root} {
+		matches, err := filepath.Glob(filepath.Join(dir, StagingPrefix+"*"))
+		if err != nil {
+			return removed, fmt.Errorf("failed to find staging files: %w", err)
+		}
+		for _, match := range matches {
+			if err := os.Remove(match); err != nil {
+				return removed, fmt.Errorf("failed to remove staging file: %w", err)
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// fullPath returns the location of a name on disk
+func (l *Local) fullPath(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// localFile adapts *os.File to File
+type localFile struct {
+	*os.File
+}
+
+func (f localFile) Stat() (FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return fileInfo(info), nil
+}
+
+// fileInfo converts an fs.FileInfo
+func fileInfo(info fs.FileInfo) FileInfo {
+	return FileInfo{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+}
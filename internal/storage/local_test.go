@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocal_PutStagesInTmp(t *testing.T) {
+	root, tmp := t.TempDir(), t.TempDir()
+	store := NewLocal(root, tmp)
+
+	if _, err := store.Put("partial.bin", &failingReader{data: []byte("partial")}); err == nil {
+		t.Fatal("Put() should fail when the source fails")
+	}
+
+	// Neither the store nor the tmp directory may keep anything
+	for _, dir := range []string{root, tmp} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", dir, err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("Failed Put() left %d file(s) in %s", len(entries), dir)
+		}
+	}
+}
+
+func TestLocal_Import(t *testing.T) {
+	root, tmp := t.TempDir(), t.TempDir()
+	store := NewLocal(root, tmp)
+
+	src := filepath.Join(tmp, "upload.bin")
+	if err := os.WriteFile(src, []byte("imported"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	if err := store.Import("in/place.bin", src); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "in", "place.bin"))
+	if err != nil || string(data) != "imported" {
+		t.Errorf("imported file = %q, %v", data, err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("Import() should move the file, not copy it")
+	}
+}
+
+func TestLocal_CleanStaging(t *testing.T) {
+	root, tmp := t.TempDir(), t.TempDir()
+	store := NewLocal(root, tmp)
+
+	orphans := []string{
+		filepath.Join(tmp, StagingPrefix+"123"),
+		filepath.Join(root, StagingPrefix+"456"),
+	}
+	for _, path := range orphans {
+		if err := os.WriteFile(path, []byte("partial"), 0644); err != nil {
+			t.Fatalf("Failed to create orphan: %v", err)
+		}
+	}
+	if _, err := store.Put("keep.txt", bytes.NewReader([]byte("keep"))); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	// Orphans in the store are never listed
+	entries, err := store.List("")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "keep.txt" {
+		t.Errorf("List() = %v, want only keep.txt", names(entries))
+	}
+
+	n, err := store.CleanStaging()
+	if err != nil {
+		t.Fatalf("CleanStaging() error = %v", err)
+	}
+	if n != len(orphans) {
+		t.Errorf("CleanStaging() removed %d files, want %d", n, len(orphans))
+	}
+	for _, path := range orphans {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("CleanStaging() did not remove %s", path)
+		}
+	}
+	if _, err := store.Stat("keep.txt"); err != nil {
+		t.Error("CleanStaging() removed a regular file")
+	}
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps files in memory. Everything is lost when the process exits,
+// which makes it suitable for tests and ephemeral servers.
+type Memory struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+	dirs  map[string]time.Time // folder modification times, without the root
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory returns an empty in-memory Storage
+func NewMemory() *Memory {
+	return &Memory{
+		files: make(map[string]*memFile),
+		dirs:  make(map[string]time.Time),
+	}
+}
+
+// List returns the entries of a folder
+func (m *Memory) List(dir string) ([]FileInfo, error) {
+	if !validName(dir) {
+		return nil, pathError("list", dir, fs.ErrInvalid)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.isDir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return nil, pathError("list", dir, fs.ErrInvalid)
+		}
+		return nil, pathError("list", dir, fs.ErrNotExist)
+	}
+
+	var result []FileInfo
+	for name, f := range m.files {
+		if parent(name) == dir {
+			result = append(result, FileInfo{Name: path.Base(name), Size: int64(len(f.data)), ModTime: f.modTime})
+		}
+	}
+	for name, modTime := range m.dirs {
+		if parent(name) == dir {
+			result = append(result, FileInfo{Name: path.Base(name), ModTime: modTime, IsDir: true})
+		}
+	}
+	return result, nil
+}
+
+// Put reads src completely and stores it as name
+func (m *Memory) Put(name string, src io.Reader) (int64, error) {
+	if !validName(name) || name == "" {
+		return 0, pathError("put", name, fs.ErrInvalid)
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.isDir(name) {
+		return 0, pathError("put", name, fs.ErrExist)
+	}
+	if err := m.mkdirAll(parent(name)); err != nil {
+		return 0, err
+	}
+	m.files[name] = &memFile{data: data, modTime: time.Now()}
+	return int64(len(data)), nil
+}
+
+// Open opens a file for reading. Later changes to the file do not affect the open file.
+func (m *Memory) Open(name string) (File, error) {
+	if !validName(name) {
+		return nil, pathError("open", name, fs.ErrInvalid)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	f, ok := m.files[name]
+	if !ok {
+		if m.isDir(name) {
+			return nil, pathError("open", name, fs.ErrInvalid)
+		}
+		return nil, pathError("open", name, fs.ErrNotExist)
+	}
+	info := FileInfo{Name: path.Base(name), Size: int64(len(f.data)), ModTime: f.modTime}
+	return &memReader{Reader: bytes.NewReader(f.data), info: info}, nil
+}
+
+// Stat describes a file or folder
+func (m *Memory) Stat(name string) (FileInfo, error) {
+	if !validName(name) {
+		return FileInfo{}, pathError("stat", name, fs.ErrInvalid)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if f, ok := m.files[name]; ok {
+		return FileInfo{Name: path.Base(name), Size: int64(len(f.data)), ModTime: f.modTime}, nil
+	}
+	if name == "" {
+		return FileInfo{IsDir: true}, nil
+	}
+	if modTime, ok := m.dirs[name]; ok {
+		return FileInfo{Name: path.Base(name), ModTime: modTime, IsDir: true}, nil
+	}
+	return FileInfo{}, pathError("stat", name, fs.ErrNotExist)
+}
+
+// Delete removes a file or an empty folder
+func (m *Memory) Delete(name string) error {
+	if !validName(name) || name == "" {
+		return pathError("delete", name, fs.ErrInvalid)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	if !m.isDir(name) {
+		return pathError("delete", name, fs.ErrNotExist)
+	}
+	prefix := name + "/"
+	for other := range m.files {
+		if strings.HasPrefix(other, prefix) {
+			return pathError("delete", name, ErrNotEmpty)
+		}
+	}
+	for other := range m.dirs {
+		if strings.HasPrefix(other, prefix) {
+			return pathError("delete", name, ErrNotEmpty)
+		}
+	}
+	delete(m.dirs, name)
+	return nil
+}
+
+// Rename moves a file or folder within the store
+func (m *Memory) Rename(oldName, newName string) error {
+	if !validName(oldName) || oldName == "" || !validName(newName) || newName == "" || within(newName, oldName) {
+		return pathError("rename", oldName, fs.ErrInvalid)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.isDir(newName) {
+		return pathError("rename", newName, fs.ErrExist)
+	}
+
+	if f, ok := m.files[oldName]; ok {
+		if err := m.mkdirAll(parent(newName)); err != nil {
+			return err
+		}
+		delete(m.files, oldName)
+		m.files[newName] = f
+		return nil
+	}
+
+	if !m.isDir(oldName) {
+		return pathError("rename", oldName, fs.ErrNotExist)
+	}
+	if _, ok := m.files[newName]; ok {
+		return pathError("rename", newName, fs.ErrExist)
+	}
+	if err := m.mkdirAll(parent(newName)); err != nil {
+		return err
+	}
+	for name, f := range m.files {
+		if within(name, oldName) {
+			delete(m.files, name)
+			m.files[newName+strings.TrimPrefix(name, oldName)] = f
+		}
+	}
+	for name, modTime := range m.dirs {
+		if within(name, oldName) {
+			delete(m.dirs, name)
+			m.dirs[newName+strings.TrimPrefix(name, oldName)] = modTime
+		}
+	}
+	return nil
+}
+
+// Mkdir creates a folder along with any missing parents
+func (m *Memory) Mkdir(dir string) error {
+	if !validName(dir) {
+		return pathError("mkdir", dir, fs.ErrInvalid)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.mkdirAll(dir)
+}
+
+// mkdirAll creates dir and its parents. The caller must hold the write lock.
+func (m *Memory) mkdirAll(dir string) error {
+	if dir == "" || m.isDir(dir) {
+		return nil
+	}
+	if _, ok := m.files[dir]; ok {
+		return pathError("mkdir", dir, fs.ErrExist)
+	}
+	if err := m.mkdirAll(parent(dir)); err != nil {
+		return err
+	}
+	m.dirs[dir] = time.Now()
+	return nil
+}
+
+// isDir reports whether dir is a folder. The caller must hold the lock.
+func (m *Memory) isDir(dir string) bool {
+	if dir == "" {
+		return true
+	}
+	_, ok := m.dirs[dir]
+	return ok
+}
+
+// parent returns the folder containing name, "" for the root
+func parent(name string) string {
+	dir := path.Dir(name)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// memReader is an open in-memory file
+type memReader struct {
+	*bytes.Reader
+	info FileInfo
+}
+
+func (r *memReader) Stat() (FileInfo, error) {
+	return r.info, nil
+}
+
+func (r *memReader) Close() error {
+	return nil
+}
@@ -0,0 +1,571 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fsrv/internal/sigv4"
+)
+
+// DefaultPartSize is the part size of multipart uploads unless configured otherwise.
+// Objects smaller than one part are uploaded with a single PUT.
+const DefaultPartSize = 16 << 20
+
+// S3Config configures the S3 storage backend
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+	Bucket    string
+	Region    string
+	Prefix    string // optional key prefix all files are kept under
+	AccessKey string
+	SecretKey string
+
+	// PartSize is the size of multipart upload parts. S3 requires at least 5 MiB.
+	PartSize int64
+
+	// Client sends the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// S3 keeps files as objects in a bucket of an S3 compatible object store,
+// such as AWS S3 or MinIO. Requests use path-style addressing and SigV4 signing.
+//
+// S3 has no real folders: a folder exists as long as any key starts with its
+// path, and Mkdir creates an empty "folder/" marker object like most S3 tools do.
+// Folders created with Mkdir keep existing through their marker once their
+// files are deleted, while those that only exist because files were put into
+// them vanish with their last file.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	prefix   string
+	client   *http.Client
+}
+
+// NewS3 returns a Storage backed by an S3 bucket
+func NewS3(cfg S3Config) (*S3, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint: '%s'", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("no S3 bucket configured")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = DefaultPartSize
+	}
+
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3{cfg: cfg, endpoint: endpoint, prefix: prefix, client: client}, nil
+}
+
+// S3Error is an error response from the S3 server
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// Is makes missing keys match fs.ErrNotExist
+func (e *S3Error) Is(target error) bool {
+	return target == fs.ErrNotExist && e.StatusCode == http.StatusNotFound && e.Code != "NoSuchBucket"
+}
+
+// List returns the entries of a folder using ListObjectsV2 with a "/" delimiter
+func (s *S3) List(dir string) ([]FileInfo, error) {
+	if !validName(dir) {
+		return nil, pathError("list", dir, fs.ErrInvalid)
+	}
+
+	prefix := s.dirKey(dir)
+	found := dir == ""
+	var result []FileInfo
+
+	token := ""
+	for {
+		list, err := s.listObjects(prefix, "/", token, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range list.Contents {
+			found = true
+			if object.Key == prefix {
+				continue // the folder's own marker
+			}
+			result = append(result, FileInfo{
+				Name:    strings.TrimPrefix(object.Key, prefix),
+				Size:    object.Size,
+				ModTime: object.LastModified,
+			})
+		}
+		for _, common := range list.CommonPrefixes {
+			found = true
+			result = append(result, FileInfo{
+				Name:  strings.TrimSuffix(strings.TrimPrefix(common.Prefix, prefix), "/"),
+				IsDir: true,
+			})
+		}
+
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+
+	if !found {
+		return nil, pathError("list", dir, fs.ErrNotExist)
+	}
+	return result, nil
+}
+
+// Put uploads src with a single PUT, or as a multipart upload once it exceeds
+// one part. Nothing is stored unless src is read completely.
+func (s *S3) Put(name string, src io.Reader) (int64, error) {
+	if !validName(name) || name == "" {
+		return 0, pathError("put", name, fs.ErrInvalid)
+	}
+	key := s.key(name)
+
+	buf := make([]byte, s.cfg.PartSize)
+	n, err := io.ReadFull(src, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := s.do(http.MethodPut, key, nil, nil, buf[:n])
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return s.putMultipart(key, src, buf)
+}
+
+// putMultipart uploads buf, which holds the first part, followed by the rest of src.
+// The upload is aborted on any error.
+func (s *S3) putMultipart(key string, src io.Reader, buf []byte) (int64, error) {
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.doXML(http.MethodPost, key, url.Values{"uploads": {""}}, nil, &initiated); err != nil {
+		return 0, err
+	}
+
+	size, parts, err := s.uploadParts(key, initiated.UploadID, src, buf)
+	if err == nil {
+		err = s.completeMultipart(key, initiated.UploadID, parts)
+	}
+	if err != nil {
+		if resp, abortErr := s.do(http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+// completedPart is one entry of a CompleteMultipartUpload request
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// uploadParts sends buf as part 1 and the rest of src in further parts
+func (s *S3) uploadParts(key, uploadID string, src io.Reader, buf []byte) (int64, []completedPart, error) {
+	var size int64
+	var parts []completedPart
+
+	n := len(buf)
+	for partNumber := 1; n > 0; partNumber++ {
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+		resp, err := s.do(http.MethodPut, key, query, nil, buf[:n])
+		if err != nil {
+			return 0, nil, err
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+		size += int64(n)
+
+		n, err = io.ReadFull(src, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, nil, err
+		}
+	}
+	return size, parts, nil
+}
+
+// completeMultipart assembles the uploaded parts into the object
+func (s *S3) completeMultipart(key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	// S3 may report a failure with status 200 once it started sending the response
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	header := http.Header{"Content-Type": {"application/xml"}}
+	if err := s.doXMLBody(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, header, body, &result); err != nil {
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		return &S3Error{StatusCode: http.StatusOK, Code: result.Code, Message: result.Message}
+	}
+	return nil
+}
+
+// Open returns a file that fetches its content with ranged GET requests as it is read.
+// Reads fail if the object is replaced after it was opened.
+func (s *S3) Open(name string) (File, error) {
+	info, etag, err := s.head(name)
+	if err != nil {
+		return nil, err
+	}
+	return &s3File{s3: s, key: s.key(name), info: info, etag: etag}, nil
+}
+
+// Stat describes an object, or a folder if any key starts with name + "/"
+func (s *S3) Stat(name string) (FileInfo, error) {
+	if name == "" {
+		return FileInfo{IsDir: true}, nil
+	}
+
+	info, _, err := s.head(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return info, err
+	}
+
+	list, err := s.listObjects(s.dirKey(name), "", "", 1)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if len(list.Contents) == 0 {
+		return FileInfo{}, pathError("stat", name, fs.ErrNotExist)
+	}
+	return FileInfo{Name: path.Base(name), IsDir: true}, nil
+}
+
+// Delete removes an object, or the marker of an empty folder
+func (s *S3) Delete(name string) error {
+	if !validName(name) || name == "" {
+		return pathError("delete", name, fs.ErrInvalid)
+	}
+
+	info, err := s.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	key := s.key(name)
+	if info.IsDir {
+		key = s.dirKey(name)
+		list, err := s.listObjects(key, "", "", 2)
+		if err != nil {
+			return err
+		}
+		for _, object := range list.Contents {
+			if object.Key != key {
+				return pathError("delete", name, ErrNotEmpty)
+			}
+		}
+	}
+
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Mkdir creates a folder marker object, along with markers for the missing
+// folders above it, so that each of them outlives the files put into it
+func (s *S3) Mkdir(dir string) error {
+	if !validName(dir) {
+		return pathError("mkdir", dir, fs.ErrInvalid)
+	}
+
+	// A marker makes the folders above it exist, so all are looked for first
+	var missing []string
+	for ; dir != ""; dir = parent(dir) {
+		info, err := s.Stat(dir)
+		if err == nil {
+			if !info.IsDir {
+				return pathError("mkdir", dir, fs.ErrExist)
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		missing = append(missing, dir)
+	}
+
+	for _, dir := range missing {
+		resp, err := s.do(http.MethodPut, s.dirKey(dir), nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// head fetches the metadata of an object
+func (s *S3) head(name string) (FileInfo, string, error) {
+	if !validName(name) || name == "" {
+		return FileInfo{}, "", pathError("stat", name, fs.ErrInvalid)
+	}
+
+	resp, err := s.do(http.MethodHead, s.key(name), nil, nil, nil)
+	if err != nil {
+		return FileInfo{}, "", err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	info := FileInfo{Name: path.Base(name), Size: resp.ContentLength, ModTime: modTime}
+	return info, resp.Header.Get("ETag"), nil
+}
+
+// listBucketResult is the response of ListObjectsV2
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// listObjects runs one ListObjectsV2 request; maxKeys 0 leaves the server's default
+func (s *S3) listObjects(prefix, delimiter, token string, maxKeys int) (*listBucketResult, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+
+	var list listBucketResult
+	if err := s.doXML(http.MethodGet, "", query, nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// doXML sends a request without body and decodes the XML response into v
+func (s *S3) doXML(method, key string, query url.Values, header http.Header, v any) error {
+	return s.doXMLBody(method, key, query, header, nil, v)
+}
+
+// doXMLBody sends a request and decodes the XML response into v
+func (s *S3) doXMLBody(method, key string, query url.Values, header http.Header, body []byte, v any) error {
+	resp, err := s.do(method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := xml.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("s3: invalid response: %w", err)
+	}
+	return nil
+}
+
+// do sends a signed request for key, "" addressing the bucket itself.
+// Error responses are returned as *S3Error.
+func (s *S3) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = sigv4.EncodePath(u.Path)
+	u.RawQuery = encodeQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := sigv4.EmptySHA256
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	creds := sigv4.Credentials{AccessKey: s.cfg.AccessKey, SecretKey: s.cfg.SecretKey}
+	sigv4.Sign(req, creds, s.cfg.Region, "s3", payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, readS3Error(resp)
+	}
+	return resp, nil
+}
+
+// readS3Error turns an error response into an *S3Error
+func readS3Error(resp *http.Response) error {
+	s3Err := &S3Error{StatusCode: resp.StatusCode}
+	var body struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &body) == nil {
+		s3Err.Code = body.Code
+		s3Err.Message = body.Message
+	}
+	return s3Err
+}
+
+// encodeQuery encodes query parameters the way SigV4 canonicalizes them
+func encodeQuery(query url.Values) string {
+	var params []string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, sigv4.Encode(key)+"="+sigv4.Encode(value))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// key returns the object key of a file
+func (s *S3) key(name string) string {
+	return s.prefix + name
+}
+
+// dirKey returns the key prefix of a folder's content, which is also its marker key
+func (s *S3) dirKey(dir string) string {
+	if dir == "" {
+		return s.prefix
+	}
+	return s.prefix + dir + "/"
+}
+
+// s3File reads an object with ranged GET requests, starting a new one after each seek
+type s3File struct {
+	s3     *S3
+	key    string
+	info   FileInfo
+	etag   string
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.offset >= f.info.Size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", f.offset)}}
+		// Weak entity tags never match, so objects carrying one cannot be pinned
+		if f.etag != "" && !strings.HasPrefix(f.etag, "W/") {
+			header.Set("If-Match", f.etag)
+		}
+		resp, err := f.s3.do(http.MethodGet, f.key, nil, header, nil)
+		if err != nil {
+			return 0, err
+		}
+		f.body = resp.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF && f.offset < f.info.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size
+	default:
+		return 0, errors.New("s3: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Stat() (FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		err := f.body.Close()
+		f.body = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fsrv/internal/sigv4"
+)
+
+var testS3Credentials = sigv4.Credentials{AccessKey: "fsrv-test", SecretKey: "fsrv-test-secret"}
+
+// fakeS3 is a minimal S3 stand-in serving a single bucket from memory.
+// It checks signatures and payload hashes like the real thing.
+type fakeS3 struct {
+	bucket   string
+	pageSize int // keys per ListObjectsV2 page
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	uploads map[string]map[int][]byte
+	nextID  int
+	ops     []string // operations served, for assertions
+}
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func (o fakeObject) etag() string {
+	sum := md5.Sum(o.data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// newFakeS3 starts a fake S3 server and returns it with a storage using it
+func newFakeS3(t *testing.T, partSize int64) (*fakeS3, *S3) {
+	fake := &fakeS3{
+		bucket:   "bucket",
+		pageSize: 1000,
+		objects:  make(map[string]fakeObject),
+		uploads:  make(map[string]map[int][]byte),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store, err := NewS3(S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "bucket",
+		Region:    "us-east-1",
+		Prefix:    "fsrv",
+		AccessKey: testS3Credentials.AccessKey,
+		SecretKey: testS3Credentials.SecretKey,
+		PartSize:  partSize,
+	})
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+	return fake, store
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	secretFor := func(accessKey string) (string, bool) {
+		return testS3Credentials.SecretKey, accessKey == testS3Credentials.AccessKey
+	}
+	if _, err := sigv4.Verify(r, secretFor, time.Now()); err != nil {
+		writeFakeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if hash := r.Header.Get("X-Amz-Content-Sha256"); hash != sigv4.UnsignedPayload && hash != hex.EncodeToString(sum[:]) {
+		writeFakeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "payload hash mismatch")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		writeFakeError(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.record("list")
+		f.list(w, r)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.record("create-multipart")
+		f.nextID++
+		id := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", bucket, key, id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		f.record("upload-part")
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "NoSuchUpload", "")
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number] = body
+		w.Header().Set("ETag", fakeObject{data: body}.etag())
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.record("complete-multipart")
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "NoSuchUpload", "")
+			return
+		}
+		var complete struct {
+			Parts []struct{ PartNumber int } `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+			return
+		}
+		var data []byte
+		for _, part := range complete.Parts {
+			data = append(data, parts[part.PartNumber]...)
+		}
+		delete(f.uploads, query.Get("uploadId"))
+		f.objects[key] = fakeObject{data: data, modTime: time.Now()}
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.record("abort-multipart")
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.record("put")
+		f.objects[key] = fakeObject{data: body, modTime: time.Now()}
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		f.record(strings.ToLower(r.Method))
+		object, ok := f.objects[key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeFakeError(w, http.StatusNotFound, "NoSuchKey", key)
+			return
+		}
+		w.Header().Set("ETag", object.etag())
+		http.ServeContent(w, r, key, object.modTime, bytes.NewReader(object.data))
+	case r.Method == http.MethodDelete:
+		f.record("delete")
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeFakeError(w, http.StatusNotImplemented, "NotImplemented", r.Method)
+	}
+}
+
+// list implements ListObjectsV2
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys := f.pageSize
+	if n, err := strconv.Atoi(query.Get("max-keys")); err == nil && n < maxKeys {
+		maxKeys = n
+	}
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var out strings.Builder
+	out.WriteString("<ListBucketResult>")
+	seen := make(map[string]bool)
+	count, last := 0, ""
+	for _, key := range keys {
+		if count == maxKeys {
+			fmt.Fprintf(&out, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", last)
+			break
+		}
+		last = key
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			common := key[:len(prefix)+i+1]
+			if !seen[common] {
+				seen[common] = true
+				count++
+				fmt.Fprintf(&out, "<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>", common)
+			}
+			continue
+		}
+		count++
+		object := f.objects[key]
+		fmt.Fprintf(&out, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>",
+			key, len(object.data), object.modTime.UTC().Format(time.RFC3339))
+	}
+	out.WriteString("</ListBucketResult>")
+	io.WriteString(w, out.String())
+}
+
+func (f *fakeS3) record(op string) {
+	f.ops = append(f.ops, op)
+}
+
+// count returns how often op was served
+func (f *fakeS3) count(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, o := range f.ops {
+		if o == op {
+			n++
+		}
+	}
+	return n
+}
+
+func writeFakeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+func TestS3_MultipartPut(t *testing.T) {
+	fake, store := newFakeS3(t, 8)
+
+	content := []byte("twenty bytes of data")
+	size, err := store.Put("big.bin", bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("Put() size = %d, want %d", size, len(content))
+	}
+	if n := fake.count("upload-part"); n != 3 {
+		t.Errorf("uploaded %d parts, want 3", n)
+	}
+	if got := fake.objects["fsrv/big.bin"].data; !bytes.Equal(got, content) {
+		t.Errorf("stored object = %q, want %q", got, content)
+	}
+
+	// Small files go in one request
+	if _, err := store.Put("small.txt", strings.NewReader("tiny")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if n := fake.count("create-multipart"); n != 1 {
+		t.Errorf("started %d multipart uploads, want 1", n)
+	}
+}
+
+func TestS3_MultipartPutAborted(t *testing.T) {
+	fake, store := newFakeS3(t, 4)
+
+	if _, err := store.Put("partial.bin", &failingReader{data: []byte("more than one part")}); err == nil {
+		t.Fatal("Put() should fail when the source fails")
+	}
+	if n := fake.count("abort-multipart"); n != 1 {
+		t.Errorf("aborted %d multipart uploads, want 1", n)
+	}
+	if len(fake.uploads) != 0 || len(fake.objects) != 0 {
+		t.Errorf("failed Put() left %d uploads and %d objects", len(fake.uploads), len(fake.objects))
+	}
+}
+
+func TestS3_FolderMarkers(t *testing.T) {
+	fake, store := newFakeS3(t, 0)
+
+	// Folders made with Mkdir, the missing ones above included, get a marker
+	if err := store.Mkdir("a/b"); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	for _, key := range []string{"fsrv/a/", "fsrv/a/b/"} {
+		if _, ok := fake.objects[key]; !ok {
+			t.Errorf("no marker %s after Mkdir()", key)
+		}
+	}
+
+	// Deleting files writes no markers, so folders that only exist through
+	// their files vanish with the last one
+	for _, name := range []string{"a/b/x.txt", "implicit/y.txt"} {
+		if _, err := store.Put(name, strings.NewReader("x")); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	puts := fake.count("put")
+	for _, name := range []string{"a/b/x.txt", "implicit/y.txt"} {
+		if err := store.Delete(name); err != nil {
+			t.Fatalf("Delete(%s) error = %v", name, err)
+		}
+	}
+	if n := fake.count("put"); n != puts {
+		t.Errorf("Delete() wrote %d objects, want none", n-puts)
+	}
+	if info, err := store.Stat("a/b"); err != nil || !info.IsDir {
+		t.Errorf("Stat() of a folder made with Mkdir = %+v, %v", info, err)
+	}
+	if _, err := store.Stat("implicit"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() of a folder without a marker error = %v, want fs.ErrNotExist", err)
+	}
+
+	// Removing a folder leaves the one above it
+	if err := store.Delete("a/b"); err != nil {
+		t.Fatalf("Delete() of an empty folder error = %v", err)
+	}
+	if info, err := store.Stat("a"); err != nil || !info.IsDir {
+		t.Errorf("Stat() of the parent of a removed folder = %+v, %v", info, err)
+	}
+}
+
+func TestS3_RangedRead(t *testing.T) {
+	fake, store := newFakeS3(t, 0)
+
+	if _, err := store.Put("abc.txt", strings.NewReader("abcdefghijklmnopqrstuvwxyz")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	file, err := store.Open("abc.txt")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer file.Close()
+
+	// Seeking to the end, as http.ServeContent does, costs no request
+	if size, err := file.Seek(0, io.SeekEnd); err != nil || size != 26 {
+		t.Fatalf("Seek(0, SeekEnd) = %d, %v", size, err)
+	}
+	if _, err := file.Seek(20, io.SeekStart); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil || string(data) != "uvwxyz" {
+		t.Errorf("read %q, %v after seek, want %q", data, err, "uvwxyz")
+	}
+	if n := fake.count("get"); n != 1 {
+		t.Errorf("sent %d GET requests, want 1", n)
+	}
+
+	// Replacing the object invalidates the open file
+	if _, err := store.Put("abc.txt", strings.NewReader("replaced")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	file.Seek(0, io.SeekStart)
+	if _, err := io.ReadAll(file); err == nil {
+		t.Error("reading a replaced object should fail")
+	}
+}
+
+func TestS3_ListPagination(t *testing.T) {
+	fake, store := newFakeS3(t, 0)
+	fake.pageSize = 2
+
+	for i := 0; i < 5; i++ {
+		if _, err := store.Put(fmt.Sprintf("file%d.txt", i), strings.NewReader("x")); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	entries, err := store.List("")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != 5 {
+		t.Errorf("List() returned %d entries, want 5: %v", len(entries), names(entries))
+	}
+	if n := fake.count("list"); n != 3 {
+		t.Errorf("sent %d list requests, want 3", n)
+	}
+}
+
+func TestS3_WrongCredentials(t *testing.T) {
+	_, store := newFakeS3(t, 0)
+	store.cfg.SecretKey = "wrong"
+
+	_, err := store.Put("a.txt", strings.NewReader("x"))
+	var s3Err *S3Error
+	if !errors.As(err, &s3Err) || s3Err.Code != "SignatureDoesNotMatch" {
+		t.Errorf("Put() error = %v, want SignatureDoesNotMatch", err)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Error("authorization errors must not look like missing files")
+	}
+}
+
+func TestNewS3_InvalidConfig(t *testing.T) {
+	for _, cfg := range []S3Config{
+		{Endpoint: "localhost:9000", Bucket: "b"},
+		{Endpoint: "ftp://localhost", Bucket: "b"},
+		{Endpoint: "http://localhost:9000"},
+	} {
+		if _, err := NewS3(cfg); err == nil {
+			t.Errorf("NewS3(%+v) should fail", cfg)
+		}
+	}
+}
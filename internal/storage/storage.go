@@ -0,0 +1,92 @@
+// Package storage abstracts where fsrv keeps the files it serves.
+//
+// Names passed to a Storage are clean, slash separated paths relative to the
+// root of the store, such as "docs/report.pdf", with "" denoting the root
+// itself. Callers are expected to have validated them (see util.SafePath);
+// implementations reject anything else with fs.ErrInvalid.
+//
+// Errors for missing and already existing entries match fs.ErrNotExist and
+// fs.ErrExist with errors.Is, regardless of the implementation.
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// ErrNotEmpty is returned when deleting a folder that still has entries
+var ErrNotEmpty = errors.New("directory not empty")
+
+// FileInfo describes a file or folder in a store
+type FileInfo struct {
+	Name    string // base name, "" for the root
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// File is an open file. It can be passed straight to http.ServeContent.
+type File interface {
+	io.ReadSeekCloser
+	Stat() (FileInfo, error)
+}
+
+// Storage is a place to keep files in
+type Storage interface {
+	// List returns the entries of the folder dir, in no particular order.
+	List(dir string) ([]FileInfo, error)
+
+	// Put stores the content of src as name, replacing any existing file and
+	// creating missing parent folders. The file only becomes visible once src
+	// has been read completely; if reading src fails, nothing is stored.
+	// It returns the number of bytes stored.
+	Put(name string, src io.Reader) (int64, error)
+
+	// Open opens a file for reading.
+	Open(name string) (File, error)
+
+	// Stat describes a file or folder.
+	Stat(name string) (FileInfo, error)
+
+	// Delete removes a file or an empty folder.
+	Delete(name string) error
+
+	// Mkdir creates a folder along with any missing parents.
+	// It is not an error if the folder already exists.
+	Mkdir(dir string) error
+}
+
+// Importer is implemented by storages that can take over a file on the local
+// disk without copying it, such as the data of a finished resumable upload.
+type Importer interface {
+	// Import moves the local file at path into the store as name,
+	// with the same semantics as Put.
+	Import(name, path string) error
+}
+
+// Renamer is implemented by storages that can move a file or folder within the
+// store without copying its content.
+type Renamer interface {
+	// Rename moves the file or folder oldName to newName, creating missing parent
+	// folders. A file replaces an existing file; anything else that exists under
+	// newName makes it fail with fs.ErrExist.
+	Rename(oldName, newName string) error
+}
+
+// validName reports whether name is acceptable to a Storage, allowing "" for the root
+func validName(name string) bool {
+	return name == "" || fs.ValidPath(name) && name != "."
+}
+
+// within reports whether name is dir or lies below it
+func within(name, dir string) bool {
+	return dir == "" || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// pathError wraps err with the operation and name it occurred on
+func pathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"testing"
+)
+
+// failingReader returns some data and then fails, like a client that disconnects mid-upload
+type failingReader struct {
+	data []byte
+	done bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, errors.New("connection reset")
+	}
+	r.done = true
+	return copy(p, r.data), nil
+}
+
+// testStorages returns a fresh instance of every implementation
+func testStorages(t *testing.T) map[string]Storage {
+	_, s3 := newFakeS3(t, 8)
+	dedup, err := NewDedup(NewLocal(t.TempDir(), t.TempDir()), nil)
+	if err != nil {
+		t.Fatalf("NewDedup() error = %v", err)
+	}
+	return map[string]Storage{
+		"local":  NewLocal(t.TempDir(), t.TempDir()),
+		"memory": NewMemory(),
+		"s3":     s3,
+		"dedup":  dedup,
+	}
+}
+
+// names returns the sorted names of entries
+func names(entries []FileInfo) []string {
+	var result []string
+	for _, entry := range entries {
+		result = append(result, entry.Name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestStorage_PutOpenStat(t *testing.T) {
+	for name, store := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			content := []byte("hello storage")
+			size, err := store.Put("docs/hello.txt", bytes.NewReader(content))
+			if err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if size != int64(len(content)) {
+				t.Errorf("Put() size = %d, want %d", size, len(content))
+			}
+
+			info, err := store.Stat("docs/hello.txt")
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if info.Name != "hello.txt" || info.Size != int64(len(content)) || info.IsDir {
+				t.Errorf("Stat() = %+v", info)
+			}
+			if info, err := store.Stat("docs"); err != nil || !info.IsDir {
+				t.Errorf("Stat() of parent folder = %+v, %v", info, err)
+			}
+
+			file, err := store.Open("docs/hello.txt")
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer file.Close()
+
+			if _, err := file.Seek(6, io.SeekStart); err != nil {
+				t.Fatalf("Seek() error = %v", err)
+			}
+			data, err := io.ReadAll(file)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(data) != "storage" {
+				t.Errorf("read %q after seek, want %q", data, "storage")
+			}
+			if info, err := file.Stat(); err != nil || info.Size != int64(len(content)) {
+				t.Errorf("File.Stat() = %+v, %v", info, err)
+			}
+		})
+	}
+}
+
+func TestStorage_PutReplaces(t *testing.T) {
+	for name, store := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.Put("a.txt", bytes.NewReader([]byte("old"))); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if _, err := store.Put("a.txt", bytes.NewReader([]byte("new content"))); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if info, _ := store.Stat("a.txt"); info.Size != 11 {
+				t.Errorf("Stat() size = %d, want 11", info.Size)
+			}
+		})
+	}
+}
+
+func TestStorage_PutFailedSource(t *testing.T) {
+	for name, store := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.Put("partial.bin", &failingReader{data: []byte("partial")}); err == nil {
+				t.Fatal("Put() should fail when the source fails")
+			}
+			if _, err := store.Stat("partial.bin"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat() after failed Put() error = %v, want fs.ErrNotExist", err)
+			}
+			entries, err := store.List("")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("List() = %v, want nothing", names(entries))
+			}
+		})
+	}
+}
+
+func TestStorage_List(t *testing.T) {
+	for name, store := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, file := range []string{"a.txt", "docs/b.txt", "docs/old/c.txt"} {
+				if _, err := store.Put(file, bytes.NewReader([]byte("x"))); err != nil {
+					t.Fatalf("Put(%q) error = %v", file, err)
+				}
+			}
+			if err := store.Mkdir("empty"); err != nil {
+				t.Fatalf("Mkdir() error = %v", err)
+			}
+
+			root, err := store.List("")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if got := names(root); len(got) != 3 || got[0] != "a.txt" || got[1] != "docs" || got[2] != "empty" {
+				t.Errorf("List(\"\") = %v", got)
+			}
+
+			docs, err := store.List("docs")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			for _, entry := range docs {
+				if entry.IsDir != (entry.Name == "old") {
+					t.Errorf("List(\"docs\") entry %+v has wrong type", entry)
+				}
+			}
+			if len(docs) != 2 {
+				t.Errorf("List(\"docs\") = %v", names(docs))
+			}
+
+			if _, err := store.List("missing"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("List() of missing folder error = %v, want fs.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestStorage_Delete(t *testing.T) {
+	for name, store := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Mkdir("docs"); err != nil {
+				t.Fatalf("Mkdir() error = %v", err)
+			}
+			if _, err := store.Put("docs/a.txt", bytes.NewReader([]byte("x"))); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			if err := store.Delete("docs"); !errors.Is(err, ErrNotEmpty) {
+				t.Errorf("Delete() of non-empty folder error = %v, want ErrNotEmpty", err)
+			}
+			if err := store.Delete("docs/a.txt"); err != nil {
+				t.Errorf("Delete() error = %v", err)
+			}
+			if err := store.Delete("docs/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Delete() of missing file error = %v, want fs.ErrNotExist", err)
+			}
+			if err := store.Delete("docs"); err != nil {
+				t.Errorf("Delete() of empty folder error = %v", err)
+			}
+			if _, err := store.Stat("docs"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat() after Delete() error = %v, want fs.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestStorage_Mkdir(t *testing.T) {
+	for name, store := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Mkdir("a/b/c"); err != nil {
+				t.Fatalf("Mkdir() error = %v", err)
+			}
+			if err := store.Mkdir("a/b"); err != nil {
+				t.Errorf("Mkdir() of existing folder error = %v", err)
+			}
+			if info, err := store.Stat("a/b/c"); err != nil || !info.IsDir {
+				t.Errorf("Stat() = %+v, %v", info, err)
+			}
+
+			if _, err := store.Put("file", bytes.NewReader(nil)); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if err := store.Mkdir("file"); !errors.Is(err, fs.ErrExist) {
+				t.Errorf("Mkdir() over a file error = %v, want fs.ErrExist", err)
+			}
+		})
+	}
+}
+
+func TestStorage_InvalidNames(t *testing.T) {
+	for name, store := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, bad := range []string{"../escape", "/abs", "a//b", "a/./b", "."} {
+				if _, err := store.Put(bad, bytes.NewReader(nil)); !errors.Is(err, fs.ErrInvalid) {
+					t.Errorf("Put(%q) error = %v, want fs.ErrInvalid", bad, err)
+				}
+				if _, err := store.Stat(bad); !errors.Is(err, fs.ErrInvalid) {
+					t.Errorf("Stat(%q) error = %v, want fs.ErrInvalid", bad, err)
+				}
+			}
+			if err := store.Delete(""); !errors.Is(err, fs.ErrInvalid) {
+				t.Errorf("Delete() of the root error = %v, want fs.ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestRenamer_Rename(t *testing.T) {
+	for name, store := range testStorages(t) {
+		renamer, ok := store.(Renamer)
+		if !ok {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			for _, file := range []string{"a.txt", "b.txt", "docs/x.txt", "docs/sub/y.txt"} {
+				if _, err := store.Put(file, bytes.NewReader([]byte(file))); err != nil {
+					t.Fatalf("Put() error = %v", err)
+				}
+			}
+
+			// Files replace files and get new parents on the way
+			if err := renamer.Rename("a.txt", "new/b.txt"); err != nil {
+				t.Fatalf("Rename() of a file error = %v", err)
+			}
+			if err := renamer.Rename("new/b.txt", "b.txt"); err != nil {
+				t.Fatalf("Rename() onto a file error = %v", err)
+			}
+			if data, _ := readAll(store, "b.txt"); data != "a.txt" {
+				t.Errorf("renamed content = %q", data)
+			}
+
+			// Folders move with their content
+			if err := renamer.Rename("docs", "archive/docs"); err != nil {
+				t.Fatalf("Rename() of a folder error = %v", err)
+			}
+			if data, _ := readAll(store, "archive/docs/sub/y.txt"); data != "docs/sub/y.txt" {
+				t.Errorf("moved content = %q", data)
+			}
+			if _, err := store.Stat("docs"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat() of the old folder error = %v, want not exist", err)
+			}
+
+			if err := renamer.Rename("archive", "b.txt"); !errors.Is(err, fs.ErrExist) {
+				t.Errorf("Rename() of a folder onto a file error = %v, want exist", err)
+			}
+			if err := renamer.Rename("b.txt", "archive"); !errors.Is(err, fs.ErrExist) {
+				t.Errorf("Rename() of a file onto a folder error = %v, want exist", err)
+			}
+			if err := renamer.Rename("archive", "archive/inside"); !errors.Is(err, fs.ErrInvalid) {
+				t.Errorf("Rename() into itself error = %v, want invalid", err)
+			}
+			if err := renamer.Rename("missing", "other"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Rename() of a missing file error = %v, want not exist", err)
+			}
+		})
+	}
+}
+
+// readAll returns the content stored as name
+func readAll(store Storage, name string) (string, error) {
+	file, err := store.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	return string(data), err
+}
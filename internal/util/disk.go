@@ -0,0 +1,14 @@
+package util
+
+import "errors"
+
+// ErrDiskSpaceUnsupported is returned by StatDisk on platforms it cannot ask for
+// the space of a filesystem
+var ErrDiskSpaceUnsupported = errors.New("disk space is not available on this platform")
+
+// DiskSpace is the size and usage of a filesystem, in bytes
+type DiskSpace struct {
+	Total int64
+	Free  int64 // available to this process, which may be less than unused
+	Used  int64
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package util
+
+// StatDisk fails with ErrDiskSpaceUnsupported on this platform
+func StatDisk(dir string) (DiskSpace, error) {
+	return DiskSpace{}, ErrDiskSpaceUnsupported
+}
+
+// IsDiskFull reports whether err is a write failing for lack of space, which
+// cannot be told on this platform
+func IsDiskFull(err error) bool {
+	return false
+}
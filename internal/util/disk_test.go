@@ -0,0 +1,31 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatDisk(t *testing.T) {
+	space, err := StatDisk(t.TempDir())
+	if errors.Is(err, ErrDiskSpaceUnsupported) {
+		t.Skip("disk space is not available on this platform")
+	}
+	if err != nil {
+		t.Fatalf("StatDisk() error = %v", err)
+	}
+	if space.Total <= 0 || space.Free < 0 || space.Free > space.Total || space.Used < 0 || space.Used > space.Total {
+		t.Errorf("StatDisk() = %+v", space)
+	}
+
+	if _, err := StatDisk(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("StatDisk() of a missing directory error = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestIsDiskFull(t *testing.T) {
+	if IsDiskFull(errors.New("disk full")) || IsDiskFull(os.ErrNotExist) {
+		t.Error("IsDiskFull() of other errors = true")
+	}
+}
@@ -0,0 +1,30 @@
+//go:build linux || darwin || freebsd
+
+package util
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+)
+
+// StatDisk returns the space of the filesystem dir is on
+func StatDisk(dir string) (DiskSpace, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return DiskSpace{}, &fs.PathError{Op: "statfs", Path: dir, Err: err}
+	}
+	// The field types differ between platforms
+	blockSize := int64(st.Bsize)
+	total := int64(st.Blocks) * blockSize
+	return DiskSpace{
+		Total: total,
+		Free:  int64(st.Bavail) * blockSize,
+		Used:  total - int64(st.Bfree)*blockSize,
+	}, nil
+}
+
+// IsDiskFull reports whether err is a write failing for lack of space
+func IsDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package util
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsDiskFull_Unix(t *testing.T) {
+	for _, errno := range []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT} {
+		if err := (&os.PathError{Op: "write", Path: "a.txt", Err: errno}); !IsDiskFull(err) {
+			t.Errorf("IsDiskFull(%v) = false", err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package util
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// Windows error codes of writes failing for lack of space
+const (
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+// StatDisk returns the space of the filesystem dir is on
+func StatDisk(dir string) (DiskSpace, error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return DiskSpace{}, &fs.PathError{Op: "GetDiskFreeSpaceEx", Path: dir, Err: err}
+	}
+	var free, total, unused uint64
+	ok, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path)),
+		uintptr(unsafe.Pointer(&free)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&unused)))
+	if ok == 0 {
+		return DiskSpace{}, &fs.PathError{Op: "GetDiskFreeSpaceEx", Path: dir, Err: err}
+	}
+	return DiskSpace{Total: int64(total), Free: int64(free), Used: int64(total - unused)}, nil
+}
+
+// IsDiskFull reports whether err is a write failing for lack of space
+func IsDiskFull(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
@@ -2,8 +2,12 @@ package util
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // HumanReadableSize converts bytes to human readable format
@@ -20,6 +24,49 @@ func HumanReadableSize(size int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
+// HumanReadableDuration converts a duration to its two largest units, like
+// "2d 5h", "3h 20m" or "45m", rounded down. Durations below a minute are "<1m".
+func HumanReadableDuration(d time.Duration) string {
+	days, hours, minutes := int64(d/(24*time.Hour)), int64(d/time.Hour)%24, int64(d/time.Minute)%60
+	switch {
+	case days > 0 && hours > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case days > 0:
+		return fmt.Sprintf("%dd", days)
+	case hours > 0 && minutes > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%dh", hours)
+	case minutes > 0:
+		return fmt.Sprintf("%dm", minutes)
+	default:
+		return "<1m"
+	}
+}
+
+// ParseSize parses a size like "512", "10K", "64 MB" or "1.5GB", the inverse of
+// HumanReadableSize. Units are powers of 1024 and case insensitive.
+func ParseSize(s string) (int64, error) {
+	number := strings.TrimSpace(strings.ToUpper(s))
+	number = strings.TrimSuffix(strings.TrimSuffix(number, "IB"), "B")
+
+	multiplier := int64(1)
+	if n := len(number); n > 0 {
+		if exp := strings.IndexByte("KMGTPE", number[n-1]); exp >= 0 {
+			number = strings.TrimSpace(number[:n-1])
+			for i := 0; i <= exp; i++ {
+				multiplier *= 1024
+			}
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(value) || value < 0 || value*float64(multiplier) >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size: '%s'", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
 // CheckAndCreateDir checks if a directory exists and creates it if it doesn't
 func CheckAndCreateDir(dir string) error {
 	_, err := os.Stat(dir)
@@ -59,3 +106,28 @@ func PrepareTmpDir() (string, error) {
 func SafeFileName(filename string) string {
 	return filepath.Base(filename)
 }
+
+// SafePath cleans a slash separated path relative to a root directory.
+//
+// Backslashes are treated as separators, empty and "." components are dropped,
+// and the result never starts with a slash. Paths containing ".." components
+// or NUL bytes are rejected instead of being resolved, so the result can never
+// point outside the root. The root itself is returned as "".
+func SafePath(p string) (string, error) {
+	if strings.ContainsRune(p, 0) {
+		return "", fmt.Errorf("invalid path: '%s'", p)
+	}
+
+	var parts []string
+	for _, part := range strings.Split(strings.ReplaceAll(p, "\\", "/"), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return "", fmt.Errorf("invalid path: '%s'", p)
+		}
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, "/"), nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestHumanReadableSize(t *testing.T) {
@@ -79,6 +80,65 @@ func TestHumanReadableSize(t *testing.T) {
 	}
 }
 
+func TestHumanReadableDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{30 * time.Second, "<1m"},
+		{-time.Hour, "<1m"},
+		{45 * time.Minute, "45m"},
+		{time.Hour, "1h"},
+		{3*time.Hour + 20*time.Minute + 59*time.Second, "3h 20m"},
+		{24 * time.Hour, "1d"},
+		{53*time.Hour + 30*time.Minute, "2d 5h"},
+	}
+	for _, tt := range tests {
+		if got := HumanReadableDuration(tt.d); got != tt.want {
+			t.Errorf("HumanReadableDuration(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "512", want: 512},
+		{input: "10K", want: 10 * 1024},
+		{input: "10KB", want: 10 * 1024},
+		{input: "64 mb", want: 64 * 1024 * 1024},
+		{input: "1.5GB", want: 1536 * 1024 * 1024},
+		{input: "2GiB", want: 2 * 1024 * 1024 * 1024},
+		{input: "1.0 TB", want: 1024 * 1024 * 1024 * 1024},
+		{input: "", wantErr: true},
+		{input: "B", wantErr: true},
+		{input: "-1K", wantErr: true},
+		{input: "ten", wantErr: true},
+		{input: "NaN", wantErr: true},
+		{input: "9000000EB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	// Sizes printed by HumanReadableSize parse back
+	if got, err := ParseSize(HumanReadableSize(3 * 1024 * 1024)); err != nil || got != 3*1024*1024 {
+		t.Errorf("ParseSize(HumanReadableSize()) = %d, %v", got, err)
+	}
+}
+
 func TestCheckAndCreateDir(t *testing.T) {
 	// Create a temporary directory for testing
 	tmpDir := filepath.Join(os.TempDir(), "fsrv-test")
@@ -175,6 +235,42 @@ func TestSafeFileName(t *testing.T) {
 	}
 }
 
+func TestSafePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{name: "simple filename", path: "test.txt", want: "test.txt"},
+		{name: "nested path", path: "a/b/c.txt", want: "a/b/c.txt"},
+		{name: "leading slash", path: "/a/b.txt", want: "a/b.txt"},
+		{name: "redundant separators", path: "a//./b/", want: "a/b"},
+		{name: "backslashes", path: `a\b\c.txt`, want: "a/b/c.txt"},
+		{name: "root", path: "", want: ""},
+		{name: "root with slashes", path: "/./", want: ""},
+		{name: "parent", path: "..", wantErr: true},
+		{name: "traversal", path: "../etc/passwd", wantErr: true},
+		{name: "hidden traversal", path: "a/../../etc/passwd", wantErr: true},
+		{name: "backslash traversal", path: `a\..\..\x`, wantErr: true},
+		{name: "NUL byte", path: "a\x00b", wantErr: true},
+		{name: "dots in names", path: "a/..b/c..", want: "a/..b/c.."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SafePath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SafePath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("SafePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestPrepareTmpDir(t *testing.T) {
 	// This test is tricky because PrepareTmpDir uses os.Executable
 	// We'll just test that it returns a valid path